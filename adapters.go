@@ -7,7 +7,9 @@
 package zeroconfig
 
 import (
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/rs/zerolog"
 )
@@ -20,24 +22,125 @@ func (lw levelWriterAdapter) WriteLevel(l zerolog.Level, p []byte) (n int, err e
 	return lw.Write(p)
 }
 
+// levelWriterCloserAdapter is a levelWriterAdapter that keeps the Close method of the wrapped writer.
+type levelWriterCloserAdapter struct {
+	levelWriterAdapter
+	io.Closer
+}
+
+// asLevelWriter returns the writer as a zerolog.LevelWriter, wrapping it if it isn't one already.
+func asLevelWriter(writer io.Writer) zerolog.LevelWriter {
+	if lw, ok := writer.(zerolog.LevelWriter); ok {
+		return lw
+	} else if closer, ok := writer.(io.Closer); ok {
+		return levelWriterCloserAdapter{levelWriterAdapter{writer}, closer}
+	}
+	return levelWriterAdapter{writer}
+}
+
+// NoLevelBehavior describes how writers with level bounds handle events without a level, like ones from log.Log().
+// Besides the constants, it can be "treat_as:<level>" (see NoLevelTreatAs). If it's empty, events without a level
+// pass min_level, but are dropped if max_level is set.
+type NoLevelBehavior string
+
+const (
+	// NoLevelPass always writes events without a level, regardless of min_level and max_level.
+	NoLevelPass NoLevelBehavior = "pass"
+	// NoLevelDrop never writes events without a level.
+	NoLevelDrop NoLevelBehavior = "drop"
+
+	noLevelTreatAsPrefix = "treat_as:"
+)
+
+// NoLevelTreatAs returns a NoLevelBehavior that filters events without a level as if they had the given level.
+// The events are still written without a level.
+func NoLevelTreatAs(level zerolog.Level) NoLevelBehavior {
+	return NoLevelBehavior(noLevelTreatAsPrefix + level.String())
+}
+
+// noLevelFilter is the parsed form of a NoLevelBehavior.
+type noLevelFilter struct {
+	pass bool
+	drop bool
+	// The level to filter events without a level as. By default, it's zerolog.NoLevel, which is above all standard
+	// levels, so events without a level pass min_level, but any max_level below it drops them.
+	as zerolog.Level
+}
+
+func (nlb NoLevelBehavior) parse() (noLevelFilter, error) {
+	switch {
+	case nlb == "":
+		return noLevelFilter{as: zerolog.NoLevel}, nil
+	case nlb == NoLevelPass:
+		return noLevelFilter{pass: true}, nil
+	case nlb == NoLevelDrop:
+		return noLevelFilter{drop: true}, nil
+	case strings.HasPrefix(string(nlb), noLevelTreatAsPrefix):
+		name := strings.TrimSpace(strings.TrimPrefix(string(nlb), noLevelTreatAsPrefix))
+		level, err := zerolog.ParseLevel(name)
+		if err != nil || name == "" || level == zerolog.NoLevel || level == zerolog.Disabled {
+			return noLevelFilter{}, fmt.Errorf("invalid level %q in no_level %s", name, nlb)
+		}
+		return noLevelFilter{as: level}, nil
+	default:
+		return noLevelFilter{}, fmt.Errorf("unknown no_level behavior %q (expected pass, drop or treat_as:<level>)", nlb)
+	}
+}
+
 type minMaxLevelWriter struct {
 	zerolog.LevelWriter
 	MinLevel zerolog.Level
 	MaxLevel zerolog.Level
+	NoLevel  noLevelFilter
 }
 
 // MinMaxLevelWriter wraps a writer in a zerolog.LevelWriter, but limits the log levels that can pass through.
+// Events without a level pass unless there's a max level. See MinMaxLevelWriterNoLevel for other behaviors.
 func MinMaxLevelWriter(writer io.Writer, minLevel, maxLevel zerolog.Level) zerolog.LevelWriter {
-	lw, ok := writer.(zerolog.LevelWriter)
-	if !ok {
-		lw = levelWriterAdapter{writer}
+	return minMaxLevelWriter{
+		LevelWriter: asLevelWriter(writer),
+		MinLevel:    minLevel,
+		MaxLevel:    maxLevel,
+		NoLevel:     noLevelFilter{as: zerolog.NoLevel},
 	}
-	return minMaxLevelWriter{LevelWriter: lw, MinLevel: minLevel, MaxLevel: maxLevel}
+}
+
+// MinMaxLevelWriterNoLevel is like MinMaxLevelWriter, but with the given behavior for events without a level.
+// NoLevelDrop applies even if both levels are NoLevel (i.e. unbounded).
+func MinMaxLevelWriterNoLevel(writer io.Writer, minLevel, maxLevel zerolog.Level, noLevel NoLevelBehavior) (zerolog.LevelWriter, error) {
+	filter, err := noLevel.parse()
+	if err != nil {
+		return nil, err
+	}
+	return minMaxLevelWriter{LevelWriter: asLevelWriter(writer), MinLevel: minLevel, MaxLevel: maxLevel, NoLevel: filter}, nil
 }
 
 func (mlw minMaxLevelWriter) WriteLevel(l zerolog.Level, p []byte) (n int, err error) {
-	if (mlw.MinLevel == zerolog.NoLevel || l >= mlw.MinLevel) && (mlw.MaxLevel == zerolog.NoLevel || l <= mlw.MaxLevel) {
+	filterLevel := l
+	if l == zerolog.NoLevel {
+		if mlw.NoLevel.drop {
+			return len(p), nil
+		} else if mlw.NoLevel.pass {
+			return mlw.LevelWriter.WriteLevel(l, p)
+		}
+		filterLevel = mlw.NoLevel.as
+	}
+	if (mlw.MinLevel == zerolog.NoLevel || filterLevel >= mlw.MinLevel) && (mlw.MaxLevel == zerolog.NoLevel || filterLevel <= mlw.MaxLevel) {
 		return mlw.LevelWriter.WriteLevel(l, p)
 	}
 	return len(p), nil
 }
+
+// nearestStandardLevel maps custom numeric levels to the closest level that has a name in zerolog.
+func nearestStandardLevel(level zerolog.Level) zerolog.Level {
+	switch {
+	case level == zerolog.NoLevel:
+		return level
+	case level < zerolog.TraceLevel:
+		return zerolog.TraceLevel
+	case level > zerolog.PanicLevel:
+		return zerolog.PanicLevel
+	default:
+		return level
+	}
+}
@@ -9,6 +9,7 @@ package zeroconfig_test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/rs/zerolog"
@@ -69,3 +70,98 @@ func TestMinMaxLevelWriter(t *testing.T) {
 		})
 	}
 }
+
+func TestMinMaxLevelWriter_CustomLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(zeroconfig.MinMaxLevelWriter(&buf, zerolog.Level(8), zerolog.Level(15)))
+	for _, level := range []zerolog.Level{zerolog.PanicLevel, 8, 10, 15, 20} {
+		log.WithLevel(level).Msg("meow")
+	}
+	dec := json.NewDecoder(&buf)
+	for _, expected := range []string{"8", "10", "15"} {
+		var ll struct {
+			Level string `json:"level"`
+		}
+		require.NoError(t, dec.Decode(&ll), "Decoding log line should be successful")
+		require.Equal(t, expected, ll.Level)
+	}
+	require.False(t, dec.More(), "Levels outside the custom bounds should be filtered")
+}
+
+func TestMinMaxLevelWriter_NoLevel(t *testing.T) {
+	bounds := []struct {
+		name string
+		min  zerolog.Level
+		max  zerolog.Level
+	}{
+		{"Unbounded", zerolog.NoLevel, zerolog.NoLevel},
+		{"Min info", zerolog.InfoLevel, zerolog.NoLevel},
+		{"Max warn", zerolog.NoLevel, zerolog.WarnLevel},
+		{"Min debug max warn", zerolog.DebugLevel, zerolog.WarnLevel},
+		{"Min error max panic", zerolog.ErrorLevel, zerolog.PanicLevel},
+	}
+	tests := []struct {
+		behavior zeroconfig.NoLevelBehavior
+		// Whether a log.Log() event is written with each of the bounds above.
+		written []bool
+	}{
+		{"", []bool{true, true, false, false, false}},
+		{zeroconfig.NoLevelPass, []bool{true, true, true, true, true}},
+		{zeroconfig.NoLevelDrop, []bool{false, false, false, false, false}},
+		{zeroconfig.NoLevelTreatAs(zerolog.DebugLevel), []bool{true, false, true, true, false}},
+		{zeroconfig.NoLevelTreatAs(zerolog.ErrorLevel), []bool{true, true, false, false, true}},
+		{"treat_as: trace", []bool{true, false, true, false, false}},
+	}
+	for _, test := range tests {
+		for i, bound := range bounds {
+			t.Run(fmt.Sprintf("%s/%s", test.behavior, bound.name), func(t *testing.T) {
+				var buf bytes.Buffer
+				writer, err := zeroconfig.MinMaxLevelWriterNoLevel(&buf, bound.min, bound.max, test.behavior)
+				require.NoError(t, err)
+				log := zerolog.New(writer)
+				log.Log().Msg("meow")
+				if test.written[i] {
+					require.Equal(t, `{"message":"meow"}`+"\n", buf.String())
+				} else {
+					require.Empty(t, buf.String())
+				}
+			})
+		}
+	}
+}
+
+func TestMinMaxLevelWriter_NoLevelDefault(t *testing.T) {
+	for _, bounds := range [][2]zerolog.Level{{zerolog.NoLevel, zerolog.NoLevel}, {zerolog.InfoLevel, zerolog.NoLevel}, {zerolog.NoLevel, zerolog.WarnLevel}} {
+		var plain, explicit bytes.Buffer
+		writer, err := zeroconfig.MinMaxLevelWriterNoLevel(&explicit, bounds[0], bounds[1], "")
+		require.NoError(t, err)
+		plainLog := zerolog.New(zeroconfig.MinMaxLevelWriter(&plain, bounds[0], bounds[1]))
+		plainLog.Log().Msg("meow")
+		explicitLog := zerolog.New(writer)
+		explicitLog.Log().Msg("meow")
+		require.Equal(t, plain.String(), explicit.String(), "MinMaxLevelWriter should match the default behavior")
+	}
+}
+
+func TestWriterConfig_Compile_NoLevel(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "stdout", "max_level": "info", "no_level": "treat_as:info"},
+	    {"type": "group", "min_level": "warn", "no_level": "drop", "writers": [{"type": "stderr"}]}
+	  ],
+	  "timestamp": false
+	}`)
+	log.Log().Msg("unleveled")
+	log.Error().Msg("error")
+	require.Equal(t, `{"message":"unleveled"}`+"\n", stdout.String())
+	require.Equal(t, `{"level":"error","message":"error"}`+"\n", stderr.String())
+}
+
+func TestWriterConfig_Compile_NoLevelInvalid(t *testing.T) {
+	_, err := parseConfig(t, `{"writers": [{"type": "stdout", "no_level": "sometimes"}, {"type": "stdout", "no_level": "treat_as:loud"}]}`).Compile()
+	require.EqualError(t, err, `writers[0].no_level: unknown no_level behavior "sometimes" (expected pass, drop or treat_as:<level>); `+
+		`writers[1].no_level: invalid level "loud" in no_level treat_as:loud`)
+}
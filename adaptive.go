@@ -0,0 +1,152 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// AdaptiveSamplingConfig contains the configuration for sampling that's only enabled while the event rate is high.
+type AdaptiveSamplingConfig struct {
+	// The rate in events per second above which sampling is enabled.
+	RateThreshold float64 `json:"rate_threshold" yaml:"rate_threshold" toml:"rate_threshold"`
+	// The rate in events per second below which sampling is disabled again.
+	// Must be lower than RateThreshold to avoid flapping. Defaults to half of RateThreshold.
+	ResumeThreshold float64 `json:"resume_threshold,omitempty" yaml:"resume_threshold,omitempty" toml:"resume_threshold,omitempty"`
+	// The fraction of events to write while sampling is enabled, e.g. 0.1 for one of every 10 events.
+	SampleRatio float64 `json:"sample_ratio" yaml:"sample_ratio" toml:"sample_ratio"`
+	// The length of the window the rate is measured over. Defaults to 1 second.
+	Window time.Duration `json:"window,omitempty" yaml:"window,omitempty" toml:"window,omitempty"`
+}
+
+const defaultAdaptiveSamplingWindow = 1 * time.Second
+
+func (asc *AdaptiveSamplingConfig) UnmarshalJSON(data []byte) error {
+	type plainAdaptiveSamplingConfig AdaptiveSamplingConfig
+	aux := struct {
+		*plainAdaptiveSamplingConfig
+		Window json.RawMessage `json:"window,omitempty"`
+	}{plainAdaptiveSamplingConfig: (*plainAdaptiveSamplingConfig)(asc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.Window, &asc.Window); err != nil {
+		return &PathError{Path: "window", Err: err}
+	}
+	return nil
+}
+
+// adaptiveSamplingWriter writes all events until the rate exceeds the threshold,
+// then only writes a fraction of them until the rate drops below the resume threshold.
+type adaptiveSamplingWriter struct {
+	zerolog.LevelWriter
+	window time.Duration
+	// Thresholds are stored as event counts per window.
+	threshold float64
+	resume    float64
+	ratio     float64
+
+	lock        sync.Mutex
+	windowStart time.Time
+	count       uint64
+	active      bool
+	// Accumulates ratio for every event while active, an event is written whenever it reaches 1.
+	credit float64
+
+	counters *writerCounters
+}
+
+func (asc *AdaptiveSamplingConfig) compile(output zerolog.LevelWriter, counters *writerCounters) (zerolog.LevelWriter, error) {
+	if asc.RateThreshold <= 0 {
+		return nil, fmt.Errorf("adaptive_sampling rate_threshold must be positive")
+	} else if asc.SampleRatio <= 0 || asc.SampleRatio >= 1 {
+		return nil, fmt.Errorf("adaptive_sampling sample_ratio must be between 0 and 1")
+	} else if asc.Window < 0 {
+		return nil, fmt.Errorf("adaptive_sampling window must not be negative")
+	}
+	resume := asc.ResumeThreshold
+	if resume == 0 {
+		resume = asc.RateThreshold / 2
+	} else if resume < 0 || resume >= asc.RateThreshold {
+		return nil, fmt.Errorf("adaptive_sampling resume_threshold must be positive and lower than rate_threshold")
+	}
+	window := asc.Window
+	if window == 0 {
+		window = defaultAdaptiveSamplingWindow
+	}
+	return &adaptiveSamplingWriter{
+		LevelWriter: output,
+		window:      window,
+		threshold:   asc.RateThreshold * window.Seconds(),
+		resume:      resume * window.Seconds(),
+		ratio:       asc.SampleRatio,
+		windowStart: time.Now(),
+		counters:    counters,
+	}, nil
+}
+
+func (asw *adaptiveSamplingWriter) Write(p []byte) (n int, err error) {
+	return asw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (asw *adaptiveSamplingWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if !asw.sample(time.Now()) {
+		if dropped := asw.counters.sampledCounter(); dropped != nil {
+			dropped.Add(1)
+		}
+		return len(p), nil
+	}
+	return asw.LevelWriter.WriteLevel(level, p)
+}
+
+func (asw *adaptiveSamplingWriter) sample(now time.Time) bool {
+	asw.lock.Lock()
+	defer asw.lock.Unlock()
+	if elapsed := now.Sub(asw.windowStart); elapsed >= asw.window {
+		prevCount := asw.count
+		if elapsed >= 2*asw.window {
+			// There were no events at all in the previous window.
+			prevCount = 0
+		}
+		asw.windowStart = now
+		asw.count = 0
+		if asw.active && float64(prevCount) < asw.resume {
+			asw.setActive(false)
+		}
+	}
+	asw.count++
+	if !asw.active && float64(asw.count) > asw.threshold {
+		asw.setActive(true)
+	}
+	if !asw.active {
+		return true
+	}
+	asw.credit += asw.ratio
+	// Allow for rounding errors, so that e.g. a ratio of 0.1 writes exactly every 10th event.
+	if asw.credit >= 1-1e-9 {
+		asw.credit--
+		return true
+	}
+	return false
+}
+
+func (asw *adaptiveSamplingWriter) setActive(active bool) {
+	asw.active = active
+	asw.credit = 0
+	if asw.counters == nil {
+		return
+	} else if active {
+		asw.counters.adaptiveActive.Add(1)
+		asw.counters.adaptiveActivations.Add(1)
+	} else {
+		asw.counters.adaptiveActive.Add(-1)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_AdaptiveSampling(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "stdout", "adaptive_sampling": {
+	  "rate_threshold": 100,
+	  "sample_ratio": 0.1,
+	  "window": "100ms"
+	}}]}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+
+	// The threshold is 10 events per window: the first 10 are written, then one of every 10.
+	for i := 0; i < 100; i++ {
+		handle.Logger.Info().Msg("meow")
+	}
+	assert.Equal(t, 19, strings.Count(stdout.String(), "\n"))
+	stats := handle.Stats()
+	assert.Equal(t, zeroconfig.WriterStats{
+		Type:                        zeroconfig.WriterTypeStdout,
+		Sampled:                     81,
+		AdaptiveSamplingActive:      true,
+		AdaptiveSamplingActivations: 1,
+	}, stats.Writers[0])
+
+	// After a quiet window, sampling is disabled again.
+	time.Sleep(250 * time.Millisecond)
+	stdout.Reset()
+	handle.Logger.Info().Msg("meow")
+	assert.Equal(t, 1, strings.Count(stdout.String(), "\n"))
+	stats = handle.Stats()
+	assert.False(t, stats.Writers[0].AdaptiveSamplingActive)
+	assert.Equal(t, uint64(1), stats.Writers[0].AdaptiveSamplingActivations)
+}
+
+func TestConfig_Compile_AdaptiveSamplingInvalid(t *testing.T) {
+	compile := func(asc zeroconfig.AdaptiveSamplingConfig) error {
+		_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, AdaptiveSampling: &asc}).Compile()
+		return err
+	}
+	assert.EqualError(t, compile(zeroconfig.AdaptiveSamplingConfig{SampleRatio: 0.5}), "adaptive_sampling rate_threshold must be positive")
+	assert.EqualError(t, compile(zeroconfig.AdaptiveSamplingConfig{RateThreshold: 10, SampleRatio: 1}), "adaptive_sampling sample_ratio must be between 0 and 1")
+	assert.EqualError(t, compile(zeroconfig.AdaptiveSamplingConfig{RateThreshold: 10, ResumeThreshold: 10, SampleRatio: 0.5}),
+		"adaptive_sampling resume_threshold must be positive and lower than rate_threshold")
+	assert.NoError(t, compile(zeroconfig.AdaptiveSamplingConfig{RateThreshold: 10, ResumeThreshold: 2, SampleRatio: 0.5}))
+}
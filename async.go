@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+const defaultAsyncBufferSize = 1000
+
+// AsyncFullPolicy describes what an async writer should do when its queue is full.
+type AsyncFullPolicy string
+
+const (
+	// AsyncFullDrop drops the event and counts it in Handle.Stats. This is the default.
+	AsyncFullDrop AsyncFullPolicy = "drop"
+	// AsyncFullBlock waits until there's space in the queue, like a synchronous writer would wait for the output.
+	AsyncFullBlock AsyncFullPolicy = "block"
+)
+
+// AsyncDroppedMessage is the message of the event logged when WriterConfig.AsyncReportDrops is enabled.
+const AsyncDroppedMessage = "Dropped events because the async queue was full"
+
+type asyncEvent struct {
+	level zerolog.Level
+	data  []byte
+}
+
+// asyncWriter queues events and writes them to the output in a background goroutine, so that logging doesn't wait
+// for slow outputs. Events are dropped (and counted) or the write blocks if the queue is full, depending on the policy.
+//
+// The level of each event is kept in the queue, so level-aware outputs like syslog work the same as without async.
+type asyncWriter struct {
+	output      zerolog.LevelWriter
+	queue       chan asyncEvent
+	done        chan struct{}
+	block       bool
+	reportDrops bool
+
+	// The lock is held for reading while queuing events, so that the queue isn't closed in the middle of a write.
+	lock      sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+	dropped   atomic.Uint64
+	deliveryHealth
+}
+
+// wrapAsync wraps the output in an asyncWriter if async is enabled for the writer.
+func (wc *writerCounters) wrapAsync(cfg *WriterConfig, output io.Writer) (io.Writer, error) {
+	if !cfg.Async {
+		return output, nil
+	}
+	bufferSize := cfg.AsyncBufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultAsyncBufferSize
+	} else if bufferSize < 0 {
+		return nil, fmt.Errorf("async_buffer_size can't be negative")
+	}
+	switch cfg.AsyncOnFull {
+	case "", AsyncFullDrop, AsyncFullBlock:
+	default:
+		return nil, fmt.Errorf("unknown async_on_full policy %q", cfg.AsyncOnFull)
+	}
+	aw := &asyncWriter{
+		output:      asLevelWriter(output),
+		queue:       make(chan asyncEvent, bufferSize),
+		done:        make(chan struct{}),
+		block:       cfg.AsyncOnFull == AsyncFullBlock,
+		reportDrops: cfg.AsyncReportDrops,
+	}
+	go aw.loop()
+	if wc != nil && wc.outputs != nil {
+		wc.outputs.addFlusher(aw)
+		wc.outputs.healthCheckers[wc.index] = append(wc.outputs.healthCheckers[wc.index], aw)
+		wc.outputs.dropCounters[wc.index] = append(wc.outputs.dropCounters[wc.index], aw)
+	}
+	return aw, nil
+}
+
+func (aw *asyncWriter) Write(p []byte) (n int, err error) {
+	return aw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (aw *asyncWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	aw.lock.RLock()
+	defer aw.lock.RUnlock()
+	if aw.closed {
+		return 0, errors.New("async writer is closed")
+	}
+	// zerolog reuses the buffer after Write returns, so it has to be copied.
+	evt := asyncEvent{level: level, data: append([]byte(nil), p...)}
+	if aw.block {
+		aw.queue <- evt
+		return len(p), nil
+	}
+	select {
+	case aw.queue <- evt:
+	default:
+		aw.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+func (aw *asyncWriter) loop() {
+	defer close(aw.done)
+	var reported uint64
+	for evt := range aw.queue {
+		_, err := aw.output.WriteLevel(evt.level, evt.data)
+		aw.setHealth(err)
+		if aw.reportDrops && len(aw.queue) == 0 {
+			reported = aw.reportDropped(reported)
+		}
+	}
+	if aw.reportDrops {
+		aw.reportDropped(reported)
+	}
+}
+
+// reportDropped writes an event with the number of events dropped since the previous report, if there are any.
+// It returns the total number of dropped events that have been reported.
+func (aw *asyncWriter) reportDropped(reported uint64) uint64 {
+	total := aw.dropped.Load()
+	if total > reported {
+		log := zerolog.New(aw.output)
+		log.Warn().Timestamp().Uint64("dropped_events", total-reported).Msg(AsyncDroppedMessage)
+	}
+	return total
+}
+
+func (aw *asyncWriter) droppedEvents() uint64 {
+	return aw.dropped.Load()
+}
+
+// Close writes the queued events to the output and stops the background goroutine. It doesn't close the output.
+func (aw *asyncWriter) Close() error {
+	aw.closeOnce.Do(func() {
+		aw.lock.Lock()
+		aw.closed = true
+		close(aw.queue)
+		aw.lock.Unlock()
+	})
+	<-aw.done
+	return nil
+}
+
+func (aw *asyncWriter) closeWithHandle() error {
+	return aw.Close()
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// levelRecorder records the level and data of each write. If unblock is set, writes wait until it's closed.
+type levelRecorder struct {
+	lock    sync.Mutex
+	levels  []zerolog.Level
+	lines   []string
+	unblock chan struct{}
+	closed  bool
+}
+
+func (lr *levelRecorder) Write(p []byte) (n int, err error) {
+	return lr.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (lr *levelRecorder) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if lr.unblock != nil {
+		<-lr.unblock
+	}
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	lr.levels = append(lr.levels, level)
+	lr.lines = append(lr.lines, string(p))
+	return len(p), nil
+}
+
+func (lr *levelRecorder) Close() error {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	lr.closed = true
+	return nil
+}
+
+func registerLevelRecorder(recorder *levelRecorder) {
+	zeroconfig.RegisterWriter("async-test", func(*zeroconfig.WriterConfig) (io.Writer, error) {
+		return recorder, nil
+	})
+}
+
+func TestWriterConfig_Compile_Async(t *testing.T) {
+	recorder := &levelRecorder{}
+	registerLevelRecorder(recorder)
+	handle, err := parseConfig(t, `{
+	  "writers": [{"type": "async-test", "async": true, "min_level": "info", "max_level": "warn"}],
+	  "min_level": "debug",
+	  "timestamp": false
+	}`).CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Debug().Msg("too low")
+	handle.Logger.Info().Msg("info")
+	handle.Logger.Warn().Msg("warn")
+	handle.Logger.Error().Msg("too high")
+	require.NoError(t, handle.Close())
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	assert.Equal(t, []zerolog.Level{zerolog.InfoLevel, zerolog.WarnLevel}, recorder.levels, "Levels should be kept through the queue")
+	assert.Equal(t, []string{
+		`{"level":"info","message":"info"}` + "\n",
+		`{"level":"warn","message":"warn"}` + "\n",
+	}, recorder.lines, "Closing should write the queued events")
+	assert.True(t, recorder.closed, "The output should be closed after flushing")
+}
+
+func TestWriterConfig_Compile_AsyncDrop(t *testing.T) {
+	recorder := &levelRecorder{unblock: make(chan struct{})}
+	registerLevelRecorder(recorder)
+	handle, err := parseConfig(t, `{
+	  "writers": [{"type": "group", "async": true, "async_buffer_size": 2, "writers": [{"type": "async-test"}]}],
+	  "timestamp": false
+	}`).CompileHandle()
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		handle.Logger.Info().Int("i", i).Msg("meow")
+	}
+	dropped := handle.Stats().Writers[0].Dropped
+	// One event may have been taken by the background goroutine, which is now blocked writing it.
+	assert.Contains(t, []uint64{7, 8}, dropped)
+	close(recorder.unblock)
+	require.NoError(t, handle.Close())
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	assert.Len(t, recorder.lines, 10-int(dropped))
+}
+
+func TestWriterConfig_Compile_AsyncStandalone(t *testing.T) {
+	recorder := &levelRecorder{}
+	registerLevelRecorder(recorder)
+	writer, err := (&zeroconfig.WriterConfig{Type: "async-test", Async: true}).Compile()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(`{"message":"meow"}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.(io.Closer).Close())
+	assert.Equal(t, []string{`{"message":"meow"}` + "\n"}, recorder.lines)
+	_, err = writer.Write([]byte(`{"message":"late"}` + "\n"))
+	assert.Error(t, err, "Writes after closing should fail")
+
+	_, err = (&zeroconfig.WriterConfig{Type: "async-test", Async: true, AsyncBufferSize: -1}).Compile()
+	assert.EqualError(t, err, "async_buffer_size can't be negative")
+}
+
+func TestWriterConfig_Compile_AsyncReportDrops(t *testing.T) {
+	recorder := &levelRecorder{unblock: make(chan struct{})}
+	registerLevelRecorder(recorder)
+	handle, err := parseConfig(t, `{
+	  "writers": [{"type": "async-test", "async": true, "async_buffer_size": 1, "async_report_drops": true}],
+	  "timestamp": false
+	}`).CompileHandle()
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		handle.Logger.Info().Int("i", i).Msg("meow")
+	}
+	dropped := handle.Stats().Writers[0].Dropped
+	require.NotZero(t, dropped)
+	close(recorder.unblock)
+	require.NoError(t, handle.Close())
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	require.Len(t, recorder.lines, 5-int(dropped)+1)
+	report := recorder.lines[len(recorder.lines)-1]
+	assert.Equal(t, zerolog.WarnLevel, recorder.levels[len(recorder.levels)-1])
+	assert.Contains(t, report, fmt.Sprintf(`"dropped_events":%d,"message":"%s"`, dropped, zeroconfig.AsyncDroppedMessage))
+}
+
+func TestWriterConfig_Compile_AsyncBlock(t *testing.T) {
+	recorder := &levelRecorder{unblock: make(chan struct{})}
+	registerLevelRecorder(recorder)
+	handle, err := parseConfig(t, `{
+	  "writers": [{"type": "async-test", "async": true, "async_buffer_size": 1, "async_on_full": "block"}],
+	  "timestamp": false
+	}`).CompileHandle()
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			handle.Logger.Info().Int("i", i).Msg("meow")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Logging should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(recorder.unblock)
+	<-done
+	require.NoError(t, handle.Close())
+	assert.Zero(t, handle.Stats().Writers[0].Dropped)
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	assert.Len(t, recorder.lines, 5)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// AtomicLevel is a minimum log level that can be changed while the logger is running, see Config.AtomicLevel.
+// It's safe for concurrent use.
+type AtomicLevel struct {
+	level atomic.Int32
+}
+
+// NewAtomicLevel creates a new AtomicLevel with the given initial level.
+func NewAtomicLevel(level zerolog.Level) *AtomicLevel {
+	var al AtomicLevel
+	al.SetLevel(level)
+	return &al
+}
+
+// Level returns the current minimum level.
+func (al *AtomicLevel) Level() zerolog.Level {
+	return zerolog.Level(al.level.Load())
+}
+
+// SetLevel changes the minimum level. It affects all loggers compiled with this AtomicLevel, including their children.
+func (al *AtomicLevel) SetLevel(level zerolog.Level) {
+	al.level.Store(int32(level))
+}
+
+// WithAtomicLevel sets Config.AtomicLevel.
+func WithAtomicLevel(level *AtomicLevel) CompileOption {
+	return func(c *Config) {
+		c.AtomicLevel = level
+	}
+}
+
+// atomicLevelSampler checks the AtomicLevel before zerolog creates events, so that disabled events cost nothing.
+type atomicLevelSampler struct {
+	level *AtomicLevel
+}
+
+func (als atomicLevelSampler) Sample(level zerolog.Level) bool {
+	minLevel := als.level.Level()
+	return minLevel != zerolog.Disabled && level >= minLevel
+}
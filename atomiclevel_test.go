@@ -0,0 +1,77 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_AtomicLevel(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	level := zeroconfig.NewAtomicLevel(zerolog.TraceLevel)
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "min_level": "info",
+	  "timestamp": false
+	}`, zeroconfig.WithAtomicLevel(level))
+	assert.Equal(t, zerolog.InfoLevel, level.Level(), "Compile should store the configured min level")
+	child := log.With().Str("component", "meow").Logger()
+
+	log.Debug().Msg("hidden")
+	level.SetLevel(zerolog.DebugLevel)
+	log.Debug().Msg("shown")
+	child.Debug().Msg("child")
+	level.SetLevel(zerolog.WarnLevel)
+	log.Info().Msg("hidden")
+	log.Warn().Msg("warning")
+	level.SetLevel(zerolog.Disabled)
+	log.Error().Msg("hidden")
+
+	assert.Equal(t, `{"level":"debug","message":"shown"}`+"\n"+
+		`{"level":"debug","component":"meow","message":"child"}`+"\n"+
+		`{"level":"warn","message":"warning"}`+"\n", out.String())
+}
+
+func TestConfig_Compile_AtomicLevelDisabled(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	level := zeroconfig.NewAtomicLevel(zerolog.InfoLevel)
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "min_level": "disabled",
+	  "timestamp": false
+	}`, zeroconfig.WithAtomicLevel(level))
+	log.Error().Msg("hidden")
+	level.SetLevel(zerolog.InfoLevel)
+	log.Info().Msg("enabled later")
+	assert.Equal(t, `{"level":"info","message":"enabled later"}`+"\n", out.String())
+}
+
+func TestAtomicLevel_Concurrent(t *testing.T) {
+	level := zeroconfig.NewAtomicLevel(zerolog.InfoLevel)
+	log := compile(t, `{"writers": [{"type": "discard"}]}`, zeroconfig.WithAtomicLevel(level))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				level.SetLevel(zerolog.Level(j % 3))
+				log.Info().Int("worker", i).Msg("meow")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
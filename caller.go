@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// CallerFormat describes how the caller field is formatted.
+type CallerFormat string
+
+const (
+	// CallerFormatFull uses zerolog.CallerMarshalFunc, which writes the full path by default.
+	CallerFormatFull CallerFormat = "full"
+	// CallerFormatShort only writes the file name and line, like file.go:123.
+	CallerFormatShort CallerFormat = "short"
+	// CallerFormatRelative writes the path relative to Config.CallerRoot, like pkg/file.go:123.
+	// Files outside the root are written with the full path.
+	CallerFormatRelative CallerFormat = "relative"
+)
+
+// compileCallerHook returns the hook that adds the caller field, or nil if zerolog's own caller hook can be used.
+func (c *Config) compileCallerHook() (zerolog.Hook, error) {
+	if (c.CallerFormat == "" || c.CallerFormat == CallerFormatFull) && c.CallerSkipFrames == 0 {
+		return nil, nil
+	}
+	hook := callerHook{format: c.CallerFormat, skip: c.CallerSkipFrames, root: c.CallerRoot}
+	if hook.format == CallerFormatRelative && hook.root == "" {
+		var err error
+		if hook.root, err = os.Getwd(); err != nil {
+			return nil, fmt.Errorf("failed to get working directory for caller_root: %w", err)
+		}
+	}
+	return hook, nil
+}
+
+// callerHook adds the caller field with a custom format. Like stackHook, it finds the caller by skipping
+// zerolog and zeroconfig frames, and then skips the configured number of extra frames (e.g. logging helpers).
+//
+// Unlike changing zerolog.CallerMarshalFunc, this only affects loggers compiled from the config,
+// so configs with different caller formats can be used at the same time.
+// defaultCallerHook adds the caller in the same format as zerolog's Context.Caller.
+var defaultCallerHook = callerHook{format: CallerFormatFull}
+
+type callerHook struct {
+	format CallerFormat
+	skip   int
+	root   string
+}
+
+func (ch callerHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	pcs := make([]uintptr, ch.skip+16)
+	// Skip runtime.Callers and this function.
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	frames := runtime.CallersFrames(pcs)
+	skip := ch.skip
+	for {
+		frame, more := frames.Next()
+		if _, internal := internalPackages[funcPackage(frame.Function)]; !internal {
+			if skip == 0 {
+				e.Str(zerolog.CallerFieldName, ch.formatFrame(frame))
+				return
+			}
+			skip--
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+func (ch callerHook) formatFrame(frame runtime.Frame) string {
+	switch ch.format {
+	case CallerFormatShort:
+		return filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+	case CallerFormatRelative:
+		rel, err := filepath.Rel(ch.root, frame.File)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel) + ":" + strconv.Itoa(frame.Line)
+		}
+		fallthrough
+	default:
+		return zerolog.CallerMarshalFunc(frame.PC, frame.File, frame.Line)
+	}
+}
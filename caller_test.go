@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// logViaHelper logs through an extra function, like a wrapper package would, and returns the line it logged on.
+func logViaHelper(log *zerolog.Logger, msg string) int {
+	line := currentLine() + 1
+	log.Info().Msg(msg)
+	return line
+}
+
+func currentLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+func callerOf(t *testing.T, out *bytes.Buffer) string {
+	var evt struct {
+		Caller string `json:"caller"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &evt))
+	out.Reset()
+	return evt.Caller
+}
+
+func TestConfig_Compile_CallerFormat(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	_, file, _, _ := runtime.Caller(0)
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{`"caller_format": "full"`, file},
+		{`"caller_format": "short"`, "caller_test.go"},
+		{`"caller_format": "relative"`, "caller_test.go"},
+		{`"caller_format": "relative", "caller_root": "` + filepath.ToSlash(filepath.Dir(wd)) + `"`, filepath.Base(wd) + "/caller_test.go"},
+		{`"caller_format": "relative", "caller_root": "/nonexistent"`, file},
+	}
+	for _, test := range tests {
+		log := compile(t, `{"writers": [{"type": "stdout"}], "caller": true, `+test.config+`}`)
+		line := currentLine() + 1
+		log.Info().Msg("meow")
+		assert.Equal(t, fmt.Sprintf("%s:%d", test.expected, line), callerOf(t, &out), test.config)
+	}
+}
+
+func TestConfig_Compile_CallerSkipFrames(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	for _, format := range []string{"full", "short"} {
+		log := compile(t, `{"writers": [{"type": "stdout"}], "caller": true, "caller_skip_frames": 1, "caller_format": "`+format+`"}`)
+		line := currentLine() + 1
+		logViaHelper(log, "meow")
+		assert.Regexp(t, fmt.Sprintf(`caller_test\.go:%d$`, line), callerOf(t, &out), "The helper function should be skipped")
+	}
+
+	log := compile(t, `{"writers": [{"type": "stdout"}], "caller": true}`)
+	helperLine := logViaHelper(log, "meow")
+	assert.Regexp(t, fmt.Sprintf(`caller_test\.go:%d$`, helperLine), callerOf(t, &out), "Without skipping, the helper should be the caller")
+}
+
+func TestConfig_Compile_CallerInvalid(t *testing.T) {
+	_, err := parseConfig(t, `{"writers": [{"type": "stdout"}], "caller_format": "tiny", "caller_skip_frames": -1}`).Compile()
+	assert.EqualError(t, err, "caller_format: unknown caller_format \"tiny\"; caller_skip_frames: must not be negative")
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// LogEvent is a single log event captured by CaptureDuring.
+type LogEvent struct {
+	Level   zerolog.Level
+	Message string
+	// All fields in the event, including the message and level.
+	Fields map[string]any
+	// The raw JSON of the event.
+	Raw json.RawMessage
+}
+
+type captureWriter struct {
+	lock   sync.Mutex
+	events []LogEvent
+}
+
+func (cw *captureWriter) Write(p []byte) (n int, err error) {
+	return cw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (cw *captureWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	evt := LogEvent{
+		Level: level,
+		Raw:   bytes.TrimSpace(append([]byte(nil), p...)),
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if dec.Decode(&evt.Fields) == nil {
+		evt.Message, _ = evt.Fields[zerolog.MessageFieldName].(string)
+	}
+	cw.lock.Lock()
+	cw.events = append(cw.events, evt)
+	cw.lock.Unlock()
+	return len(p), nil
+}
+
+// CaptureDuring redirects the output of the given logger into a buffer while fn runs,
+// then restores the original output and returns the captured events.
+//
+// Only events logged through this logger are captured. Child loggers created from it before
+// the call keep writing to the original output, while child loggers created inside fn write
+// into the capture buffer, so anything they log after fn returns is discarded.
+//
+// Replacing the output modifies the logger in place, so the logger must not be used from other
+// goroutines that were started outside fn while the capture is active. Goroutines started and
+// waited for inside fn may log concurrently.
+func CaptureDuring(logger *zerolog.Logger, fn func()) []LogEvent {
+	var capture captureWriter
+	original := *logger
+	*logger = logger.Output(&capture)
+	defer func() {
+		*logger = original
+	}()
+	fn()
+	capture.lock.Lock()
+	defer capture.lock.Unlock()
+	return capture.events
+}
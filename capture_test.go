@@ -0,0 +1,64 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestCaptureDuring(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "metadata": {"service": "meow"},
+	  "min_level": "debug",
+	  "timestamp": false
+	}`)
+	other := log.With().Str("other", "logger").Logger()
+
+	log.Info().Msg("before")
+	events := zeroconfig.CaptureDuring(log, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				log.Warn().Int("num", i).Msg("captured")
+			}(i)
+		}
+		wg.Wait()
+		log.Trace().Msg("below minimum level")
+		other.Info().Msg("not captured")
+	})
+	log.Info().Msg("after")
+
+	require.Len(t, events, 10, "Should capture all events logged through the logger")
+	for _, evt := range events {
+		assert.Equal(t, zerolog.WarnLevel, evt.Level)
+		assert.Equal(t, "captured", evt.Message)
+		assert.Equal(t, "meow", evt.Fields["service"], "Captured events should include metadata")
+		assert.True(t, json.Valid(evt.Raw))
+	}
+
+	dec := json.NewDecoder(&out)
+	for _, expected := range []string{"before", "not captured", "after"} {
+		var ll logLine
+		require.NoError(t, dec.Decode(&ll))
+		assert.Equal(t, expected, ll.Message, "Original output should only have uncaptured events")
+	}
+	assert.False(t, dec.More())
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// CompileOption changes programmatic settings of a Config for a single Compile call,
+// without modifying the Config itself.
+type CompileOption func(*Config)
+
+// WithClock sets Config.TimestampSource, e.g. to a function returning a fixed time in tests.
+func WithClock(now func() time.Time) CompileOption {
+	return func(c *Config) {
+		c.TimestampSource = now
+	}
+}
+
+// applyOptions returns a shallow copy of the config with the given options applied,
+// or the config itself if there are no options.
+func (c *Config) applyOptions(opts []CompileOption) *Config {
+	if len(opts) == 0 {
+		return c
+	}
+	cp := *c
+	for _, opt := range opts {
+		opt(&cp)
+	}
+	return &cp
+}
+
+// now returns the current time from TimestampSource, or time.Now if it's not set.
+func (c *Config) now() time.Time {
+	if c.TimestampSource != nil {
+		return c.TimestampSource()
+	}
+	return time.Now()
+}
+
+// timestampHook adds the timestamp field using a custom time source,
+// so that zerolog.TimestampFunc doesn't need to be changed.
+type timestampHook struct {
+	source func() time.Time
+}
+
+func (th timestampHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	e.Time(zerolog.TimestampFieldName, th.source())
+}
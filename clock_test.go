@@ -0,0 +1,76 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func frozenClock() time.Time {
+	return time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)
+}
+
+func TestWithClock_Deterministic(t *testing.T) {
+	cfg := `{
+	  "writers": [
+	    {"type": "stdout"},
+	    {"type": "stdout", "format": "pretty", "time_format": "2006-01-02 15:04:05"}
+	  ],
+	  "include_uptime": true,
+	  "min_level": "debug"
+	}`
+	run := func() []byte {
+		var out bytes.Buffer
+		zeroconfig.Stdout = &out
+		log := compile(t, cfg, zeroconfig.WithClock(frozenClock))
+		log.Info().Int("cats", 5).Msg("meow")
+		log.Debug().Str("sound", "purr").Msg("hmm")
+		return out.Bytes()
+	}
+	first := run()
+	// The pretty writer renders times in the local time zone.
+	local := frozenClock().Local().Format("2006-01-02 15:04:05")
+	assert.Equal(t, `{"level":"info","cats":5,"time":"2023-03-14T15:09:26Z","uptime":0,"message":"meow"}
+`+local+` INF meow cats=5 uptime=0
+{"level":"debug","sound":"purr","time":"2023-03-14T15:09:26Z","uptime":0,"message":"hmm"}
+`+local+` DBG hmm sound=purr uptime=0
+`, string(first))
+	assert.Equal(t, first, run(), "Repeated runs should produce identical output")
+}
+
+func TestWithClock_DoesntModifyConfig(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}}
+	log, err := cfg.Compile(zeroconfig.WithClock(frozenClock))
+	require.NoError(t, err)
+	assert.Nil(t, cfg.TimestampSource)
+	log.Info().Msg("meow")
+	assert.Contains(t, out.String(), `"time":"2023-03-14T15:09:26Z"`)
+}
+
+func TestConfig_TimestampSource_Disabled(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	noTimestamp := false
+	cfg := zeroconfig.Config{
+		Writers:         []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		Timestamp:       &noTimestamp,
+		TimestampSource: frozenClock,
+	}
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", out.String())
+}
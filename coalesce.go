@@ -0,0 +1,199 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// CoalesceFieldName is the field that contains the number of coalesced events in coalesce summary events.
+var CoalesceFieldName = "coalesced_count"
+
+const defaultCoalesceMaxKeys = 1000
+
+// CoalesceConfig contains the configuration for coalescing repeated events.
+//
+// The first event with a given level and message is written normally. Further events with the same level and
+// message are dropped, and at the end of each window, a summary event saying how many times the message occurred
+// is written instead. The summary is a copy of the first event with the message and timestamp replaced.
+type CoalesceConfig struct {
+	// The length of each window.
+	Window time.Duration `json:"window,omitempty" yaml:"window,omitempty" toml:"window,omitempty"`
+	// Maximum number of distinct messages to track. Events with new messages are written normally when the limit
+	// is reached. Defaults to 1000.
+	MaxKeys int `json:"max_keys,omitempty" yaml:"max_keys,omitempty" toml:"max_keys,omitempty"`
+	// Only coalesce events at or above this level. Defaults to warn.
+	MinLevel *zerolog.Level `json:"min_level,omitempty" yaml:"min_level,omitempty" toml:"min_level,omitempty"`
+}
+
+func (cc *CoalesceConfig) UnmarshalJSON(data []byte) error {
+	type plainCoalesceConfig CoalesceConfig
+	aux := struct {
+		*plainCoalesceConfig
+		Window   json.RawMessage `json:"window,omitempty"`
+		MinLevel json.RawMessage `json:"min_level,omitempty"`
+	}{plainCoalesceConfig: (*plainCoalesceConfig)(cc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.Window, &cc.Window); err != nil {
+		return &PathError{Path: "window", Err: err}
+	} else if err = parseLevelJSON(aux.MinLevel, &cc.MinLevel); err != nil {
+		return &PathError{Path: "min_level", Err: err}
+	}
+	return nil
+}
+
+type coalesceEntry struct {
+	level zerolog.Level
+	event jsonObject
+	count uint64
+}
+
+// coalesceWriter drops repeated events and periodically writes summaries of them.
+type coalesceWriter struct {
+	zerolog.LevelWriter
+	window   time.Duration
+	maxKeys  int
+	minLevel zerolog.Level
+
+	lock    sync.Mutex
+	entries map[string]*coalesceEntry
+	// Counter for events that were dropped and included in a summary instead.
+	coalesced atomic.Uint64
+}
+
+func (cc *CoalesceConfig) compile(writer io.Writer) (*coalesceWriter, error) {
+	if cc.Window <= 0 {
+		return nil, fmt.Errorf("coalesce window must be positive")
+	} else if cc.MaxKeys < 0 {
+		return nil, fmt.Errorf("coalesce max_keys can't be negative")
+	}
+	lw := asLevelWriter(writer)
+	cw := &coalesceWriter{
+		LevelWriter: lw,
+		window:      cc.Window,
+		maxKeys:     cc.MaxKeys,
+		minLevel:    zerolog.WarnLevel,
+		entries:     make(map[string]*coalesceEntry),
+	}
+	if cw.maxKeys == 0 {
+		cw.maxKeys = defaultCoalesceMaxKeys
+	}
+	if cc.MinLevel != nil {
+		cw.minLevel = *cc.MinLevel
+	}
+	return cw, nil
+}
+
+func (cw *coalesceWriter) Write(p []byte) (n int, err error) {
+	return cw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (cw *coalesceWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if level == zerolog.NoLevel || level < cw.minLevel {
+		return cw.LevelWriter.WriteLevel(level, p)
+	}
+	obj, err := parseJSONObject(p)
+	if err != nil {
+		return cw.LevelWriter.WriteLevel(level, p)
+	}
+	rawMessage, _ := obj.get(zerolog.MessageFieldName)
+	var message string
+	_ = json.Unmarshal(rawMessage, &message)
+	key := strconv.Itoa(int(level)) + ":" + message
+	cw.lock.Lock()
+	entry, ok := cw.entries[key]
+	if ok {
+		entry.count++
+	} else if len(cw.entries) < cw.maxKeys {
+		cw.entries[key] = &coalesceEntry{level: level, event: obj}
+	}
+	cw.lock.Unlock()
+	if ok {
+		cw.coalesced.Add(1)
+		return len(p), nil
+	}
+	return cw.LevelWriter.WriteLevel(level, p)
+}
+
+// formatTimestamp formats the current time like zerolog's Timestamp does.
+func formatTimestamp() json.RawMessage {
+	now := zerolog.TimestampFunc()
+	switch zerolog.TimeFieldFormat {
+	case zerolog.TimeFormatUnix:
+		return strconv.AppendInt(nil, now.Unix(), 10)
+	case zerolog.TimeFormatUnixMs:
+		return strconv.AppendInt(nil, now.UnixMilli(), 10)
+	case zerolog.TimeFormatUnixMicro:
+		return strconv.AppendInt(nil, now.UnixMicro(), 10)
+	case zerolog.TimeFormatUnixNano:
+		return strconv.AppendInt(nil, now.UnixNano(), 10)
+	default:
+		return appendJSONString(nil, now.Format(zerolog.TimeFieldFormat))
+	}
+}
+
+// flush writes summaries for all messages that were repeated in the current window and forgets messages that weren't.
+func (cw *coalesceWriter) flush() {
+	type summary struct {
+		level zerolog.Level
+		line  []byte
+	}
+	var summaries []summary
+	cw.lock.Lock()
+	for _, key := range sortedKeys(cw.entries) {
+		entry := cw.entries[key]
+		if entry.count == 0 {
+			delete(cw.entries, key)
+			continue
+		}
+		event := make(jsonObject, 0, len(entry.event)+1)
+		var message string
+		for _, field := range entry.event {
+			switch field.Key {
+			case zerolog.MessageFieldName:
+				_ = json.Unmarshal(field.Value, &message)
+				continue
+			case zerolog.TimestampFieldName:
+				field.Value = formatTimestamp()
+			}
+			event = append(event, field)
+		}
+		event = append(event,
+			jsonField{Key: CoalesceFieldName, Value: strconv.AppendUint(nil, entry.count, 10)},
+			jsonField{Key: zerolog.MessageFieldName, Value: appendJSONString(nil, fmt.Sprintf("%s occurred %d times in the last %s", message, entry.count, cw.window))},
+		)
+		summaries = append(summaries, summary{level: entry.level, line: append(event.appendTo(nil), '\n')})
+		entry.count = 0
+	}
+	cw.lock.Unlock()
+	for _, s := range summaries {
+		_, _ = cw.LevelWriter.WriteLevel(s.level, s.line)
+	}
+}
+
+func (cw *coalesceWriter) loop(stop <-chan struct{}) {
+	ticker := time.NewTicker(cw.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			cw.flush()
+			return
+		case <-ticker.C:
+			cw.flush()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/zeroconfig"
+	"go.mau.fi/zeroconfig/logtest"
+)
+
+func TestConfig_Compile_Coalesce(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "coalesce": {"window": "1h"}
+	}`), &cfg))
+	assert.Equal(t, time.Hour, cfg.Coalesce.Window)
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		handle.Logger.Warn().Int("i", i).Msg("Disk is slow")
+		handle.Logger.Info().Msg("Still working")
+	}
+	handle.Logger.Error().Msg("Disk is slow")
+	handle.Logger.Error().Msg("Disk is slow")
+	assert.Equal(t, zeroconfig.Stats{Writers: []zeroconfig.WriterStats{{Type: zeroconfig.WriterTypeStdout}}, Coalesced: 5}, handle.Stats())
+	require.NoError(t, handle.Close())
+	logtest.AssertJSONLines(t, out.Bytes(),
+		logtest.Line{"level": "warn", "i": 0, "message": "Disk is slow"},
+		logtest.Line{"level": "info", "message": "Still working"},
+		logtest.Line{"level": "info", "message": "Still working"},
+		logtest.Line{"level": "info", "message": "Still working"},
+		logtest.Line{"level": "info", "message": "Still working"},
+		logtest.Line{"level": "info", "message": "Still working"},
+		logtest.Line{"level": "error", "message": "Disk is slow"},
+		// Summaries are written at the end of the window (or when the handle is closed).
+		logtest.Line{"level": "warn", "i": 0, "coalesced_count": 4, "message": "Disk is slow occurred 4 times in the last 1h0m0s"},
+		logtest.Line{"level": "error", "coalesced_count": 1, "message": "Disk is slow occurred 1 times in the last 1h0m0s"},
+	)
+}
+
+func TestConfig_Compile_CoalesceMaxKeys(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var cfg zeroconfig.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+writers: [{type: stdout}]
+timestamp: false
+coalesce:
+  window: 1h
+  max_keys: 1
+  min_level: error
+`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Warn().Msg("Not coalesced")
+	handle.Logger.Warn().Msg("Not coalesced")
+	handle.Logger.Error().Msg("Tracked")
+	handle.Logger.Error().Msg("Tracked")
+	handle.Logger.Error().Msg("Untracked")
+	handle.Logger.Error().Msg("Untracked")
+	require.NoError(t, handle.Close())
+	logtest.AssertJSONLines(t, out.Bytes(),
+		logtest.Line{"level": "warn", "message": "Not coalesced"},
+		logtest.Line{"level": "warn", "message": "Not coalesced"},
+		logtest.Line{"level": "error", "message": "Tracked"},
+		logtest.Line{"level": "error", "message": "Untracked"},
+		logtest.Line{"level": "error", "message": "Untracked"},
+		logtest.Line{"level": "error", "coalesced_count": 1, "message": "Tracked occurred 1 times in the last 1h0m0s"},
+	)
+}
+
+func TestConfig_Compile_CoalesceWindow(t *testing.T) {
+	var out lockedBuffer
+	zeroconfig.Stdout = &out
+	cfg := zeroconfig.Config{
+		Writers:  []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		Coalesce: &zeroconfig.CoalesceConfig{Window: 20 * time.Millisecond},
+	}
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	handle.Logger.Warn().Msg("meow")
+	handle.Logger.Warn().Msg("meow")
+	require.Eventually(t, func() bool {
+		return bytes.Count(out.Bytes(), []byte("\n")) == 2
+	}, time.Second, 5*time.Millisecond, "Summary should be written at the end of the window")
+	logtest.AssertJSONLines(t, out.Bytes(),
+		logtest.Line{"level": "warn", "time": logtest.Ignore, "message": "meow"},
+		logtest.Line{"level": "warn", "time": logtest.Regex(`^\d{4}-`), "coalesced_count": 1, "message": "meow occurred 1 times in the last 20ms"},
+	)
+	// The key is forgotten after a window without repeats, so the next event is written normally.
+	time.Sleep(50 * time.Millisecond)
+	handle.Logger.Warn().Msg("meow")
+	assert.Equal(t, 3, bytes.Count(out.Bytes(), []byte("\n")))
+}
@@ -7,13 +7,17 @@
 package zeroconfig
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // SyslogConfig contains the configuration options for the syslog writer.
@@ -21,10 +25,35 @@ import (
 // See https://pkg.go.dev/log/syslog for exact details.
 type SyslogConfig struct {
 	// All parameters are passed to https://pkg.go.dev/log/syslog#Dial directly.
-	Network string `json:"network,omitempty" yaml:"network,omitempty"`
-	Host    string `json:"host,omitempty" yaml:"host,omitempty"`
-	Flags   int    `json:"flags,omitempty" yaml:"flags,omitempty"`
-	Tag     string `json:"tag,omitempty" yaml:"tag,omitempty"`
+	Network string `json:"network,omitempty" yaml:"network,omitempty" toml:"network,omitempty"`
+	Host    string `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
+	Flags   int    `json:"flags,omitempty" yaml:"flags,omitempty" toml:"flags,omitempty"`
+	Tag     string `json:"tag,omitempty" yaml:"tag,omitempty" toml:"tag,omitempty"`
+	// Don't connect until the first event is written. By default, Compile fails if the syslog server
+	// isn't reachable, or if the host is a socket path that doesn't exist.
+	LazyConnect bool `json:"lazy_connect,omitempty" yaml:"lazy_connect,omitempty" toml:"lazy_connect,omitempty"`
+	// The syslog facility by name (e.g. daemon or local0). Overrides the facility in Flags.
+	Facility string `json:"facility,omitempty" yaml:"facility,omitempty" toml:"facility,omitempty"`
+	// What to send as the syslog message. Defaults to the whole formatted event.
+	Payload SyslogPayload `json:"payload,omitempty" yaml:"payload,omitempty" toml:"payload,omitempty"`
+}
+
+// SyslogPayload describes what the syslog writer sends as the message of each entry.
+type SyslogPayload string
+
+const (
+	// SyslogPayloadEvent sends the whole formatted event (e.g. the JSON object). This is the default.
+	SyslogPayloadEvent SyslogPayload = "event"
+	// SyslogPayloadMessage sends only the message field, leaving out the level, timestamp and other fields.
+	// It requires the json format.
+	SyslogPayloadMessage SyslogPayload = "message"
+)
+
+// JournaldConfig contains the configuration options for the journald writer.
+type JournaldConfig struct {
+	// Path to the journald native protocol socket. Defaults to using zerolog's journald writer,
+	// which sends to /run/systemd/journal/socket.
+	Socket string `json:"socket,omitempty" yaml:"socket,omitempty" toml:"socket,omitempty"`
 }
 
 // FileConfig contains the configuration options for the file writer.
@@ -32,17 +61,62 @@ type SyslogConfig struct {
 // See https://github.com/natefinch/lumberjack for exact details.
 type FileConfig struct {
 	// File name for the current log. Backups will be stored in the same directory, named as name-<timestamp>.ext
-	Filename string `json:"filename,omitempty" yaml:"filename,omitempty"`
+	// Environment variables like $LOG_DIR or ${HOME} are expanded unless ExpandEnv is false.
+	Filename string `json:"filename,omitempty" yaml:"filename,omitempty" toml:"filename,omitempty"`
+	// Should environment variables in Filename be expanded? Undefined variables expand to an empty string.
+	// Defaults to true.
+	ExpandEnv *bool `json:"expand_env,omitempty" yaml:"expand_env,omitempty" toml:"expand_env,omitempty"`
 	// Maximum size in megabytes for the log file before rotating. Defaults to 100 megabytes.
-	MaxSize int `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+	MaxSize int `json:"max_size,omitempty" yaml:"max_size,omitempty" toml:"max_size,omitempty"`
 	// Maximum age of rotated log files to keep as days. Defaults to no limit.
-	MaxAge int `json:"max_age,omitempty" yaml:"max_age,omitempty"`
+	MaxAge int `json:"max_age,omitempty" yaml:"max_age,omitempty" toml:"max_age,omitempty"`
 	// Maximum number of rotated log files to keep. Defaults to no limit.
-	MaxBackups int `json:"max_backups,omitempty" yaml:"max_backups,omitempty"`
+	MaxBackups int `json:"max_backups,omitempty" yaml:"max_backups,omitempty" toml:"max_backups,omitempty"`
 	// Should rotated log file names use local time instead of UTC? Defaults to false.
-	LocalTime bool `json:"local_time,omitempty" yaml:"local_time,omitempty"`
+	LocalTime bool `json:"local_time,omitempty" yaml:"local_time,omitempty" toml:"local_time,omitempty"`
 	// Should rotated log files be compressed with gzip? Defaults to false.
-	Compress bool `json:"compress,omitempty" yaml:"compress,omitempty"`
+	Compress bool `json:"compress,omitempty" yaml:"compress,omitempty" toml:"compress,omitempty"`
+	// The gzip compression level to use for rotated files: 1-9, fast, best or default. Only applies if Compress is true.
+	// Defaults to gzip's default level.
+	CompressLevel CompressLevel `json:"compress_level,omitempty" yaml:"compress_level,omitempty" toml:"compress_level,omitempty"`
+	// What to do when writing fails because the disk is full: error, stderr or purge-oldest. Defaults to error.
+	OnDiskFull DiskFullPolicy `json:"on_disk_full,omitempty" yaml:"on_disk_full,omitempty" toml:"on_disk_full,omitempty"`
+	// How to move the current file aside when rotating: rename or copytruncate. Defaults to DefaultRotationMode.
+	RotationMode RotationMode `json:"rotation_mode,omitempty" yaml:"rotation_mode,omitempty" toml:"rotation_mode,omitempty"`
+	// Start a new file every hour or day even if MaxSize isn't reached. The period is embedded in the file name,
+	// like name-2006-01-02.ext, and MaxAge and MaxBackups also apply to the files of previous periods.
+	// Only supported with the lumberjack backend. Defaults to no time-based rotation.
+	RotationInterval RotationInterval `json:"rotation_interval,omitempty" yaml:"rotation_interval,omitempty" toml:"rotation_interval,omitempty"`
+	// A line to write at the start of every new file, including files created by rotation.
+	// A newline is added if it doesn't end with one. Only supported with the lumberjack backend.
+	HeaderLine string `json:"header_line,omitempty" yaml:"header_line,omitempty" toml:"header_line,omitempty"`
+	// The name of the file backend to use, see RegisterFileBackend. Defaults to lumberjack.
+	// Custom backends get the whole FileConfig, so they can decide which other options they support.
+	Backend string `json:"backend,omitempty" yaml:"backend,omitempty" toml:"backend,omitempty"`
+	// Split events into multiple files based on the hash of a field. Each shard is rotated independently.
+	HashShard *HashShardConfig `json:"hash_shard,omitempty" yaml:"hash_shard,omitempty" toml:"hash_shard,omitempty"`
+}
+
+// UnixgramConfig contains the configuration options for the unixgram writer.
+type UnixgramConfig struct {
+	// Path to the unix datagram socket.
+	Path string `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	// Maximum size of a datagram in bytes. Defaults to 65536.
+	MaxDatagramSize int `json:"max_datagram_size,omitempty" yaml:"max_datagram_size,omitempty" toml:"max_datagram_size,omitempty"`
+	// Truncate events that are larger than MaxDatagramSize instead of returning an error.
+	Truncate bool `json:"truncate,omitempty" yaml:"truncate,omitempty" toml:"truncate,omitempty"`
+}
+
+// WebSocketConfig contains the configuration options for the websocket writer.
+type WebSocketConfig struct {
+	// The ws:// or wss:// URL to connect to.
+	URL string `json:"url,omitempty" yaml:"url,omitempty" toml:"url,omitempty"`
+	// Maximum number of events to buffer while the connection is down. Defaults to 1000.
+	BufferSize int `json:"buffer_size,omitempty" yaml:"buffer_size,omitempty" toml:"buffer_size,omitempty"`
+	// A bearer token to send in the Authorization header, or a file or environment variable to read it from.
+	Token     string `json:"token,omitempty" yaml:"token,omitempty" toml:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty" yaml:"token_file,omitempty" toml:"token_file,omitempty"`
+	TokenEnv  string `json:"token_env,omitempty" yaml:"token_env,omitempty" toml:"token_env,omitempty"`
 }
 
 // WriterType is a type of writer.
@@ -63,6 +137,54 @@ const (
 	WriterTypeSyslogCEE WriterType = "syslog-cee"
 	// WriterTypeJournald writes to systemd's logging service.
 	WriterTypeJournald WriterType = "journald"
+	// WriterTypeEventlog writes to the Windows Event Log. Errors and above are logged as errors, warnings as warnings
+	// and everything else as information. The configuration is stored in the EventlogConfig struct.
+	// It's only supported on Windows.
+	WriterTypeEventlog WriterType = "eventlog"
+	// WriterTypeRingFile writes to a preallocated file that is reused as a ring buffer.
+	// The configuration is stored in the RingFileConfig struct, plus the Filename field of FileConfig.
+	WriterTypeRingFile WriterType = "ringfile"
+	// WriterTypeUnixgram sends each event as a datagram to a unix socket.
+	// The configuration is stored in the UnixgramConfig struct.
+	WriterTypeUnixgram WriterType = "unixgram"
+	// WriterTypeWebSocket sends each event as a message to a WebSocket server.
+	// The configuration is stored in the WebSocketConfig struct.
+	// It's only available when built with the zeroconfig_websocket build tag.
+	WriterTypeWebSocket WriterType = "websocket"
+	// WriterTypeTail listens for TCP connections and streams events to connected clients.
+	// The configuration is stored in the TailConfig struct.
+	// The listener is only closed when closing the Handle returned by CompileHandle.
+	WriterTypeTail WriterType = "tail"
+	// WriterTypeKV stores each event under a new key in a key-value store like consul or etcd.
+	// The configuration is stored in the KVConfig struct. It's only meant for low volumes of events.
+	WriterTypeKV WriterType = "kv"
+	// WriterTypeNetwork writes events to a TCP or UDP connection, reconnecting with a backoff if the connection is lost.
+	// The configuration is stored in the NetworkConfig struct. Events are dropped while the connection is down,
+	// and UDP writes are best-effort, as lost datagrams can't be detected. Only the json format is supported.
+	// In configs, "net" is an alias for this.
+	WriterTypeNetwork WriterType = "network"
+	// WriterTypeHTTP sends events to an HTTP endpoint as newline-delimited JSON, in batches.
+	// The configuration is stored in the HTTPConfig struct. Failed requests are retried with a backoff,
+	// after which the batch is dropped. The final batch is sent when the writer is closed.
+	WriterTypeHTTP WriterType = "http"
+	// WriterTypeGELF sends events to Graylog as GELF messages over UDP or TCP.
+	// The configuration is stored in the GELFConfig struct. Like with the network writer, events are dropped
+	// while the connection is down. Only the json format is supported.
+	WriterTypeGELF WriterType = "gelf"
+	// WriterTypeMemory keeps the most recent lines in memory, e.g. for attaching them to crash reports.
+	// The configuration is stored in the MemoryConfig struct. The output is a *MemoryBuffer, see Handle.Writers.
+	WriterTypeMemory WriterType = "memory"
+	// WriterTypeRingBuffer keeps the most recent events in a named in-memory buffer that can be read with
+	// GetRingBuffer and DumpRingBuffer, e.g. in panic handlers. The configuration is stored in the RingBufferConfig
+	// struct. Buffers are global: compiling a writer with the same name again reuses the existing buffer.
+	// Only the json format is supported.
+	WriterTypeRingBuffer WriterType = "ringbuffer"
+	// WriterTypeDiscard doesn't write anywhere, which is useful for disabling a writer in some environments
+	// while keeping it in the config. Unlike min_level: disabled, a config with only discard writers still
+	// produces a real logger, so metadata and hooks work normally. In configs, "none" is an alias for this.
+	WriterTypeDiscard WriterType = "discard"
+	// WriterTypeGroup doesn't write anywhere by itself, but contains child writers that inherit its settings.
+	WriterTypeGroup WriterType = "group"
 )
 
 // LogFormat describes how logs should be formatted for a writer.
@@ -75,33 +197,266 @@ const (
 	LogFormatPretty LogFormat = "pretty"
 	// LogFormatPrettyColored uses zerolog's console writer including color.
 	LogFormatPrettyColored LogFormat = "pretty-colored"
+	// LogFormatLogfmt outputs logs as logfmt lines (key=value pairs separated by spaces), with the timestamp,
+	// level and message first. Unlike the pretty formats, it's meant to be machine-parseable.
+	LogFormatLogfmt LogFormat = "logfmt"
+)
+
+// ColorMode describes whether the pretty formats should use colors.
+type ColorMode string
+
+const (
+	// ColorAuto uses the format to decide, but respects the NO_COLOR and FORCE_COLOR environment variables.
+	ColorAuto ColorMode = "auto"
+	// ColorAlways always uses colors for pretty formats, ignoring environment variables.
+	ColorAlways ColorMode = "always"
+	// ColorNever never uses colors, ignoring environment variables.
+	ColorNever ColorMode = "never"
 )
 
 // WriterConfig contains the configuration for an individual log writer.
 type WriterConfig struct {
 	// The type of writer.
-	Type   WriterType `json:"type" yaml:"type"`
-	Format LogFormat  `json:"format,omitempty" yaml:"format,omitempty"`
+	Type   WriterType `json:"type" yaml:"type" toml:"type"`
+	Format LogFormat  `json:"format,omitempty" yaml:"format,omitempty" toml:"format,omitempty"`
 
-	MinLevel *zerolog.Level `json:"min_level,omitempty" yaml:"min_level,omitempty"`
-	MaxLevel *zerolog.Level `json:"max_level,omitempty" yaml:"max_level,omitempty"`
+	MinLevel *zerolog.Level `json:"min_level,omitempty" yaml:"min_level,omitempty" toml:"min_level,omitempty"`
+	MaxLevel *zerolog.Level `json:"max_level,omitempty" yaml:"max_level,omitempty" toml:"max_level,omitempty"`
+	// How to handle events without a level (like ones from log.Log()): pass, drop or treat_as:<level>.
+	NoLevel NoLevelBehavior `json:"no_level,omitempty" yaml:"no_level,omitempty" toml:"no_level,omitempty"`
 
 	// Only applies when format=console or format=console-colored
-	TimeFormat string `json:"time_format,omitempty" yaml:"time_format,omitempty"`
+	TimeFormat string `json:"time_format,omitempty" yaml:"time_format,omitempty" toml:"time_format,omitempty"`
+	// Options for the pretty formats, like the order of parts and fields to hide.
+	Console *ConsoleConfig `json:"console,omitempty" yaml:"console,omitempty" toml:"console,omitempty"`
+	// Override whether colors are used in pretty formats. Takes precedence over NO_COLOR and FORCE_COLOR.
+	Color ColorMode `json:"color,omitempty" yaml:"color,omitempty" toml:"color,omitempty"`
+	// Ring the terminal bell after events at or above this level. Only applies to the pretty formats.
+	NotifyOnLevel *zerolog.Level `json:"notify_on_level,omitempty" yaml:"notify_on_level,omitempty" toml:"notify_on_level,omitempty"`
+	// Also send a desktop notification for events at or above NotifyOnLevel.
+	// Only supported on macOS and on systems with notify-send. Other platforms just get the bell.
+	// At most one notification is shown every 5 seconds, events in between are merged into the next one.
+	NotifyDesktop bool `json:"notify_desktop,omitempty" yaml:"notify_desktop,omitempty" toml:"notify_desktop,omitempty"`
+
+	// Escape <, > and & in strings (as \u003c etc) like encoding/json does by default. Only applies to the json format.
+	// Meant for writers that feed web UIs, as it makes the raw output harder to read and grep.
+	HTMLSafe bool `json:"html_safe,omitempty" yaml:"html_safe,omitempty" toml:"html_safe,omitempty"`
+	// Remove ANSI escape sequences (e.g. colors from subprocess output) from the message and other top-level
+	// string fields. Ignored for pretty formats when colors are enabled.
+	StripANSI bool `json:"strip_ansi,omitempty" yaml:"strip_ansi,omitempty" toml:"strip_ansi,omitempty"`
+
+	// Keys to remove from events written to this writer. Meant for global metadata fields,
+	// but applies to any field in the event. Keys that aren't present are ignored.
+	MetadataRemove []string `json:"metadata_remove,omitempty" yaml:"metadata_remove,omitempty" toml:"metadata_remove,omitempty"`
+	// Values to replace in events written to this writer. Like MetadataRemove,
+	// only keys that are already present in the event are affected.
+	MetadataOverride map[string]any `json:"metadata_override,omitempty" yaml:"metadata_override,omitempty" toml:"metadata_override,omitempty"`
+	// Static fields to add to events written to this writer, in addition to the global metadata.
+	// Keys that are already present in the event are left as-is. Also shown by pretty formats.
+	Fields map[string]any `json:"fields,omitempty" yaml:"fields,omitempty" toml:"fields,omitempty"`
+	// Fields to remove from events written to this writer, e.g. credentials that must not reach a scraped output.
+	// Nested fields can be specified with dots, like request.headers.authorization. Missing fields are ignored.
+	RemoveFields []string `json:"remove_fields,omitempty" yaml:"remove_fields,omitempty" toml:"remove_fields,omitempty"`
+	// Fields whose values are replaced with RedactedValue in events written to this writer.
+	// Nested fields are specified like in RemoveFields.
+	RedactFields []string `json:"redact_fields,omitempty" yaml:"redact_fields,omitempty" toml:"redact_fields,omitempty"`
+
+	// Only write events where all of these fields have the given values.
+	MatchFields map[string]any `json:"match_fields,omitempty" yaml:"match_fields,omitempty" toml:"match_fields,omitempty"`
+	// Don't write events where any of these fields have the given values.
+	ExcludeFields map[string]any `json:"exclude_fields,omitempty" yaml:"exclude_fields,omitempty" toml:"exclude_fields,omitempty"`
 
-	SyslogConfig `json:",inline,omitempty" yaml:",inline,omitempty"`
-	FileConfig   `json:",inline,omitempty" yaml:",inline,omitempty"`
+	// Only use this writer when the active environment (see Config.Environment) is one of these.
+	OnlyIn []string `json:"only_in,omitempty" yaml:"only_in,omitempty" toml:"only_in,omitempty"`
+	// Don't use this writer when the active environment is one of these.
+	ExceptIn []string `json:"except_in,omitempty" yaml:"except_in,omitempty" toml:"except_in,omitempty"`
+
+	// Sample events written to this writer. Sampling is applied after the min and max levels.
+	Sampling *SamplingConfig `json:"sampling,omitempty" yaml:"sampling,omitempty" toml:"sampling,omitempty"`
+	// Write all events normally, but switch to sampling while the event rate is high.
+	// Applied after the min and max levels, but before Sampling.
+	AdaptiveSampling *AdaptiveSamplingConfig `json:"adaptive_sampling,omitempty" yaml:"adaptive_sampling,omitempty" toml:"adaptive_sampling,omitempty"`
+
+	// Child writers for the group writer type. The children inherit the group's format, time format, color,
+	// level bounds and metadata settings unless they specify their own. The group's field filters apply to
+	// all children in addition to their own filters.
+	Writers []WriterConfig `json:"writers,omitempty" yaml:"writers,omitempty" toml:"writers,omitempty"`
+
+	// Record the count, size, latency and errors of writes to the output, see Handle.Stats.
+	// When disabled, there's no overhead at all.
+	Instrument bool `json:"instrument,omitempty" yaml:"instrument,omitempty" toml:"instrument,omitempty"`
+	// Also publish the instrumentation counters as an expvar map with this name. Requires Instrument.
+	InstrumentExpvar string `json:"instrument_expvar,omitempty" yaml:"instrument_expvar,omitempty" toml:"instrument_expvar,omitempty"`
+
+	// Write events to the output in a background goroutine, so that logging never waits for a slow output.
+	// Events are dropped (and counted in Handle.Stats) if the queue is full. Closing the Handle writes the queued events.
+	Async bool `json:"async,omitempty" yaml:"async,omitempty" toml:"async,omitempty"`
+	// Maximum number of events to queue when Async is enabled. Defaults to 1000.
+	AsyncBufferSize int `json:"async_buffer_size,omitempty" yaml:"async_buffer_size,omitempty" toml:"async_buffer_size,omitempty"`
+	// What to do when the queue is full: drop or block. Defaults to drop.
+	AsyncOnFull AsyncFullPolicy `json:"async_on_full,omitempty" yaml:"async_on_full,omitempty" toml:"async_on_full,omitempty"`
+	// Write a warning with the number of dropped events (see AsyncDroppedMessage) to the output when the queue
+	// has drained after dropping events.
+	AsyncReportDrops bool `json:"async_report_drops,omitempty" yaml:"async_report_drops,omitempty" toml:"async_report_drops,omitempty"`
+
+	// Options for the kv writer type.
+	KV *KVConfig `json:"kv,omitempty" yaml:"kv,omitempty" toml:"kv,omitempty"`
+	// Options for the network writer type.
+	Net *NetworkConfig `json:"net,omitempty" yaml:"net,omitempty" toml:"net,omitempty"`
+	// Options for the http writer type.
+	HTTP *HTTPConfig `json:"http,omitempty" yaml:"http,omitempty" toml:"http,omitempty"`
+	// Options for the gelf writer type.
+	GELF *GELFConfig `json:"gelf,omitempty" yaml:"gelf,omitempty" toml:"gelf,omitempty"`
+	// Options for the memory writer type.
+	Memory *MemoryConfig `json:"memory,omitempty" yaml:"memory,omitempty" toml:"memory,omitempty"`
+	// Options for the ringbuffer writer type.
+	RingBuffer *RingBufferConfig `json:"ringbuffer,omitempty" yaml:"ringbuffer,omitempty" toml:"ringbuffer,omitempty"`
+	// Options for the eventlog writer type.
+	Eventlog *EventlogConfig `json:"eventlog,omitempty" yaml:"eventlog,omitempty" toml:"eventlog,omitempty"`
+
+	SyslogConfig    `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+	JournaldConfig  `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+	FileConfig      `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+	RingFileConfig  `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+	UnixgramConfig  `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+	WebSocketConfig `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+	TailConfig      `json:",inline,omitempty" yaml:",inline,omitempty" toml:",inline"`
+
+	// The level labels of the whole config, only set when the writer is compiled as a part of a Config.
+	levelLabels *levelLabels
 }
 
 // Config contains all the configuration to create a zerolog logger.
 type Config struct {
-	Writers  []WriterConfig `json:"writers,omitempty" yaml:"writers,omitempty"`
-	MinLevel *zerolog.Level `json:"min_level,omitempty" yaml:"min_level,omitempty"`
+	Writers  []WriterConfig `json:"writers,omitempty" yaml:"writers,omitempty" toml:"writers,omitempty"`
+	MinLevel *zerolog.Level `json:"min_level,omitempty" yaml:"min_level,omitempty" toml:"min_level,omitempty"`
+	// Min levels for specific environments, which take precedence over MinLevel when the environment is active.
+	// In JSON and YAML, this can also be specified as a map in min_level, where the default key sets MinLevel.
+	EnvMinLevel map[string]zerolog.Level `json:"env_min_level,omitempty" yaml:"env_min_level,omitempty" toml:"env_min_level,omitempty"`
+	// If set, the logger reads its min level from this on every event, so it can be changed without recompiling
+	// (e.g. from a signal handler or an admin endpoint). Compile stores the configured min level in it, if there is one.
+	// The level is checked using a zerolog sampler, so it's bypassed by zerolog.DisableSampling and replaced
+	// if Logger.Sample is called on the compiled logger. See also WithAtomicLevel.
+	AtomicLevel *AtomicLevel `json:"-" yaml:"-" toml:"-"`
+
+	Timestamp *bool `json:"timestamp,omitempty" yaml:"timestamp,omitempty" toml:"timestamp,omitempty"`
+	Caller    bool  `json:"caller,omitempty" yaml:"caller,omitempty" toml:"caller,omitempty"`
+	// Number of extra stack frames to skip when finding the caller, e.g. 1 if all logging goes through a helper function.
+	CallerSkipFrames int `json:"caller_skip_frames,omitempty" yaml:"caller_skip_frames,omitempty" toml:"caller_skip_frames,omitempty"`
+	// How to format the caller field: full (default), short or relative. See the CallerFormat constants.
+	CallerFormat CallerFormat `json:"caller_format,omitempty" yaml:"caller_format,omitempty" toml:"caller_format,omitempty"`
+	// The directory that caller paths are relative to with the relative caller format. Defaults to the working directory.
+	CallerRoot string `json:"caller_root,omitempty" yaml:"caller_root,omitempty" toml:"caller_root,omitempty"`
+	// The function used to get the time for the timestamp and uptime fields. Defaults to time.Now.
+	// Unlike zerolog.TimestampFunc, this only affects loggers compiled from this config. See also WithClock.
+	TimestampSource func() time.Time `json:"-" yaml:"-" toml:"-"`
+	// Add the time since the logger was compiled to every event. The value is computed with a hook for each event,
+	// which adds a small cost to every log line. The unit is zerolog.DurationFieldUnit (milliseconds by default).
+	IncludeUptime bool `json:"include_uptime,omitempty" yaml:"include_uptime,omitempty" toml:"include_uptime,omitempty"`
+	// The field name for IncludeUptime. Defaults to uptime.
+	UptimeFieldName string `json:"uptime_field_name,omitempty" yaml:"uptime_field_name,omitempty" toml:"uptime_field_name,omitempty"`
+	// Add the call stack as a stack array to events at or above this level. Meant for debugging sessions,
+	// as capturing the stack is relatively expensive. Events below the level don't have any extra cost.
+	StackAbove *zerolog.Level `json:"stack_above,omitempty" yaml:"stack_above,omitempty" toml:"stack_above,omitempty"`
+	// The maximum number of frames to include for StackAbove. Defaults to DefaultStackMaxFrames.
+	StackMaxFrames int `json:"stack_max_frames,omitempty" yaml:"stack_max_frames,omitempty" toml:"stack_max_frames,omitempty"`
+
+	// Values of type json.RawMessage are embedded into events verbatim.
+	Metadata map[string]any `json:"metadata,omitempty" yaml:"metadata,omitempty" toml:"metadata,omitempty"`
+	// Metadata in a specific order. In config files, this is set by using a list of key-value objects in metadata
+	// instead of a map. The keys are added to events in the order of the list, while map keys are sorted.
+	// Metadata and MetadataList can't be used at the same time.
+	MetadataList []MetadataEntry `json:"-" yaml:"-" toml:"-"`
+	// Pre-serialized JSON metadata that is embedded into events verbatim, preserving key order and number formatting.
+	// In YAML and TOML, the values are strings containing JSON.
+	RawMetadata map[string]RawJSON `json:"raw_metadata,omitempty" yaml:"raw_metadata,omitempty" toml:"raw_metadata,omitempty"`
+	// Metadata for specific environments, merged over Metadata when the environment is active.
+	// Keys in the environment's map override the same keys in Metadata.
+	EnvMetadata map[string]map[string]any `json:"env_metadata,omitempty" yaml:"env_metadata,omitempty" toml:"env_metadata,omitempty"`
+	// The active environment (e.g. prod). Defaults to the LOG_ENVIRONMENT environment variable.
+	Environment string `json:"environment,omitempty" yaml:"environment,omitempty" toml:"environment,omitempty"`
+	// Expand environment variables like ${LOG_DIR} or ${LOG_DIR:-/var/log} in filenames, syslog hosts and tags,
+	// time formats and string metadata values when compiling. Unlike the default expansion of filenames,
+	// variables that aren't set and don't have a default are an error.
+	ExpandEnv bool `json:"expand_env,omitempty" yaml:"expand_env,omitempty" toml:"expand_env,omitempty"`
+	// Functions that compute additional metadata values. They're called in Compile in sorted key order,
+	// and keys that are also in Metadata are skipped. Errors fail compilation, unless the function is
+	// wrapped with OptionalMetadata.
+	MetadataFuncs map[string]func() (any, error) `json:"-" yaml:"-" toml:"-"`
+	// What to do with metadata values (including ones from MetadataFuncs) that can't be serialized as JSON,
+	// like channels, functions or cyclic structures: error (default), skip or stringify.
+	MetadataErrors MetadataErrorPolicy `json:"metadata_errors,omitempty" yaml:"metadata_errors,omitempty" toml:"metadata_errors,omitempty"`
+
+	// Log each distinct stack trace in full only once. Later events with the same stack only get a stack_ref
+	// field containing the hash of the stack, which matches the stack_ref field of the first event.
+	DedupStacks bool `json:"dedup_stacks,omitempty" yaml:"dedup_stacks,omitempty" toml:"dedup_stacks,omitempty"`
+
+	// Panic with a *FatalPanic after writing fatal events instead of letting zerolog call os.Exit.
+	// Meant for tests, so that fatal paths can be tested with recover. Events logged with WithLevel(zerolog.FatalLevel)
+	// panic too. This doesn't change any global state, so nothing needs to be restored afterwards.
+	FatalPanics bool `json:"fatal_panics,omitempty" yaml:"fatal_panics,omitempty" toml:"fatal_panics,omitempty"`
+	// Called with exit code 1 after writing events logged with Logger.Fatal, instead of os.Exit.
+	// Logging continues normally if the function returns. FatalPanics takes precedence if both are set.
+	ExitFunc func(code int) `json:"-" yaml:"-" toml:"-"`
+	// Called with the message after writing events logged with Logger.Panic, instead of panic.
+	//
+	// FatalPanics, ExitFunc and PanicFunc rely on zerolog internals. If they can't be found in the zerolog version
+	// that's in use, compiling fails instead of silently exiting or panicking.
+	PanicFunc func(msg string) `json:"-" yaml:"-" toml:"-"`
+
+	// Names of hooks to add to the logger, in order. Hooks must be registered with RegisterHook first.
+	Hooks []string `json:"hooks,omitempty" yaml:"hooks,omitempty" toml:"hooks,omitempty"`
+	// Hooks to add to the logger after the ones in Hooks. See also WithHooks.
+	ExtraHooks []zerolog.Hook `json:"-" yaml:"-" toml:"-"`
+
+	// Log an event with a summary of the writers after compiling, and another event when the Handle is closed,
+	// so that gaps in logs can be told apart from crashes. Both events are logged at info level.
+	LogLifecycleEvents bool `json:"log_lifecycle_events,omitempty" yaml:"log_lifecycle_events,omitempty" toml:"log_lifecycle_events,omitempty"`
+
+	// What to do when multiple writers use the same file or syslog server: warn (default), share or error.
+	// With warn and share, the writers share a single underlying output, but keep their own formats and level bounds.
+	DedupeOutputs DedupeOutputsMode `json:"dedupe_outputs,omitempty" yaml:"dedupe_outputs,omitempty" toml:"dedupe_outputs,omitempty"`
+
+	// Pass each event to all writers while holding a single lock, so that every writer receives concurrently
+	// logged events in the same order. This limits throughput to one event at a time across all writers,
+	// so a slow writer will also delay logging from other goroutines. Only matters with multiple writers.
+	SerializeWriters bool `json:"serialize_writers,omitempty" yaml:"serialize_writers,omitempty" toml:"serialize_writers,omitempty"`
+	// Recover panics in all writers instead of letting them crash the application from a log call.
+	// Writer types registered with RegisterWriter always have panics recovered. Recovered panics are
+	// returned as write errors (WriterPanicError) and counted in the writer's stats.
+	RecoverPanics bool `json:"recover_panics,omitempty" yaml:"recover_panics,omitempty" toml:"recover_panics,omitempty"`
+	// Disable writers after this many consecutive panics, and log an error through the remaining writers.
+	// Defaults to DefaultPanicQuarantine.
+	PanicQuarantine int `json:"panic_quarantine,omitempty" yaml:"panic_quarantine,omitempty" toml:"panic_quarantine,omitempty"`
+
+	// Enable WriterConfig.StripANSI for all writers.
+	StripANSI bool `json:"strip_ansi,omitempty" yaml:"strip_ansi,omitempty" toml:"strip_ansi,omitempty"`
 
-	Timestamp *bool `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
-	Caller    bool  `json:"caller,omitempty" yaml:"caller,omitempty"`
+	// Coalesce repeated events with the same level and message into periodic summaries.
+	Coalesce *CoalesceConfig `json:"coalesce,omitempty" yaml:"coalesce,omitempty" toml:"coalesce,omitempty"`
 
-	Metadata map[string]any `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// Log a summary of events dropped by sampling and field filters and stacks removed by DedupStacks at this interval.
+	// Summaries are only logged if something was suppressed since the previous summary.
+	SuppressionSummaryInterval time.Duration `json:"suppression_summary_interval,omitempty" yaml:"suppression_summary_interval,omitempty" toml:"suppression_summary_interval,omitempty"`
+	// Log a heartbeat event periodically, starting immediately after compiling. Stopped by closing the Handle.
+	Heartbeat *HeartbeatConfig `json:"heartbeat,omitempty" yaml:"heartbeat,omitempty" toml:"heartbeat,omitempty"`
+
+	// How to write level names: short (default), long or upper. See the LevelStyle constants for details.
+	// Only the output changes, level bounds and other filters always use the real levels.
+	LevelStyle LevelStyle `json:"level_style,omitempty" yaml:"level_style,omitempty" toml:"level_style,omitempty"`
+	// Custom labels for specific levels (e.g. info: notice), which override the labels from LevelStyle.
+	// The labels are used as-is in JSON and in uppercase in pretty formats.
+	LevelLabels map[string]string `json:"level_labels,omitempty" yaml:"level_labels,omitempty" toml:"level_labels,omitempty"`
+
+	// Convert all field keys to the given case (snake, camel or pascal), including keys in nested objects.
+	// This requires parsing and reserializing every event once (for all writers), so it has a per-line cost.
+	// Converted keys are cached, up to 1024 distinct keys (typically well under a megabyte).
+	FieldCase FieldCase `json:"field_case,omitempty" yaml:"field_case,omitempty" toml:"field_case,omitempty"`
+	// Should FieldCase also apply to the time, level and message fields?
+	FieldCaseReserved bool `json:"field_case_reserved,omitempty" yaml:"field_case_reserved,omitempty" toml:"field_case_reserved,omitempty"`
+	// Custom names for the level, message, time and caller fields. These are global in zerolog,
+	// so they affect all loggers in the process, see FieldNamesConfig.
+	FieldNames *FieldNamesConfig `json:"field_names,omitempty" yaml:"field_names,omitempty" toml:"field_names,omitempty"`
 }
 
 // Outputs used for the stdout and stderr writer types.
@@ -110,6 +465,9 @@ var (
 	Stderr io.Writer = os.Stderr
 )
 
+// Getenv is used to read environment variables like NO_COLOR while compiling configs.
+var Getenv = os.Getenv
+
 func compileUnsupported(wc *WriterConfig) (io.Writer, error) {
 	return nil, fmt.Errorf("writer type %q not supported on this OS", wc.Type)
 }
@@ -120,29 +478,29 @@ var writerCompilers = map[WriterType]WriterCompiler{
 	WriterTypeStdout:    func(_ *WriterConfig) (io.Writer, error) { return Stdout, nil },
 	WriterTypeStderr:    func(_ *WriterConfig) (io.Writer, error) { return Stderr, nil },
 	WriterTypeFile:      compileFile,
+	WriterTypeRingFile:  compileRingFile,
 	WriterTypeJournald:  compileUnsupported,
+	WriterTypeEventlog:  compileUnsupported,
 	WriterTypeSyslog:    compileUnsupported,
 	WriterTypeSyslogCEE: compileUnsupported,
+	WriterTypeUnixgram:  compileUnsupported,
+	WriterTypeWebSocket: func(_ *WriterConfig) (io.Writer, error) {
+		return nil, fmt.Errorf("the websocket writer requires building with the zeroconfig_websocket tag")
+	},
+	WriterTypeTail:       compileTail,
+	WriterTypeKV:         compileKV,
+	WriterTypeNetwork:    compileNetwork,
+	WriterTypeHTTP:       compileHTTP,
+	WriterTypeGELF:       compileGELF,
+	WriterTypeMemory:     compileMemory,
+	WriterTypeRingBuffer: compileRingBuffer,
+	WriterTypeDiscard:    func(_ *WriterConfig) (io.Writer, error) { return io.Discard, nil },
 }
 
+// RegisterWriter adds a custom writer type. Panics in custom writers are always recovered, see Config.RecoverPanics.
 func RegisterWriter(wt WriterType, compiler WriterCompiler) {
 	writerCompilers[wt] = compiler
-}
-
-func compileFile(wc *WriterConfig) (io.Writer, error) {
-	writer := &lumberjack.Logger{
-		Filename:   wc.Filename,
-		MaxSize:    wc.MaxSize,
-		MaxAge:     wc.MaxAge,
-		MaxBackups: wc.MaxBackups,
-		LocalTime:  wc.LocalTime,
-		Compress:   wc.Compress,
-	}
-	err := writer.Rotate()
-	if err != nil {
-		return nil, err
-	}
-	return writer, nil
+	customWriterTypes[wt] = struct{}{}
 }
 
 func (wc *WriterConfig) compileMain() (io.Writer, error) {
@@ -153,6 +511,28 @@ func (wc *WriterConfig) compileMain() (io.Writer, error) {
 	return compiler(wc)
 }
 
+// useColor decides whether a pretty format should be colored.
+//
+// The precedence is: the color field, then NO_COLOR (https://no-color.org),
+// then FORCE_COLOR and finally the format itself.
+func (wc *WriterConfig) useColor(format LogFormat) (bool, error) {
+	switch wc.Color {
+	case ColorAlways:
+		return true, nil
+	case ColorNever:
+		return false, nil
+	case "", ColorAuto:
+	default:
+		return false, fmt.Errorf("unknown color mode %q", wc.Color)
+	}
+	if Getenv("NO_COLOR") != "" {
+		return false, nil
+	} else if Getenv("FORCE_COLOR") != "" {
+		return true, nil
+	}
+	return format == LogFormatPrettyColored, nil
+}
+
 func levelPtr(ptr *zerolog.Level) zerolog.Level {
 	if ptr == nil {
 		return zerolog.NoLevel
@@ -160,79 +540,714 @@ func levelPtr(ptr *zerolog.Level) zerolog.Level {
 	return *ptr
 }
 
+func (wc *WriterConfig) compileRewriters() ([]eventRewriteFunc, error) {
+	var funcs []eventRewriteFunc
+	if len(wc.MetadataRemove) > 0 {
+		funcs = append(funcs, removeFieldsRewriter(wc.MetadataRemove))
+	}
+	if len(wc.MetadataOverride) > 0 {
+		fn, err := overrideFieldsRewriter(wc.MetadataOverride)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata_override: %w", err)
+		}
+		funcs = append(funcs, fn)
+	}
+	if len(wc.Fields) > 0 {
+		fn, err := addFieldsRewriter(wc.Fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fields: %w", err)
+		}
+		funcs = append(funcs, fn)
+	}
+	if len(wc.RemoveFields) > 0 {
+		funcs = append(funcs, removePathsRewriter(wc.RemoveFields))
+	}
+	if len(wc.RedactFields) > 0 {
+		funcs = append(funcs, redactPathsRewriter(wc.RedactFields))
+	}
+	filters, err := wc.compileFilters()
+	if err != nil {
+		return nil, err
+	}
+	// Filters go first so that dropped events don't need to be rewritten.
+	return append(filters, funcs...), nil
+}
+
 // Compile creates an io.Writer instance out of the configuration in this struct.
+//
+// If any outputs need to be closed (like files and syslog connections), the returned writer implements io.Closer,
+// which closes all of them, including the outputs of group children. Stdout and stderr are never closed.
 func (wc *WriterConfig) Compile() (io.Writer, error) {
-	output, err := wc.compileMain()
+	outputs, err := newOutputRegistry(DedupeOutputsShare)
 	if err != nil {
 		return nil, err
 	}
-	switch wc.Format {
+	writer, err := wc.compile(&writerCounters{writerType: wc.Type, index: 1, outputs: outputs})
+	if err != nil {
+		_ = closeAll(outputs.allClosers())
+		return nil, err
+	}
+	closers := outputs.allClosers()
+	if len(closers) == 0 {
+		return writer, nil
+	} else if _, ok := writer.(io.Closer); ok && len(closers) == 1 {
+		// The output isn't wrapped, so it can be closed directly.
+		return writer, nil
+	}
+	return &closingWriter{LevelWriter: asLevelWriter(writer), closers: closers}, nil
+}
+
+// CompileLevelWriter is like Compile, but always returns a zerolog.LevelWriter, so that the writer can be combined
+// with others using zerolog.MultiLevelWriter without losing the level of each event.
+// Like with Compile, the returned writer implements io.Closer if any outputs need to be closed.
+func (wc *WriterConfig) CompileLevelWriter() (zerolog.LevelWriter, error) {
+	writer, err := wc.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return asLevelWriter(writer), nil
+}
+
+func (wc *WriterConfig) compileLevelWriter(counters *writerCounters) (zerolog.LevelWriter, error) {
+	writer, err := wc.compile(counters)
+	if err != nil {
+		return nil, err
+	}
+	return asLevelWriter(writer), nil
+}
+
+// compile compiles the writer, counting intentionally dropped events in the given counters (which may be nil).
+func (wc *WriterConfig) compile(counters *writerCounters) (_ io.Writer, err error) {
+	if counters != nil && counters.outputs != nil {
+		// Close the outputs of this writer if a later step fails, so that they aren't leaked
+		// and don't block the resources they hold from being used by the next compile.
+		mark := counters.outputs.mark()
+		defer func() {
+			if err != nil {
+				counters.outputs.rollback(mark)
+			}
+		}()
+	}
+	if wc.Type == WriterTypeGroup {
+		return wc.compileGroup(false, counters)
+	}
+	rewriters, err := wc.compileRewriters()
+	if err != nil {
+		return nil, err
+	}
+	format, err := ParseLogFormat(string(wc.Format))
+	if err != nil {
+		return nil, err
+	}
+	if wc.StripANSI {
+		// Colored pretty output goes to terminals, so escape sequences are expected there.
+		colored, _ := wc.useColor(format)
+		if format == LogFormatJSON || format == "" || !colored {
+			rewriters = append(rewriters, stripANSIRewriter)
+		}
+	}
+	if wc.Type == WriterTypeDiscard {
+		// Nothing is written, so there's no point in formatting events.
+		return io.Discard, nil
+	}
+	output, err := counters.compileOutput(wc)
+	if err != nil {
+		return nil, err
+	}
+	output = counters.wrapPanicGuard(wc, output)
+	output, err = wc.wrapInstrumentation(output, counters)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
 	case "", LogFormatJSON:
 		// output directly
+		if wc.NotifyOnLevel != nil {
+			return nil, fmt.Errorf("notify_on_level is only supported with pretty formats")
+		}
+		if wc.HTMLSafe {
+			output = newHTMLSafeWriter(output)
+		}
+	case LogFormatLogfmt:
+		if wc.NotifyOnLevel != nil {
+			return nil, fmt.Errorf("notify_on_level is only supported with pretty formats")
+		} else if wc.HTMLSafe {
+			return nil, fmt.Errorf("html_safe is only supported with the json format")
+		}
+		output = newLogfmtWriter(output)
 	case LogFormatPretty, LogFormatPrettyColored:
+		if wc.HTMLSafe {
+			return nil, fmt.Errorf("html_safe is only supported with the json format")
+		}
 		wrapper := zerolog.ConsoleWriter{
 			Out: output,
 		}
-		if wc.Format == LogFormatPretty {
-			wrapper.NoColor = true
+		colored, err := wc.useColor(format)
+		if err != nil {
+			return nil, err
+		}
+		wrapper.NoColor = !colored
+		if err = wc.Console.apply(&wrapper, wc.levelLabels); err != nil {
+			return nil, fmt.Errorf("invalid console: %w", err)
 		}
 		if wc.TimeFormat != "" {
 			wrapper.TimeFormat = wc.TimeFormat
 		} else {
 			wrapper.TimeFormat = "2006-01-02T15:04:05.999Z07:00"
 		}
-		output = wrapper
-	default:
-		return nil, fmt.Errorf("unknown format %q", wc.Format)
+		if wc.NotifyOnLevel != nil {
+			nw := &notifyWriter{
+				LevelWriter: asLevelWriter(wrapper),
+				terminal:    output,
+				level:       *wc.NotifyOnLevel,
+			}
+			if wc.NotifyDesktop {
+				nw.desktop = newDesktopNotifier()
+				if counters != nil && counters.outputs != nil {
+					counters.outputs.addFlusher(nw.desktop)
+				}
+			}
+			output = nw
+		} else {
+			output = asLevelWriter(wrapper)
+		}
+	}
+	// Filtering, sampling and level checks happen before queuing, so that dropped events don't take up space.
+	output, err = counters.wrapAsync(wc, output)
+	if err != nil {
+		return nil, err
+	}
+	if len(rewriters) > 0 {
+		rewriter := newEventRewriter(output, rewriters...)
+		rewriter.dropped = counters.filteredCounter()
+		output = rewriter
+	}
+	sampler, err := wc.Sampling.compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid sampling: %w", err)
+	} else if sampler != nil {
+		output = wrapSampling(output, sampler, counters.sampledCounter())
+	}
+	if wc.AdaptiveSampling != nil {
+		output, err = wc.AdaptiveSampling.compile(asLevelWriter(output), counters)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if wc.MinLevel != nil || wc.MaxLevel != nil {
-		output = MinMaxLevelWriter(output, levelPtr(wc.MinLevel), levelPtr(wc.MaxLevel))
+	if wc.MinLevel != nil || wc.MaxLevel != nil || wc.NoLevel != "" {
+		output, err = MinMaxLevelWriterNoLevel(output, levelPtr(wc.MinLevel), levelPtr(wc.MaxLevel), wc.NoLevel)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return output, nil
 }
 
+// compileRewriters returns the event rewriters that apply to all writers.
+// They're applied once before the event is passed to the individual writers.
+func (c *Config) compileRewriters(stats *compileStats, labels *levelLabels) ([]eventRewriteFunc, error) {
+	var funcs []eventRewriteFunc
+	if labels != nil {
+		funcs = append(funcs, labels.rewrite)
+	}
+	if c.DedupStacks {
+		stats.dedup = newStackDeduplicator()
+		funcs = append(funcs, stats.dedup.rewrite)
+	}
+	fieldCase, err := newFieldCaseRewriter(c.FieldCase, c.FieldCaseReserved)
+	if err != nil {
+		return nil, err
+	} else if fieldCase != nil {
+		funcs = append(funcs, fieldCase)
+	}
+	return funcs, nil
+}
+
+// ErrOmitMetadata can be returned by functions in Config.MetadataFuncs to leave the key out of the metadata.
+var ErrOmitMetadata = errors.New("omit metadata")
+
+// OptionalMetadata wraps a metadata function so that errors cause the key to be omitted instead of failing Compile.
+func OptionalMetadata(fn func() (any, error)) func() (any, error) {
+	return func() (any, error) {
+		val, err := fn()
+		if err != nil {
+			return nil, ErrOmitMetadata
+		}
+		return val, nil
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compileContext returns the metadata fields as a JSON object without the closing brace, like the context
+// of a zerolog.Logger, or nil if there's no metadata.
+func (c *Config) compileContext(metadata map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	with, _, err := c.compileMetadata(zerolog.New(&buf).With(), metadata)
+	if err != nil {
+		return nil, err
+	}
+	// An event without a level or message only contains the context. Nothing is written if the global level
+	// is disabled, but then nothing would be logged with the context either.
+	log := with.Logger()
+	log.Log().Send()
+	context := bytes.TrimSuffix(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), []byte{'}'})
+	if len(context) <= 1 {
+		return nil, nil
+	}
+	return context, nil
+}
+
+// compileMetadata adds all metadata to the logger context.
+// It returns the keys of integers that were too large to be logged as numbers.
+func (c *Config) compileMetadata(with zerolog.Context, metadata map[string]any) (zerolog.Context, []string, error) {
+	switch c.MetadataErrors {
+	case "", MetadataErrorsFail, MetadataErrorsSkip, MetadataErrorsStringify:
+	default:
+		return with, nil, fmt.Errorf("unknown metadata_errors policy %q", c.MetadataErrors)
+	}
+	if len(c.Metadata) > 0 && len(c.MetadataList) > 0 {
+		return with, nil, fmt.Errorf("metadata can't be set as both a map and a list")
+	}
+	var tooLarge []string
+	var err error
+	for _, key := range c.metadataKeys(metadata) {
+		switch val := metadata[key].(type) {
+		case json.RawMessage:
+			if !json.Valid(val) {
+				return with, nil, fmt.Errorf("metadata %q is not valid JSON", key)
+			}
+			with = with.RawJSON(key, val)
+		case json.Number:
+			if intVal, err := val.Int64(); err == nil {
+				with = with.Int64(key, intVal)
+			} else if !strings.ContainsAny(string(val), ".eE") {
+				with = with.Str(key, string(val))
+				tooLarge = append(tooLarge, key)
+			} else if floatVal, err := val.Float64(); err == nil {
+				with = with.Float64(key, floatVal)
+			} else {
+				return with, nil, fmt.Errorf("metadata %q is not a valid number: %w", key, err)
+			}
+		default:
+			if with, err = c.appendMetadataValue(with, key, val); err != nil {
+				return with, nil, err
+			}
+		}
+	}
+	for _, key := range sortedKeys(c.RawMetadata) {
+		if _, isStatic := metadata[key]; isStatic {
+			return with, nil, fmt.Errorf("metadata %q is set in both metadata and raw_metadata", key)
+		} else if !json.Valid(c.RawMetadata[key]) {
+			return with, nil, fmt.Errorf("raw_metadata %q is not valid JSON", key)
+		}
+		with = with.RawJSON(key, c.RawMetadata[key])
+	}
+	for _, key := range sortedKeys(c.MetadataFuncs) {
+		if _, isStatic := metadata[key]; isStatic {
+			continue
+		} else if _, isStatic = c.RawMetadata[key]; isStatic {
+			continue
+		}
+		val, err := c.MetadataFuncs[key]()
+		if errors.Is(err, ErrOmitMetadata) {
+			continue
+		} else if err != nil {
+			return with, nil, fmt.Errorf("failed to compute metadata %q: %w", key, err)
+		}
+		if with, err = c.appendMetadataValue(with, key, val); err != nil {
+			return with, nil, err
+		}
+	}
+	return with, tooLarge, nil
+}
+
+// metadataKeys returns the keys of the metadata in the order they should be added to events:
+// first the keys of MetadataList in declaration order, then the rest in sorted order.
+func (c *Config) metadataKeys(metadata map[string]any) []string {
+	if len(c.MetadataList) == 0 {
+		return sortedKeys(metadata)
+	}
+	keys := make([]string, 0, len(metadata))
+	listed := make(map[string]struct{}, len(c.MetadataList))
+	for _, entry := range c.MetadataList {
+		keys = append(keys, entry.Key)
+		listed[entry.Key] = struct{}{}
+	}
+	for _, key := range sortedKeys(metadata) {
+		if _, ok := listed[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// MetadataErrorPolicy describes what to do with metadata values that can't be serialized as JSON.
+type MetadataErrorPolicy string
+
+const (
+	// MetadataErrorsFail makes Compile return an error that names the key. This is the default.
+	MetadataErrorsFail MetadataErrorPolicy = "error"
+	// MetadataErrorsSkip leaves the key out of events.
+	MetadataErrorsSkip MetadataErrorPolicy = "skip"
+	// MetadataErrorsStringify formats the value with fmt (%+v) and logs it as a string.
+	MetadataErrorsStringify MetadataErrorPolicy = "stringify"
+)
+
+// appendMetadataValue serializes a metadata value up front, so that unserializable values are caught when compiling
+// instead of zerolog silently logging an error string in their place.
+func (c *Config) appendMetadataValue(with zerolog.Context, key string, val any) (zerolog.Context, error) {
+	data, err := zerolog.InterfaceMarshalFunc(val)
+	if err == nil {
+		return with.RawJSON(key, data), nil
+	}
+	switch c.MetadataErrors {
+	case MetadataErrorsSkip:
+		return with, nil
+	case MetadataErrorsStringify:
+		return with.Str(key, fmt.Sprintf("%+v", val)), nil
+	default:
+		return with, fmt.Errorf("metadata %q can't be serialized as JSON: %w", key, err)
+	}
+}
+
 // Compile creates a zerolog.Logger instance out of the configuration in this struct.
-func (c *Config) Compile() (*zerolog.Logger, error) {
-	if len(c.Writers) == 0 || (c.MinLevel != nil && *c.MinLevel == zerolog.Disabled) {
-		log := zerolog.Nop()
-		return &log, nil
+//
+// If the config starts background tasks (like Coalesce, SuppressionSummaryInterval or Heartbeat),
+// use CompileHandle instead to be able to stop them.
+func (c *Config) Compile(opts ...CompileOption) (*zerolog.Logger, error) {
+	handle, err := c.CompileHandle(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return handle.Logger, nil
+}
+
+// CompileHandle creates a zerolog.Logger instance out of the configuration in this struct,
+// and returns it in a Handle that can be used to get stats and to stop background tasks.
+func (c *Config) CompileHandle(opts ...CompileOption) (*Handle, error) {
+	return c.applyOptions(opts).compileHandle(nil)
+}
+
+// compiledLogger contains the parts that compileHandle puts together into the zerolog.Logger of a Handle.
+// ReloadableLogger uses them directly, so that it can switch between configs for each event.
+type compiledLogger struct {
+	writer zerolog.LevelWriter
+	// The minimum level, which is overridden by atomicLevel if it's set.
+	minLevel    zerolog.Level
+	atomicLevel *AtomicLevel
+	// The metadata fields as a JSON object without the closing brace, or nil if there are none.
+	context []byte
+	// All hooks in the order they run, including the timestamp and caller which zerolog adds as part of the context.
+	hooks []zerolog.Hook
+}
+
+// enabled returns whether events at the given level should be logged, like zerolog's level check.
+func (cl *compiledLogger) enabled(level zerolog.Level) bool {
+	minLevel := cl.minLevel
+	if cl.atomicLevel != nil {
+		minLevel = cl.atomicLevel.Level()
 	}
-	writers := make([]io.Writer, len(c.Writers))
-	for i, wc := range c.Writers {
-		writer, err := wc.Compile()
+	return minLevel != zerolog.Disabled && level >= minLevel
+}
+
+// compileHandle compiles the config into a Handle. If parts is not nil, it's filled with the parts of the logger.
+func (c *Config) compileHandle(parts *compiledLogger) (_ *Handle, err error) {
+	// Check the whole config first, so that nothing is opened if any writer is invalid.
+	if err := c.Validate(); err != nil {
+		return nil, err
+	} else if err = c.checkEventInternals(); err != nil {
+		return nil, err
+	}
+	// Set the names before anything is logged, including warnings logged while compiling.
+	c.FieldNames.apply()
+	if c.ExpandEnv {
+		var err error
+		if c, err = c.expandEnv(); err != nil {
+			return nil, err
+		}
+	}
+	env := c.ActiveEnvironment()
+	minLevel := c.activeMinLevel()
+	stats := &compileStats{writers: make([]writerCounters, len(c.Writers))}
+	outputs, err := newOutputRegistry(c.DedupeOutputs)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse config for writer #%d: %w", i+1, err)
+			// Don't leak the files, listeners and connections of the writers that were already compiled.
+			_ = closeAll(outputs.allClosers())
 		}
-		writers[i] = writer
+	}()
+	labels, err := compileLevelLabels(c.LevelStyle, c.LevelLabels)
+	if err != nil {
+		return nil, err
+	}
+	panics, err := newPanicPolicy(c.RecoverPanics, c.PanicQuarantine)
+	if err != nil {
+		return nil, err
+	}
+	writers := make([]io.Writer, 0, len(c.Writers))
+	active := make([]activeWriter, 0, len(c.Writers))
+	compiledOutputs := make([]io.Writer, len(c.Writers))
+	// Writers are needed even when the level is disabled if it can be changed later.
+	if minLevel == nil || *minLevel != zerolog.Disabled || c.AtomicLevel != nil {
+		for i, wc := range c.Writers {
+			stats.writers[i].writerType = wc.Type
+			stats.writers[i].index = i + 1
+			stats.writers[i].outputs = outputs
+			stats.writers[i].panicPolicy = panics
+			wc, ok := wc.filterEnvironment(env)
+			if !ok {
+				continue
+			}
+			wc.levelLabels = labels
+			if c.StripANSI {
+				wc.StripANSI = true
+			}
+			writer, err := wc.compileLevelWriter(&stats.writers[i])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse config for writer #%d (%s): %w", i+1, wc.Type, err)
+			}
+			writers = append(writers, writer)
+			active = append(active, activeWriter{index: i + 1, config: wc})
+			compiledOutputs[i] = outputs.writerOutput(&wc, i+1)
+		}
+	}
+	if len(writers) == 0 {
+		log := zerolog.Nop()
+		var hook zerolog.Hook
+		if c.ExitFunc != nil || c.PanicFunc != nil {
+			// zerolog calls os.Exit even for disabled loggers, so enable the fatal level to be able to intercept it.
+			hook = exitHook{exit: c.ExitFunc, panicFunc: c.PanicFunc}
+		}
+		if c.FatalPanics {
+			// Hooks don't run on a disabled logger, so enable the fatal level to be able to panic.
+			hook = fatalPanicHook{writer: levelWriterAdapter{io.Discard}}
+		}
+		if hook != nil {
+			log = zerolog.New(io.Discard).Level(zerolog.FatalLevel).Hook(hook)
+		}
+		if parts != nil {
+			*parts = compiledLogger{writer: levelWriterAdapter{io.Discard}, minLevel: log.GetLevel()}
+			if hook != nil {
+				parts.hooks = []zerolog.Hook{hook}
+			}
+		}
+		handle := newHandle(&log, &compileStats{})
+		handle.outputs = make([]io.Writer, len(c.Writers))
+		return handle, nil
 	}
 	var realWriter io.Writer
 	if len(writers) == 1 {
 		realWriter = writers[0]
-	} else if len(writers) > 1 {
-		realWriter = zerolog.MultiLevelWriter(writers...)
+	} else {
+		multi := zerolog.MultiLevelWriter(writers...)
+		if c.SerializeWriters {
+			multi = &serializedWriter{LevelWriter: multi}
+		}
+		realWriter = multi
 	}
+	rewriters, err := c.compileRewriters(stats, labels)
+	if err != nil {
+		return nil, err
+	}
+	if len(rewriters) > 0 {
+		realWriter = newEventRewriter(realWriter, rewriters...)
+	}
+	if c.Coalesce != nil {
+		stats.coalesce, err = c.Coalesce.compile(realWriter)
+		if err != nil {
+			return nil, err
+		}
+		realWriter = stats.coalesce
+	}
+	// The timestamp and caller that zerolog adds as part of the context are hooks too, which run first.
+	var contextHooks, hooks []zerolog.Hook
 	with := zerolog.New(realWriter).With()
-	if c.Timestamp == nil || *c.Timestamp {
+	if (c.Timestamp == nil || *c.Timestamp) && c.TimestampSource == nil {
 		with = with.Timestamp()
+		contextHooks = append(contextHooks, zerolog.HookFunc(func(e *zerolog.Event, _ zerolog.Level, _ string) {
+			e.Timestamp()
+		}))
 	}
-	if c.Caller {
+	callerHook, err := c.compileCallerHook()
+	if err != nil {
+		return nil, err
+	} else if c.Caller && callerHook == nil {
 		with = with.Caller()
+		contextHooks = append(contextHooks, defaultCallerHook)
+	}
+	metadata, knownEnv := c.activeMetadata()
+	with, tooLarge, err := c.compileMetadata(with, metadata)
+	if err != nil {
+		return nil, err
+	}
+	log := with.Logger()
+	if (c.Timestamp == nil || *c.Timestamp) && c.TimestampSource != nil {
+		hooks = append(hooks, timestampHook{source: c.TimestampSource})
+	}
+	if c.AtomicLevel != nil {
+		if minLevel != nil {
+			c.AtomicLevel.SetLevel(*minLevel)
+		}
+		log = log.Sample(atomicLevelSampler{level: c.AtomicLevel})
+	} else if minLevel != nil {
+		log = log.Level(*minLevel)
 	}
-	if len(c.Metadata) > 0 {
-		keys := make([]string, len(c.Metadata))
-		i := 0
-		for key := range c.Metadata {
-			keys[i] = key
-			i++
+	if c.Caller && callerHook != nil {
+		hooks = append(hooks, callerHook)
+	}
+	if c.IncludeUptime {
+		field := c.UptimeFieldName
+		if field == "" {
+			field = DefaultUptimeFieldName
 		}
-		sort.Strings(keys)
-		for _, key := range keys {
-			with = with.Interface(key, c.Metadata[key])
+		hooks = append(hooks, uptimeHook{field: field, start: c.now(), now: c.now})
+	}
+	if c.StackAbove != nil {
+		maxFrames := c.StackMaxFrames
+		if maxFrames == 0 {
+			maxFrames = DefaultStackMaxFrames
+		} else if maxFrames < 0 {
+			return nil, fmt.Errorf("stack_max_frames must not be negative")
 		}
+		hooks = append(hooks, stackHook{level: *c.StackAbove, maxFrames: maxFrames})
 	}
-	log := with.Logger()
-	if c.MinLevel != nil {
-		log = log.Level(*c.MinLevel)
+	customHooks, err := c.compileHooks()
+	if err != nil {
+		return nil, err
+	}
+	hooks = append(hooks, customHooks...)
+	if c.ExitFunc != nil || c.PanicFunc != nil {
+		hooks = append(hooks, exitHook{exit: c.ExitFunc, panicFunc: c.PanicFunc})
+	}
+	if parts != nil {
+		*parts = compiledLogger{
+			writer:      asLevelWriter(realWriter),
+			minLevel:    log.GetLevel(),
+			atomicLevel: c.AtomicLevel,
+			hooks:       append(contextHooks, hooks...),
+		}
+		if parts.context, err = c.compileContext(metadata); err != nil {
+			return nil, err
+		}
+		if c.FatalPanics {
+			// The events of ReloadableLogger don't have the metadata yet when the hook writes them.
+			parts.hooks = append(parts.hooks, fatalPanicHook{writer: contextWriter{parts.writer, parts.context}})
+		}
+	}
+	if c.FatalPanics {
+		// This must be the last hook, see fatalPanicHook.
+		hooks = append(hooks, fatalPanicHook{writer: asLevelWriter(realWriter)})
+	}
+	for _, hook := range hooks {
+		log = log.Hook(hook)
+	}
+	if !knownEnv {
+		log.Warn().
+			Str("environment", c.ActiveEnvironment()).
+			Strs("available_environments", sortedKeys(c.EnvMetadata)).
+			Msg("No environment-specific metadata for the active environment, using base metadata only")
+	}
+	for _, key := range tooLarge {
+		log.Warn().Str("metadata_key", key).Msg("Metadata integer is too large for int64, logging it as a string")
+	}
+	outputs.logWarnings(&log)
+	handle := newHandle(&log, stats)
+	handle.closers = outputs.allClosers()
+	handle.writers = active
+	handle.outputs = compiledOutputs
+	handle.healthCheckers = outputs.healthCheckers
+	handle.dropCounters = outputs.dropCounters
+	if c.LogLifecycleEvents {
+		logStarted(&log, minLevel, env, active)
+		handle.logStopped = true
+	}
+	if stats.coalesce != nil {
+		handle.startTask(stats.coalesce.loop)
+	}
+	if c.SuppressionSummaryInterval > 0 {
+		handle.startSuppressionSummary(c.SuppressionSummaryInterval)
+	}
+	if c.Heartbeat != nil {
+		handle.startHeartbeat(c.Heartbeat)
+	}
+	if panics.guards > 0 {
+		handle.startQuarantineNotices(panics.notices)
+	}
+	return handle, nil
+}
+
+// CompileWithCloser is like Compile, but also returns a Closer that stops background tasks and
+// closes the outputs of the logger (files, syslog connections and so on) when closed. See Handle.Close.
+func (c *Config) CompileWithCloser(opts ...CompileOption) (*zerolog.Logger, io.Closer, error) {
+	handle, err := c.CompileHandle(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return handle.Logger, handle, nil
+}
+
+// CompileWithWriters is like Compile, but also returns the output of each writer in c.Writers, in the same order.
+// See Handle.Writers for details. Like with Compile, nothing is closed automatically, but the outputs that hold
+// resources implement io.Closer and can be closed individually.
+func (c *Config) CompileWithWriters(opts ...CompileOption) (*zerolog.Logger, []io.Writer, error) {
+	handle, err := c.CompileHandle(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return handle.Logger, handle.Writers(), nil
+}
+
+// DefaultFallbackConfig is used by CompileOrFallback when no fallback config is given.
+// It writes pretty logs to stderr at info level.
+func DefaultFallbackConfig() *Config {
+	minLevel := zerolog.InfoLevel
+	return &Config{
+		MinLevel: &minLevel,
+		Writers: []WriterConfig{{
+			Type:   WriterTypeStderr,
+			Format: LogFormatPretty,
+		}},
+	}
+}
+
+// CompileOrFallback compiles the config, but never leaves the application without a logger.
+//
+// If compiling fails, the fallback config (or DefaultFallbackConfig if nil) is compiled instead, the
+// original error is logged through it, and the fallback logger is returned along with the original error,
+// so the caller can decide whether to continue. If the fallback fails too, a Nop logger is returned.
+func (c *Config) CompileOrFallback(fallback *Config) (*zerolog.Logger, error) {
+	log, err := c.Compile()
+	if err == nil {
+		return log, nil
+	}
+	if fallback == nil {
+		fallback = DefaultFallbackConfig()
+	}
+	log, fallbackErr := fallback.Compile()
+	if fallbackErr != nil {
+		nop := zerolog.Nop()
+		return &nop, err
+	}
+	log.Error().Err(err).Msg("Failed to compile logging config, using fallback config")
+	return log, err
+}
+
+// MustCompile is like Compile, but panics if the config is invalid. It's meant for main functions and examples.
+func (c *Config) MustCompile() *zerolog.Logger {
+	log, err := c.Compile()
+	if err != nil {
+		panic(fmt.Errorf("zeroconfig: invalid logging config: %w", err))
 	}
-	return &log, nil
+	return log
 }
@@ -10,26 +10,29 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 
 	"go.mau.fi/zeroconfig"
+	"go.mau.fi/zeroconfig/logtest"
 )
 
-func compile(t *testing.T, cfg string) *zerolog.Logger {
+func compile(t *testing.T, cfg string, opts ...zeroconfig.CompileOption) *zerolog.Logger {
 	var parsed zeroconfig.Config
 	err := json.Unmarshal([]byte(cfg), &parsed)
 	if err != nil {
 		require.NoError(t, err, "Unmarshaling config should be successful")
 	}
-	logger, err := parsed.Compile()
+	logger, err := parsed.Compile(opts...)
 	if err != nil {
 		require.NoError(t, err, "Compiling config should be successful")
 	}
@@ -66,30 +69,30 @@ func TestWriterConfig_Compile_Metadata(t *testing.T) {
 	  "metadata": {
 	    "meow": 5,
 	    "foo": {"bar": "asd"}
-	  },
-	  "timestamp": false
+	  }
 	}`)
 
 	log.Debug().Msg("meow")
 	require.NotEmpty(t, out.String(), "Output should not be empty after logging")
-	require.Equal(t, `<nil> DBG meow foo={"bar":"asd"} meow=5`+"\n", out.String(), "Output contains global metadata fields")
-	out.Reset()
+	logtest.Golden(t, "pretty_metadata", logtest.Normalize(out.Bytes(), logtest.Options{}))
 }
 
 func TestWriterConfig_Compile_TimeFormat(t *testing.T) {
 	var out bytes.Buffer
 	zeroconfig.Stdout = &out
+	now := time.Date(2021, 12, 31, 23, 59, 59, 0, time.UTC)
 	log := compile(t, `{
 	  "writers": [
 	    {"type": "stdout", "format": "pretty", "time_format": "2006"}
 	  ],
 	  "min_level": "debug"
-	}`)
-
-	require.Equal(t, time.Now().Year(), time.Now().Add(5*time.Second).Year(), "This test can't be ran at midnight right before the new year")
+	}`, zeroconfig.WithClock(func() time.Time {
+		return now
+	}))
 
 	log.Debug().Int("cats", 5).Msg("meow")
-	require.Equal(t, strconv.Itoa(time.Now().Year())+" DBG meow cats=5\n", out.String(), "Output has current year as date")
+	// The pretty writer uses the local time zone, where it may already be 2022.
+	require.Equal(t, now.Local().Format("2006")+" DBG meow cats=5\n", out.String(), "Output has the year of the clock as date")
 }
 
 func TestWriterConfig_Compile_MultiLevel_Stdio(t *testing.T) {
@@ -136,21 +139,668 @@ func TestWriterConfig_Compile_StdoutAndFile(t *testing.T) {
 	  "min_level": "trace",
 	  "timestamp": false
 	}`, dir))
-	fileName := filepath.Join(dir, "test.log")
-	file, err := os.Open(fileName)
-	require.NoError(t, err, "Opening log file should be successful")
-	dec := json.NewDecoder(file)
-	var ll logLine
+	readFile := func() []byte {
+		file, err := os.ReadFile(filepath.Join(dir, "test.log"))
+		require.NoError(t, err, "Reading log file should be successful")
+		return file
+	}
 
 	log.Debug().Msg("meow")
 	assert.Empty(t, stdout.String(), "Stdout is empty when logging debug")
-	assert.NoError(t, dec.Decode(&ll), "File should contain JSON log line")
-	assert.Equal(t, ll.Level, zerolog.DebugLevel)
-	assert.Equal(t, ll.Message, "meow")
+	logtest.AssertJSONLines(t, readFile(), logtest.Line{"level": "debug", "message": "meow"})
 
 	log.Error().Msg("meow #2")
 	assert.Equal(t, "<nil> ERR meow #2\n", stdout.String(), "Stdout should have error log")
-	assert.NoError(t, dec.Decode(&ll), "File should contain JSON log line")
-	assert.Equal(t, ll.Level, zerolog.ErrorLevel)
-	assert.Equal(t, ll.Message, "meow #2")
+	logtest.AssertJSONLines(t, readFile(),
+		logtest.Line{"level": "debug", "message": "meow"},
+		logtest.Line{"level": "error", "message": "meow #2"},
+	)
+}
+
+func TestWriterConfig_Compile_MetadataRemoveOverride(t *testing.T) {
+	dir := t.TempDir()
+	var stderr, stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, fmt.Sprintf(`{
+	  "writers": [
+	    {"type": "stdout", "metadata_remove": ["hostname", "nonexistent"]},
+	    {"type": "stderr", "metadata_override": {"environment": "production", "nonexistent": 1}},
+	    {"type": "file", "filename": "%s/test.log"}
+	  ],
+	  "metadata": {
+	    "environment": "dev",
+	    "hostname": "meow.local"
+	  },
+	  "timestamp": false
+	}`, dir))
+
+	log.Info().Str("cat", "meow").Msg("meow")
+	assert.Equal(t, `{"level":"info","environment":"dev","cat":"meow","message":"meow"}`+"\n", stdout.String(), "Stdout should not have hostname")
+	assert.Equal(t, `{"level":"info","environment":"production","hostname":"meow.local","cat":"meow","message":"meow"}`+"\n", stderr.String(), "Stderr should have overridden environment")
+	file, err := os.ReadFile(filepath.Join(dir, "test.log"))
+	require.NoError(t, err, "Reading log file should be successful")
+	assert.Equal(t, `{"level":"info","environment":"dev","hostname":"meow.local","cat":"meow","message":"meow"}`+"\n", string(file), "File should be untouched")
+}
+
+func TestWriterConfig_Compile_RemoveRedactFields(t *testing.T) {
+	dir := t.TempDir()
+	var stderr, stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, fmt.Sprintf(`{
+	  "writers": [
+	    {"type": "stdout", "remove_fields": ["token", "request.headers.authorization", "missing.field"], "redact_fields": ["user_id", "request.id"]},
+	    {"type": "stderr", "format": "pretty", "remove_fields": ["token"], "redact_fields": ["user_id"]},
+	    {"type": "file", "filename": "%s/test.log"}
+	  ],
+	  "timestamp": false
+	}`, dir))
+
+	log.Info().
+		Str("token", "hunter2").
+		Str("user_id", "@meow:example.com").
+		RawJSON("request", []byte(`{"id":5,"headers":{"authorization":"Bearer hunter2","accept":"*/*"}}`)).
+		Msg("meow")
+	assert.Equal(t, `{"level":"info","user_id":"[redacted]","request":{"id":"[redacted]","headers":{"accept":"*/*"}},"message":"meow"}`+"\n", stdout.String())
+	assert.Equal(t, `<nil> INF meow request={"headers":{"accept":"*/*","authorization":"Bearer hunter2"},"id":5} user_id=[redacted]`+"\n", stderr.String())
+	file, err := os.ReadFile(filepath.Join(dir, "test.log"))
+	require.NoError(t, err, "Reading log file should be successful")
+	assert.Contains(t, string(file), `"token":"hunter2","user_id":"@meow:example.com"`, "File should be untouched")
+
+	stdout.Reset()
+	_, err = zeroconfig.Stdout.Write([]byte("not json\n"))
+	require.NoError(t, err)
+	log.Info().RawJSON("request", []byte(`"a string"`)).Msg("meow")
+	assert.Equal(t, "not json\n"+`{"level":"info","request":"a string","message":"meow"}`+"\n", stdout.String(), "Non-object fields should be left alone")
+}
+
+func TestWriterConfig_Compile_Fields(t *testing.T) {
+	var stderr, stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "stdout", "fields": {"service": "bridge", "dc": "eu1", "replicas": 3}},
+	    {"type": "stderr"},
+	    {"type": "group", "format": "pretty", "fields": {"dc": "eu1"}, "writers": [{"type": "stderr"}]}
+	  ],
+	  "metadata": {"service": "global"},
+	  "timestamp": false
+	}`)
+
+	log.Info().Int("replicas", 5).Msg("meow")
+	assert.Equal(t, `{"level":"info","service":"global","replicas":5,"dc":"eu1","message":"meow"}`+"\n", stdout.String(), "Existing keys shouldn't be overwritten")
+	assert.Equal(t, `{"level":"info","service":"global","replicas":5,"message":"meow"}`+"\n"+
+		`<nil> INF meow dc=eu1 replicas=5 service=global`+"\n", stderr.String(), "Only writers with fields should get them")
+
+	_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, Fields: map[string]any{"ch": make(chan int)}}).Compile()
+	assert.ErrorContains(t, err, `invalid fields: failed to marshal value of "ch"`)
+}
+
+func TestWriterConfig_Compile_HTMLSafe(t *testing.T) {
+	var stderr, stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "stdout", "html_safe": true, "metadata_override": {"env": "<prod>"}},
+	    {"type": "stderr"}
+	  ],
+	  "metadata": {"env": "dev"},
+	  "timestamp": false
+	}`)
+
+	log.Info().Str("name", "<script>alert(1)</script> & more").Msg("meow")
+	assert.Equal(t, `{"level":"info","env":"\u003cprod\u003e","name":"\u003cscript\u003ealert(1)\u003c/script\u003e \u0026 more","message":"meow"}`+"\n", stdout.String(), "Stdout should be HTML-escaped")
+	assert.Equal(t, `{"level":"info","env":"dev","name":"<script>alert(1)</script> & more","message":"meow"}`+"\n", stderr.String(), "Stderr should be raw")
+
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "stdout", "format": "pretty", "html_safe": true}]}`), &cfg))
+	_, err := cfg.Compile()
+	assert.EqualError(t, err, "writers[0].html_safe: only supported with the json format")
+}
+
+func TestWriterConfig_Compile_NotifyOnLevel(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "stdout", "format": "pretty", "notify_on_level": "error"}
+	  ],
+	  "timestamp": false
+	}`)
+
+	log.Warn().Msg("meow")
+	assert.Equal(t, "<nil> WRN meow\n", out.String(), "Warning should not ring the bell")
+	out.Reset()
+
+	log.Error().Msg("meow #2")
+	assert.Equal(t, "<nil> ERR meow #2\n\a", out.String(), "Error should ring the bell after the line")
+	out.Reset()
+
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "stdout", "notify_on_level": "error"}]}`), &cfg))
+	_, err := cfg.Compile()
+	assert.Error(t, err, "notify_on_level should not be allowed with JSON format")
+}
+
+func TestWriterConfig_Compile_CustomLevels(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "stdout", "format": "pretty", "min_level": 10}
+	  ],
+	  "min_level": -1,
+	  "timestamp": false
+	}`)
+
+	log.WithLevel(zerolog.PanicLevel).Msg("meow")
+	assert.Empty(t, out.String(), "Panic level should be below the custom minimum")
+
+	log.WithLevel(zerolog.Level(10)).Msg("meow #2")
+	assert.Equal(t, "<nil> 10 meow #2\n", out.String(), "Custom level should be rendered as a number")
+
+	var cfg zeroconfig.Config
+	assert.Error(t, json.Unmarshal([]byte(`{"min_level": 1000}`), &cfg), "Out-of-range levels should be rejected")
+	assert.Error(t, json.Unmarshal([]byte(`{"writers": [{"type": "stdout", "max_level": "meow"}]}`), &cfg), "Unknown level names should be rejected")
+}
+
+func TestWriterConfig_Compile_ColorPrecedence(t *testing.T) {
+	defer func() {
+		zeroconfig.Getenv = os.Getenv
+	}()
+	tests := []struct {
+		format   string
+		color    string
+		env      map[string]string
+		expected bool
+	}{
+		{"pretty", "", nil, false},
+		{"pretty-colored", "", nil, true},
+		{"pretty-colored", "", map[string]string{"NO_COLOR": "1"}, false},
+		{"pretty-colored", "", map[string]string{"NO_COLOR": ""}, true},
+		{"pretty", "", map[string]string{"FORCE_COLOR": "1"}, true},
+		{"pretty", "auto", map[string]string{"FORCE_COLOR": "1"}, true},
+		{"pretty-colored", "", map[string]string{"NO_COLOR": "1", "FORCE_COLOR": "1"}, false},
+		{"pretty", "always", map[string]string{"NO_COLOR": "1"}, true},
+		{"pretty-colored", "never", map[string]string{"FORCE_COLOR": "1"}, false},
+		{"pretty-colored", "never", nil, false},
+		{"pretty", "always", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%s/%v", test.format, test.color, test.env), func(t *testing.T) {
+			zeroconfig.Getenv = func(key string) string {
+				return test.env[key]
+			}
+			var out bytes.Buffer
+			zeroconfig.Stdout = &out
+			log := compile(t, fmt.Sprintf(`{
+			  "writers": [{"type": "stdout", "format": "%s", "color": "%s"}],
+			  "timestamp": false
+			}`, test.format, test.color))
+			log.Info().Msg("meow")
+			assert.Equal(t, test.expected, strings.Contains(out.String(), "\x1b["), "Output should be colored: %v", test.expected)
+		})
+	}
+}
+
+func TestConfig_CompileOrFallback(t *testing.T) {
+	defer func() {
+		zeroconfig.Stderr = os.Stderr
+	}()
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var stderr bytes.Buffer
+	zeroconfig.Stderr = &stderr
+
+	working := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}}
+	log, err := working.CompileOrFallback(nil)
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.NotEmpty(t, out.String(), "Working config should be used directly")
+	assert.Empty(t, stderr.String())
+
+	broken := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{Type: "meow"}}}
+	log, err = broken.CompileOrFallback(nil)
+	require.Error(t, err, "Original error should be returned")
+	assert.Contains(t, err.Error(), `unknown writer type "meow"`)
+	require.NotNil(t, log)
+	assert.Contains(t, stderr.String(), "Failed to compile logging config", "Original error should be logged through the fallback")
+	assert.Contains(t, stderr.String(), `unknown writer type \"meow\"`)
+	stderr.Reset()
+	log.Debug().Msg("meow")
+	assert.Empty(t, stderr.String(), "Default fallback should log at info level")
+
+	out.Reset()
+	log, err = broken.CompileOrFallback(&working)
+	require.Error(t, err)
+	assert.Contains(t, out.String(), "Failed to compile logging config", "Custom fallback should be used")
+
+	log, err = broken.CompileOrFallback(&broken)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown writer type "meow"`, "Original error should be returned if the fallback fails")
+	require.NotNil(t, log, "Nop logger should be returned if the fallback fails")
+	assert.Equal(t, zerolog.Disabled, log.GetLevel())
+}
+
+func TestConfig_MustCompile(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}}
+	cfg.MustCompile().Info().Msg("meow")
+	assert.NotEmpty(t, out.String())
+
+	cfg.Writers = append(cfg.Writers, zeroconfig.WriterConfig{Type: "meow"})
+	assert.PanicsWithError(t, `zeroconfig: invalid logging config: writers[1].type: unknown writer type "meow" (expected one of `+writerTypeList(t)+`)`, func() {
+		cfg.MustCompile()
+	})
+
+	cfg.Writers = append(cfg.Writers, zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeFile})
+	assert.PanicsWithError(t, `zeroconfig: invalid logging config: writers[1].type: unknown writer type "meow" (expected one of `+writerTypeList(t)+`); writers[2].filename: required for the file writer`, func() {
+		cfg.MustCompile()
+	}, "Panic message should list every invalid writer on one line")
+}
+
+func TestConfig_Compile_MetadataFuncs(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var calls []string
+	metaFunc := func(key string, val any, err error) func() (any, error) {
+		return func() (any, error) {
+			calls = append(calls, key)
+			return val, err
+		}
+	}
+	cfg := zeroconfig.Config{
+		Writers:   []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		Timestamp: ptr(false),
+		Metadata:  map[string]any{"static": "a", "both": "static"},
+		MetadataFuncs: map[string]func() (any, error){
+			"shard":    metaFunc("shard", 3, nil),
+			"both":     metaFunc("both", "func", nil),
+			"license":  metaFunc("license", "meow", nil),
+			"optional": zeroconfig.OptionalMetadata(metaFunc("optional", nil, fmt.Errorf("not found"))),
+		},
+	}
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"license", "optional", "shard"}, calls, "Funcs should be called in sorted order, skipping static keys")
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","both":"static","static":"a","license":"meow","shard":3,"message":"meow"}`+"\n", out.String())
+
+	cfg.MetadataFuncs["broken"] = metaFunc("broken", nil, fmt.Errorf("license server unreachable"))
+	_, err = cfg.Compile()
+	require.Error(t, err)
+	assert.Equal(t, `failed to compute metadata "broken": license server unreachable`, err.Error())
+}
+
+func TestConfig_Compile_MetadataErrors(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	type cyclic struct {
+		Self *cyclic
+	}
+	loop := &cyclic{}
+	loop.Self = loop
+	cfg := zeroconfig.Config{
+		Writers:   []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		Timestamp: ptr(false),
+		Metadata:  map[string]any{"fine": "a", "channel": make(chan int)},
+	}
+	_, err := cfg.Compile()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `metadata "channel" can't be serialized as JSON`)
+
+	cfg.Metadata = map[string]any{"fine": "a"}
+	cfg.MetadataFuncs = map[string]func() (any, error){
+		"cycle": func() (any, error) { return loop, nil },
+	}
+	_, err = cfg.Compile()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `metadata "cycle" can't be serialized as JSON`)
+
+	cfg.Metadata["func"] = func() {}
+	cfg.MetadataFuncs = nil
+	cfg.MetadataErrors = zeroconfig.MetadataErrorsSkip
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","fine":"a","message":"meow"}`+"\n", out.String())
+
+	out.Reset()
+	cfg.Metadata = map[string]any{"id": struct {
+		ID int
+		Ch chan int
+	}{ID: 5}}
+	cfg.MetadataErrors = zeroconfig.MetadataErrorsStringify
+	log, err = cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","id":"{ID:5 Ch:<nil>}","message":"meow"}`+"\n", out.String())
+
+	cfg.MetadataErrors = "meow"
+	_, err = cfg.Compile()
+	assert.EqualError(t, err, `unknown metadata_errors policy "meow"`)
+}
+
+func TestConfig_Compile_RawMetadata(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "raw_metadata": {"labels": {"zone": "b", "alloc": 1.50, "big": 12345678901234567890}}
+	}`)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","labels":{"zone": "b", "alloc": 1.50, "big": 12345678901234567890},"message":"meow"}`+"\n", out.String())
+	out.Reset()
+
+	var cfg zeroconfig.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+writers: [{type: stdout}]
+timestamp: false
+raw_metadata:
+  labels: '{"zone":"b","alloc":1.50}'
+metadata:
+  blob: meow
+`), &cfg))
+	cfg.Metadata["blob"] = json.RawMessage(`{"b":1e3,"a":[]}`)
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","blob":{"b":1e3,"a":[]},"labels":{"zone":"b","alloc":1.50},"message":"meow"}`+"\n", out.String())
+
+	assert.Error(t, yaml.Unmarshal([]byte(`raw_metadata: {labels: '{"zone":'}`), &cfg), "Invalid raw JSON should be rejected when unmarshaling YAML")
+	cfg.Metadata["blob"] = json.RawMessage(`{"b":`)
+	_, err = cfg.Compile()
+	assert.Error(t, err, "Invalid raw JSON should be rejected in Compile")
+	cfg.Metadata = map[string]any{"labels": "meow"}
+	_, err = cfg.Compile()
+	assert.Error(t, err, "Duplicate keys in metadata and raw_metadata should be rejected")
+}
+
+func TestConfig_Compile_MetadataNumbers(t *testing.T) {
+	const expected = `{"level":"info","big":"18446744073709551616","id":1234567890123456789,"nested":{"id":1234567890123456789},"port":8443,"ratio":0.5,"message":"meow"}` + "\n"
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "metadata": {"port": 8443, "id": 1234567890123456789, "ratio": 0.5, "big": 18446744073709551616, "nested": {"id": 1234567890123456789}}
+	}`)
+	lines := strings.Split(out.String(), "\n")
+	require.Len(t, lines, 2, "Too large integer should cause a warning")
+	assert.Contains(t, lines[0], `"metadata_key":"big"`)
+	out.Reset()
+	log.Info().Msg("meow")
+	assert.Equal(t, expected, out.String(), "Integers should be preserved exactly")
+	out.Reset()
+
+	var cfg zeroconfig.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+writers: [{type: stdout}]
+timestamp: false
+metadata:
+  port: 8443
+  id: 1234567890123456789
+  ratio: 0.5
+  big: 18446744073709551616
+  nested:
+    id: 1234567890123456789
+`), &cfg))
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	out.Reset()
+	log.Info().Msg("meow")
+	assert.Equal(t, expected, out.String(), "Integers from YAML should be preserved exactly")
+}
+
+func TestConfig_Compile_EnvMetadata(t *testing.T) {
+	defer func() {
+		zeroconfig.Getenv = os.Getenv
+	}()
+	const cfgYAML = `
+writers: [{type: stdout}]
+timestamp: false
+metadata:
+  service: api
+  region: local
+env_metadata:
+  prod:
+    region: eu-west-1
+    replicas: 3
+  dev:
+    debug: true
+`
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	compileEnv := func(env, envVar string) {
+		zeroconfig.Getenv = func(key string) string {
+			if key == zeroconfig.EnvironmentEnvVar {
+				return envVar
+			}
+			return ""
+		}
+		var cfg zeroconfig.Config
+		require.NoError(t, yaml.Unmarshal([]byte(cfgYAML), &cfg))
+		cfg.Environment = env
+		log, err := cfg.Compile()
+		require.NoError(t, err)
+		out.Reset()
+		log.Info().Msg("meow")
+	}
+
+	compileEnv("prod", "dev")
+	assert.Equal(t, `{"level":"info","region":"eu-west-1","replicas":3,"service":"api","message":"meow"}`+"\n", out.String(), "Environment field should take precedence over the env var")
+	compileEnv("", "dev")
+	assert.Equal(t, `{"level":"info","debug":true,"region":"local","service":"api","message":"meow"}`+"\n", out.String(), "Env var should select the environment")
+	compileEnv("", "")
+	assert.Equal(t, `{"level":"info","region":"local","service":"api","message":"meow"}`+"\n", out.String(), "No environment should use base metadata")
+
+	compileEnv("staging", "")
+	lines := strings.Split(out.String(), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, `{"level":"info","region":"local","service":"api","message":"meow"}`, lines[0], "Unknown environment should use base metadata")
+	out.Reset()
+	var cfg zeroconfig.Config
+	require.NoError(t, yaml.Unmarshal([]byte(cfgYAML), &cfg))
+	cfg.Environment = "staging"
+	_, err := cfg.Compile()
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), `"environment":"staging","available_environments":["dev","prod"]`, "Unknown environment should be warned about")
+}
+
+func TestWriterConfig_CompileLevelWriter(t *testing.T) {
+	var pretty, stderr bytes.Buffer
+	zeroconfig.Stdout = &pretty
+	zeroconfig.Stderr = &stderr
+	prettyWriter, err := (&zeroconfig.WriterConfig{
+		Type:     zeroconfig.WriterTypeStdout,
+		Format:   zeroconfig.LogFormatPretty,
+		Color:    zeroconfig.ColorNever,
+		MaxLevel: ptr(zerolog.InfoLevel),
+	}).CompileLevelWriter()
+	require.NoError(t, err)
+	jsonWriter, err := (&zeroconfig.WriterConfig{
+		Type:     zeroconfig.WriterTypeStderr,
+		MinLevel: ptr(zerolog.WarnLevel),
+	}).CompileLevelWriter()
+	require.NoError(t, err)
+
+	log := zerolog.New(zerolog.MultiLevelWriter(prettyWriter, jsonWriter))
+	log.Info().Msg("info")
+	log.Warn().Msg("warn")
+	assert.Equal(t, "<nil> INF info\n", pretty.String(), "Pretty writer should only get events up to info")
+	assert.Equal(t, `{"level":"warn","message":"warn"}`+"\n", stderr.String(), "JSON writer should only get events from warn")
+
+	plainPretty, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, Format: zeroconfig.LogFormatPretty, Color: zeroconfig.ColorNever}).CompileLevelWriter()
+	require.NoError(t, err)
+	pretty.Reset()
+	_, err = plainPretty.WriteLevel(zerolog.ErrorLevel, []byte(`{"level":"error","message":"meow"}`+"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "<nil> ERR meow\n", pretty.String())
+
+	fileWriter, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeFile, FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(t.TempDir(), "test.log")}}).CompileLevelWriter()
+	require.NoError(t, err)
+	closer, ok := fileWriter.(io.Closer)
+	require.True(t, ok, "File writer should be closable")
+	assert.NoError(t, closer.Close())
+}
+
+func TestConfig_Compile_IncludeUptime(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	cfg := zeroconfig.Config{
+		Writers:       []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		Timestamp:     ptr(false),
+		IncludeUptime: true,
+	}
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("first")
+	time.Sleep(20 * time.Millisecond)
+	log.Info().Msg("second")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	var first, second struct {
+		Uptime float64 `json:"uptime"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Less(t, first.Uptime, float64(20))
+	assert.GreaterOrEqual(t, second.Uptime-first.Uptime, float64(20), "Uptime should be computed for each event")
+
+	out.Reset()
+	cfg.UptimeFieldName = "process_uptime"
+	log, err = cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Contains(t, out.String(), `"process_uptime":`)
+}
+
+func TestConfig_Compile_MetadataList(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	expected := `{"level":"info","service":"bridge","version":1.2,"instance":3,"message":"meow"}` + "\n"
+
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "metadata": [{"key": "service", "value": "bridge"}, {"key": "version", "value": 1.2}, {"key": "instance", "value": 3}]
+	}`)
+	log.Info().Msg("meow")
+	assert.Equal(t, expected, out.String(), "JSON list form should preserve declaration order")
+
+	out.Reset()
+	var cfg zeroconfig.Config
+	require.NoError(t, yaml.Unmarshal([]byte(`
+writers:
+- type: stdout
+timestamp: false
+metadata:
+- key: service
+  value: bridge
+- key: version
+  value: 1.2
+- key: instance
+  value: 3
+`), &cfg))
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, expected, out.String(), "YAML list form should preserve declaration order")
+
+	out.Reset()
+	cfg.Environment = "prod"
+	cfg.EnvMetadata = map[string]map[string]any{"prod": {"version": "2.0", "az": "b"}}
+	log, err = cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","service":"bridge","version":"2.0","instance":3,"az":"b","message":"meow"}`+"\n", out.String(),
+		"Environment overrides should keep the list position and new keys should be added after the list")
+
+	for name, data := range map[string]string{
+		"mixed":     `{"metadata": [{"key": "service", "value": "bridge"}, {"version": 1.2}]}`,
+		"extra":     `{"metadata": [{"key": "service", "value": "bridge", "meow": true}]}`,
+		"duplicate": `{"metadata": [{"key": "service", "value": "a"}, {"key": "service", "value": "b"}]}`,
+		"scalar":    `{"metadata": "meow"}`,
+	} {
+		err = json.Unmarshal([]byte(data), &zeroconfig.Config{})
+		assert.Error(t, err, name)
+	}
+	err = yaml.Unmarshal([]byte("metadata:\n- key: service\n  value: bridge\n- version: 1.2\n"), &zeroconfig.Config{})
+	assert.EqualError(t, err, "metadata[1]: list entries must be objects with only a key and a value")
+
+	_, err = (&zeroconfig.Config{
+		Writers:      []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		Metadata:     map[string]any{"a": "b"},
+		MetadataList: []zeroconfig.MetadataEntry{{Key: "c", Value: "d"}},
+	}).Compile()
+	assert.EqualError(t, err, "metadata can't be set as both a map and a list")
+}
+
+func TestWriterConfig_Compile_Discard(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "discard", "format": "pretty"},
+	    {"type": "stdout"},
+	    {"type": "none"}
+	  ],
+	  "timestamp": false
+	}`)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", out.String())
+}
+
+func TestConfig_Compile_OnlyDiscard(t *testing.T) {
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "none"}],
+	  "metadata": {"service": "meow"}
+	}`), &cfg))
+	assert.Equal(t, zeroconfig.WriterTypeDiscard, cfg.Writers[0].Type, "none should be parsed as discard")
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	assert.NotEqual(t, zerolog.Disabled, log.GetLevel(), "Logger with only discard writers shouldn't be a nop logger")
+
+	var out bytes.Buffer
+	child := log.Output(&out)
+	child.Info().Msg("meow")
+	assert.Contains(t, out.String(), `"service":"meow"`, "Child loggers should carry metadata")
+}
+
+func TestWriterConfig_Compile_Closer(t *testing.T) {
+	var recorders []*closeRecorder
+	zeroconfig.RegisterWriter("closable", func(wc *zeroconfig.WriterConfig) (io.Writer, error) {
+		cr := &closeRecorder{}
+		recorders = append(recorders, cr)
+		return cr, nil
+	})
+	writer, err := (&zeroconfig.WriterConfig{
+		Type:   zeroconfig.WriterTypeGroup,
+		Format: zeroconfig.LogFormatPretty,
+		Writers: []zeroconfig.WriterConfig{
+			{Type: "closable"},
+			{Type: "closable", MinLevel: ptr(zerolog.WarnLevel)},
+			{Type: zeroconfig.WriterTypeStdout},
+		},
+	}).CompileLevelWriter()
+	require.NoError(t, err)
+	closer, ok := writer.(io.Closer)
+	require.True(t, ok, "Writer should be closable even when wrapped in a group and pretty format")
+	require.NoError(t, closer.Close())
+	require.NoError(t, closer.Close())
+	require.Len(t, recorders, 2)
+	for _, cr := range recorders {
+		assert.Equal(t, 1, cr.closed, "Outputs should be closed exactly once")
+	}
+
+	writer, err = (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, Format: zeroconfig.LogFormatPretty}).CompileLevelWriter()
+	require.NoError(t, err)
+	_, ok = writer.(io.Closer)
+	assert.False(t, ok, "Stdout writers shouldn't be closable")
 }
@@ -9,27 +9,230 @@
 package zeroconfig
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"log/syslog"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/journald"
 )
 
+// SyslogDial is used by the syslog writer types to connect to the syslog service.
+// The parameters are the same as in syslog.Dial, which is used by default.
+var SyslogDial = func(network, raddr string, priority syslog.Priority, tag string) (zerolog.SyslogWriter, error) {
+	sl, err := syslog.Dial(network, raddr, priority, tag)
+	if err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// checkSyslogSocket validates the syslog address (see checkSyslogAddress), and that the socket exists
+// if the host is a path.
+func checkSyslogSocket(network, host string, lazy bool) error {
+	if err := checkSyslogAddress(network, host); err != nil {
+		return err
+	} else if !strings.HasPrefix(host, "/") || lazy {
+		return nil
+	}
+	info, err := os.Stat(host)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("syslog socket %s doesn't exist", host)
+	} else if err != nil {
+		return fmt.Errorf("failed to check syslog socket: %w", err)
+	} else if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("syslog host %s is not a socket", host)
+	}
+	return nil
+}
+
+// syslogConn holds the connection of a syslog writer. If a write fails, the connection is re-dialed once and
+// the write is retried before giving up, because log/syslog only reconnects in some cases.
+// With lazy_connect, the first connection is made on the first write, and failed dials are retried on later writes.
+type syslogConn struct {
+	dial func() (zerolog.SyslogWriter, error)
+	lock sync.Mutex
+	conn zerolog.SyslogWriter
+}
+
+func (sc *syslogConn) connect() error {
+	conn, err := sc.dial()
+	if err != nil {
+		return err
+	}
+	sc.conn = conn
+	return nil
+}
+
+func (sc *syslogConn) closeConn() {
+	if closer, ok := sc.conn.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	sc.conn = nil
+}
+
+func (sc *syslogConn) send(fn func(zerolog.SyslogWriter) error) error {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if sc.conn == nil {
+		if err := sc.connect(); err != nil {
+			return err
+		}
+	}
+	err := fn(sc.conn)
+	if err == nil {
+		return nil
+	}
+	sc.closeConn()
+	if dialErr := sc.connect(); dialErr != nil {
+		return fmt.Errorf("%w (reconnecting failed: %v)", err, dialErr)
+	}
+	return fn(sc.conn)
+}
+
+// Close closes the connection if one has been made.
+func (sc *syslogConn) Close() error {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	closer, ok := sc.conn.(io.Closer)
+	sc.conn = nil
+	if ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// syslogOutput is the output of the syslog writer types. It sends events with the severity matching their level.
+type syslogOutput struct {
+	*syslogConn
+	prefix      string
+	messageOnly bool
+}
+
 func compileSyslog(wc *WriterConfig) (io.Writer, error) {
-	sl, err := syslog.Dial(wc.Network, wc.Host, syslog.Priority(wc.Flags), wc.Tag)
+	if err := checkSyslogSocket(wc.Network, wc.Host, wc.LazyConnect); err != nil {
+		return nil, err
+	} else if err = wc.checkSyslogPayload(); err != nil {
+		return nil, err
+	}
+	facility, err := parseSyslogFacility(wc.Facility)
 	if err != nil {
 		return nil, err
 	}
+	network, host, priority, tag := wc.Network, wc.Host, syslog.Priority(wc.Flags), wc.Tag
+	if facility >= 0 {
+		// The severity part of the priority is only used for plain writes, the level methods replace it.
+		priority = syslog.Priority(facility) | priority&0x07
+	}
+	conn := &syslogConn{dial: func() (zerolog.SyslogWriter, error) {
+		return SyslogDial(network, host, priority, tag)
+	}}
+	if !wc.LazyConnect {
+		if err = conn.connect(); err != nil {
+			return nil, err
+		}
+	}
+	output := &syslogOutput{syslogConn: conn, messageOnly: wc.Payload == SyslogPayloadMessage}
 	if wc.Type == WriterTypeSyslogCEE {
-		return zerolog.SyslogCEEWriter(sl), nil
-	} else {
-		return zerolog.SyslogLevelWriter(sl), nil
+		// See http://cee.mitre.org/language/1.0-beta1/clt.html#syslog
+		output.prefix = "@cee:"
+	}
+	return output, nil
+}
+
+// payload returns the message to send to syslog for the given event.
+func (so *syslogOutput) payload(p []byte) string {
+	if so.messageOnly {
+		if obj, err := parseJSONObject(p); err == nil {
+			var msg string
+			if val, ok := obj.get(zerolog.MessageFieldName); ok && json.Unmarshal(val, &msg) == nil {
+				return msg
+			}
+		}
+	}
+	return so.prefix + string(p)
+}
+
+func (so *syslogOutput) Write(p []byte) (n int, err error) {
+	msg := so.payload(p)
+	err = so.send(func(w zerolog.SyslogWriter) error {
+		_, err := w.Write([]byte(msg))
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
+	return len(p), nil
 }
 
-func compileJournald(_ *WriterConfig) (io.Writer, error) {
-	return journald.NewJournalDWriter(), nil
+// WriteLevel sends the event with the syslog severity of the level. Custom levels use the nearest standard level.
+func (so *syslogOutput) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	msg := so.payload(p)
+	var method func(w zerolog.SyslogWriter) error
+	switch nearestStandardLevel(level) {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		method = func(w zerolog.SyslogWriter) error { return w.Debug(msg) }
+	case zerolog.InfoLevel, zerolog.NoLevel:
+		method = func(w zerolog.SyslogWriter) error { return w.Info(msg) }
+	case zerolog.WarnLevel:
+		method = func(w zerolog.SyslogWriter) error { return w.Warning(msg) }
+	case zerolog.ErrorLevel:
+		method = func(w zerolog.SyslogWriter) error { return w.Err(msg) }
+	case zerolog.FatalLevel:
+		method = func(w zerolog.SyslogWriter) error { return w.Emerg(msg) }
+	case zerolog.PanicLevel:
+		method = func(w zerolog.SyslogWriter) error { return w.Crit(msg) }
+	default:
+		return len(p), nil
+	}
+	if err = so.send(method); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journaldLevelWriter replaces custom levels in events with the nearest standard level,
+// because zerolog's journald writer maps levels to priorities by parsing the level field.
+type journaldLevelWriter struct {
+	io.Writer
+}
+
+func (jw journaldLevelWriter) WriteLevel(l zerolog.Level, p []byte) (n int, err error) {
+	if std := nearestStandardLevel(l); std != l {
+		if obj, parseErr := parseJSONObject(p); parseErr == nil {
+			if i := obj.index(zerolog.LevelFieldName); i >= 0 {
+				obj[i].Value = appendJSONString(nil, zerolog.LevelFieldMarshalFunc(std))
+				_, err = jw.Writer.Write(append(obj.appendTo(nil), '\n'))
+				if err != nil {
+					return 0, err
+				}
+				return len(p), nil
+			}
+		}
+	}
+	return jw.Writer.Write(p)
+}
+
+func (jw journaldLevelWriter) Close() error {
+	if closer, ok := jw.Writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func compileJournald(wc *WriterConfig) (io.Writer, error) {
+	if wc.Socket != "" {
+		writer, err := newJournaldSocketWriter(wc.Socket)
+		if err != nil {
+			return nil, err
+		}
+		return journaldLevelWriter{writer}, nil
+	}
+	return journaldLevelWriter{journald.NewJournalDWriter()}, nil
 }
 
 func init() {
@@ -4,14 +4,301 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at http://mozilla.org/MPL/2.0/.
 
-// go:build unix
+//go:build unix
 
 package zeroconfig_test
 
 import (
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+	"go.mau.fi/zeroconfig/internal/testutil"
 )
 
 func TestWriterConfig_Compile_Journald(t *testing.T) {
 	compile(t, `{"writers": [{"type": "journald"}]}`)
 }
+
+func TestWriterConfig_Compile_JournaldSocket(t *testing.T) {
+	// Unix socket paths have a short length limit, so t.TempDir may be too long.
+	dir, err := os.MkdirTemp("", "zc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	log := compile(t, fmt.Sprintf(`{"writers": [{"type": "journald", "socket": "%s"}]}`, path))
+	receive := func() map[string]string {
+		buf := make([]byte, 65536)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		fields, err := testutil.DecodeJournalEntry(buf[:n])
+		require.NoError(t, err)
+		return fields
+	}
+
+	log.Warn().Str("user_id", "@meow:example.com").Int("count", 5).Bool("ok", false).Str("stack", "line 1\nline 2").Msg("hello")
+	fields := receive()
+	assert.Contains(t, fields["JSON"], `"message":"hello"`)
+	delete(fields, "JSON")
+	assert.Equal(t, map[string]string{
+		"PRIORITY": "4",
+		"MESSAGE":  "hello",
+		"USER_ID":  "@meow:example.com",
+		"COUNT":    "5",
+		"OK":       "false",
+		"STACK":    "line 1\nline 2",
+	}, fields)
+
+	log.Error().Msg("error")
+	assert.Equal(t, "3", receive()["PRIORITY"])
+	log.WithLevel(10).Msg("custom")
+	assert.Equal(t, "0", receive()["PRIORITY"], "Custom levels should be mapped to the nearest standard level")
+	log.Log().Msg("no level")
+	assert.Equal(t, "5", receive()["PRIORITY"])
+}
+
+type syslogMessage struct {
+	Severity string
+	Message  string
+}
+
+type memorySyslog struct {
+	messages []syslogMessage
+	// If set, the next write fails with this error.
+	failNext error
+}
+
+func (ms *memorySyslog) add(severity, msg string) error {
+	if err := ms.failNext; err != nil {
+		ms.failNext = nil
+		return err
+	}
+	ms.messages = append(ms.messages, syslogMessage{severity, msg})
+	return nil
+}
+
+func (ms *memorySyslog) Write(p []byte) (int, error) { return len(p), ms.add("write", string(p)) }
+func (ms *memorySyslog) Debug(m string) error        { return ms.add("debug", m) }
+func (ms *memorySyslog) Info(m string) error         { return ms.add("info", m) }
+func (ms *memorySyslog) Warning(m string) error      { return ms.add("warning", m) }
+func (ms *memorySyslog) Err(m string) error          { return ms.add("err", m) }
+func (ms *memorySyslog) Emerg(m string) error        { return ms.add("emerg", m) }
+func (ms *memorySyslog) Crit(m string) error         { return ms.add("crit", m) }
+
+type syslogDialParams struct {
+	Network  string
+	Host     string
+	Priority syslog.Priority
+	Tag      string
+}
+
+func mockSyslogDial(t *testing.T, err error) (*memorySyslog, *syslogDialParams) {
+	origDial := zeroconfig.SyslogDial
+	t.Cleanup(func() {
+		zeroconfig.SyslogDial = origDial
+	})
+	var mem memorySyslog
+	var params syslogDialParams
+	zeroconfig.SyslogDial = func(network, raddr string, priority syslog.Priority, tag string) (zerolog.SyslogWriter, error) {
+		params = syslogDialParams{network, raddr, priority, tag}
+		if err != nil {
+			return nil, err
+		}
+		return &mem, nil
+	}
+	return &mem, &params
+}
+
+func TestWriterConfig_Compile_Syslog(t *testing.T) {
+	mem, params := mockSyslogDial(t, nil)
+	log := compile(t, `{
+	  "writers": [{"type": "syslog", "network": "udp", "host": "localhost:514", "flags": 8, "tag": "meow"}],
+	  "timestamp": false
+	}`)
+	assert.Equal(t, syslogDialParams{"udp", "localhost:514", syslog.LOG_USER, "meow"}, *params)
+
+	log.Trace().Msg("trace")
+	log.Debug().Msg("debug")
+	log.Info().Msg("info")
+	log.Warn().Msg("warn")
+	log.Error().Msg("error")
+	log.WithLevel(zerolog.FatalLevel).Msg("fatal")
+	log.WithLevel(zerolog.PanicLevel).Msg("panic")
+	log.WithLevel(10).Msg("custom")
+	log.Log().Msg("nolevel")
+	assert.Equal(t, []syslogMessage{
+		{"debug", `{"level":"trace","message":"trace"}` + "\n"},
+		{"debug", `{"level":"debug","message":"debug"}` + "\n"},
+		{"info", `{"level":"info","message":"info"}` + "\n"},
+		{"warning", `{"level":"warn","message":"warn"}` + "\n"},
+		{"err", `{"level":"error","message":"error"}` + "\n"},
+		{"emerg", `{"level":"fatal","message":"fatal"}` + "\n"},
+		{"crit", `{"level":"panic","message":"panic"}` + "\n"},
+		{"crit", `{"level":"10","message":"custom"}` + "\n"},
+		{"info", `{"message":"nolevel"}` + "\n"},
+	}, mem.messages)
+}
+
+func TestWriterConfig_Compile_SyslogCEE(t *testing.T) {
+	mem, _ := mockSyslogDial(t, nil)
+	log := compile(t, `{"writers": [{"type": "syslog-cee"}], "timestamp": false}`)
+	log.Warn().Str("cat", "meow").Msg("hi")
+	assert.Equal(t, []syslogMessage{
+		{"warning", `@cee:{"level":"warn","cat":"meow","message":"hi"}` + "\n"},
+	}, mem.messages)
+}
+
+func TestWriterConfig_Compile_SyslogDialError(t *testing.T) {
+	mockSyslogDial(t, errors.New("connection refused"))
+	var cfg zeroconfig.Config
+	cfg.Writers = []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeSyslog}}
+	_, err := cfg.Compile()
+	require.Error(t, err)
+	assert.Equal(t, "failed to parse config for writer #1 (syslog): connection refused", err.Error())
+}
+
+func TestWriterConfig_Compile_SyslogUnixgramPath(t *testing.T) {
+	dir, err := os.MkdirTemp("", "zc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	log := compile(t, fmt.Sprintf(`{
+	  "writers": [{"type": "syslog", "network": "unixgram", "host": "%s", "flags": 8, "tag": "meow"}],
+	  "timestamp": false
+	}`, path))
+	log.Warn().Msg("hello")
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	// LOG_USER (8) | LOG_WARNING (4)
+	assert.Regexp(t, `^<12>`, msg)
+	assert.Contains(t, msg, ` meow[`)
+	assert.Contains(t, msg, `{"level":"warn","message":"hello"}`)
+}
+
+func TestWriterConfig_Compile_SyslogSocketPathErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "log")
+	compileErr := func(cfg zeroconfig.SyslogConfig) error {
+		_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeSyslog, SyslogConfig: cfg}).Compile()
+		return err
+	}
+	assert.EqualError(t, compileErr(zeroconfig.SyslogConfig{Network: "unixgram", Host: missing}),
+		fmt.Sprintf("syslog socket %s doesn't exist", missing))
+	assert.EqualError(t, compileErr(zeroconfig.SyslogConfig{Network: "udp", Host: missing}),
+		fmt.Sprintf("syslog host %s is a path, which requires the unix or unixgram network", missing))
+	assert.EqualError(t, compileErr(zeroconfig.SyslogConfig{Network: "unixgram"}),
+		"syslog network unixgram requires host to be an absolute socket path")
+}
+
+func TestWriterConfig_Compile_SyslogLazyConnect(t *testing.T) {
+	dir, err := os.MkdirTemp("", "zc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	log := compile(t, fmt.Sprintf(`{
+	  "writers": [{"type": "syslog", "network": "unixgram", "host": "%s", "lazy_connect": true}],
+	  "timestamp": false
+	}`, path))
+	// The socket doesn't exist yet, so this event is lost, but the next write retries connecting.
+	log.Info().Msg("lost")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	log.Error().Msg("delivered")
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	// LOG_KERN (0) | LOG_ERR (3)
+	assert.Regexp(t, `^<3>.*"message":"delivered"`, string(buf[:n]))
+}
+
+func TestWriterConfig_Compile_SyslogFacility(t *testing.T) {
+	dir, err := os.MkdirTemp("", "zc")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	log := compile(t, fmt.Sprintf(`{
+	  "writers": [{"type": "syslog", "network": "unixgram", "host": "%s", "flags": 12, "tag": "meow", "facility": "local0", "payload": "message"}],
+	  "timestamp": false
+	}`, path))
+	receive := func() string {
+		buf := make([]byte, 65536)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+	// LOG_LOCAL0 (128) | LOG_WARNING (4)
+	log.Warn().Str("cat", "meow").Msg("hello")
+	assert.Regexp(t, `^<132>.* meow\[\d+\]: hello\n$`, receive())
+	// LOG_LOCAL0 (128) | LOG_DEBUG (7)
+	log.Trace().Msg("trace")
+	assert.Regexp(t, `^<135>.*: trace\n$`, receive())
+	// LOG_LOCAL0 (128) | LOG_CRIT (2)
+	log.WithLevel(10).Msg("custom")
+	assert.Regexp(t, `^<130>.*: custom\n$`, receive())
+	log.Info().Int("count", 1).Send()
+	assert.Regexp(t, `^<134>.*: \{"level":"info","count":1\}\n$`, receive(), "Events without a message should be sent whole")
+}
+
+func TestWriterConfig_Compile_SyslogReconnect(t *testing.T) {
+	mem, _ := mockSyslogDial(t, nil)
+	dials := 0
+	dial := zeroconfig.SyslogDial
+	zeroconfig.SyslogDial = func(network, raddr string, priority syslog.Priority, tag string) (zerolog.SyslogWriter, error) {
+		dials++
+		return dial(network, raddr, priority, tag)
+	}
+	writer, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeSyslog}).Compile()
+	require.NoError(t, err)
+	lw := writer.(zerolog.LevelWriter)
+	assert.Equal(t, 1, dials)
+
+	mem.failNext = errors.New("broken pipe")
+	_, err = lw.WriteLevel(zerolog.ErrorLevel, []byte("first\n"))
+	require.NoError(t, err, "A failed write should be retried after reconnecting")
+	assert.Equal(t, 2, dials)
+	_, err = lw.WriteLevel(zerolog.InfoLevel, []byte("second\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, dials, "Successful writes shouldn't reconnect")
+	assert.Equal(t, []syslogMessage{{"err", "first\n"}, {"info", "second\n"}}, mem.messages)
+
+	zeroconfig.SyslogDial = func(network, raddr string, priority syslog.Priority, tag string) (zerolog.SyslogWriter, error) {
+		return nil, errors.New("connection refused")
+	}
+	mem.failNext = errors.New("broken pipe")
+	_, err = lw.WriteLevel(zerolog.WarnLevel, []byte("lost\n"))
+	assert.EqualError(t, err, "broken pipe (reconnecting failed: connection refused)")
+	assert.Len(t, mem.messages, 2)
+}
+
+func TestWriterConfig_Compile_EventlogUnsupported(t *testing.T) {
+	_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeEventlog}).Compile()
+	assert.EqualError(t, err, `writer type "eventlog" not supported on this OS`)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// ConsoleConfig contains options for the pretty formats, which use zerolog.ConsoleWriter.
+type ConsoleConfig struct {
+	// The order of the parts at the start of each line. Parts are time, level, caller and message,
+	// or the names of other fields, which are left out when the event doesn't have them.
+	// Defaults to time, level, caller, message.
+	PartsOrder []string `json:"parts_order,omitempty" yaml:"parts_order,omitempty" toml:"parts_order,omitempty"`
+	// Parts to leave out, e.g. time if the output already goes somewhere that adds timestamps.
+	PartsExclude []string `json:"parts_exclude,omitempty" yaml:"parts_exclude,omitempty" toml:"parts_exclude,omitempty"`
+	// Fields to leave out of the output. Unlike remove_fields, this only affects how events are displayed.
+	FieldsExclude []string `json:"fields_exclude,omitempty" yaml:"fields_exclude,omitempty" toml:"fields_exclude,omitempty"`
+	// Pad level labels with spaces to at least this many characters, so that messages line up.
+	LevelWidth int `json:"level_width,omitempty" yaml:"level_width,omitempty" toml:"level_width,omitempty"`
+	// Show levels as uppercase names (e.g. WARN) instead of abbreviations (e.g. WRN).
+	UppercaseLevel bool `json:"uppercase_level,omitempty" yaml:"uppercase_level,omitempty" toml:"uppercase_level,omitempty"`
+}
+
+// consolePartName maps the standard part names to the current zerolog field names, which may have been changed
+// with field_names. Other names are used as-is.
+func consolePartName(name string) string {
+	switch name {
+	case "time":
+		return zerolog.TimestampFieldName
+	case "level":
+		return zerolog.LevelFieldName
+	case "caller":
+		return zerolog.CallerFieldName
+	case "message":
+		return zerolog.MessageFieldName
+	default:
+		return name
+	}
+}
+
+func consolePartNames(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = consolePartName(name)
+	}
+	return parts
+}
+
+func (cc *ConsoleConfig) validate() error {
+	if cc == nil {
+		return nil
+	} else if cc.LevelWidth < 0 {
+		return fmt.Errorf("level_width must not be negative")
+	}
+	return nil
+}
+
+// apply sets the options on a console writer. The labels are the level labels of the config, if any.
+func (cc *ConsoleConfig) apply(cw *zerolog.ConsoleWriter, labels *levelLabels) error {
+	if err := cc.validate(); err != nil {
+		return err
+	}
+	if cc == nil {
+		if labels != nil {
+			cw.FormatLevel = labels.formatLevel(cw.NoColor)
+		}
+		return nil
+	}
+	cw.PartsOrder = consolePartNames(cc.PartsOrder)
+	cw.PartsExclude = consolePartNames(cc.PartsExclude)
+	cw.FieldsExclude = append([]string(nil), cc.FieldsExclude...)
+	var customParts bool
+	for _, part := range cw.PartsOrder {
+		switch part {
+		case zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.CallerFieldName, zerolog.MessageFieldName:
+		default:
+			// Other fields used as parts would be shown again with the rest of the fields.
+			cw.FieldsExclude = append(cw.FieldsExclude, part)
+			customParts = true
+		}
+	}
+	if customParts {
+		// Same as zerolog's default, except that missing parts are left out instead of showing %!s(<nil>).
+		cw.FormatFieldValue = func(i any) string {
+			if i == nil {
+				return ""
+			}
+			return fmt.Sprintf("%s", i)
+		}
+	}
+	if cc.LevelWidth > 0 || cc.UppercaseLevel {
+		cw.FormatLevel = cc.formatLevel(labels, cw.NoColor)
+	} else if labels != nil {
+		cw.FormatLevel = labels.formatLevel(cw.NoColor)
+	}
+	return nil
+}
+
+// formatLevel returns a zerolog.ConsoleWriter level formatter that applies UppercaseLevel and LevelWidth
+// on top of the level labels, or zerolog's default labels if there are no custom ones.
+func (cc *ConsoleConfig) formatLevel(labels *levelLabels, noColor bool) zerolog.Formatter {
+	return func(i any) string {
+		name, ok := i.(string)
+		if !ok && i == nil {
+			name = "???"
+		} else if !ok {
+			name = fmt.Sprint(i)
+		}
+		var level zerolog.Level
+		var known bool
+		if labels != nil {
+			level, known = labels.levels[name]
+		} else if parsed, err := zerolog.ParseLevel(name); err == nil && name != "" {
+			level, known = parsed, true
+		}
+		label := strings.ToUpper(name)
+		if known {
+			switch {
+			case cc.UppercaseLevel && labels != nil:
+				label = strings.ToUpper(labels.json[level])
+			case cc.UppercaseLevel:
+				label = strings.ToUpper(zerolog.LevelFieldMarshalFunc(level))
+			case labels != nil:
+				label = labels.pretty[level]
+			case shortLevelNames[level] != "":
+				label = shortLevelNames[level]
+			}
+		}
+		if padding := cc.LevelWidth - len(label); padding > 0 {
+			label += strings.Repeat(" ", padding)
+		}
+		if color, ok := levelColors[level]; ok && known && !noColor {
+			return color + label + "\x1b[0m"
+		}
+		return label
+	}
+}
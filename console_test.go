@@ -0,0 +1,85 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_Console(t *testing.T) {
+	configs := map[zeroconfig.ConfigFormat]string{
+		zeroconfig.ConfigFormatYAML: `
+writers:
+- type: stdout
+  format: pretty
+  console:
+    parts_order: [level, component, message]
+    fields_exclude: [secret]
+    level_width: 5
+    uppercase_level: true
+- type: stderr
+  format: pretty
+  console:
+    parts_exclude: [time]
+`,
+		zeroconfig.ConfigFormatJSON: `{"writers": [{
+  "type": "stdout", "format": "pretty",
+  "console": {"parts_order": ["level", "component", "message"], "fields_exclude": ["secret"], "level_width": 5, "uppercase_level": true}
+}, {
+  "type": "stderr", "format": "pretty",
+  "console": {"parts_exclude": ["time"]}
+}]}`,
+	}
+	for format, data := range configs {
+		t.Run(string(format), func(t *testing.T) {
+			cfg, err := zeroconfig.UnmarshalStrict([]byte(data), format)
+			require.NoError(t, err)
+			assert.Equal(t, &zeroconfig.ConsoleConfig{
+				PartsOrder:     []string{"level", "component", "message"},
+				FieldsExclude:  []string{"secret"},
+				LevelWidth:     5,
+				UppercaseLevel: true,
+			}, cfg.Writers[0].Console)
+			assert.Equal(t, []string{"time"}, cfg.Writers[1].Console.PartsExclude)
+
+			var stdout, stderr bytes.Buffer
+			zeroconfig.Stdout = &stdout
+			zeroconfig.Stderr = &stderr
+			log, err := cfg.Compile(zeroconfig.WithClock(func() time.Time {
+				return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			}))
+			require.NoError(t, err)
+			log.Info().Str("component", "sync").Str("secret", "hunter2").Int("count", 1).Msg("meow")
+			log.Error().Msg("hiss")
+			assert.Equal(t, "INFO  sync meow count=1\nERROR hiss\n", stdout.String())
+			assert.Equal(t, "INF meow component=sync count=1 secret=hunter2\nERR hiss\n", stderr.String())
+		})
+	}
+}
+
+func TestWriterConfig_Compile_ConsoleLevelLabels(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	log := compile(t, `{
+	  "writers": [{"type": "stdout", "format": "pretty-colored", "color": "always", "console": {"parts_exclude": ["time"], "level_width": 8}}],
+	  "level_style": "long"
+	}`)
+	log.Warn().Msg("meow")
+	assert.Equal(t, "\x1b[31mWARNING \x1b[0m meow\n", stdout.String())
+}
+
+func TestWriterConfig_Compile_ConsoleInvalid(t *testing.T) {
+	_, err := parseConfig(t, `{"writers": [{"type": "stdout", "format": "pretty", "console": {"level_width": -1}}]}`).Compile()
+	assert.EqualError(t, err, "writers[0].console: level_width must not be negative")
+}
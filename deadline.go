@@ -0,0 +1,37 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// RemainingFieldName is the field used by LoggerWithDeadline for the time left until the deadline.
+var RemainingFieldName = "remaining_ms"
+
+type deadlineHook struct {
+	deadline time.Time
+}
+
+func (dh deadlineHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	e.Int64(RemainingFieldName, time.Until(dh.deadline).Milliseconds())
+}
+
+// LoggerWithDeadline returns a child logger that adds the number of milliseconds remaining until
+// the deadline of the context to every event. The value is negative if the deadline has already passed.
+//
+// If the context doesn't have a deadline, the base logger is returned as-is.
+func LoggerWithDeadline(ctx context.Context, base zerolog.Logger) zerolog.Logger {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return base
+	}
+	return base.Hook(deadlineHook{deadline: deadline})
+}
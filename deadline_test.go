@@ -0,0 +1,60 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestLoggerWithDeadline(t *testing.T) {
+	var buf bytes.Buffer
+	base := zerolog.New(&buf)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	log := zeroconfig.LoggerWithDeadline(ctx, base)
+	log.Info().Msg("first")
+	time.Sleep(20 * time.Millisecond)
+	log.Info().Msg("second")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var first, second struct {
+		Remaining int64 `json:"remaining_ms"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.InDelta(t, time.Hour.Milliseconds(), first.Remaining, 1000)
+	assert.GreaterOrEqual(t, first.Remaining-second.Remaining, int64(20), "Remaining time should be computed when each event is logged")
+
+	buf.Reset()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	log = zeroconfig.LoggerWithDeadline(expired, base)
+	log.Info().Msg("late")
+	var late struct {
+		Remaining int64 `json:"remaining_ms"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &late))
+	assert.LessOrEqual(t, late.Remaining, int64(-1000), "Passed deadlines should be negative")
+
+	buf.Reset()
+	log = zeroconfig.LoggerWithDeadline(context.Background(), base)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", buf.String(), "Field should be omitted without a deadline")
+}
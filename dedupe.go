@@ -0,0 +1,284 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/rs/zerolog"
+)
+
+// DedupeOutputsMode describes what to do when multiple writers use the same output resource.
+type DedupeOutputsMode string
+
+const (
+	// DedupeOutputsWarn shares a single output between the writers and logs a warning. This is the default.
+	DedupeOutputsWarn DedupeOutputsMode = "warn"
+	// DedupeOutputsShare shares a single output between the writers without a warning.
+	DedupeOutputsShare DedupeOutputsMode = "share"
+	// DedupeOutputsError makes Compile return an error.
+	DedupeOutputsError DedupeOutputsMode = "error"
+)
+
+// outputResource returns an identifier for the resource the writer outputs to,
+// or an empty string if there's nothing that two writers could fight over.
+func (wc *WriterConfig) outputResource() string {
+	switch wc.Type {
+	case WriterTypeFile, WriterTypeRingFile:
+		filename := wc.expandedFilename()
+		if filename == "" {
+			return ""
+		}
+		path, err := filepath.Abs(filename)
+		if err != nil {
+			path = filepath.Clean(filename)
+		}
+		return "file:" + path
+	case WriterTypeSyslog, WriterTypeSyslogCEE:
+		return fmt.Sprintf("syslog:%s/%s", wc.Network, wc.Host)
+	case WriterTypeTail:
+		if wc.Listen == "" {
+			return ""
+		}
+		return "tail:" + wc.Listen
+	case WriterTypeRingBuffer:
+		if wc.RingBuffer == nil || wc.RingBuffer.Name == "" {
+			return ""
+		}
+		return "ringbuffer:" + wc.RingBuffer.Name
+	default:
+		return ""
+	}
+}
+
+// outputSettings contains the parts of a writer config that affect the shared output.
+// Level bounds, formats and other wrappers are applied separately for each writer.
+type outputSettings struct {
+	Type WriterType
+	SyslogConfig
+	FileConfig
+	RingFileConfig
+	TailConfig
+	RingBuffer *RingBufferConfig
+}
+
+func (wc *WriterConfig) outputSettings() outputSettings {
+	return outputSettings{
+		Type:           wc.Type,
+		SyslogConfig:   wc.SyslogConfig,
+		FileConfig:     wc.FileConfig,
+		RingFileConfig: wc.RingFileConfig,
+		TailConfig:     wc.TailConfig,
+		RingBuffer:     wc.RingBuffer,
+	}
+}
+
+type sharedOutput struct {
+	writerIndex int
+	settings    outputSettings
+	writer      io.Writer
+}
+
+type duplicateOutput struct {
+	resource    string
+	firstWriter int
+	writer      int
+}
+
+// outputRegistry keeps track of the outputs compiled for a config, so that writers using the same resource can share them.
+type outputRegistry struct {
+	mode    DedupeOutputsMode
+	outputs map[string]*sharedOutput
+	// The keys of outputs in the order they were added.
+	resources  []string
+	duplicates []duplicateOutput
+	// Outputs that hold resources which must be released when the Handle is closed.
+	closers []handleCloser
+	// Wrappers that must be closed before the outputs, like async writers that still have queued events.
+	// They're closed in reverse order, so that wrappers added later (which are further out) are closed first.
+	flushers []handleCloser
+	// Outputs that can report their health, by 1-based writer index.
+	healthCheckers map[int][]healthChecker
+	// Outputs that count events they failed to deliver, by 1-based writer index.
+	dropCounters map[int][]dropCounter
+	// All outputs used by each writer, including shared ones, by 1-based writer index.
+	writerOutputs map[int][]io.Writer
+}
+
+// handleCloser is implemented by outputs that need to be closed when the Handle they belong to is closed.
+type handleCloser interface {
+	closeWithHandle() error
+}
+
+// outputCloser closes a plain io.Closer output (like a file or syslog connection) when the Handle is closed.
+type outputCloser struct {
+	io.Closer
+}
+
+func (oc outputCloser) closeWithHandle() error {
+	return oc.Close()
+}
+
+// ownsOutput returns false for outputs that are shared with the rest of the process, like stdout,
+// which must not be closed even though they implement io.Closer.
+func ownsOutput(wc *WriterConfig, writer io.Writer) bool {
+	switch wc.Type {
+	case WriterTypeStdout, WriterTypeStderr, WriterTypeDiscard:
+		return false
+	}
+	return writer != os.Stdout && writer != os.Stderr && writer != Stdout && writer != Stderr
+}
+
+func newOutputRegistry(mode DedupeOutputsMode) (*outputRegistry, error) {
+	switch mode {
+	case "":
+		mode = DedupeOutputsWarn
+	case DedupeOutputsWarn, DedupeOutputsShare, DedupeOutputsError:
+	default:
+		return nil, fmt.Errorf("unknown dedupe_outputs mode %q", mode)
+	}
+	return &outputRegistry{
+		mode:           mode,
+		outputs:        make(map[string]*sharedOutput),
+		healthCheckers: make(map[int][]healthChecker),
+		dropCounters:   make(map[int][]dropCounter),
+		writerOutputs:  make(map[int][]io.Writer),
+	}, nil
+}
+
+// compile compiles the main output of the given writer, or returns the existing output if one was already compiled
+// for the same resource. The writer index is the 1-based index of the top-level writer, used in errors and warnings.
+func (or *outputRegistry) compile(wc *WriterConfig, writerIndex int) (io.Writer, error) {
+	resource := wc.outputResource()
+	if or == nil {
+		return wc.compileMain()
+	} else if resource == "" {
+		writer, err := or.compileMain(wc, writerIndex)
+		if err == nil {
+			or.writerOutputs[writerIndex] = append(or.writerOutputs[writerIndex], writer)
+		}
+		return writer, err
+	}
+	existing, ok := or.outputs[resource]
+	if !ok {
+		writer, err := or.compileMain(wc, writerIndex)
+		if err != nil {
+			return nil, err
+		}
+		or.outputs[resource] = &sharedOutput{writerIndex: writerIndex, settings: wc.outputSettings(), writer: writer}
+		or.resources = append(or.resources, resource)
+		or.writerOutputs[writerIndex] = append(or.writerOutputs[writerIndex], writer)
+		return writer, nil
+	} else if or.mode == DedupeOutputsError {
+		return nil, fmt.Errorf("output %s is already used by writer #%d", resource, existing.writerIndex)
+	} else if !reflect.DeepEqual(existing.settings, wc.outputSettings()) {
+		return nil, fmt.Errorf("output %s is already used by writer #%d with different settings", resource, existing.writerIndex)
+	}
+	if checker, ok := existing.writer.(healthChecker); ok {
+		or.healthCheckers[writerIndex] = append(or.healthCheckers[writerIndex], checker)
+	}
+	if or.mode == DedupeOutputsWarn {
+		or.duplicates = append(or.duplicates, duplicateOutput{
+			resource:    resource,
+			firstWriter: existing.writerIndex,
+			writer:      writerIndex,
+		})
+	}
+	or.writerOutputs[writerIndex] = append(or.writerOutputs[writerIndex], existing.writer)
+	return existing.writer, nil
+}
+
+func (or *outputRegistry) compileMain(wc *WriterConfig, writerIndex int) (io.Writer, error) {
+	writer, err := wc.compileMain()
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := writer.(handleCloser); ok {
+		or.closers = append(or.closers, closer)
+	} else if closer, ok := writer.(io.Closer); ok && ownsOutput(wc, writer) {
+		or.closers = append(or.closers, outputCloser{closer})
+	}
+	if checker, ok := writer.(healthChecker); ok {
+		or.healthCheckers[writerIndex] = append(or.healthCheckers[writerIndex], checker)
+	}
+	if counter, ok := writer.(dropCounter); ok {
+		or.dropCounters[writerIndex] = append(or.dropCounters[writerIndex], counter)
+	}
+	return writer, nil
+}
+
+// addFlusher adds a closer that must run before the outputs are closed, like an async writer
+// that still has events to write to its output.
+func (or *outputRegistry) addFlusher(closer handleCloser) {
+	or.flushers = append(or.flushers, closer)
+}
+
+// allClosers returns the flushers and outputs in the order they must be closed.
+func (or *outputRegistry) allClosers() []handleCloser {
+	closers := make([]handleCloser, 0, len(or.flushers)+len(or.closers))
+	for i := len(or.flushers) - 1; i >= 0; i-- {
+		closers = append(closers, or.flushers[i])
+	}
+	return append(closers, or.closers...)
+}
+
+// registryMark is a point in the compilation that rollback can return to.
+type registryMark struct {
+	closers   int
+	flushers  int
+	resources int
+}
+
+func (or *outputRegistry) mark() registryMark {
+	return registryMark{closers: len(or.closers), flushers: len(or.flushers), resources: len(or.resources)}
+}
+
+// rollback closes and forgets the outputs and flushers added after the mark,
+// so that a writer that fails to compile doesn't leave files or sockets open.
+func (or *outputRegistry) rollback(mark registryMark) {
+	added := &outputRegistry{closers: or.closers[mark.closers:], flushers: or.flushers[mark.flushers:]}
+	_ = closeAll(added.allClosers())
+	or.closers = or.closers[:mark.closers]
+	or.flushers = or.flushers[:mark.flushers]
+	for _, resource := range or.resources[mark.resources:] {
+		delete(or.outputs, resource)
+	}
+	or.resources = or.resources[:mark.resources]
+}
+
+// writerOutput returns the output of the given top-level writer for Handle.Writers.
+func (or *outputRegistry) writerOutput(wc *WriterConfig, writerIndex int) io.Writer {
+	outputs := or.writerOutputs[writerIndex]
+	if wc.Type == WriterTypeGroup {
+		return OutputGroup(outputs)
+	} else if len(outputs) == 0 {
+		return nil
+	}
+	return outputs[0]
+}
+
+func (or *outputRegistry) logWarnings(log *zerolog.Logger) {
+	for _, dup := range or.duplicates {
+		log.Warn().
+			Str("output", dup.resource).
+			Int("first_writer", dup.firstWriter).
+			Int("writer", dup.writer).
+			Msg("Multiple writers use the same output, sharing it between them")
+	}
+}
+
+// compileOutput compiles the main output of a writer, deduplicating it with the other writers of the config if known.
+func (wc *writerCounters) compileOutput(cfg *WriterConfig) (io.Writer, error) {
+	if wc == nil {
+		return cfg.compileMain()
+	}
+	return wc.outputs.compile(cfg, wc.index)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_DedupeOutputs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	cfg := zeroconfig.Config{
+		Timestamp: ptr(false),
+		Writers: []zeroconfig.WriterConfig{{
+			Type:       zeroconfig.WriterTypeFile,
+			MaxLevel:   ptr(zerolog.WarnLevel),
+			FileConfig: zeroconfig.FileConfig{Filename: path},
+		}, {
+			Type:       zeroconfig.WriterTypeFile,
+			Format:     zeroconfig.LogFormatPretty,
+			Color:      zeroconfig.ColorNever,
+			MinLevel:   ptr(zerolog.ErrorLevel),
+			FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(dir, "subdir", "..", "test.log")},
+		}},
+	}
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	log.Error().Msg("hiss")
+
+	backups, err := filepath.Glob(filepath.Join(dir, "test-*.log"))
+	require.NoError(t, err)
+	assert.Empty(t, backups, "The shared file should only be opened (and rotated) once")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "Multiple writers use the same output")
+	assert.Contains(t, lines[0], `"first_writer":1,"writer":2`)
+	assert.Equal(t, `{"level":"info","message":"meow"}`, lines[1], "First writer should keep its own level bounds")
+	assert.Equal(t, "<nil> ERR hiss", lines[2], "Second writer should keep its own format")
+
+	t.Run("Share", func(t *testing.T) {
+		cfg := cfg
+		cfg.DedupeOutputs = zeroconfig.DedupeOutputsShare
+		cfg.Writers = []zeroconfig.WriterConfig{cfg.Writers[0], cfg.Writers[1]}
+		cfg.Writers[0].Filename = filepath.Join(t.TempDir(), "test.log")
+		cfg.Writers[1].Filename = cfg.Writers[0].Filename
+		log, err := cfg.Compile()
+		require.NoError(t, err)
+		log.Info().Msg("meow")
+		data, err := os.ReadFile(cfg.Writers[0].Filename)
+		require.NoError(t, err)
+		assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", string(data), "No warning should be logged")
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		cfg := cfg
+		cfg.DedupeOutputs = zeroconfig.DedupeOutputsError
+		_, err := cfg.Compile()
+		assert.EqualError(t, err, "failed to parse config for writer #2 (file): output file:"+path+" is already used by writer #1")
+	})
+
+	t.Run("DifferentSettings", func(t *testing.T) {
+		cfg := cfg
+		cfg.Writers = []zeroconfig.WriterConfig{cfg.Writers[0], cfg.Writers[1]}
+		cfg.Writers[1].MaxBackups = 5
+		_, err := cfg.Compile()
+		assert.EqualError(t, err, "failed to parse config for writer #2 (file): output file:"+path+" is already used by writer #1 with different settings")
+	})
+
+	t.Run("UnknownMode", func(t *testing.T) {
+		cfg := cfg
+		cfg.DedupeOutputs = "meow"
+		_, err := cfg.Compile()
+		assert.EqualError(t, err, `dedupe_outputs: unknown dedupe_outputs mode "meow"`)
+	})
+}
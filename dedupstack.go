@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// StackRefFieldName is the field that contains the hash of a deduplicated stack trace.
+var StackRefFieldName = "stack_ref"
+
+// maxDedupStacks is the number of stack hashes to remember. When it's exceeded, the set is cleared,
+// which means the next occurrence of each stack is logged in full again.
+const maxDedupStacks = 10000
+
+// StackHash returns the hash that is used as the stack_ref of the given stack trace (the raw JSON value of the stack field).
+func StackHash(stack json.RawMessage) string {
+	hash := sha256.Sum256(stack)
+	return hex.EncodeToString(hash[:8])
+}
+
+// stackDeduplicator replaces repeated stack traces with a reference to the first event that had the same stack.
+type stackDeduplicator struct {
+	lock sync.Mutex
+	seen map[string]struct{}
+	// Counter for stacks that were replaced with a reference.
+	deduped atomic.Uint64
+}
+
+func newStackDeduplicator() *stackDeduplicator {
+	return &stackDeduplicator{seen: make(map[string]struct{})}
+}
+
+func (sd *stackDeduplicator) rewrite(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+	idx := obj.index(zerolog.ErrorStackFieldName)
+	if idx < 0 {
+		return obj, true
+	}
+	hash := StackHash(obj[idx].Value)
+	ref := jsonField{Key: StackRefFieldName, Value: appendJSONString(nil, hash)}
+	sd.lock.Lock()
+	_, seen := sd.seen[hash]
+	if !seen {
+		if len(sd.seen) >= maxDedupStacks {
+			sd.seen = make(map[string]struct{})
+		}
+		sd.seen[hash] = struct{}{}
+	}
+	sd.lock.Unlock()
+	if seen {
+		sd.deduped.Add(1)
+		obj[idx] = ref
+	} else {
+		obj = append(obj[:idx+1], append(jsonObject{ref}, obj[idx+1:]...)...)
+	}
+	return obj, true
+}
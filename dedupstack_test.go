@@ -0,0 +1,51 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_DedupStacks(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "dedup_stacks": true
+	}`)
+	stackA := []byte(`[{"func":"a","line":"1"},{"func":"main","line":"10"}]`)
+	stackB := []byte(`[{"func":"b","line":"2"}]`)
+	log.Error().RawJSON("stack", stackA).Msg("meow")
+	log.Error().RawJSON("stack", stackA).Msg("meow")
+	log.Error().RawJSON("stack", stackB).Msg("meow")
+	log.Error().Msg("no stack")
+	log.Error().RawJSON("stack", stackB).Msg("meow")
+
+	refA := zeroconfig.StackHash(stackA)
+	refB := zeroconfig.StackHash(stackB)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, `{"level":"error","stack":`+string(stackA)+`,"stack_ref":"`+refA+`","message":"meow"}`, lines[0], "First occurrence should include the full stack and the reference")
+	assert.Equal(t, `{"level":"error","stack_ref":"`+refA+`","message":"meow"}`, lines[1], "Repeated stack should be replaced with the reference")
+	assert.Equal(t, `{"level":"error","stack":`+string(stackB)+`,"stack_ref":"`+refB+`","message":"meow"}`, lines[2])
+	assert.Equal(t, `{"level":"error","message":"no stack"}`, lines[3])
+	assert.Equal(t, `{"level":"error","stack_ref":"`+refB+`","message":"meow"}`, lines[4])
+	assert.NotEqual(t, refA, refB)
+
+	var evt map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &evt))
+	assert.Equal(t, refA, zeroconfig.StackHash(evt["stack"]), "Reference should be resolvable by hashing the stack field")
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"github.com/rs/zerolog"
+)
+
+// EnvironmentEnvVar is the environment variable that the active environment is read from if Config.Environment is empty.
+const EnvironmentEnvVar = "LOG_ENVIRONMENT"
+
+// ActiveEnvironment returns the Environment field, or the LOG_ENVIRONMENT environment variable if the field is empty.
+func (c *Config) ActiveEnvironment() string {
+	if c.Environment != "" {
+		return c.Environment
+	}
+	return Getenv(EnvironmentEnvVar)
+}
+
+// activeMetadata returns the static metadata with the active environment's metadata merged over it.
+// If the active environment isn't in EnvMetadata, it returns the base metadata and false.
+func (c *Config) activeMetadata() (map[string]any, bool) {
+	base := c.Metadata
+	if len(c.MetadataList) > 0 {
+		base = make(map[string]any, len(c.MetadataList))
+		for _, entry := range c.MetadataList {
+			base[entry.Key] = entry.Value
+		}
+	}
+	env := c.ActiveEnvironment()
+	if env == "" || len(c.EnvMetadata) == 0 {
+		return base, true
+	}
+	envMetadata, ok := c.EnvMetadata[env]
+	if !ok {
+		return base, false
+	}
+	merged := make(map[string]any, len(base)+len(envMetadata))
+	for key, val := range base {
+		merged[key] = val
+	}
+	for key, val := range envMetadata {
+		merged[key] = val
+	}
+	return merged, true
+}
+
+// activeMinLevel returns the min level for the active environment from EnvMinLevel, or MinLevel if there isn't one.
+func (c *Config) activeMinLevel() *zerolog.Level {
+	if level, ok := c.EnvMinLevel[c.ActiveEnvironment()]; ok {
+		return &level
+	}
+	return c.MinLevel
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
+
+// activeIn checks if the writer should be used in the given environment based on OnlyIn and ExceptIn.
+func (wc *WriterConfig) activeIn(env string) bool {
+	if len(wc.OnlyIn) > 0 && !containsString(wc.OnlyIn, env) {
+		return false
+	}
+	return !containsString(wc.ExceptIn, env)
+}
+
+// filterEnvironment returns a copy of the writer config with group children that aren't active in the given
+// environment removed. It returns false if the writer itself isn't active, or if it's a group with no active children.
+func (wc *WriterConfig) filterEnvironment(env string) (WriterConfig, bool) {
+	if !wc.activeIn(env) {
+		return *wc, false
+	}
+	filtered := *wc
+	if wc.Type == WriterTypeGroup && len(wc.Writers) > 0 {
+		filtered.Writers = make([]WriterConfig, 0, len(wc.Writers))
+		for _, child := range wc.Writers {
+			if child, ok := child.filterEnvironment(env); ok {
+				filtered.Writers = append(filtered.Writers, child)
+			}
+		}
+		if len(filtered.Writers) == 0 {
+			return filtered, false
+		}
+	}
+	return filtered, true
+}
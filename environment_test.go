@@ -0,0 +1,85 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/zeroconfig"
+)
+
+const environmentConfigYAML = `
+timestamp: false
+min_level:
+  default: info
+  dev: debug
+writers:
+- type: stdout
+  only_in: [prod, staging]
+- type: stderr
+  except_in: [prod]
+- type: group
+  writers:
+  - type: stdout
+    format: pretty
+    color: never
+    only_in: [dev]
+`
+
+const environmentConfigJSON = `{
+  "timestamp": false,
+  "min_level": {"default": "info", "dev": "debug"},
+  "writers": [
+    {"type": "stdout", "only_in": ["prod", "staging"]},
+    {"type": "stderr", "except_in": ["prod"]},
+    {"type": "group", "writers": [{"type": "stdout", "format": "pretty", "color": "never", "only_in": ["dev"]}]}
+  ]
+}`
+
+func TestConfig_Compile_Environment(t *testing.T) {
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var cfg zeroconfig.Config
+			if format == "yaml" {
+				require.NoError(t, yaml.Unmarshal([]byte(environmentConfigYAML), &cfg))
+			} else {
+				require.NoError(t, json.Unmarshal([]byte(environmentConfigJSON), &cfg))
+			}
+			require.NotNil(t, cfg.MinLevel)
+			assert.Equal(t, zerolog.InfoLevel, *cfg.MinLevel)
+			assert.Equal(t, map[string]zerolog.Level{"dev": zerolog.DebugLevel}, cfg.EnvMinLevel)
+
+			var stdout, stderr bytes.Buffer
+			zeroconfig.Stdout = &stdout
+			zeroconfig.Stderr = &stderr
+			compileIn := func(env string) {
+				cfg.Environment = env
+				log, err := cfg.Compile()
+				require.NoError(t, err)
+				stdout.Reset()
+				stderr.Reset()
+				log.Debug().Msg("debug")
+				log.Info().Msg("info")
+			}
+
+			compileIn("prod")
+			assert.Equal(t, `{"level":"info","message":"info"}`+"\n", stdout.String(), "prod should only write json to stdout")
+			assert.Empty(t, stderr.String(), "prod should not write to stderr")
+
+			compileIn("dev")
+			assert.Equal(t, "<nil> DBG debug\n<nil> INF info\n", stdout.String(), "dev should write pretty logs including debug to stdout")
+			assert.Equal(t, `{"level":"debug","message":"debug"}`+"\n"+`{"level":"info","message":"info"}`+"\n", stderr.String(), "dev should write to stderr")
+		})
+	}
+}
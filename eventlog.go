@@ -0,0 +1,16 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+// EventlogConfig contains the configuration for the eventlog writer type.
+type EventlogConfig struct {
+	// The event source name to log as. Defaults to the name of the executable without the extension.
+	Source string `json:"source,omitempty" yaml:"source,omitempty" toml:"source,omitempty"`
+	// Register the source in the registry if it isn't registered yet, which requires administrator rights.
+	// Unregistered sources still work, but Event Viewer shows a warning about the missing description with each event.
+	InstallSource bool `json:"install_source,omitempty" yaml:"install_source,omitempty" toml:"install_source,omitempty"`
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build windows
+
+package zeroconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventlogEventID is the event ID used for all events. The message file registered by InstallSource
+// (EventCreate.exe) accepts IDs from 1 to 1000 and displays the message as-is.
+const eventlogEventID = 1
+
+// EventLogger is the interface of *eventlog.Log that the eventlog writer uses.
+type EventLogger interface {
+	Info(eid uint32, msg string) error
+	Warning(eid uint32, msg string) error
+	Error(eid uint32, msg string) error
+	Close() error
+}
+
+// EventlogOpen is used by the eventlog writer type to open the event log. Defaults to eventlog.Open.
+var EventlogOpen = func(source string) (EventLogger, error) {
+	return eventlog.Open(source)
+}
+
+// EventlogInstall is used by the eventlog writer type to register the event source if InstallSource is set.
+// Defaults to eventlog.InstallAsEventCreate, ignoring the error if the source is already registered.
+var EventlogInstall = func(source string) error {
+	err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil && strings.HasSuffix(err.Error(), "registry key already exists") {
+		return nil
+	}
+	return err
+}
+
+// eventlogWriter writes events to the Windows Event Log, mapping zerolog levels to event types.
+type eventlogWriter struct {
+	log EventLogger
+}
+
+func compileEventlog(wc *WriterConfig) (io.Writer, error) {
+	var cfg EventlogConfig
+	if wc.Eventlog != nil {
+		cfg = *wc.Eventlog
+	}
+	if cfg.Source == "" {
+		cfg.Source = strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0]))
+	}
+	if cfg.InstallSource {
+		if err := EventlogInstall(cfg.Source); err != nil {
+			return nil, fmt.Errorf("failed to register event source %s: %w", cfg.Source, err)
+		}
+	}
+	log, err := EventlogOpen(cfg.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	return &eventlogWriter{log: log}, nil
+}
+
+func (ew *eventlogWriter) Write(p []byte) (n int, err error) {
+	return ew.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (ew *eventlogWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	switch {
+	case level == zerolog.NoLevel || level < zerolog.WarnLevel:
+		err = ew.log.Info(eventlogEventID, msg)
+	case level == zerolog.WarnLevel:
+		err = ew.log.Warning(eventlogEventID, msg)
+	default:
+		// Error, fatal and panic, as well as custom levels above them.
+		err = ew.log.Error(eventlogEventID, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ew *eventlogWriter) Close() error {
+	return ew.log.Close()
+}
+
+func init() {
+	writerCompilers[WriterTypeEventlog] = compileEventlog
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build windows
+
+package zeroconfig_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type fakeEventLog struct {
+	source string
+	events []string
+	closed bool
+}
+
+func (fel *fakeEventLog) Info(_ uint32, msg string) error {
+	fel.events = append(fel.events, "info: "+msg)
+	return nil
+}
+
+func (fel *fakeEventLog) Warning(_ uint32, msg string) error {
+	fel.events = append(fel.events, "warning: "+msg)
+	return nil
+}
+
+func (fel *fakeEventLog) Error(_ uint32, msg string) error {
+	fel.events = append(fel.events, "error: "+msg)
+	return nil
+}
+
+func (fel *fakeEventLog) Close() error {
+	fel.closed = true
+	return nil
+}
+
+func TestWriterConfig_Compile_Eventlog(t *testing.T) {
+	fake := &fakeEventLog{}
+	var installed string
+	origOpen, origInstall := zeroconfig.EventlogOpen, zeroconfig.EventlogInstall
+	defer func() {
+		zeroconfig.EventlogOpen, zeroconfig.EventlogInstall = origOpen, origInstall
+	}()
+	zeroconfig.EventlogOpen = func(source string) (zeroconfig.EventLogger, error) {
+		fake.source = source
+		return fake, nil
+	}
+	zeroconfig.EventlogInstall = func(source string) error {
+		installed = source
+		return nil
+	}
+
+	cfg := parseConfig(t, `{
+	  "writers": [{"type": "eventlog", "eventlog": {"source": "meow", "install_source": true}}],
+	  "timestamp": false
+	}`)
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	assert.Equal(t, "meow", installed)
+	assert.Equal(t, "meow", fake.source)
+
+	handle.Logger.Debug().Msg("debug")
+	handle.Logger.Info().Msg("info")
+	handle.Logger.Warn().Msg("warn")
+	handle.Logger.Error().Msg("error")
+	handle.Logger.Log().Msg("no level")
+	require.NoError(t, handle.Close())
+	assert.True(t, fake.closed)
+	assert.Equal(t, []string{
+		`info: {"level":"debug","message":"debug"}`,
+		`info: {"level":"info","message":"info"}`,
+		`warning: {"level":"warn","message":"warn"}`,
+		`error: {"level":"error","message":"error"}`,
+		`info: {"message":"no level"}`,
+	}, fake.events)
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/rs/zerolog"
+)
+
+// WithExit sets Config.ExitFunc, e.g. to a function that records the exit code in tests.
+func WithExit(exit func(code int)) CompileOption {
+	return func(c *Config) {
+		c.ExitFunc = exit
+	}
+}
+
+// WithPanic sets Config.PanicFunc.
+func WithPanic(panicFunc func(msg string)) CompileOption {
+	return func(c *Config) {
+		c.PanicFunc = panicFunc
+	}
+}
+
+// eventDoneField is the index of zerolog's unexported Event.done field, or nil if the zerolog version
+// doesn't have it. Compile refuses to use ExitFunc and PanicFunc if it's not found.
+var eventDoneField = findEventField("done", reflect.TypeOf(func(msg string) {}))
+
+// findEventField returns the index of an unexported field of zerolog.Event if it has the expected type.
+func findEventField(name string, fieldType reflect.Type) []int {
+	field, ok := reflect.TypeOf(zerolog.Event{}).FieldByName(name)
+	if !ok || field.Type != fieldType {
+		return nil
+	}
+	return field.Index
+}
+
+// checkEventInternals returns an error if the config uses options that need zerolog internals
+// which weren't found in the zerolog version that's in use.
+func (c *Config) checkEventInternals() error {
+	if (c.ExitFunc != nil || c.PanicFunc != nil) && eventDoneField == nil {
+		return fmt.Errorf("ExitFunc and PanicFunc aren't supported with this zerolog version")
+	} else if c.FatalPanics && eventBufField == nil {
+		return fmt.Errorf("fatal_panics isn't supported with this zerolog version")
+	}
+	return nil
+}
+
+// setEventDone replaces the function zerolog calls after writing an event, which is what calls os.Exit
+// for Logger.Fatal and panic for Logger.Panic. Events that don't have one
+// (e.g. ones logged with WithLevel) are left alone.
+func setEventDone(e *zerolog.Event, done func(msg string)) {
+	if eventDoneField == nil {
+		return
+	}
+	field := reflect.ValueOf(e).Elem().FieldByIndex(eventDoneField)
+	if field.IsNil() {
+		return
+	}
+	// zerolog doesn't allow changing the done function, so the unexported field has to be written directly.
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(reflect.ValueOf(done))
+}
+
+// exitHook makes fatal and panic events call the configured functions instead of os.Exit and panic.
+// The event is still written normally before the function is called.
+type exitHook struct {
+	exit      func(code int)
+	panicFunc func(msg string)
+}
+
+func (eh exitHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	switch {
+	case level == zerolog.FatalLevel && eh.exit != nil:
+		setEventDone(e, func(string) { eh.exit(1) })
+	case level == zerolog.PanicLevel && eh.panicFunc != nil:
+		setEventDone(e, eh.panicFunc)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWithExit(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var codes []int
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false
+	}`, zeroconfig.WithExit(func(code int) {
+		codes = append(codes, code)
+	}))
+	log.Fatal().Str("path", "/etc/meow").Msg("Failed to read config")
+	assert.Equal(t, []int{1}, codes, "Exit func should be called with code 1")
+	assert.Equal(t, `{"level":"fatal","path":"/etc/meow","message":"Failed to read config"}`+"\n", out.String())
+
+	out.Reset()
+	log.WithLevel(zerolog.FatalLevel).Msg("not exiting")
+	log.Error().Msg("still alive")
+	assert.Equal(t, []int{1}, codes, "Exit func shouldn't be called for WithLevel or other levels")
+	assert.Equal(t, `{"level":"fatal","message":"not exiting"}`+"\n"+`{"level":"error","message":"still alive"}`+"\n", out.String())
+}
+
+func TestWithPanic(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var messages []string
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false
+	}`, zeroconfig.WithPanic(func(msg string) {
+		messages = append(messages, msg)
+	}))
+	require.NotPanics(t, func() {
+		log.Panic().Msg("meow")
+	})
+	assert.Equal(t, []string{"meow"}, messages)
+	assert.Equal(t, `{"level":"panic","message":"meow"}`+"\n", out.String())
+}
+
+func TestConfig_ExitFunc_NoWriters(t *testing.T) {
+	var codes []int
+	cfg := zeroconfig.Config{ExitFunc: func(code int) {
+		codes = append(codes, code)
+	}}
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Fatal().Msg("meow")
+	assert.Equal(t, []int{1}, codes)
+}
+
+func TestEventFieldsFound(t *testing.T) {
+	done, buf := zeroconfig.EventFieldsFound()
+	assert.True(t, done, "zerolog.Event.done wasn't found, so ExitFunc and PanicFunc don't work with this zerolog version")
+	assert.True(t, buf, "zerolog.Event.buf wasn't found, so FatalPanics doesn't work with this zerolog version")
+}
+
+func TestConfig_ExitFunc_Unsupported(t *testing.T) {
+	defer zeroconfig.SetEventDoneField(nil)()
+	cfg := zeroconfig.Config{ExitFunc: func(code int) {}}
+	_, err := cfg.Compile()
+	assert.EqualError(t, err, "ExitFunc and PanicFunc aren't supported with this zerolog version")
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LookupEnv is used to read environment variables when Config.ExpandEnv is enabled.
+var LookupEnv = os.LookupEnv
+
+// expandEnvStrict expands $VAR, ${VAR} and ${VAR:-default} in the value.
+// Variables that aren't set and don't have a default are an error.
+func expandEnvStrict(value string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		name, fallback, hasFallback := strings.Cut(name, ":-")
+		if val, ok := LookupEnv(name); ok && (val != "" || !hasFallback) {
+			return val
+		} else if hasFallback {
+			return fallback
+		}
+		missing = append(missing, name)
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// expandEnv returns a copy of the config with environment variables expanded, see Config.ExpandEnv.
+func (c *Config) expandEnv() (*Config, error) {
+	expanded := *c
+	var err error
+	expanded.Metadata, err = expandEnvMetadata("metadata", c.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if c.MetadataList != nil {
+		expanded.MetadataList = make([]MetadataEntry, len(c.MetadataList))
+		for i, entry := range c.MetadataList {
+			if str, ok := entry.Value.(string); ok {
+				if entry.Value, err = expandEnvStrict(str); err != nil {
+					return nil, &PathError{Path: fmt.Sprintf("metadata[%d].value", i), Err: err}
+				}
+			}
+			expanded.MetadataList[i] = entry
+		}
+	}
+	if c.EnvMetadata != nil {
+		expanded.EnvMetadata = make(map[string]map[string]any, len(c.EnvMetadata))
+		for env, metadata := range c.EnvMetadata {
+			if expanded.EnvMetadata[env], err = expandEnvMetadata("env_metadata."+env, metadata); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if expanded.Writers, err = expandEnvWriters("writers", c.Writers); err != nil {
+		return nil, err
+	}
+	return &expanded, nil
+}
+
+func expandEnvMetadata(path string, metadata map[string]any) (map[string]any, error) {
+	if metadata == nil {
+		return nil, nil
+	}
+	expanded := make(map[string]any, len(metadata))
+	for key, value := range metadata {
+		if str, ok := value.(string); ok {
+			var err error
+			if value, err = expandEnvStrict(str); err != nil {
+				return nil, &PathError{Path: path + "." + key, Err: err}
+			}
+		}
+		expanded[key] = value
+	}
+	return expanded, nil
+}
+
+type envField struct {
+	name  string
+	value *string
+}
+
+func expandEnvWriters(path string, writers []WriterConfig) ([]WriterConfig, error) {
+	if writers == nil {
+		return nil, nil
+	}
+	expanded := make([]WriterConfig, len(writers))
+	for i, wc := range writers {
+		writerPath := fmt.Sprintf("%s[%d]", path, i)
+		fields := []envField{
+			{"host", &wc.Host},
+			{"tag", &wc.Tag},
+			{"time_format", &wc.TimeFormat},
+		}
+		if wc.ExpandEnv == nil || *wc.ExpandEnv {
+			fields = append(fields, envField{"filename", &wc.Filename})
+			// The filename is expanded here, so it must not be expanded again when compiling.
+			disabled := false
+			wc.ExpandEnv = &disabled
+		}
+		for _, field := range fields {
+			var err error
+			if *field.value, err = expandEnvStrict(*field.value); err != nil {
+				return nil, &PathError{Path: writerPath + "." + field.name, Err: err}
+			}
+		}
+		var err error
+		if wc.Writers, err = expandEnvWriters(writerPath+".writers", wc.Writers); err != nil {
+			return nil, err
+		}
+		expanded[i] = wc
+	}
+	return expanded, nil
+}
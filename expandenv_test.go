@@ -0,0 +1,81 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_ExpandEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ZEROCONFIG_TEST_DIR", dir)
+	t.Setenv("ZEROCONFIG_TEST_REGION", "eu-west-1")
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	handle, err := parseConfig(t, `{
+	  "expand_env": true,
+	  "writers": [
+	    {"type": "stdout"},
+	    {"type": "file", "filename": "${ZEROCONFIG_TEST_DIR}/app.log"}
+	  ],
+	  "metadata": {"region": "${ZEROCONFIG_TEST_REGION}", "zone": "${ZEROCONFIG_TEST_ZONE:-b}", "replicas": 3},
+	  "timestamp": false
+	}`).CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Info().Msg("meow")
+	require.NoError(t, handle.Close())
+	expected := `{"level":"info","region":"eu-west-1","replicas":3,"zone":"b","message":"meow"}` + "\n"
+	assert.Equal(t, expected, out.String())
+	data, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	require.NoError(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func TestConfig_Compile_ExpandEnvUnset(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		err    string
+	}{
+		"Metadata": {
+			`{"expand_env": true, "metadata": {"region": "${ZEROCONFIG_TEST_UNSET}"}}`,
+			"metadata.region: environment variable ZEROCONFIG_TEST_UNSET is not set",
+		},
+		"Writer": {
+			`{"expand_env": true, "writers": [{"type": "stdout"}, {"type": "group", "writers": [{"type": "syslog", "tag": "app-${ZEROCONFIG_TEST_UNSET}"}]}]}`,
+			"writers[1].writers[0].tag: environment variable ZEROCONFIG_TEST_UNSET is not set",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := parseConfig(t, test.config)
+			_, err := cfg.Compile()
+			assert.EqualError(t, err, test.err)
+			assert.ErrorContains(t, cfg.Validate(), test.err)
+		})
+	}
+}
+
+func TestConfig_Compile_ExpandEnvDisabled(t *testing.T) {
+	t.Setenv("ZEROCONFIG_TEST_REGION", "eu-west-1")
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "metadata": {"region": "${ZEROCONFIG_TEST_REGION}"},
+	  "timestamp": false
+	}`)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","region":"${ZEROCONFIG_TEST_REGION}","message":"meow"}`+"\n", out.String())
+}
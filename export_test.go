@@ -0,0 +1,103 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"io"
+	"net"
+	"os/exec"
+	"time"
+)
+
+// SetFileOutput replaces the writer that a compiled file writer writes events to, for simulating write errors.
+func SetFileOutput(writer io.Writer, output io.Writer) {
+	writer.(*fileWriter).output = output
+}
+
+// SetRotationClock replaces the clock used by time-based file rotation and returns a function that restores it.
+func SetRotationClock(now func() time.Time) (restore func()) {
+	rotationNow = now
+	return func() {
+		rotationNow = time.Now
+	}
+}
+
+// TailClients returns the number of clients connected to the tail writers of a handle.
+func TailClients(h *Handle) (count int) {
+	for _, closer := range h.closers {
+		if tw, ok := closer.(*tailWriter); ok {
+			tw.lock.RLock()
+			count += len(tw.clients)
+			tw.lock.RUnlock()
+		}
+	}
+	return
+}
+
+// ResetRingBuffers forgets all ring buffers, so that tests don't see the events of previous runs.
+func ResetRingBuffers() {
+	ringBuffersLock.Lock()
+	ringBuffers = make(map[string]*ringBuffer)
+	ringBuffersLock.Unlock()
+}
+
+// SetMemoryClock replaces the clock used by a memory buffer to timestamp and expire lines.
+func SetMemoryClock(mb *MemoryBuffer, now func() time.Time) {
+	mb.lock.Lock()
+	mb.now = now
+	mb.lock.Unlock()
+}
+
+// EventFieldsFound returns whether the unexported zerolog.Event fields used by ExitFunc, PanicFunc
+// and FatalPanics were found.
+func EventFieldsFound() (done, buf bool) {
+	return eventDoneField != nil, eventBufField != nil
+}
+
+// SetEventDoneField replaces the index of the Event.done field and returns a function that restores it.
+func SetEventDoneField(index []int) (restore func()) {
+	prev := eventDoneField
+	eventDoneField = index
+	return func() {
+		eventDoneField = prev
+	}
+}
+
+// StartTailAcceptLoop runs the accept loop of the tail writer on the given listener.
+// The returned function closes the listener and waits for the loop to exit.
+func StartTailAcceptLoop(listener net.Listener) (stop func()) {
+	tl := &tailListener{listener: listener}
+	tl.wg.Add(1)
+	go tl.acceptLoop()
+	return func() {
+		_ = listener.Close()
+		tl.wg.Wait()
+	}
+}
+
+// SetDesktopNotifications replaces the function that creates desktop notification commands and the minimum
+// interval between notifications, and returns a function that restores them.
+func SetDesktopNotifications(command func(title, body string) *exec.Cmd, interval time.Duration) (restore func()) {
+	prevCommand, prevInterval := desktopNotificationCommand, desktopNotifyInterval
+	desktopNotificationCommand, desktopNotifyInterval = command, interval
+	return func() {
+		desktopNotificationCommand, desktopNotifyInterval = prevCommand, prevInterval
+	}
+}
+
+// ConvertFieldKeys converts the given keys with a new field case converter and returns how many of them it cached.
+func ConvertFieldKeys(fieldCase FieldCase, keys []string) (converted []string, cached int) {
+	fcc := &fieldCaseConverter{fieldCase: fieldCase}
+	for _, key := range keys {
+		converted = append(converted, fcc.convertKey(key))
+	}
+	fcc.cache.Range(func(_, _ any) bool {
+		cached++
+		return true
+	})
+	return
+}
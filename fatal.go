@@ -0,0 +1,71 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"reflect"
+
+	"github.com/rs/zerolog"
+)
+
+// FatalPanic is the value that loggers compiled with Config.FatalPanics panic with after writing a fatal event.
+type FatalPanic struct {
+	// The message of the fatal event.
+	Message string
+	// The error returned by the writers when writing the event, if any.
+	WriteErr error
+}
+
+func (fp *FatalPanic) Error() string {
+	return "fatal log: " + fp.Message
+}
+
+// eventBufField is the index of zerolog's unexported Event.buf field, or nil if the zerolog version
+// doesn't have it. Compile refuses to use FatalPanics if it's not found.
+var eventBufField = findEventField("buf", reflect.TypeOf([]byte(nil)))
+
+// eventBuffer returns a copy of the fields that have been added to the event so far.
+//
+// zerolog doesn't expose the buffer, but it's the only way to write a fatal event without going through
+// Event.msg, which calls os.Exit in a deferred function even if a writer panics.
+func eventBuffer(e *zerolog.Event) []byte {
+	if eventBufField == nil {
+		return nil
+	}
+	return append([]byte(nil), reflect.ValueOf(e).Elem().FieldByIndex(eventBufField).Bytes()...)
+}
+
+// fatalPanicHook writes fatal events itself and then panics, which prevents zerolog from calling os.Exit.
+//
+// Hooks are the only part of zerolog that run for fatal events before the exit is scheduled, so this
+// must be the last hook of the logger, as it stops later hooks from running.
+type fatalPanicHook struct {
+	writer zerolog.LevelWriter
+}
+
+func (fph fatalPanicHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level != zerolog.FatalLevel {
+		return
+	}
+	buf := eventBuffer(e)
+	if buf == nil {
+		// This should only happen if zerolog's internals change.
+		panic(&FatalPanic{Message: msg})
+	}
+	if msg != "" {
+		if len(buf) > 0 && buf[len(buf)-1] != '{' {
+			buf = append(buf, ',')
+		}
+		buf = appendJSONString(buf, zerolog.MessageFieldName)
+		buf = append(buf, ':')
+		buf = appendJSONString(buf, msg)
+	}
+	buf = append(buf, '}', '\n')
+	_, err := fph.writer.WriteLevel(level, buf)
+	e.Discard()
+	panic(&FatalPanic{Message: msg, WriteErr: err})
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_FatalPanics(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}, {"type": "stderr", "format": "pretty", "color": "never"}],
+	  "timestamp": false,
+	  "fatal_panics": true
+	}`)
+	log.Error().Msg("not fatal")
+	var recovered any
+	func() {
+		defer func() {
+			recovered = recover()
+		}()
+		log.Fatal().Str("path", "/etc/meow").Msg("Failed to read config")
+	}()
+	require.IsType(t, &zeroconfig.FatalPanic{}, recovered, "Fatal should panic with a FatalPanic")
+	fp := recovered.(*zeroconfig.FatalPanic)
+	assert.Equal(t, "Failed to read config", fp.Message)
+	assert.NoError(t, fp.WriteErr)
+	assert.Equal(t, "fatal log: Failed to read config", fp.Error())
+	assert.Equal(t, `{"level":"error","message":"not fatal"}`+"\n"+`{"level":"fatal","path":"/etc/meow","message":"Failed to read config"}`+"\n", stdout.String(), "Fatal event should be written before panicking")
+	assert.Contains(t, stderr.String(), "FTL Failed to read config", "Fatal event should be written to all writers")
+}
+
+func TestConfig_Compile_FatalPanicsNoWriters(t *testing.T) {
+	log, err := (&zeroconfig.Config{FatalPanics: true}).Compile()
+	require.NoError(t, err)
+	defer func() {
+		assert.Equal(t, &zeroconfig.FatalPanic{Message: "meow"}, recover())
+	}()
+	log.Fatal().Msg("meow")
+}
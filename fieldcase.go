@@ -0,0 +1,178 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/rs/zerolog"
+)
+
+// FieldCase is a naming convention for field keys.
+type FieldCase string
+
+const (
+	// FieldCaseNone keeps field keys as-is.
+	FieldCaseNone FieldCase = "none"
+	// FieldCaseSnake converts field keys to snake_case.
+	FieldCaseSnake FieldCase = "snake"
+	// FieldCaseCamel converts field keys to camelCase.
+	FieldCaseCamel FieldCase = "camel"
+	// FieldCasePascal converts field keys to PascalCase.
+	FieldCasePascal FieldCase = "pascal"
+)
+
+// splitWords splits an identifier in any common naming convention into lowercase words.
+func splitWords(key string) []string {
+	var words []string
+	var current []rune
+	runes := []rune(key)
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ' || r == '.':
+			flush()
+			continue
+		case unicode.IsUpper(r) && i > 0:
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// Start a new word on lower->upper transitions (fooBar), and at the last capital
+			// of an acronym that's followed by a lowercase word (HTTPServer -> http, server).
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+	return words
+}
+
+func titleWord(word string) string {
+	runes := []rune(word)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func (fc FieldCase) convert(key string) string {
+	words := splitWords(key)
+	if len(words) == 0 {
+		return key
+	}
+	switch fc {
+	case FieldCaseSnake:
+		return strings.Join(words, "_")
+	case FieldCaseCamel:
+		for i := 1; i < len(words); i++ {
+			words[i] = titleWord(words[i])
+		}
+		return strings.Join(words, "")
+	case FieldCasePascal:
+		for i := range words {
+			words[i] = titleWord(words[i])
+		}
+		return strings.Join(words, "")
+	default:
+		return key
+	}
+}
+
+// fieldCaseCacheSize is the maximum number of converted keys that are cached. Keys are usually
+// a small fixed set, but ones that contain IDs or come from maps could otherwise grow the cache forever.
+// With typical key lengths, a full cache takes up a few hundred kilobytes.
+const fieldCaseCacheSize = 1024
+
+// fieldCaseConverter rewrites the keys of every event. Each line is parsed and reserialized, which allocates
+// a new copy of the line, and keys that aren't in the cache are split into words and converted again.
+type fieldCaseConverter struct {
+	fieldCase       FieldCase
+	includeReserved bool
+	cache           sync.Map
+	cached          atomic.Int64
+}
+
+func newFieldCaseRewriter(fieldCase FieldCase, includeReserved bool) (eventRewriteFunc, error) {
+	switch fieldCase {
+	case "", FieldCaseNone:
+		return nil, nil
+	case FieldCaseSnake, FieldCaseCamel, FieldCasePascal:
+	default:
+		return nil, fmt.Errorf("unknown field case %q", fieldCase)
+	}
+	fcc := &fieldCaseConverter{fieldCase: fieldCase, includeReserved: includeReserved}
+	return fcc.rewrite, nil
+}
+
+func isReservedField(key string) bool {
+	return key == zerolog.TimestampFieldName || key == zerolog.LevelFieldName || key == zerolog.MessageFieldName
+}
+
+func (fcc *fieldCaseConverter) convertKey(key string) string {
+	if cached, ok := fcc.cache.Load(key); ok {
+		return cached.(string)
+	}
+	converted := fcc.fieldCase.convert(key)
+	// Once the cache is full, new keys are converted every time instead of being added.
+	// Concurrent stores may go slightly over the limit, which doesn't matter.
+	if fcc.cached.Load() < fieldCaseCacheSize {
+		if _, loaded := fcc.cache.LoadOrStore(key, converted); !loaded {
+			fcc.cached.Add(1)
+		}
+	}
+	return converted
+}
+
+func (fcc *fieldCaseConverter) convertObject(obj jsonObject, topLevel bool) jsonObject {
+	for i, field := range obj {
+		if !topLevel || fcc.includeReserved || !isReservedField(field.Key) {
+			obj[i].Key = fcc.convertKey(field.Key)
+		}
+		obj[i].Value = fcc.convertValue(field.Value)
+	}
+	return obj
+}
+
+func (fcc *fieldCaseConverter) convertValue(val json.RawMessage) json.RawMessage {
+	switch {
+	case len(val) > 0 && val[0] == '{':
+		obj, err := parseJSONObject(val)
+		if err != nil {
+			return val
+		}
+		return fcc.convertObject(obj, false).appendTo(nil)
+	case len(val) > 0 && val[0] == '[' && bytes.IndexByte(val, '{') >= 0:
+		var arr []json.RawMessage
+		if json.Unmarshal(val, &arr) != nil {
+			return val
+		}
+		out := []byte{'['}
+		for i, item := range arr {
+			if i > 0 {
+				out = append(out, ',')
+			}
+			out = append(out, fcc.convertValue(item)...)
+		}
+		return append(out, ']')
+	default:
+		return val
+	}
+}
+
+func (fcc *fieldCaseConverter) rewrite(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+	return fcc.convertObject(obj, true), true
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_FieldCase(t *testing.T) {
+	tests := []struct {
+		fieldCase string
+		reserved  bool
+		expected  string
+	}{
+		{"snake", false, `{"level":"info","user_id":1,"http_server":{"remote_addr":"x","sub_items":[{"item_name":"a"}]},"message":"meow"}`},
+		{"camel", false, `{"level":"info","userId":1,"httpServer":{"remoteAddr":"x","subItems":[{"itemName":"a"}]},"message":"meow"}`},
+		{"pascal", false, `{"level":"info","UserId":1,"HttpServer":{"RemoteAddr":"x","SubItems":[{"ItemName":"a"}]},"message":"meow"}`},
+		{"pascal", true, `{"Level":"info","UserId":1,"HttpServer":{"RemoteAddr":"x","SubItems":[{"ItemName":"a"}]},"Message":"meow"}`},
+		{"none", false, `{"level":"info","userID":1,"HTTPServer":{"remote-addr":"x","sub_items":[{"ItemName":"a"}]},"message":"meow"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.fieldCase, func(t *testing.T) {
+			var out bytes.Buffer
+			zeroconfig.Stdout = &out
+			cfg := `{"writers": [{"type": "stdout"}], "timestamp": false, "field_case": "` + test.fieldCase + `"`
+			if test.reserved {
+				cfg += `, "field_case_reserved": true`
+			}
+			log := compile(t, cfg+"}")
+			log.Info().
+				Int("userID", 1).
+				RawJSON("HTTPServer", []byte(`{"remote-addr":"x","sub_items":[{"ItemName":"a"}]}`)).
+				Msg("meow")
+			assert.Equal(t, test.expected+"\n", out.String())
+		})
+	}
+}
+
+func TestFieldCase_CacheLimit(t *testing.T) {
+	keys := make([]string, 5000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("requestID%d", i)
+	}
+	converted, cached := zeroconfig.ConvertFieldKeys(zeroconfig.FieldCaseSnake, append(keys, keys...))
+	assert.Equal(t, 1024, cached, "Cache should stop growing at the limit")
+	assert.Equal(t, "request_id0", converted[0])
+	assert.Equal(t, "request_id4999", converted[4999], "Keys beyond the limit should still be converted")
+	assert.Equal(t, "request_id4999", converted[9999])
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// FieldNamesConfig contains custom names for the fields that zerolog adds to events. Empty names are left unchanged.
+//
+// The names are package-global variables in zerolog, so compiling a config with FieldNames changes them for all
+// loggers in the process, including ones that were compiled earlier or created without zeroconfig.
+type FieldNamesConfig struct {
+	// The name of the level field. Sets zerolog.LevelFieldName, which defaults to "level".
+	Level string `json:"level,omitempty" yaml:"level,omitempty" toml:"level,omitempty"`
+	// The name of the message field. Sets zerolog.MessageFieldName, which defaults to "message".
+	Message string `json:"message,omitempty" yaml:"message,omitempty" toml:"message,omitempty"`
+	// The name of the timestamp field. Sets zerolog.TimestampFieldName, which defaults to "time".
+	Time string `json:"time,omitempty" yaml:"time,omitempty" toml:"time,omitempty"`
+	// The name of the caller field. Sets zerolog.CallerFieldName, which defaults to "caller".
+	Caller string `json:"caller,omitempty" yaml:"caller,omitempty" toml:"caller,omitempty"`
+}
+
+func (fnc *FieldNamesConfig) validate() error {
+	if fnc == nil {
+		return nil
+	}
+	names := []struct{ field, name string }{
+		{"level", fnc.Level},
+		{"message", fnc.Message},
+		{"time", fnc.Time},
+		{"caller", fnc.Caller},
+	}
+	seen := make(map[string]string, len(names))
+	for _, name := range names {
+		if name.name == "" {
+			continue
+		} else if other, ok := seen[name.name]; ok {
+			return fmt.Errorf("%s and %s can't both be named %q", other, name.field, name.name)
+		}
+		seen[name.name] = name.field
+	}
+	return nil
+}
+
+// apply sets the zerolog field name variables.
+func (fnc *FieldNamesConfig) apply() {
+	if fnc == nil {
+		return
+	}
+	if fnc.Level != "" {
+		zerolog.LevelFieldName = fnc.Level
+	}
+	if fnc.Message != "" {
+		zerolog.MessageFieldName = fnc.Message
+	}
+	if fnc.Time != "" {
+		zerolog.TimestampFieldName = fnc.Time
+	}
+	if fnc.Caller != "" {
+		zerolog.CallerFieldName = fnc.Caller
+	}
+}
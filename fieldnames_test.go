@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func restoreFieldNames(t *testing.T) {
+	level, message, timestamp, caller := zerolog.LevelFieldName, zerolog.MessageFieldName, zerolog.TimestampFieldName, zerolog.CallerFieldName
+	t.Cleanup(func() {
+		zerolog.LevelFieldName, zerolog.MessageFieldName, zerolog.TimestampFieldName, zerolog.CallerFieldName = level, message, timestamp, caller
+	})
+}
+
+func TestConfig_Compile_FieldNames(t *testing.T) {
+	restoreFieldNames(t)
+	var stdout, stderr bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}, {"type": "stderr", "format": "logfmt"}],
+	  "field_names": {"level": "severity", "message": "msg", "time": "ts"}
+	}`, zeroconfig.WithClock(frozenClock))
+	log.Info().Str("user", "meow").Msg("hello")
+	assert.Equal(t, `{"severity":"info","user":"meow","ts":"2023-03-14T15:09:26Z","msg":"hello"}`+"\n", stdout.String())
+	assert.Equal(t, `ts=2023-03-14T15:09:26Z severity=info msg=hello user=meow`+"\n", stderr.String())
+	assert.Equal(t, "caller", zerolog.CallerFieldName, "Empty names should be left unchanged")
+}
+
+func TestConfig_Compile_FieldNamesDuplicate(t *testing.T) {
+	restoreFieldNames(t)
+	cfg := parseConfig(t, `{"writers": [{"type": "stdout"}], "field_names": {"level": "msg", "message": "msg"}}`)
+	_, err := cfg.Compile()
+	assert.EqualError(t, err, `field_names: level and message can't both be named "msg"`)
+	assert.Equal(t, "level", zerolog.LevelFieldName, "Invalid names shouldn't be applied")
+}
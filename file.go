@@ -0,0 +1,556 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// CompressLevel is a gzip compression level for rotated log files.
+//
+// In config files, it can be specified either as a number from 1 to 9, or as one of "fast", "best" or "default".
+type CompressLevel int
+
+const (
+	// CompressLevelUnset means the compression level wasn't specified.
+	// Files will be compressed by lumberjack using the default gzip level.
+	CompressLevelUnset   CompressLevel = 0
+	CompressLevelFast    CompressLevel = gzip.BestSpeed
+	CompressLevelBest    CompressLevel = gzip.BestCompression
+	CompressLevelDefault CompressLevel = gzip.DefaultCompression
+)
+
+// ParseCompressLevel parses a compression level from a string.
+func ParseCompressLevel(val string) (CompressLevel, error) {
+	switch strings.ToLower(val) {
+	case "":
+		return CompressLevelUnset, nil
+	case "fast":
+		return CompressLevelFast, nil
+	case "best":
+		return CompressLevelBest, nil
+	case "default":
+		return CompressLevelDefault, nil
+	}
+	level, err := strconv.Atoi(val)
+	if err != nil || level < gzip.BestSpeed || level > gzip.BestCompression {
+		return CompressLevelUnset, fmt.Errorf("invalid compression level %q (expected 1-9, fast, best or default)", val)
+	}
+	return CompressLevel(level), nil
+}
+
+func (cl CompressLevel) String() string {
+	switch cl {
+	case CompressLevelUnset:
+		return ""
+	case CompressLevelDefault:
+		return "default"
+	default:
+		return strconv.Itoa(int(cl))
+	}
+}
+
+func (cl *CompressLevel) UnmarshalText(text []byte) (err error) {
+	*cl, err = ParseCompressLevel(string(text))
+	return
+}
+
+func (cl CompressLevel) MarshalText() ([]byte, error) {
+	return []byte(cl.String()), nil
+}
+
+func (cl *CompressLevel) UnmarshalJSON(data []byte) error {
+	var str string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+	} else {
+		str = string(data)
+	}
+	return cl.UnmarshalText([]byte(str))
+}
+
+func (cl CompressLevel) MarshalJSON() ([]byte, error) {
+	if cl == CompressLevelDefault {
+		return []byte(`"default"`), nil
+	}
+	return []byte(strconv.Itoa(int(cl))), nil
+}
+
+// DiskFullPolicy describes what the file writer should do when the disk is full.
+type DiskFullPolicy string
+
+const (
+	// DiskFullError returns the error, which makes zerolog pass it to zerolog.ErrorHandler.
+	DiskFullError DiskFullPolicy = "error"
+	// DiskFullStderr writes the event to Stderr instead.
+	DiskFullStderr DiskFullPolicy = "stderr"
+	// DiskFullPurgeOldest deletes the oldest rotated files one by one and retries until the write succeeds.
+	// If there are no rotated files left, the error is returned.
+	DiskFullPurgeOldest DiskFullPolicy = "purge-oldest"
+)
+
+// RotationMode describes how the file writer moves the current file aside when rotating.
+type RotationMode string
+
+const (
+	// RotationModeRename renames the current file and creates a new one. This is the default on non-Windows systems.
+	RotationModeRename RotationMode = "rename"
+	// RotationModeCopyTruncate copies the current file to the backup name and truncates it in place.
+	// It works even if other processes have the file open, which prevents renaming on Windows,
+	// but events written by other processes between the copy and truncation are lost.
+	// This is the default on Windows.
+	RotationModeCopyTruncate RotationMode = "copytruncate"
+)
+
+// DefaultRotationMode is the rotation mode used when the rotation_mode option is empty.
+var DefaultRotationMode = defaultRotationMode()
+
+func defaultRotationMode() RotationMode {
+	if runtime.GOOS == "windows" {
+		return RotationModeCopyTruncate
+	}
+	return RotationModeRename
+}
+
+// These match the values used internally in lumberjack.
+const (
+	megabyte           = 1024 * 1024
+	defaultMaxSize     = 100
+	backupTimeFormat   = "2006-01-02T15-04-05.000"
+	compressSuffix     = ".gz"
+	compressTempSuffix = ".gz.tmp"
+)
+
+// fileWriter wraps lumberjack to detect when the file is rotated,
+// so that extra processing can be done on the rotated files.
+type fileWriter struct {
+	*lumberjack.Logger
+	// The writer that events are written to, normally the lumberjack logger itself.
+	output       io.Writer
+	onDiskFull   DiskFullPolicy
+	rotationMode RotationMode
+
+	lock    sync.Mutex
+	size    int64
+	maxSize int64
+	header  []byte
+
+	compressLevel CompressLevel
+	compressWG    sync.WaitGroup
+}
+
+// FileBackend is the storage used by the file writer type. The default backend uses lumberjack,
+// and other backends can be added with RegisterFileBackend and selected with the backend option.
+//
+// The file writer serializes all calls, so backends don't need to be safe for concurrent use.
+type FileBackend interface {
+	// Open is called once after creating the backend, before any writes.
+	Open() error
+	Write(p []byte) (n int, err error)
+	// Rotate moves the current file aside and starts a new one.
+	Rotate() error
+	Close() error
+}
+
+// FileBackendFactory creates a FileBackend from the file writer config.
+type FileBackendFactory = func(FileConfig) (FileBackend, error)
+
+// DefaultFileBackend is the name of the lumberjack-based backend that is used if the backend option is empty.
+const DefaultFileBackend = "lumberjack"
+
+var fileBackends = map[string]FileBackendFactory{
+	DefaultFileBackend: newLumberjackBackend,
+}
+
+// RegisterFileBackend adds a file backend that can be selected with the backend option of file writers.
+func RegisterFileBackend(name string, factory FileBackendFactory) {
+	fileBackends[name] = factory
+}
+
+// rotatingWriter is a compiled file writer.
+type rotatingWriter interface {
+	io.Writer
+	Rotate() error
+	Close() error
+}
+
+// expandedFilename returns the filename with environment variables expanded, unless ExpandEnv is disabled.
+func (fc *FileConfig) expandedFilename() string {
+	if fc.ExpandEnv != nil && !*fc.ExpandEnv {
+		return fc.Filename
+	}
+	return os.Expand(fc.Filename, Getenv)
+}
+
+func compileFile(wc *WriterConfig) (io.Writer, error) {
+	expanded := *wc
+	expanded.Filename = wc.expandedFilename()
+	if expanded.HashShard != nil {
+		return compileHashShard(&expanded)
+	}
+	return compileFileBackend(&expanded.FileConfig)
+}
+
+func compileFileBackend(cfg *FileConfig) (rotatingWriter, error) {
+	name := cfg.Backend
+	if name == "" {
+		name = DefaultFileBackend
+	}
+	factory, ok := fileBackends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown file backend %q", name)
+	} else if name != DefaultFileBackend && cfg.OnDiskFull != "" && cfg.OnDiskFull != DiskFullError {
+		return nil, fmt.Errorf("on_disk_full is only supported with the %s backend", DefaultFileBackend)
+	} else if name != DefaultFileBackend && cfg.HeaderLine != "" {
+		return nil, fmt.Errorf("header_line is only supported with the %s backend", DefaultFileBackend)
+	} else if name != DefaultFileBackend && cfg.RotationMode != "" {
+		return nil, fmt.Errorf("rotation_mode is only supported with the %s backend", DefaultFileBackend)
+	} else if name != DefaultFileBackend && cfg.RotationInterval != "" {
+		return nil, fmt.Errorf("rotation_interval is only supported with the %s backend", DefaultFileBackend)
+	}
+	if cfg.RotationInterval != "" {
+		factory = func(cfg FileConfig) (FileBackend, error) {
+			return newTimeRotatingWriter(cfg)
+		}
+	}
+	backend, err := factory(*cfg)
+	if err != nil {
+		return nil, err
+	} else if err = backend.Open(); err != nil {
+		return nil, err
+	}
+	switch typedBackend := backend.(type) {
+	case *fileWriter:
+		// The lumberjack backend already has its own locking.
+		return typedBackend, nil
+	case *timeRotatingWriter:
+		return typedBackend, nil
+	}
+	return &lockedFileBackend{backend: backend}, nil
+}
+
+// lockedFileBackend serializes calls to a custom file backend.
+type lockedFileBackend struct {
+	lock    sync.Mutex
+	backend FileBackend
+}
+
+func (lfb *lockedFileBackend) Write(p []byte) (n int, err error) {
+	lfb.lock.Lock()
+	defer lfb.lock.Unlock()
+	return lfb.backend.Write(p)
+}
+
+func (lfb *lockedFileBackend) Rotate() error {
+	lfb.lock.Lock()
+	defer lfb.lock.Unlock()
+	return lfb.backend.Rotate()
+}
+
+func (lfb *lockedFileBackend) Close() error {
+	lfb.lock.Lock()
+	defer lfb.lock.Unlock()
+	return lfb.backend.Close()
+}
+
+func newLumberjackBackend(cfg FileConfig) (FileBackend, error) {
+	if cfg.MaxSize < 0 || cfg.MaxAge < 0 || cfg.MaxBackups < 0 {
+		return nil, fmt.Errorf("max_size, max_age and max_backups must not be negative")
+	}
+	writer := &fileWriter{
+		Logger: &lumberjack.Logger{
+			Filename:   cfg.Filename,
+			MaxSize:    cfg.MaxSize,
+			MaxAge:     cfg.MaxAge,
+			MaxBackups: cfg.MaxBackups,
+			LocalTime:  cfg.LocalTime,
+			Compress:   cfg.Compress,
+		},
+		maxSize: int64(cfg.MaxSize) * megabyte,
+	}
+	writer.output = writer.Logger
+	if cfg.HeaderLine != "" {
+		writer.header = []byte(cfg.HeaderLine)
+		if !bytes.HasSuffix(writer.header, []byte{'\n'}) {
+			writer.header = append(writer.header, '\n')
+		}
+	}
+	switch cfg.OnDiskFull {
+	case "", DiskFullError, DiskFullStderr, DiskFullPurgeOldest:
+		writer.onDiskFull = cfg.OnDiskFull
+	default:
+		return nil, fmt.Errorf("unknown on_disk_full policy %q", cfg.OnDiskFull)
+	}
+	switch cfg.RotationMode {
+	case "":
+		writer.rotationMode = DefaultRotationMode
+	case RotationModeRename, RotationModeCopyTruncate:
+		writer.rotationMode = cfg.RotationMode
+	default:
+		return nil, fmt.Errorf("unknown rotation_mode %q", cfg.RotationMode)
+	}
+	if writer.rotationMode == RotationModeCopyTruncate && cfg.Filename == "" {
+		return nil, fmt.Errorf("rotation_mode %s requires a filename", RotationModeCopyTruncate)
+	}
+	if writer.maxSize == 0 {
+		writer.maxSize = defaultMaxSize * megabyte
+	}
+	if cfg.Compress && cfg.CompressLevel != CompressLevelUnset {
+		// lumberjack doesn't support setting the compression level, so compress files ourselves.
+		writer.Logger.Compress = false
+		writer.compressLevel = cfg.CompressLevel
+	}
+	return writer, nil
+}
+
+// Open rotates the file once, so that logs from previous runs are moved aside.
+func (fw *fileWriter) Open() error {
+	return fw.Rotate()
+}
+
+func (fw *fileWriter) Write(p []byte) (n int, err error) {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	writeLen := int64(len(p))
+	if fw.size+writeLen > fw.maxSize && writeLen <= fw.maxSize {
+		// lumberjack would rotate inside Write, so rotate here first to know that it happened.
+		if err = fw.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = fw.output.Write(p)
+	fw.size += int64(n)
+	if err != nil && errors.Is(err, syscall.ENOSPC) {
+		return fw.handleDiskFull(p, n, err)
+	}
+	return
+}
+
+func (fw *fileWriter) handleDiskFull(p []byte, n int, err error) (int, error) {
+	switch fw.onDiskFull {
+	case DiskFullStderr:
+		// The whole event is written to stderr even if part of it made it to the file,
+		// as half an event on stderr wouldn't be useful.
+		if _, stderrErr := Stderr.Write(p); stderrErr != nil {
+			return n, err
+		}
+		return len(p), nil
+	case DiskFullPurgeOldest:
+		for {
+			files, listErr := fw.backupFiles()
+			if listErr != nil || len(files) == 0 {
+				return n, err
+			} else if os.Remove(files[0]) != nil {
+				return n, err
+			}
+			var written int
+			written, err = fw.output.Write(p[n:])
+			fw.size += int64(written)
+			n += written
+			if err == nil || !errors.Is(err, syscall.ENOSPC) {
+				return n, err
+			}
+		}
+	default:
+		return n, err
+	}
+}
+
+// Rotate closes the current file, moves it aside with a timestamp in the name and opens a new file.
+func (fw *fileWriter) Rotate() error {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	return fw.rotate()
+}
+
+func (fw *fileWriter) rotate() error {
+	var err error
+	if fw.rotationMode == RotationModeCopyTruncate {
+		err = fw.copyTruncate()
+	} else {
+		err = fw.Logger.Rotate()
+	}
+	if err != nil {
+		return err
+	}
+	fw.size = 0
+	fw.afterRotate()
+	if len(fw.header) > 0 {
+		// Every file is created through a rotation (including the first one in Open),
+		// so this writes the header exactly once at the start of each file.
+		n, err := fw.output.Write(fw.header)
+		fw.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to write header line: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyTruncate copies the current file to a backup and truncates the original in place.
+func (fw *fileWriter) copyTruncate() error {
+	// Closing makes lumberjack reopen the file in append mode on the next write,
+	// which also runs its cleanup of old backups like a normal rotation would.
+	if err := fw.Logger.Close(); err != nil {
+		return err
+	}
+	src, err := os.Open(fw.Filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(fw.backupName(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy log file to backup: %w", err)
+	}
+	return os.Truncate(fw.Filename, 0)
+}
+
+// backupName returns a new backup file name in the same format as lumberjack.
+func (fw *fileWriter) backupName() string {
+	dir := filepath.Dir(fw.Filename)
+	base := filepath.Base(fw.Filename)
+	ext := filepath.Ext(base)
+	now := time.Now()
+	if !fw.LocalTime {
+		now = now.UTC()
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%s%s", base[:len(base)-len(ext)], now.Format(backupTimeFormat), ext))
+}
+
+func (fw *fileWriter) afterRotate() {
+	if fw.compressLevel != CompressLevelUnset {
+		fw.compressWG.Add(1)
+		go fw.compressBackups()
+	}
+}
+
+// Close closes the current log file and waits for any background compression to finish.
+func (fw *fileWriter) Close() error {
+	fw.lock.Lock()
+	err := fw.Logger.Close()
+	fw.lock.Unlock()
+	fw.compressWG.Wait()
+	return err
+}
+
+// backupFiles returns the paths of all rotated log files belonging to this writer.
+func (fw *fileWriter) backupFiles() ([]string, error) {
+	dir := filepath.Dir(fw.Filename)
+	base := filepath.Base(fw.Filename)
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)] + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts := strings.TrimSuffix(name[len(prefix):], compressSuffix)
+		if !strings.HasSuffix(ts, ext) {
+			continue
+		} else if _, err = time.Parse(backupTimeFormat, ts[:len(ts)-len(ext)]); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files, nil
+}
+
+var compressLock sync.Mutex
+
+func (fw *fileWriter) compressBackups() {
+	defer fw.compressWG.Done()
+	// Only compress one batch at a time so that concurrent rotations don't try to compress the same files.
+	compressLock.Lock()
+	defer compressLock.Unlock()
+	files, err := fw.backupFiles()
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file, compressSuffix) {
+			// Errors are ignored like lumberjack does: the file may have been removed by cleanup concurrently.
+			_ = compressFile(file, fw.compressLevel)
+		}
+	}
+}
+
+func gzipFile(srcPath, dstPath string, level CompressLevel) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewWriterLevel(dst, int(level))
+	if err == nil {
+		_, err = io.Copy(gz, src)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func compressFile(path string, level CompressLevel) error {
+	tempPath := path + compressTempSuffix
+	err := gzipFile(path, tempPath, level)
+	if err == nil {
+		err = os.Rename(tempPath, path+compressSuffix)
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestCompressLevel_Unmarshal(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected zeroconfig.CompressLevel
+	}{
+		{`1`, 1},
+		{`9`, 9},
+		{`"5"`, 5},
+		{`"fast"`, zeroconfig.CompressLevelFast},
+		{`"best"`, zeroconfig.CompressLevelBest},
+		{`"default"`, zeroconfig.CompressLevelDefault},
+	}
+	for _, test := range tests {
+		var level zeroconfig.CompressLevel
+		require.NoError(t, json.Unmarshal([]byte(test.input), &level), "Unmarshaling %s should be successful", test.input)
+		assert.Equal(t, test.expected, level)
+	}
+	for _, input := range []string{`0`, `10`, `"fastest"`, `-1`} {
+		var level zeroconfig.CompressLevel
+		assert.Error(t, json.Unmarshal([]byte(input), &level), "Unmarshaling %s should fail", input)
+	}
+}
+
+type rotatable interface {
+	io.WriteCloser
+	Rotate() error
+}
+
+func TestWriterConfig_Compile_FileCompressLevel(t *testing.T) {
+	dir := t.TempDir()
+	wc := zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{
+			Filename:      filepath.Join(dir, "test.log"),
+			Compress:      true,
+			CompressLevel: zeroconfig.CompressLevelBest,
+		},
+	}
+	writer, err := wc.Compile()
+	require.NoError(t, err, "Compiling file writer should be successful")
+	fw, ok := writer.(rotatable)
+	require.True(t, ok, "File writer should be rotatable")
+
+	line := strings.Repeat("meow ", 1000) + "\n"
+	_, err = fw.Write([]byte(line))
+	require.NoError(t, err)
+	require.NoError(t, fw.Rotate(), "Rotating should be successful")
+	require.NoError(t, fw.Close(), "Closing should wait for compression")
+
+	matches, err := filepath.Glob(filepath.Join(dir, "test-*.log.gz"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1, "There should be exactly one compressed backup")
+	uncompressed, err := filepath.Glob(filepath.Join(dir, "test-*.log"))
+	require.NoError(t, err)
+	assert.Empty(t, uncompressed, "Uncompressed backup should be removed")
+
+	file, err := os.Open(matches[0])
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err, "Backup should be valid gzip")
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, line, string(data), "Backup should contain the written line")
+}
+
+// diskFullWriter simulates a disk that's full until the condition function returns false.
+type diskFullWriter struct {
+	full func() bool
+	out  io.Writer
+}
+
+func (dfw *diskFullWriter) Write(p []byte) (int, error) {
+	if dfw.full() {
+		return 0, &os.PathError{Op: "write", Path: "test.log", Err: syscall.ENOSPC}
+	}
+	return dfw.out.Write(p)
+}
+
+func compileDiskFullWriter(t *testing.T, policy zeroconfig.DiskFullPolicy) (rotatable, string) {
+	dir := t.TempDir()
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{
+			Filename:   filepath.Join(dir, "test.log"),
+			OnDiskFull: policy,
+		},
+	}).Compile()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = writer.(rotatable).Close()
+	})
+	return writer.(rotatable), dir
+}
+
+func TestWriterConfig_Compile_FileOnDiskFull(t *testing.T) {
+	t.Run("Error", func(t *testing.T) {
+		writer, _ := compileDiskFullWriter(t, zeroconfig.DiskFullError)
+		zeroconfig.SetFileOutput(writer, &diskFullWriter{full: func() bool { return true }})
+		_, err := writer.Write([]byte("meow\n"))
+		assert.ErrorIs(t, err, syscall.ENOSPC)
+	})
+	t.Run("Stderr", func(t *testing.T) {
+		defer func() {
+			zeroconfig.Stderr = os.Stderr
+		}()
+		var stderr bytes.Buffer
+		zeroconfig.Stderr = &stderr
+		writer, _ := compileDiskFullWriter(t, zeroconfig.DiskFullStderr)
+		zeroconfig.SetFileOutput(writer, &diskFullWriter{full: func() bool { return true }})
+		n, err := writer.Write([]byte("meow\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Equal(t, "meow\n", stderr.String(), "Event should be written to stderr")
+	})
+	t.Run("PurgeOldest", func(t *testing.T) {
+		writer, dir := compileDiskFullWriter(t, zeroconfig.DiskFullPurgeOldest)
+		for i := 0; i < 3; i++ {
+			time.Sleep(2 * time.Millisecond)
+			_, err := writer.Write([]byte(fmt.Sprintf("backup #%d\n", i)))
+			require.NoError(t, err)
+			require.NoError(t, writer.Rotate())
+		}
+		listBackups := func() []string {
+			matches, err := filepath.Glob(filepath.Join(dir, "test-*.log"))
+			require.NoError(t, err)
+			return matches
+		}
+		require.Len(t, listBackups(), 3)
+		oldest := listBackups()[0]
+
+		var out bytes.Buffer
+		zeroconfig.SetFileOutput(writer, &diskFullWriter{
+			full: func() bool { return len(listBackups()) > 2 },
+			out:  &out,
+		})
+		n, err := writer.Write([]byte("meow\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Equal(t, "meow\n", out.String())
+		remaining := listBackups()
+		assert.Len(t, remaining, 2, "Only as many backups as necessary should be deleted")
+		assert.NotContains(t, remaining, oldest, "Oldest backup should be deleted first")
+
+		zeroconfig.SetFileOutput(writer, &diskFullWriter{full: func() bool { return true }})
+		_, err = writer.Write([]byte("meow\n"))
+		assert.ErrorIs(t, err, syscall.ENOSPC, "Error should be returned once there are no backups left")
+		assert.Empty(t, listBackups())
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := (&zeroconfig.WriterConfig{
+			Type:       zeroconfig.WriterTypeFile,
+			FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(t.TempDir(), "test.log"), OnDiskFull: "meow"},
+		}).Compile()
+		assert.Error(t, err)
+	})
+}
+
+// fakeFileBackend records the calls made to it.
+type fakeFileBackend struct {
+	cfg   zeroconfig.FileConfig
+	calls []string
+}
+
+func (ffb *fakeFileBackend) Open() error {
+	ffb.calls = append(ffb.calls, "open "+ffb.cfg.Filename)
+	return nil
+}
+
+func (ffb *fakeFileBackend) Write(p []byte) (int, error) {
+	ffb.calls = append(ffb.calls, "write "+string(p))
+	return len(p), nil
+}
+
+func (ffb *fakeFileBackend) Rotate() error {
+	ffb.calls = append(ffb.calls, "rotate")
+	return nil
+}
+
+func (ffb *fakeFileBackend) Close() error {
+	ffb.calls = append(ffb.calls, "close")
+	return nil
+}
+
+func TestWriterConfig_Compile_FileBackend(t *testing.T) {
+	var backend *fakeFileBackend
+	zeroconfig.RegisterFileBackend("fake", func(cfg zeroconfig.FileConfig) (zeroconfig.FileBackend, error) {
+		backend = &fakeFileBackend{cfg: cfg}
+		return backend, nil
+	})
+	var wc zeroconfig.WriterConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"type": "file", "filename": "meow.log", "backend": "fake", "max_size": 5}`), &wc))
+	writer, err := wc.Compile()
+	require.NoError(t, err)
+	require.NotNil(t, backend)
+	assert.Equal(t, 5, backend.cfg.MaxSize, "Backend should get the whole file config")
+	fw := writer.(rotatable)
+	_, err = fw.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Rotate())
+	_, err = fw.Write([]byte("world\n"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+	assert.Equal(t, []string{"open meow.log", "write hello\n", "rotate", "write world\n", "close"}, backend.calls)
+
+	wc.Backend = "nonexistent"
+	_, err = wc.Compile()
+	assert.EqualError(t, err, `unknown file backend "nonexistent"`)
+	wc.Backend = "fake"
+	wc.OnDiskFull = zeroconfig.DiskFullPurgeOldest
+	_, err = wc.Compile()
+	assert.EqualError(t, err, "on_disk_full is only supported with the lumberjack backend")
+}
+
+func TestWriterConfig_Compile_FileHeaderLine(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{
+			Filename:   filepath.Join(dir, "test.log"),
+			MaxSize:    1,
+			HeaderLine: "# schema: meow/v1",
+		},
+	}).Compile()
+	require.NoError(t, err)
+	fw := writer.(rotatable)
+	defer fw.Close()
+
+	line := strings.Repeat("meow", 100*1024) + "\n"
+	for i := 0; i < 3; i++ {
+		_, err = fw.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	// Backup names have millisecond precision, so make sure the second rotation gets a different name.
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, fw.Rotate())
+	_, err = fw.Write([]byte("{}\n"))
+	require.NoError(t, err)
+
+	backups, err := filepath.Glob(filepath.Join(dir, "test-*.log"))
+	require.NoError(t, err)
+	require.Len(t, backups, 2, "There should be one backup from the size limit and one from the manual rotation")
+	for _, path := range append(backups, filepath.Join(dir, "test.log")) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), "# schema: meow/v1\n"), "%s should start with the header", path)
+		assert.Equal(t, 1, strings.Count(string(data), "# schema"), "%s should contain the header exactly once", path)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "test.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "# schema: meow/v1\n{}\n", string(data))
+
+	zeroconfig.RegisterFileBackend("fake-header", func(cfg zeroconfig.FileConfig) (zeroconfig.FileBackend, error) {
+		return &fakeFileBackend{cfg: cfg}, nil
+	})
+	_, err = (&zeroconfig.WriterConfig{
+		Type:       zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(dir, "other.log"), Backend: "fake-header", HeaderLine: "meow"},
+	}).Compile()
+	assert.EqualError(t, err, "header_line is only supported with the lumberjack backend")
+}
+
+func TestWriterConfig_Compile_FileCopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.log")
+	require.NoError(t, os.WriteFile(path, []byte("previous run\n"), 0600))
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{
+			Filename:      path,
+			RotationMode:  zeroconfig.RotationModeCopyTruncate,
+			Compress:      true,
+			CompressLevel: zeroconfig.CompressLevelBest,
+		},
+	}).Compile()
+	require.NoError(t, err)
+	fw := writer.(rotatable)
+
+	infoBefore, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, infoBefore.Size(), "Opening should truncate the file from the previous run")
+	_, err = fw.Write([]byte("meow\n"))
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, fw.Rotate())
+	infoAfter, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(infoBefore, infoAfter), "The live file should be truncated in place rather than replaced")
+	assert.Zero(t, infoAfter.Size())
+	_, err = fw.Write([]byte("hiss\n"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hiss\n", string(data), "Writes after truncation should start from the beginning of the file")
+
+	backups, err := filepath.Glob(filepath.Join(dir, "test-*.log.gz"))
+	require.NoError(t, err)
+	require.Len(t, backups, 2, "Both backups should be compressed")
+	var contents []string
+	for _, backup := range backups {
+		file, err := os.Open(backup)
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		data, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		_ = file.Close()
+		contents = append(contents, string(data))
+	}
+	assert.ElementsMatch(t, []string{"previous run\n", "meow\n"}, contents)
+
+	_, err = (&zeroconfig.WriterConfig{
+		Type:       zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{Filename: path, RotationMode: "meow"},
+	}).Compile()
+	assert.EqualError(t, err, `unknown rotation_mode "meow"`)
+}
+
+func TestWriterConfig_Compile_FileExpandEnv(t *testing.T) {
+	dir := t.TempDir()
+	defer func() {
+		zeroconfig.Getenv = os.Getenv
+	}()
+	zeroconfig.Getenv = func(key string) string {
+		if key == "LOG_DIR" {
+			return dir
+		}
+		return ""
+	}
+	compileAndWrite := func(t *testing.T, cfg string) {
+		var wc zeroconfig.WriterConfig
+		require.NoError(t, json.Unmarshal([]byte(cfg), &wc))
+		writer, err := wc.Compile()
+		require.NoError(t, err)
+		_, err = writer.Write([]byte("{}\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.(io.Closer).Close())
+	}
+
+	t.Run("Expanded", func(t *testing.T) {
+		compileAndWrite(t, `{"type": "file", "filename": "$LOG_DIR/${UNDEFINED}app.log"}`)
+		assert.FileExists(t, filepath.Join(dir, "app.log"))
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		literal := filepath.Join(dir, "$LOG_DIR")
+		compileAndWrite(t, `{"type": "file", "filename": "`+literal+`", "expand_env": false}`)
+		assert.FileExists(t, literal)
+	})
+}
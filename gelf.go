@@ -0,0 +1,312 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// GELFConfig contains the configuration for the gelf writer type.
+type GELFConfig struct {
+	// The address of the Graylog GELF input, like graylog:12201.
+	Address string `json:"address" yaml:"address" toml:"address"`
+	// The protocol to use: udp, tcp or tcp+tls. Defaults to udp.
+	Protocol string `json:"protocol,omitempty" yaml:"protocol,omitempty" toml:"protocol,omitempty"`
+	// TLS options for the tcp+tls protocol.
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" toml:"tls,omitempty"`
+	// How to compress UDP messages: gzip, zlib or none. GELF over TCP doesn't support compression. Defaults to none.
+	Compression string `json:"compression,omitempty" yaml:"compression,omitempty" toml:"compression,omitempty"`
+	// The host field of messages. Defaults to the hostname of the machine.
+	Host string `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
+	// Maximum size of a UDP datagram. Larger messages are split into chunks. Defaults to DefaultGELFChunkSize.
+	ChunkSize int `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty" toml:"chunk_size,omitempty"`
+}
+
+// DefaultGELFChunkSize is the UDP chunk size used by the gelf writer if GELFConfig.ChunkSize isn't set.
+// It's the size recommended by Graylog for networks that go through the internet.
+const DefaultGELFChunkSize = 1420
+
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfWriter converts events to GELF messages and sends them with a network writer.
+type gelfWriter struct {
+	*networkWriter
+	host        string
+	compression string
+	chunkSize   int
+	tcp         bool
+}
+
+func compileGELF(wc *WriterConfig) (io.Writer, error) {
+	cfg := wc.GELF
+	if cfg == nil || cfg.Address == "" {
+		return nil, fmt.Errorf("gelf.address is required for the gelf writer")
+	} else if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid gelf.address: %w", err)
+	} else if cfg.ChunkSize < 0 || (cfg.ChunkSize > 0 && cfg.ChunkSize <= gelfChunkHeaderSize) {
+		return nil, fmt.Errorf("gelf.chunk_size must be larger than %d bytes", gelfChunkHeaderSize)
+	}
+	gw := &gelfWriter{host: cfg.Host, compression: cfg.Compression, chunkSize: cfg.ChunkSize}
+	var tlsConfig *tls.Config
+	switch cfg.Protocol {
+	case "", "udp":
+	case "tcp":
+		gw.tcp = true
+	case "tcp+tls":
+		gw.tcp = true
+		var err error
+		if tlsConfig, err = cfg.TLS.compile(); err != nil {
+			return nil, fmt.Errorf("invalid gelf.tls: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported gelf.protocol %q (expected udp, tcp or tcp+tls)", cfg.Protocol)
+	}
+	if cfg.TLS != nil && tlsConfig == nil {
+		return nil, fmt.Errorf("gelf.tls is only supported with the tcp+tls protocol")
+	}
+	switch cfg.Compression {
+	case "", "none":
+		gw.compression = ""
+	case "gzip", "zlib":
+		if gw.tcp {
+			return nil, fmt.Errorf("gelf.compression is only supported with the udp protocol")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported gelf.compression %q (expected gzip, zlib or none)", cfg.Compression)
+	}
+	// The fields are taken from zerolog's JSON output, so the other formats can't be converted.
+	if format, _ := ParseLogFormat(string(wc.Format)); format != "" && format != LogFormatJSON {
+		return nil, fmt.Errorf("the gelf writer only supports the json format")
+	}
+	if gw.host == "" {
+		gw.host, _ = os.Hostname()
+	}
+	if gw.chunkSize == 0 {
+		gw.chunkSize = DefaultGELFChunkSize
+	}
+	netCfg := &NetworkConfig{Network: "udp", Address: cfg.Address}
+	if gw.tcp {
+		netCfg.Network = "tcp"
+		// A GELF input restarting shouldn't lose events. Each message is null-terminated, so at worst
+		// a partially sent message is discarded by Graylog before the resent one.
+		netCfg.ReconnectOnError = true
+	}
+	var err error
+	gw.networkWriter, err = newNetworkWriter(netCfg, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func (gw *gelfWriter) Write(p []byte) (n int, err error) {
+	return gw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (gw *gelfWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	msg, err := gw.convert(level, p)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert event to GELF: %w", err)
+	}
+	var parts [][]byte
+	if gw.tcp {
+		// GELF over TCP is delimited with null bytes.
+		parts = [][]byte{append(msg, 0)}
+	} else if parts, err = gw.chunk(msg); err != nil {
+		gw.dropped.Add(1)
+		gw.setHealth(err)
+		return len(p), nil
+	}
+	if err = gw.writeParts(parts...); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// gelfLevel maps a zerolog level to a syslog severity in the same way as zerolog's journald writer.
+func gelfLevel(level zerolog.Level) int {
+	switch nearestStandardLevel(level) {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.InfoLevel:
+		return 6
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 0
+	default:
+		return 5
+	}
+}
+
+// convert builds a GELF message from a zerolog event. Fields other than the level, message and timestamp
+// become additional fields with an underscore prefix.
+func (gw *gelfWriter) convert(level zerolog.Level, p []byte) ([]byte, error) {
+	obj, err := parseJSONObject(p)
+	if err != nil {
+		return nil, err
+	}
+	if level == zerolog.NoLevel {
+		if val, ok := obj.get(zerolog.LevelFieldName); ok {
+			var levelName string
+			if json.Unmarshal(val, &levelName) == nil {
+				level, _ = zerolog.ParseLevel(levelName)
+			}
+		}
+	}
+	shortMessage := "-"
+	if val, ok := obj.get(zerolog.MessageFieldName); ok {
+		var msg string
+		if json.Unmarshal(val, &msg) == nil && msg != "" {
+			shortMessage = msg
+		}
+	}
+	out := []byte(`{"version":"1.1","host":`)
+	out = appendJSONString(out, gw.host)
+	out = append(out, `,"short_message":`...)
+	out = appendJSONString(out, shortMessage)
+	out = append(out, `,"level":`...)
+	out = strconv.AppendInt(out, int64(gelfLevel(level)), 10)
+	for _, field := range obj {
+		switch field.Key {
+		case zerolog.LevelFieldName, zerolog.MessageFieldName:
+			continue
+		case zerolog.TimestampFieldName:
+			if ts, ok := parseEventTime(field.Value); ok {
+				out = append(out, `,"timestamp":`...)
+				out = strconv.AppendFloat(out, float64(ts.UnixMicro())/1e6, 'f', -1, 64)
+				continue
+			}
+		}
+		out = gw.appendAdditionalField(out, field)
+	}
+	return append(out, '}'), nil
+}
+
+// appendAdditionalField appends a field with an underscore prefix. GELF only allows strings and numbers,
+// so other values are added as JSON strings and nulls are skipped.
+func (gw *gelfWriter) appendAdditionalField(out []byte, field jsonField) []byte {
+	value := bytes.TrimSpace(field.Value)
+	if len(value) == 0 || string(value) == "null" {
+		return out
+	}
+	out = append(out, ',')
+	out = appendJSONString(out, gelfFieldName(field.Key))
+	out = append(out, ':')
+	switch {
+	case value[0] == '"', value[0] == '-', value[0] >= '0' && value[0] <= '9':
+		return append(out, value...)
+	default:
+		return appendJSONString(out, string(value))
+	}
+}
+
+// gelfFieldName returns the additional field name for a key. GELF only allows letters, digits, underscores,
+// dashes and dots, and reserves _id for Graylog's own use.
+func gelfFieldName(key string) string {
+	name := []byte("_" + key)
+	for i := 1; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			name[i] = '_'
+		}
+	}
+	if string(name) == "_id" {
+		return "__id"
+	}
+	return string(name)
+}
+
+// parseEventTime parses the timestamp of an event according to zerolog.TimeFieldFormat.
+func parseEventTime(val json.RawMessage) (time.Time, bool) {
+	var str string
+	if json.Unmarshal(val, &str) == nil {
+		ts, err := time.Parse(zerolog.TimeFieldFormat, str)
+		return ts, err == nil
+	}
+	num, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch zerolog.TimeFieldFormat {
+	case zerolog.TimeFormatUnix:
+		return time.Unix(num, 0), true
+	case zerolog.TimeFormatUnixMs:
+		return time.UnixMilli(num), true
+	case zerolog.TimeFormatUnixMicro:
+		return time.UnixMicro(num), true
+	case zerolog.TimeFormatUnixNano:
+		return time.Unix(0, num), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// chunk compresses a message if enabled and splits it into UDP chunks if it's larger than the chunk size.
+func (gw *gelfWriter) chunk(msg []byte) ([][]byte, error) {
+	if gw.compression != "" {
+		var buf bytes.Buffer
+		var cw io.WriteCloser
+		if gw.compression == "gzip" {
+			cw = gzip.NewWriter(&buf)
+		} else {
+			cw = zlib.NewWriter(&buf)
+		}
+		_, _ = cw.Write(msg)
+		if err := cw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress message: %w", err)
+		}
+		msg = buf.Bytes()
+	}
+	if len(msg) <= gw.chunkSize {
+		return [][]byte{msg}, nil
+	}
+	dataSize := gw.chunkSize - gelfChunkHeaderSize
+	count := (len(msg) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("message is too large (%d bytes would need %d chunks, the maximum is %d)", len(msg), count, gelfMaxChunks)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate message ID: %w", err)
+	}
+	chunks := make([][]byte, count)
+	for i := range chunks {
+		data := msg[i*dataSize:]
+		if len(data) > dataSize {
+			data = data[:dataSize]
+		}
+		chunk := make([]byte, 0, gelfChunkHeaderSize+len(data))
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks[i] = append(chunk, data...)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func compileGELF(t *testing.T, gelf string) *zeroconfig.Handle {
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "gelf", "gelf": `+gelf+`}], "timestamp": false}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = handle.Close()
+	})
+	return handle
+}
+
+func receiveDatagram(t *testing.T, conn net.PacketConn) []byte {
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return buf[:n]
+}
+
+func TestWriterConfig_Compile_GELF(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	handle := compileGELF(t, `{"address": "`+conn.LocalAddr().String()+`", "host": "meowhost"}`)
+
+	handle.Logger.Warn().Str("user.name", "meow").Int("count", 3).Bool("ok", true).
+		Interface("tags", []string{"a"}).Str("id", "x").Msg("hello")
+	assert.Equal(t, `{"version":"1.1","host":"meowhost","short_message":"hello","level":4,`+
+		`"_user.name":"meow","_count":3,"_ok":"true","_tags":"[\"a\"]","__id":"x"}`, string(receiveDatagram(t, conn)))
+
+	handle.Logger.Log().Msg("")
+	assert.Equal(t, `{"version":"1.1","host":"meowhost","short_message":"-","level":5}`, string(receiveDatagram(t, conn)))
+}
+
+func TestWriterConfig_Compile_GELFTimestamp(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "gelf", "gelf": {"address": "`+conn.LocalAddr().String()+`", "host": "h"}}]}`), &cfg))
+	log, err := cfg.Compile(zeroconfig.WithClock(func() time.Time {
+		return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	}))
+	require.NoError(t, err)
+	log.Error().Msg("meow")
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"meow","level":3,"timestamp":1704164645}`, string(receiveDatagram(t, conn)))
+}
+
+func TestWriterConfig_Compile_GELFChunked(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	handle := compileGELF(t, `{"address": "`+conn.LocalAddr().String()+`", "compression": "gzip", "chunk_size": 100}`)
+
+	// Random-ish data doesn't compress well, so the message needs multiple chunks even when compressed.
+	var long bytes.Buffer
+	for i := 0; i < 200; i++ {
+		long.WriteString(zerolog.Level(i%7 - 1).String())
+	}
+	handle.Logger.Info().Str("long", long.String()).Msg("chunked")
+
+	var parts [][]byte
+	var id []byte
+	for {
+		chunk := receiveDatagram(t, conn)
+		require.Greater(t, len(chunk), 12)
+		require.LessOrEqual(t, len(chunk), 100)
+		assert.Equal(t, []byte{0x1e, 0x0f}, chunk[:2])
+		if id == nil {
+			id = chunk[2:10]
+			parts = make([][]byte, chunk[11])
+		}
+		assert.Equal(t, id, chunk[2:10], "All chunks should have the same message ID")
+		parts[chunk[10]] = chunk[12:]
+		if len(parts) > 1 && parts[len(parts)-1] != nil {
+			break
+		}
+	}
+	require.Greater(t, len(parts), 1)
+	gz, err := gzip.NewReader(bytes.NewReader(bytes.Join(parts, nil)))
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	var msg map[string]any
+	require.NoError(t, json.Unmarshal(data, &msg))
+	assert.Equal(t, "chunked", msg["short_message"])
+	assert.Equal(t, long.String(), msg["_long"])
+}
+
+func TestWriterConfig_Compile_GELFTooLarge(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	handle := compileGELF(t, `{"address": "`+conn.LocalAddr().String()+`", "chunk_size": 13}`)
+	handle.Logger.Info().Msg("this needs more than 128 one-byte chunks once the GELF fields are added")
+	assert.EqualValues(t, 1, handle.Stats().Writers[0].Dropped)
+	assert.ErrorContains(t, handle.HealthCheck()["writer #1 (gelf)"], "message is too large")
+}
+
+// gelfTCPInput accepts connections on a listener and sends each null-terminated message to a channel.
+type gelfTCPInput struct {
+	listener net.Listener
+	messages chan string
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+func newGELFTCPInput(t *testing.T, listener net.Listener) *gelfTCPInput {
+	input := &gelfTCPInput{listener: listener, messages: make(chan string, 10)}
+	t.Cleanup(input.stop)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			input.lock.Lock()
+			input.conns = append(input.conns, conn)
+			input.lock.Unlock()
+			go input.read(conn)
+		}
+	}()
+	return input
+}
+
+func (gti *gelfTCPInput) read(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := reader.ReadString(0)
+		if err != nil {
+			_ = conn.Close()
+			return
+		}
+		gti.messages <- msg
+	}
+}
+
+// stop closes the listener and all accepted connections.
+func (gti *gelfTCPInput) stop() {
+	_ = gti.listener.Close()
+	gti.lock.Lock()
+	for _, conn := range gti.conns {
+		_ = conn.Close()
+	}
+	gti.lock.Unlock()
+}
+
+func TestWriterConfig_Compile_GELFTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	messages := newGELFTCPInput(t, listener).messages
+	handle := compileGELF(t, `{"address": "`+listener.Addr().String()+`", "protocol": "tcp", "host": "h"}`)
+	handle.Logger.Debug().Msg("one")
+	handle.Logger.Trace().Msg("two")
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"one","level":7}`+"\x00", receiveLine(t, messages))
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"two","level":7}`+"\x00", receiveLine(t, messages))
+}
+
+func TestWriterConfig_Compile_GELFTCPReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	input := newGELFTCPInput(t, listener)
+	handle := compileGELF(t, `{"address": "`+addr+`", "protocol": "tcp", "host": "h"}`)
+	handle.Logger.Info().Msg("one")
+	assert.Contains(t, receiveLine(t, input.messages), `"short_message":"one"`)
+
+	// Restart the input. Writes to the old connection fail, which should reconnect and send the event again.
+	input.stop()
+	listener, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	messages := newGELFTCPInput(t, listener).messages
+	for i := 0; i < 10; i++ {
+		handle.Logger.Info().Msg("back")
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Contains(t, receiveLine(t, messages), `"short_message":"back"`)
+	assert.Zero(t, handle.Stats().Writers[0].Dropped)
+}
+
+func TestWriterConfig_Compile_GELFTLS(t *testing.T) {
+	// httptest generates a certificate for 127.0.0.1, which is reused for a plain TLS listener.
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	tlsConfig := server.TLS.Clone()
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	server.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	require.NoError(t, err)
+	messages := newGELFTCPInput(t, listener).messages
+	handle := compileGELF(t, `{"address": "`+listener.Addr().String()+`", "protocol": "tcp+tls", "host": "h", "tls": {"ca_file": "`+caPath+`"}}`)
+	handle.Logger.Warn().Msg("secret")
+	assert.Equal(t, `{"version":"1.1","host":"h","short_message":"secret","level":4}`+"\x00", receiveLine(t, messages))
+
+	_, err = (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeGELF,
+		GELF: &zeroconfig.GELFConfig{Address: listener.Addr().String(), Protocol: "tcp+tls"},
+	}).Compile()
+	assert.ErrorContains(t, err, "certificate", "The certificate shouldn't be trusted without ca_file")
+}
+
+func TestWriterConfig_Compile_GELFInvalid(t *testing.T) {
+	tests := map[string]struct {
+		gelf *zeroconfig.GELFConfig
+		err  string
+	}{
+		"Missing":     {nil, "gelf.address is required for the gelf writer"},
+		"Address":     {&zeroconfig.GELFConfig{Address: "graylog"}, "invalid gelf.address: address graylog: missing port in address"},
+		"Protocol":    {&zeroconfig.GELFConfig{Address: "graylog:12201", Protocol: "http"}, `unsupported gelf.protocol "http" (expected udp, tcp or tcp+tls)`},
+		"Compression": {&zeroconfig.GELFConfig{Address: "graylog:12201", Compression: "brotli"}, `unsupported gelf.compression "brotli" (expected gzip, zlib or none)`},
+		"TCPCompress": {&zeroconfig.GELFConfig{Address: "graylog:12201", Protocol: "tcp", Compression: "gzip"}, "gelf.compression is only supported with the udp protocol"},
+		"TLSOnUDP":    {&zeroconfig.GELFConfig{Address: "graylog:12201", TLS: &zeroconfig.TLSConfig{}}, "gelf.tls is only supported with the tcp+tls protocol"},
+		"TLSKey":      {&zeroconfig.GELFConfig{Address: "graylog:12201", Protocol: "tcp+tls", TLS: &zeroconfig.TLSConfig{CertFile: "client.pem"}}, "invalid gelf.tls: cert_file and key_file must be set together"},
+		"ChunkSize":   {&zeroconfig.GELFConfig{Address: "graylog:12201", ChunkSize: 12}, "gelf.chunk_size must be larger than 12 bytes"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeGELF, GELF: test.gelf}).Compile()
+			assert.EqualError(t, err, test.err)
+		})
+	}
+	_, err := (&zeroconfig.WriterConfig{
+		Type:   zeroconfig.WriterTypeGELF,
+		Format: zeroconfig.LogFormatPretty,
+		GELF:   &zeroconfig.GELFConfig{Address: "127.0.0.1:12201"},
+	}).Compile()
+	assert.EqualError(t, err, "the gelf writer only supports the json format")
+}
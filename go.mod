@@ -3,9 +3,13 @@ module go.mau.fi/zeroconfig
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/gorilla/websocket v1.5.0
 	github.com/rs/zerolog v1.29.0
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -14,6 +18,4 @@ require (
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.0.0-20210927094055-39ccf1dd6fa6 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,148 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// inherit returns a copy of the child writer config with unset shared settings copied from the group.
+func (wc *WriterConfig) inherit(group *WriterConfig) WriterConfig {
+	child := *wc
+	if child.Format == "" {
+		child.Format = group.Format
+	}
+	if child.TimeFormat == "" {
+		child.TimeFormat = group.TimeFormat
+	}
+	if child.Color == "" {
+		child.Color = group.Color
+	}
+	if child.Console == nil {
+		child.Console = group.Console
+	}
+	if child.MinLevel == nil {
+		child.MinLevel = group.MinLevel
+	}
+	if child.MaxLevel == nil {
+		child.MaxLevel = group.MaxLevel
+	}
+	if child.NoLevel == "" {
+		child.NoLevel = group.NoLevel
+	}
+	if child.NotifyOnLevel == nil {
+		child.NotifyOnLevel = group.NotifyOnLevel
+		child.NotifyDesktop = group.NotifyDesktop
+	}
+	if child.Sampling == nil {
+		child.Sampling = group.Sampling
+	}
+	if group.Instrument {
+		child.Instrument = true
+		if child.InstrumentExpvar == "" {
+			child.InstrumentExpvar = group.InstrumentExpvar
+		}
+	}
+	if group.Async {
+		child.Async = true
+		if child.AsyncBufferSize == 0 {
+			child.AsyncBufferSize = group.AsyncBufferSize
+		}
+		if child.AsyncOnFull == "" {
+			child.AsyncOnFull = group.AsyncOnFull
+		}
+		if group.AsyncReportDrops {
+			child.AsyncReportDrops = true
+		}
+	}
+	if child.AdaptiveSampling == nil {
+		child.AdaptiveSampling = group.AdaptiveSampling
+	}
+	if group.HTMLSafe {
+		child.HTMLSafe = true
+	}
+	if group.StripANSI {
+		child.StripANSI = true
+	}
+	child.levelLabels = group.levelLabels
+	if child.MetadataRemove == nil {
+		child.MetadataRemove = group.MetadataRemove
+	}
+	if child.MetadataOverride == nil {
+		child.MetadataOverride = group.MetadataOverride
+	}
+	if child.Fields == nil {
+		child.Fields = group.Fields
+	}
+	if child.RemoveFields == nil {
+		child.RemoveFields = group.RemoveFields
+	}
+	if child.RedactFields == nil {
+		child.RedactFields = group.RedactFields
+	}
+	return child
+}
+
+func (wc *WriterConfig) compileFilters() ([]eventRewriteFunc, error) {
+	var funcs []eventRewriteFunc
+	if len(wc.MatchFields) > 0 {
+		fn, err := matchFieldsRewriter(wc.MatchFields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match_fields: %w", err)
+		}
+		funcs = append(funcs, fn)
+	}
+	if len(wc.ExcludeFields) > 0 {
+		fn, err := excludeFieldsRewriter(wc.ExcludeFields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude_fields: %w", err)
+		}
+		funcs = append(funcs, fn)
+	}
+	return funcs, nil
+}
+
+// compileGroup compiles each child writer of a group with the group's settings applied.
+// The group's filters are applied before the child writer, so they take effect before the child's own level bounds.
+func (wc *WriterConfig) compileGroup(nested bool, counters *writerCounters) (io.Writer, error) {
+	if len(wc.Writers) == 0 {
+		return nil, fmt.Errorf("group must have at least one writer")
+	}
+	filters, err := wc.compileFilters()
+	if err != nil {
+		return nil, err
+	}
+	writers := make([]io.Writer, len(wc.Writers))
+	for i, child := range wc.Writers {
+		merged := child.inherit(wc)
+		var writer io.Writer
+		if merged.Type == WriterTypeGroup {
+			if nested {
+				return nil, fmt.Errorf("groups can only be nested one level deep")
+			}
+			writer, err = merged.compileGroup(true, counters)
+		} else {
+			writer, err = merged.compile(counters)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config for group writer #%d (%s): %w", i+1, child.Type, err)
+		}
+		if len(filters) > 0 {
+			rewriter := newEventRewriter(writer, filters...)
+			rewriter.dropped = counters.filteredCounter()
+			writer = rewriter
+		}
+		writers[i] = writer
+	}
+	if len(writers) == 1 {
+		return writers[0], nil
+	}
+	return zerolog.MultiLevelWriter(writers...), nil
+}
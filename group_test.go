@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_Group(t *testing.T) {
+	var stderr, stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [{
+	    "type": "group",
+	    "format": "pretty",
+	    "min_level": "info",
+	    "exclude_fields": {"component": "sync"},
+	    "writers": [
+	      {"type": "stdout", "metadata_remove": ["component"]},
+	      {"type": "stderr", "format": "json", "min_level": "warn"}
+	    ]
+	  }],
+	  "timestamp": false
+	}`)
+
+	log.Debug().Str("component", "api").Msg("meow")
+	assert.Empty(t, stdout.String(), "Stdout should inherit min_level from group")
+	assert.Empty(t, stderr.String(), "Stderr should have its own min_level")
+
+	log.Info().Str("component", "api").Msg("meow #2")
+	assert.Equal(t, "<nil> INF meow #2\n", stdout.String(), "Stdout should inherit pretty format from group")
+	assert.Empty(t, stderr.String(), "Stderr should have its own min_level")
+	stdout.Reset()
+
+	log.Error().Str("component", "sync").Msg("meow #3")
+	assert.Empty(t, stdout.String(), "Group filter should apply before the child removes the field")
+	assert.Empty(t, stderr.String(), "Group filter should apply to all children")
+
+	log.Error().Str("component", "api").Msg("meow #4")
+	assert.Equal(t, "<nil> ERR meow #4\n", stdout.String())
+	assert.Equal(t, `{"level":"error","component":"api","message":"meow #4"}`+"\n", stderr.String(), "Stderr should override format")
+}
+
+func TestWriterConfig_Compile_GroupNesting(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	log := compile(t, `{
+	  "writers": [{
+	    "type": "group",
+	    "match_fields": {"important": true},
+	    "writers": [{
+	      "type": "group",
+	      "exclude_fields": {"cat": 5},
+	      "writers": [{"type": "stdout"}]
+	    }]
+	  }],
+	  "timestamp": false
+	}`)
+	log.Info().Bool("important", true).Int("cat", 5).Msg("meow")
+	log.Info().Bool("important", false).Int("cat", 4).Msg("meow")
+	log.Info().Bool("important", true).Int("cat", 4).Msg("meow")
+	assert.Equal(t, `{"level":"info","important":true,"cat":4,"message":"meow"}`+"\n", stdout.String(), "Both groups' filters should apply")
+
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "group", "writers": [
+	  {"type": "group", "writers": [{"type": "group", "writers": [{"type": "stdout"}]}]}
+	]}]}`), &cfg))
+	_, err := cfg.Compile()
+	assert.Error(t, err, "Groups should only be nestable one level deep")
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// writerCounters counts events that were intentionally not written to a single top-level writer.
+type writerCounters struct {
+	writerType WriterType
+	sampled    atomic.Uint64
+	filtered   atomic.Uint64
+	// The number of adaptive samplers (one per group child) that are currently sampling, and how many times they've started.
+	adaptiveActive      atomic.Int32
+	adaptiveActivations atomic.Uint64
+	// Only set if the writer has instrumentation enabled.
+	writes *writeInstrumentation
+	// Recovered panics, and the number of outputs (one per group child) that were disabled due to panics.
+	panics      atomic.Uint64
+	quarantined atomic.Int32
+
+	// The 1-based index of the writer and the outputs of the whole config, used for deduplicating outputs.
+	index   int
+	outputs *outputRegistry
+	// The panic recovery settings of the whole config.
+	panicPolicy *panicPolicy
+}
+
+func (wc *writerCounters) sampledCounter() *atomic.Uint64 {
+	if wc == nil {
+		return nil
+	}
+	return &wc.sampled
+}
+
+func (wc *writerCounters) filteredCounter() *atomic.Uint64 {
+	if wc == nil {
+		return nil
+	}
+	return &wc.filtered
+}
+
+type compileStats struct {
+	writers  []writerCounters
+	dedup    *stackDeduplicator
+	coalesce *coalesceWriter
+}
+
+// WriterStats contains the number of events that were intentionally not written to a writer.
+type WriterStats struct {
+	Type WriterType `json:"type"`
+	// Events dropped by sampling.
+	Sampled uint64 `json:"sampled"`
+	// Events dropped by match_fields or exclude_fields (including those of groups).
+	Filtered uint64 `json:"filtered"`
+	// Whether adaptive sampling is currently enabled due to a high event rate.
+	AdaptiveSamplingActive bool `json:"adaptive_sampling_active,omitempty"`
+	// The number of times adaptive sampling has been enabled. Events it dropped are counted in Sampled.
+	AdaptiveSamplingActivations uint64 `json:"adaptive_sampling_activations,omitempty"`
+	// Write counters of the output, only set if WriterConfig.Instrument is enabled.
+	Writes *WriteStats `json:"writes,omitempty"`
+	// Panics recovered from the output, see Config.RecoverPanics.
+	Panics uint64 `json:"panics,omitempty"`
+	// Whether the output has been disabled due to repeated panics.
+	Quarantined bool `json:"quarantined,omitempty"`
+	// Events the output failed to deliver and discarded, e.g. while the network writer was reconnecting.
+	Dropped uint64 `json:"dropped,omitempty"`
+}
+
+// Stats contains counters for a compiled logger.
+type Stats struct {
+	// Stats for each writer, in the same order as Config.Writers.
+	Writers []WriterStats `json:"writers"`
+	// Stack traces that were replaced with a stack_ref by Config.DedupStacks.
+	DedupedStacks uint64 `json:"deduped_stacks"`
+	// Events that were dropped by Config.Coalesce and counted in a summary event instead.
+	Coalesced uint64 `json:"coalesced"`
+}
+
+func (s *Stats) sub(other *Stats) *Stats {
+	diff := &Stats{
+		Writers:       make([]WriterStats, len(s.Writers)),
+		DedupedStacks: s.DedupedStacks - other.DedupedStacks,
+		Coalesced:     s.Coalesced - other.Coalesced,
+	}
+	for i, ws := range s.Writers {
+		diff.Writers[i] = WriterStats{
+			Type:     ws.Type,
+			Sampled:  ws.Sampled - other.Writers[i].Sampled,
+			Filtered: ws.Filtered - other.Writers[i].Filtered,
+
+			AdaptiveSamplingActive:      ws.AdaptiveSamplingActive,
+			AdaptiveSamplingActivations: ws.AdaptiveSamplingActivations - other.Writers[i].AdaptiveSamplingActivations,
+
+			Panics:      ws.Panics - other.Writers[i].Panics,
+			Quarantined: ws.Quarantined,
+			Dropped:     ws.Dropped - other.Writers[i].Dropped,
+		}
+	}
+	return diff
+}
+
+func (s *Stats) isZero() bool {
+	if s.DedupedStacks != 0 || s.Coalesced != 0 {
+		return false
+	}
+	for _, ws := range s.Writers {
+		if ws.Sampled != 0 || ws.Filtered != 0 || ws.AdaptiveSamplingActivations != 0 || ws.Panics != 0 || ws.Dropped != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Handle contains a compiled logger along with things related to it, like stats and background tasks.
+type Handle struct {
+	Logger *zerolog.Logger
+
+	stats      *compileStats
+	stop       chan struct{}
+	tasks      sync.WaitGroup
+	closeOnce  sync.Once
+	closeErr   error
+	logStopped bool
+	closers    []handleCloser
+
+	writers        []activeWriter
+	outputs        []io.Writer
+	healthCheckers map[int][]healthChecker
+	dropCounters   map[int][]dropCounter
+}
+
+func newHandle(log *zerolog.Logger, stats *compileStats) *Handle {
+	return &Handle{Logger: log, stats: stats, stop: make(chan struct{})}
+}
+
+// Stats returns the total counters since the logger was compiled.
+func (h *Handle) Stats() Stats {
+	stats := Stats{Writers: make([]WriterStats, len(h.stats.writers))}
+	for i := range h.stats.writers {
+		counters := &h.stats.writers[i]
+		stats.Writers[i] = WriterStats{
+			Type:     counters.writerType,
+			Sampled:  counters.sampled.Load(),
+			Filtered: counters.filtered.Load(),
+
+			AdaptiveSamplingActive:      counters.adaptiveActive.Load() > 0,
+			AdaptiveSamplingActivations: counters.adaptiveActivations.Load(),
+			Writes:                      counters.writes.stats(),
+			Panics:                      counters.panics.Load(),
+			Quarantined:                 counters.quarantined.Load() > 0,
+		}
+		for _, counter := range h.dropCounters[i+1] {
+			stats.Writers[i].Dropped += counter.droppedEvents()
+		}
+	}
+	if h.stats.dedup != nil {
+		stats.DedupedStacks = h.stats.dedup.deduped.Load()
+	}
+	if h.stats.coalesce != nil {
+		stats.Coalesced = h.stats.coalesce.coalesced.Load()
+	}
+	return stats
+}
+
+// Writers returns the output of each writer in Config.Writers, in the same order, e.g. for rotating files on SIGHUP.
+// Outputs are the innermost writers (like the file or syslog connection), so writing to them directly bypasses
+// formatting and filtering. File writer outputs implement Rotator.
+//
+// Entries are nil for writers that aren't active (e.g. in other environments or when min_level is disabled)
+// and for discard writers.
+// For groups, the entry is an OutputGroup containing the outputs of all writers in the group.
+func (h *Handle) Writers() []io.Writer {
+	return append([]io.Writer(nil), h.outputs...)
+}
+
+// Rotator is implemented by outputs that can start a new file on demand, like the outputs of file writers.
+type Rotator interface {
+	Rotate() error
+}
+
+// OutputGroup contains the outputs of the writers in a group. Writes go to every output.
+type OutputGroup []io.Writer
+
+func (og OutputGroup) Write(p []byte) (n int, err error) {
+	for _, output := range og {
+		if n, err = output.Write(p); err != nil {
+			return
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops background tasks started by the logger. If Config.LogLifecycleEvents is enabled,
+// the logging stopped event is logged after the tasks have stopped. After that, all outputs that hold
+// resources (like files, syslog connections and the tail writer's listener) are closed, which also flushes
+// any data they have buffered. Stdout and stderr are never closed. It's safe to call multiple times.
+//
+// If closing multiple outputs fails, the returned error contains all the errors.
+func (h *Handle) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.stop)
+		h.tasks.Wait()
+		if h.logStopped {
+			h.Logger.Info().Msg(LoggingStoppedMessage)
+		}
+		h.closeErr = closeAll(h.closers)
+	})
+	return h.closeErr
+}
+
+// closeAll closes all the given outputs, returning all errors.
+func closeAll(closers []handleCloser) error {
+	var errs closeErrors
+	for _, closer := range closers {
+		if err := closer.closeWithHandle(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	default:
+		return errs
+	}
+}
+
+// closingWriter closes the outputs of a writer compiled with WriterConfig.Compile,
+// which may be hidden behind wrappers or spread over the children of a group.
+type closingWriter struct {
+	zerolog.LevelWriter
+	closers   []handleCloser
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (cw *closingWriter) Close() error {
+	cw.closeOnce.Do(func() {
+		cw.closeErr = closeAll(cw.closers)
+	})
+	return cw.closeErr
+}
+
+// closeErrors contains the errors from closing multiple outputs.
+type closeErrors []error
+
+func (ce closeErrors) Error() string {
+	msgs := make([]string, len(ce))
+	for i, err := range ce {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the individual errors, which errors.Is and errors.As check on Go 1.20 and later.
+func (ce closeErrors) Unwrap() []error {
+	return ce
+}
+
+// startTask runs a background task until the handle is closed.
+func (h *Handle) startTask(task func(stop <-chan struct{})) {
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		task(h.stop)
+	}()
+}
+
+func (h *Handle) startSuppressionSummary(interval time.Duration) {
+	prev := h.Stats()
+	h.startTask(func(stop <-chan struct{}) {
+		h.suppressionSummaryLoop(stop, interval, prev)
+	})
+}
+
+func (h *Handle) suppressionSummaryLoop(stop <-chan struct{}, interval time.Duration, prev Stats) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := h.Stats()
+			diff := current.sub(&prev)
+			prev = current
+			if !diff.isZero() {
+				h.logSuppressionSummary(diff, interval)
+			}
+		}
+	}
+}
+
+func (h *Handle) logSuppressionSummary(diff *Stats, interval time.Duration) {
+	writers := zerolog.Arr()
+	for i, ws := range diff.Writers {
+		writers = writers.Dict(zerolog.Dict().
+			Int("index", i+1).
+			Str("type", string(ws.Type)).
+			Uint64("sampled", ws.Sampled).
+			Uint64("filtered", ws.Filtered).
+			Uint64("panics", ws.Panics).
+			Uint64("dropped", ws.Dropped))
+	}
+	h.Logger.Info().
+		Dur("interval", interval).
+		Array("writers", writers).
+		Uint64("deduped_stacks", diff.DedupedStacks).
+		Uint64("coalesced", diff.Coalesced).
+		Msg("Log suppression summary")
+}
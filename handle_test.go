@@ -0,0 +1,225 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+	"go.mau.fi/zeroconfig/logtest"
+)
+
+// lockedBuffer is a bytes.Buffer that can be written to from a background goroutine.
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(p []byte) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buf.Write(p)
+}
+
+func (lb *lockedBuffer) Bytes() []byte {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return append([]byte(nil), lb.buf.Bytes()...)
+}
+
+func TestHandle_Stats(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [
+	    {"type": "stdout", "sampling": {"basic_n": 5}},
+	    {"type": "group", "exclude_fields": {"component": "sync"}, "writers": [{"type": "stderr", "match_fields": {"cat": true}}]}
+	  ],
+	  "dedup_stacks": true
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	for i := 0; i < 10; i++ {
+		handle.Logger.Info().Bool("cat", i%2 == 0).Str("component", "main").RawJSON("stack", []byte(`[]`)).Msg("meow")
+	}
+	handle.Logger.Info().Bool("cat", true).Str("component", "sync").Msg("meow")
+	assert.Equal(t, zeroconfig.Stats{
+		Writers: []zeroconfig.WriterStats{
+			{Type: zeroconfig.WriterTypeStdout, Sampled: 8},
+			{Type: zeroconfig.WriterTypeGroup, Filtered: 6},
+		},
+		DedupedStacks: 9,
+	}, handle.Stats())
+}
+
+func TestConfig_Compile_SuppressionSummary(t *testing.T) {
+	var out lockedBuffer
+	zeroconfig.Stdout = &out
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "stdout", "exclude_fields": {"summary": false}}],
+	  "timestamp": false,
+	  "suppression_summary_interval": "20ms"
+	}`), &cfg))
+	assert.Equal(t, 20*time.Millisecond, cfg.SuppressionSummaryInterval)
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Info().Bool("summary", false).Msg("meow")
+	handle.Logger.Info().Bool("summary", false).Msg("meow")
+	require.Eventually(t, func() bool {
+		return len(out.Bytes()) > 0
+	}, time.Second, 5*time.Millisecond, "Summary should be logged")
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, handle.Close())
+	require.NoError(t, handle.Close(), "Closing twice should be safe")
+	logtest.AssertJSONLines(t, out.Bytes(), logtest.Line{
+		"level":          "info",
+		"interval":       20,
+		"writers":        []any{map[string]any{"index": 1, "type": "stdout", "sampled": 0, "filtered": 2, "panics": 0, "dropped": 0}},
+		"deduped_stacks": 0,
+		"coalesced":      0,
+		"message":        "Log suppression summary",
+	})
+	assert.Equal(t, 1, strings.Count(string(out.Bytes()), "\n"), "Summaries should only be logged when something was suppressed")
+}
+
+func TestConfig_Compile_SuppressionSummaryDropped(t *testing.T) {
+	var out lockedBuffer
+	zeroconfig.Stdout = &out
+	addr := freeTCPAddr(t)
+	handle, err := parseConfig(t, `{
+	  "writers": [
+	    {"type": "stdout", "min_level": "info"},
+	    {"type": "network", "max_level": "debug", "net": {"network": "tcp", "address": "`+addr+`", "lazy_connect": true}}
+	  ],
+	  "min_level": "debug",
+	  "timestamp": false,
+	  "suppression_summary_interval": "20ms"
+	}`).CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	handle.Logger.Debug().Msg("meow")
+	require.Eventually(t, func() bool {
+		return len(out.Bytes()) > 0
+	}, time.Second, 5*time.Millisecond, "Events dropped by outputs should be included in the summary")
+	require.NoError(t, handle.Close())
+	line, _, _ := bytes.Cut(out.Bytes(), []byte{'\n'})
+	assert.Contains(t, string(line), `{"index":2,"type":"network","sampled":0,"filtered":0,"panics":0,"dropped":1}`)
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed int
+	err    error
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed++
+	return cr.err
+}
+
+func TestConfig_CompileWithCloser(t *testing.T) {
+	var recorders []*closeRecorder
+	zeroconfig.RegisterWriter("close-test", func(wc *zeroconfig.WriterConfig) (io.Writer, error) {
+		cr := &closeRecorder{}
+		if wc.Tag != "" {
+			cr.err = errors.New(wc.Tag)
+		}
+		recorders = append(recorders, cr)
+		return cr, nil
+	})
+	stdout := &closeRecorder{}
+	zeroconfig.Stdout = stdout
+	logPath := filepath.Join(t.TempDir(), "test.log")
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [
+	    {"type": "stdout"},
+	    {"type": "file", "filename": "`+logPath+`"},
+	    {"type": "close-test"},
+	    {"type": "group", "writers": [{"type": "close-test", "tag": "meow"}, {"type": "close-test", "tag": "hmm"}]}
+	  ],
+	  "timestamp": false
+	}`), &cfg))
+	log, closer, err := cfg.CompileWithCloser()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+
+	err = closer.Close()
+	assert.EqualError(t, err, "meow\nhmm", "Close should return all errors")
+	assert.Equal(t, err, closer.Close(), "Closing again should return the same error")
+	require.Len(t, recorders, 3)
+	for _, cr := range recorders {
+		assert.Equal(t, 1, cr.closed, "Outputs should be closed exactly once")
+		assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", cr.String())
+	}
+	assert.Equal(t, 0, stdout.closed, "Stdout should never be closed")
+
+	data, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", string(data))
+}
+
+func TestConfig_CompileWithWriters(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "test.log")
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "environment": "prod",
+	  "writers": [
+	    {"type": "stdout"},
+	    {"type": "file", "filename": "`+logPath+`"},
+	    {"type": "stderr", "only_in": ["dev"]},
+	    {"type": "group", "writers": [{"type": "stdout", "format": "pretty"}, {"type": "discard"}]}
+	  ],
+	  "timestamp": false
+	}`), &cfg))
+	log, writers, err := cfg.CompileWithWriters()
+	require.NoError(t, err)
+	require.Len(t, writers, 4, "There should be an entry for every writer")
+	assert.Equal(t, &stdout, writers[0])
+	assert.Nil(t, writers[2], "Inactive writers should be nil")
+	assert.Equal(t, zeroconfig.OutputGroup{&stdout}, writers[3])
+
+	rotator, ok := writers[1].(zeroconfig.Rotator)
+	require.True(t, ok, "File outputs should be rotatable")
+	log.Info().Msg("before")
+	require.NoError(t, rotator.Rotate())
+	log.Info().Msg("after")
+	data, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, `{"level":"info","message":"after"}`+"\n", string(data))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "Rotating should leave a backup file")
+	require.NoError(t, writers[1].(io.Closer).Close())
+}
+
+func TestConfig_CompileWithWriters_Disabled(t *testing.T) {
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"min_level": "disabled", "writers": [{"type": "stdout"}]}`), &cfg))
+	_, writers, err := cfg.CompileWithWriters()
+	require.NoError(t, err)
+	assert.Equal(t, []io.Writer{nil}, writers)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+// HashShardConfig contains the configuration for splitting a file writer into multiple files
+// based on the hash of a field, so that all events with the same value end up in the same file.
+type HashShardConfig struct {
+	// Number of files to split events into.
+	ShardCount int `json:"shard_count" yaml:"shard_count" toml:"shard_count"`
+	// The field whose value is hashed to choose the file.
+	KeyField string `json:"key_field" yaml:"key_field" toml:"key_field"`
+}
+
+// ShardFilename returns the file name used for the given shard, e.g. example.3.log for example.log.
+func ShardFilename(filename string, shard int) string {
+	ext := filepath.Ext(filename)
+	return filename[:len(filename)-len(ext)] + "." + strconv.Itoa(shard) + ext
+}
+
+// ShardIndex returns the shard that events with the given key field value are written to.
+// The value is hashed with 32-bit FNV-1a: strings are hashed without quotes, other values as raw JSON.
+// Events that don't have the key field are hashed as if the value was an empty string.
+func ShardIndex(value json.RawMessage, shardCount int) int {
+	hash := fnv.New32a()
+	var str string
+	if len(value) > 0 && value[0] == '"' && json.Unmarshal(value, &str) == nil {
+		_, _ = hash.Write([]byte(str))
+	} else {
+		_, _ = hash.Write(value)
+	}
+	return int(hash.Sum32() % uint32(shardCount))
+}
+
+// hashShardWriter writes events to one of several rotating files based on the hash of a field.
+type hashShardWriter struct {
+	shards   []rotatingWriter
+	keyField string
+}
+
+func compileHashShard(wc *WriterConfig) (io.Writer, error) {
+	if wc.Format != "" && wc.Format != LogFormatJSON {
+		return nil, fmt.Errorf("hash_shard is only supported with the json format")
+	} else if wc.HashShard.ShardCount < 1 {
+		return nil, fmt.Errorf("hash_shard.shard_count must be positive")
+	} else if wc.HashShard.KeyField == "" {
+		return nil, fmt.Errorf("hash_shard.key_field is required")
+	}
+	hsw := &hashShardWriter{
+		shards:   make([]rotatingWriter, wc.HashShard.ShardCount),
+		keyField: wc.HashShard.KeyField,
+	}
+	for i := range hsw.shards {
+		shardConfig := *wc
+		shardConfig.Filename = ShardFilename(wc.Filename, i)
+		shardConfig.HashShard = nil
+		writer, err := compileFileBackend(&shardConfig.FileConfig)
+		if err != nil {
+			_ = hsw.Close()
+			return nil, fmt.Errorf("failed to open shard #%d: %w", i, err)
+		}
+		hsw.shards[i] = writer
+	}
+	return hsw, nil
+}
+
+func (hsw *hashShardWriter) Write(p []byte) (n int, err error) {
+	var key json.RawMessage
+	obj, err := parseJSONObject(p)
+	if err == nil {
+		key, _ = obj.get(hsw.keyField)
+	}
+	return hsw.shards[ShardIndex(key, len(hsw.shards))].Write(p)
+}
+
+// Rotate rotates all shard files.
+func (hsw *hashShardWriter) Rotate() error {
+	for _, shard := range hsw.shards {
+		if err := shard.Rotate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all shard files.
+func (hsw *hashShardWriter) Close() error {
+	var firstErr error
+	for _, shard := range hsw.shards {
+		if shard == nil {
+			continue
+		} else if err := shard.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_HashShard(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.log")
+	cfg := zeroconfig.Config{
+		Writers: []zeroconfig.WriterConfig{{
+			Type: zeroconfig.WriterTypeFile,
+			FileConfig: zeroconfig.FileConfig{
+				Filename:  filename,
+				HashShard: &zeroconfig.HashShardConfig{ShardCount: 4, KeyField: "tenant_id"},
+			},
+		}},
+	}
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		log.Info().Str("tenant_id", fmt.Sprintf("tenant-%d", i%10)).Int("i", i).Msg("meow")
+	}
+	log.Info().Msg("no tenant")
+
+	seen := make(map[string]int)
+	total := 0
+	for shard := 0; shard < 4; shard++ {
+		file, err := os.Open(zeroconfig.ShardFilename(filename, shard))
+		require.NoError(t, err, "Shard #%d should exist", shard)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var evt map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &evt))
+			tenant, _ := evt["tenant_id"].(string)
+			if prev, ok := seen[tenant]; ok {
+				assert.Equal(t, prev, shard, "All events for %q should be in the same shard", tenant)
+			}
+			seen[tenant] = shard
+			expected := zeroconfig.ShardIndex(json.RawMessage(fmt.Sprintf("%q", tenant)), 4)
+			assert.Equal(t, expected, shard, "Event for %q should be in the shard given by ShardIndex", tenant)
+			total++
+		}
+		_ = file.Close()
+	}
+	assert.Equal(t, 51, total)
+	assert.Len(t, seen, 11)
+	assert.Equal(t, "test.2.log", filepath.Base(zeroconfig.ShardFilename(filename, 2)))
+
+	writer, err := cfg.Writers[0].Compile()
+	require.NoError(t, err)
+	assert.NoError(t, writer.(io.Closer).Close())
+}
+
+func TestWriterConfig_Compile_HashShardInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.log")
+	for _, wc := range []zeroconfig.WriterConfig{
+		{Format: zeroconfig.LogFormatPretty, FileConfig: zeroconfig.FileConfig{HashShard: &zeroconfig.HashShardConfig{ShardCount: 2, KeyField: "a"}}},
+		{FileConfig: zeroconfig.FileConfig{HashShard: &zeroconfig.HashShardConfig{ShardCount: 0, KeyField: "a"}}},
+		{FileConfig: zeroconfig.FileConfig{HashShard: &zeroconfig.HashShardConfig{ShardCount: 2}}},
+	} {
+		wc.Type = zeroconfig.WriterTypeFile
+		wc.Filename = filename
+		_, err := wc.Compile()
+		assert.Error(t, err)
+	}
+}
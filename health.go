@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"sync"
+)
+
+// healthChecker is implemented by outputs that can tell whether they're currently able to deliver events.
+type healthChecker interface {
+	healthCheck() error
+}
+
+// deliveryHealth tracks the result of the most recent delivery attempt of an output.
+type deliveryHealth struct {
+	lock sync.Mutex
+	err  error
+}
+
+func (dh *deliveryHealth) setHealth(err error) {
+	dh.lock.Lock()
+	dh.err = err
+	dh.lock.Unlock()
+}
+
+func (dh *deliveryHealth) healthCheck() error {
+	dh.lock.Lock()
+	defer dh.lock.Unlock()
+	return dh.err
+}
+
+// HealthCheck reports whether each writer is currently able to deliver events. The keys are the same
+// as in compile errors (e.g. "writer #2 (websocket)") and the values are nil for healthy writers.
+//
+// Network writers report the result of their most recent delivery attempt, so they're healthy until
+// something fails. Writers without a remote end, like stdout and file, always report healthy.
+// Groups are unhealthy if any of their children are.
+func (h *Handle) HealthCheck() map[string]error {
+	result := make(map[string]error, len(h.writers))
+	for _, writer := range h.writers {
+		var err error
+		for _, checker := range h.healthCheckers[writer.index] {
+			if err = checker.healthCheck(); err != nil {
+				break
+			}
+		}
+		result[fmt.Sprintf("writer #%d (%s)", writer.index, writer.config.Type)] = err
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// flakyKVBackend fails all writes while failing is set.
+type flakyKVBackend struct {
+	fakeKVBackend
+	failing bool
+}
+
+func (fkb *flakyKVBackend) Put(key string, value []byte, ttl time.Duration) error {
+	if fkb.failing {
+		return errors.New("connection refused")
+	}
+	return fkb.fakeKVBackend.Put(key, value, ttl)
+}
+
+func TestHandle_HealthCheck(t *testing.T) {
+	zeroconfig.Stdout = io.Discard
+	backend := &flakyKVBackend{fakeKVBackend: fakeKVBackend{values: make(map[string]string), ttls: make(map[string]time.Duration)}}
+	zeroconfig.RegisterKVBackend("flaky", func(cfg zeroconfig.KVConfig) (zeroconfig.KVBackend, error) {
+		return backend, nil
+	})
+	handle, err := (&zeroconfig.Config{Writers: []zeroconfig.WriterConfig{
+		{Type: zeroconfig.WriterTypeStdout},
+		{Type: zeroconfig.WriterTypeGroup, Writers: []zeroconfig.WriterConfig{
+			{Type: zeroconfig.WriterTypeStdout},
+			{Type: zeroconfig.WriterTypeKV, KV: &zeroconfig.KVConfig{Backend: "flaky", Prefix: "logs/", RateLimit: 100}},
+		}},
+	}}).CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+
+	healthy := map[string]error{"writer #1 (stdout)": nil, "writer #2 (group)": nil}
+	assert.Equal(t, healthy, handle.HealthCheck())
+
+	backend.failing = true
+	handle.Logger.Info().Msg("meow")
+	health := handle.HealthCheck()
+	assert.NoError(t, health["writer #1 (stdout)"])
+	assert.EqualError(t, health["writer #2 (group)"], "connection refused")
+
+	backend.failing = false
+	handle.Logger.Info().Msg("meow")
+	assert.Equal(t, healthy, handle.HealthCheck())
+}
+
+func TestHandle_HealthCheck_Tail(t *testing.T) {
+	handle, _ := compileTail(t, 0)
+	assert.Equal(t, map[string]error{"writer #1 (tail)": nil}, handle.HealthCheck())
+	require.NoError(t, handle.Close())
+	assert.EqualError(t, handle.HealthCheck()["writer #1 (tail)"], "tail listener is closed")
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultHeartbeatMessage is the message of heartbeat events if HeartbeatConfig.Message is empty.
+const DefaultHeartbeatMessage = "heartbeat"
+
+// HeartbeatConfig contains the configuration for periodic heartbeat events,
+// which let log pipelines tell an idle application apart from broken logging.
+type HeartbeatConfig struct {
+	// How often to log the heartbeat. The first heartbeat is logged immediately.
+	Interval time.Duration `json:"interval" yaml:"interval" toml:"interval"`
+	// The level of heartbeat events. Defaults to info.
+	Level *zerolog.Level `json:"level,omitempty" yaml:"level,omitempty" toml:"level,omitempty"`
+	// The message of heartbeat events. Defaults to DefaultHeartbeatMessage.
+	Message string `json:"message,omitempty" yaml:"message,omitempty" toml:"message,omitempty"`
+	// Extra fields to add to heartbeat events.
+	Fields map[string]any `json:"fields,omitempty" yaml:"fields,omitempty" toml:"fields,omitempty"`
+}
+
+func (hc *HeartbeatConfig) UnmarshalJSON(data []byte) error {
+	type plainHeartbeatConfig HeartbeatConfig
+	aux := struct {
+		*plainHeartbeatConfig
+		Interval json.RawMessage `json:"interval,omitempty"`
+		Level    json.RawMessage `json:"level,omitempty"`
+	}{plainHeartbeatConfig: (*plainHeartbeatConfig)(hc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.Interval, &hc.Interval); err != nil {
+		return &PathError{Path: "interval", Err: err}
+	} else if err = parseLevelJSON(aux.Level, &hc.Level); err != nil {
+		return &PathError{Path: "level", Err: err}
+	}
+	return nil
+}
+
+func (hc *HeartbeatConfig) validate() error {
+	if hc.Interval <= 0 {
+		return fmt.Errorf("heartbeat interval must be positive")
+	}
+	return nil
+}
+
+func (h *Handle) startHeartbeat(cfg *HeartbeatConfig) {
+	level := zerolog.InfoLevel
+	if cfg.Level != nil {
+		level = *cfg.Level
+	}
+	msg := cfg.Message
+	if msg == "" {
+		msg = DefaultHeartbeatMessage
+	}
+	beat := func() {
+		h.Logger.WithLevel(level).Fields(cfg.Fields).Msg(msg)
+	}
+	h.startTask(func(stop <-chan struct{}) {
+		beat()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				beat()
+			}
+		}
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_Heartbeat(t *testing.T) {
+	var out lockedBuffer
+	zeroconfig.Stdout = &out
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "heartbeat": {"interval": "20ms", "level": "debug", "message": "still alive", "fields": {"service": "meow"}}
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+
+	expectedLine := []byte(`{"level":"debug","service":"meow","message":"still alive"}` + "\n")
+	// The first heartbeat is logged immediately.
+	require.Eventually(t, func() bool {
+		return bytes.Count(out.Bytes(), expectedLine) >= 1
+	}, 15*time.Millisecond, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return bytes.Count(out.Bytes(), expectedLine) >= 3
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, handle.Close())
+	beats := bytes.Count(out.Bytes(), expectedLine)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, beats, bytes.Count(out.Bytes(), expectedLine), "No heartbeats should be logged after closing")
+	assert.Equal(t, len(out.Bytes()), beats*len(expectedLine))
+}
+
+func TestConfig_Compile_HeartbeatInvalid(t *testing.T) {
+	_, err := (&zeroconfig.Config{Heartbeat: &zeroconfig.HeartbeatConfig{}}).Compile()
+	assert.EqualError(t, err, "heartbeat: heartbeat interval must be positive")
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+var hookRegistry = map[string]zerolog.Hook{}
+
+// RegisterHook adds a zerolog hook that configs can enable by name in Config.Hooks.
+func RegisterHook(name string, hook zerolog.Hook) {
+	hookRegistry[name] = hook
+}
+
+// WithHooks adds hooks to Config.ExtraHooks, e.g. hooks that need state from the caller and can't be registered globally.
+func WithHooks(hooks ...zerolog.Hook) CompileOption {
+	return func(c *Config) {
+		c.ExtraHooks = append(append([]zerolog.Hook(nil), c.ExtraHooks...), hooks...)
+	}
+}
+
+func lookupHook(name string) (zerolog.Hook, error) {
+	if hook, ok := hookRegistry[name]; ok {
+		return hook, nil
+	} else if len(hookRegistry) == 0 {
+		return nil, fmt.Errorf("unknown hook %q (no hooks have been registered)", name)
+	}
+	return nil, unknownValueError("hook", name, sortedKeys(hookRegistry))
+}
+
+// compileHooks returns the hooks from Hooks followed by ExtraHooks.
+func (c *Config) compileHooks() ([]zerolog.Hook, error) {
+	hooks := make([]zerolog.Hook, 0, len(c.Hooks)+len(c.ExtraHooks))
+	for i, name := range c.Hooks {
+		hook, err := lookupHook(name)
+		if err != nil {
+			return nil, &PathError{Path: fmt.Sprintf("hooks[%d]", i), Err: err}
+		}
+		hooks = append(hooks, hook)
+	}
+	return append(hooks, c.ExtraHooks...), nil
+}
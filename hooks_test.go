@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type countingHook map[zerolog.Level]int
+
+func (ch countingHook) Run(_ *zerolog.Event, level zerolog.Level, _ string) {
+	ch[level]++
+}
+
+type fieldHook struct {
+	key, value string
+}
+
+func (fh fieldHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	e.Str(fh.key, fh.value)
+}
+
+func TestConfig_Compile_Hooks(t *testing.T) {
+	counts := countingHook{}
+	zeroconfig.RegisterHook("counter", counts)
+	zeroconfig.RegisterHook("trace", fieldHook{"trace_id", "abc"})
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "hooks": ["counter", "trace"],
+	  "min_level": "info",
+	  "timestamp": false
+	}`, zeroconfig.WithHooks(fieldHook{"extra", "meow"}))
+	log.Debug().Msg("hidden")
+	log.Info().Msg("one")
+	log.Info().Msg("two")
+	log.Warn().Msg("three")
+	log.Error().Msg("four")
+	assert.Equal(t, countingHook{zerolog.InfoLevel: 2, zerolog.WarnLevel: 1, zerolog.ErrorLevel: 1}, counts)
+	assert.Contains(t, out.String(), `{"level":"info","trace_id":"abc","extra":"meow","message":"one"}`, "Hooks should run in order")
+}
+
+func TestConfig_Compile_UnknownHook(t *testing.T) {
+	zeroconfig.RegisterHook("counter", countingHook{})
+	zeroconfig.RegisterHook("trace", fieldHook{"trace_id", "abc"})
+	cfg := parseConfig(t, `{"writers": [{"type": "stdout"}], "hooks": ["counter", "countr", "nonexistent"]}`)
+	_, err := cfg.Compile()
+	assert.EqualError(t, err, "hooks[1]: unknown hook \"countr\" (did you mean \"counter\"?); "+
+		"hooks[2]: unknown hook \"nonexistent\" (expected one of counter, trace)")
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// htmlSafeWriter escapes <, >, & as well as U+2028 and U+2029 in JSON events like encoding/json does by default.
+// Those characters can only appear inside strings in JSON, so the whole event can be escaped at once.
+type htmlSafeWriter struct {
+	zerolog.LevelWriter
+}
+
+func newHTMLSafeWriter(writer io.Writer) *htmlSafeWriter {
+	lw := asLevelWriter(writer)
+	return &htmlSafeWriter{LevelWriter: lw}
+}
+
+func (hsw *htmlSafeWriter) Write(p []byte) (n int, err error) {
+	return hsw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (hsw *htmlSafeWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	var buf bytes.Buffer
+	buf.Grow(len(p))
+	json.HTMLEscape(&buf, p)
+	_, err = hsw.LevelWriter.WriteLevel(level, buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
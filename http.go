@@ -0,0 +1,301 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPConfig contains the configuration for the http writer type.
+type HTTPConfig struct {
+	// The http:// or https:// URL to send events to.
+	URL string `json:"url" yaml:"url" toml:"url"`
+	// The HTTP method to use. Defaults to POST.
+	Method string `json:"method,omitempty" yaml:"method,omitempty" toml:"method,omitempty"`
+	// Additional headers to send with each request. The Content-Type defaults to application/x-ndjson.
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty" toml:"headers,omitempty"`
+	// Headers whose values are read from files or environment variables, like an Authorization header that
+	// shouldn't be committed with the config. Maps header names to file paths or variable names.
+	// If a header is set in several maps, Headers is used first, then HeadersFile, then HeadersEnv.
+	HeadersFile map[string]string `json:"headers_file,omitempty" yaml:"headers_file,omitempty" toml:"headers_file,omitempty"`
+	HeadersEnv  map[string]string `json:"headers_env,omitempty" yaml:"headers_env,omitempty" toml:"headers_env,omitempty"`
+	// Maximum number of events to send in a single request. Defaults to 100.
+	BatchSize int `json:"batch_size,omitempty" yaml:"batch_size,omitempty" toml:"batch_size,omitempty"`
+	// How often to send events when the batch isn't full. Defaults to 1s.
+	FlushInterval time.Duration `json:"flush_interval,omitempty" yaml:"flush_interval,omitempty" toml:"flush_interval,omitempty"`
+	// How many times to retry failed requests before dropping the batch. Defaults to 3, -1 disables retries.
+	MaxRetries int `json:"max_retries,omitempty" yaml:"max_retries,omitempty" toml:"max_retries,omitempty"`
+	// How long to wait before the first retry. The wait is doubled after each attempt. Defaults to 500ms.
+	RetryBackoff time.Duration `json:"retry_backoff,omitempty" yaml:"retry_backoff,omitempty" toml:"retry_backoff,omitempty"`
+	// Timeout for a single request. Defaults to 10s. It also limits how long closing waits for the remaining events.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+}
+
+const (
+	defaultHTTPBatchSize     = 100
+	defaultHTTPFlushInterval = 1 * time.Second
+	defaultHTTPMaxRetries    = 3
+	defaultHTTPRetryBackoff  = 500 * time.Millisecond
+	defaultHTTPTimeout       = 10 * time.Second
+	// Number of full batches that can wait for the sender before new batches are dropped.
+	httpQueuedBatches = 4
+)
+
+func (hc *HTTPConfig) UnmarshalJSON(data []byte) error {
+	type plainHTTPConfig HTTPConfig
+	aux := struct {
+		*plainHTTPConfig
+		FlushInterval json.RawMessage `json:"flush_interval,omitempty"`
+		RetryBackoff  json.RawMessage `json:"retry_backoff,omitempty"`
+		Timeout       json.RawMessage `json:"timeout,omitempty"`
+	}{plainHTTPConfig: (*plainHTTPConfig)(hc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.FlushInterval, &hc.FlushInterval); err != nil {
+		return &PathError{Path: "flush_interval", Err: err}
+	} else if err = parseDurationJSON(aux.RetryBackoff, &hc.RetryBackoff); err != nil {
+		return &PathError{Path: "retry_backoff", Err: err}
+	} else if err = parseDurationJSON(aux.Timeout, &hc.Timeout); err != nil {
+		return &PathError{Path: "timeout", Err: err}
+	}
+	return nil
+}
+
+type httpBatch struct {
+	data   []byte
+	events int
+}
+
+// httpWriter sends events to an HTTP endpoint in batches.
+//
+// Writes only append to the current batch. Batches are sent in the background when they fill up or when
+// the flush interval passes, and failed requests are retried with a backoff before the batch is dropped.
+type httpWriter struct {
+	client        *http.Client
+	url           string
+	method        string
+	header        http.Header
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+
+	lock    sync.Mutex
+	batch   httpBatch
+	closed  bool
+	queue   chan httpBatch
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Uint64
+	// Requests are made with this context, which is canceled if closing takes longer than the timeout.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+	deliveryHealth
+}
+
+func compileHTTP(wc *WriterConfig) (io.Writer, error) {
+	cfg := wc.HTTP
+	if cfg == nil || cfg.URL == "" {
+		return nil, fmt.Errorf("http.url is required for the http writer")
+	}
+	parsed, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http.url: %w", err)
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("http.url must use the http or https scheme")
+	} else if cfg.BatchSize < 0 || cfg.FlushInterval < 0 || cfg.RetryBackoff < 0 || cfg.Timeout < 0 {
+		return nil, fmt.Errorf("http.batch_size, http.flush_interval, http.retry_backoff and http.timeout must not be negative")
+	}
+	headers, err := resolveSecretMap("http.headers", cfg.Headers, cfg.HeadersFile, cfg.HeadersEnv)
+	if err != nil {
+		return nil, err
+	}
+	hw := &httpWriter{
+		url:           cfg.URL,
+		method:        strings.ToUpper(cfg.Method),
+		header:        make(http.Header, len(headers)+1),
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		maxRetries:    cfg.MaxRetries,
+		retryBackoff:  cfg.RetryBackoff,
+		queue:         make(chan httpBatch, httpQueuedBatches),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+	hw.client = &http.Client{Timeout: timeout}
+	hw.ctx, hw.cancel = context.WithCancel(context.Background())
+	if hw.method == "" {
+		hw.method = http.MethodPost
+	}
+	hw.header.Set("Content-Type", "application/x-ndjson")
+	for key, value := range headers {
+		hw.header.Set(key, value)
+	}
+	if hw.batchSize == 0 {
+		hw.batchSize = defaultHTTPBatchSize
+	}
+	if hw.flushInterval == 0 {
+		hw.flushInterval = defaultHTTPFlushInterval
+	}
+	if hw.maxRetries == 0 {
+		hw.maxRetries = defaultHTTPMaxRetries
+	} else if hw.maxRetries < 0 {
+		hw.maxRetries = 0
+	}
+	if hw.retryBackoff == 0 {
+		hw.retryBackoff = defaultHTTPRetryBackoff
+	}
+	go hw.loop()
+	return hw, nil
+}
+
+func (hw *httpWriter) Write(p []byte) (n int, err error) {
+	hw.lock.Lock()
+	defer hw.lock.Unlock()
+	if hw.closed {
+		return 0, errors.New("http writer is closed")
+	}
+	hw.batch.data = append(hw.batch.data, p...)
+	hw.batch.events++
+	if hw.batch.events >= hw.batchSize {
+		select {
+		case hw.queue <- hw.batch:
+		default:
+			// The sender is busy retrying earlier batches, most likely because the endpoint is down.
+			hw.dropped.Add(uint64(hw.batch.events))
+			hw.setHealth(fmt.Errorf("send queue is full, dropping events"))
+		}
+		hw.batch = httpBatch{}
+	}
+	return len(p), nil
+}
+
+// takeBatch returns the current incomplete batch and starts a new one.
+func (hw *httpWriter) takeBatch() httpBatch {
+	hw.lock.Lock()
+	defer hw.lock.Unlock()
+	batch := hw.batch
+	hw.batch = httpBatch{}
+	return batch
+}
+
+func (hw *httpWriter) loop() {
+	defer close(hw.done)
+	ticker := time.NewTicker(hw.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case batch := <-hw.queue:
+			hw.send(batch)
+		case <-ticker.C:
+			hw.flush()
+		case <-hw.stop:
+			// Writes are rejected after closing, so nothing is added to the queue anymore.
+			hw.flush()
+			return
+		}
+	}
+}
+
+// flush sends the full batches waiting in the queue and then the current incomplete batch.
+func (hw *httpWriter) flush() {
+	for {
+		select {
+		case batch := <-hw.queue:
+			hw.send(batch)
+		default:
+			hw.send(hw.takeBatch())
+			return
+		}
+	}
+}
+
+// send sends a batch, retrying with a backoff if the request fails.
+// After the writer is closed, retries don't wait for the backoff.
+func (hw *httpWriter) send(batch httpBatch) {
+	if batch.events == 0 {
+		return
+	}
+	backoff := hw.retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := hw.post(batch.data)
+		if err == nil {
+			hw.setHealth(nil)
+			return
+		}
+		hw.setHealth(err)
+		if attempt >= hw.maxRetries || hw.ctx.Err() != nil {
+			hw.dropped.Add(uint64(batch.events))
+			return
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-hw.stop:
+			timer.Stop()
+		}
+		backoff *= 2
+	}
+}
+
+func (hw *httpWriter) post(data []byte) error {
+	req, err := http.NewRequestWithContext(hw.ctx, hw.method, hw.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header = hw.header.Clone()
+	resp, err := hw.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send events: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send events: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (hw *httpWriter) droppedEvents() uint64 {
+	return hw.dropped.Load()
+}
+
+// Close sends the remaining events and stops the writer. It blocks until the final batches have been sent
+// or dropped, which takes at most the request timeout: failed requests are retried without waiting for
+// the backoff, and requests that are still running when the timeout passes are canceled.
+// Writes after closing return an error.
+func (hw *httpWriter) Close() error {
+	hw.closeOnce.Do(func() {
+		hw.lock.Lock()
+		hw.closed = true
+		hw.lock.Unlock()
+		deadline := time.AfterFunc(hw.client.Timeout, hw.cancel)
+		close(hw.stop)
+		<-hw.done
+		deadline.Stop()
+		hw.cancel()
+	})
+	<-hw.done
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// requestCollector is an HTTP server that records request bodies and responds with the given status codes in order,
+// then with 200 once they run out.
+type requestCollector struct {
+	*httptest.Server
+	lock     sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	statuses []int
+	requests atomic.Int32
+}
+
+func newRequestCollector(statuses ...int) *requestCollector {
+	rc := &requestCollector{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.requests.Add(1)
+		rc.lock.Lock()
+		status := http.StatusOK
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		if status == http.StatusOK {
+			rc.bodies = append(rc.bodies, string(body))
+			rc.headers = append(rc.headers, r.Header)
+		}
+		rc.lock.Unlock()
+		w.WriteHeader(status)
+	}))
+	return rc
+}
+
+func (rc *requestCollector) received() []string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return append([]string(nil), rc.bodies...)
+}
+
+func TestWriterConfig_Compile_HTTPBatches(t *testing.T) {
+	collector := newRequestCollector()
+	defer collector.Close()
+
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "http", "http": {"url": "`+collector.URL+`/ingest", "headers": {"X-Scope": "meow"}, "batch_size": 2, "flush_interval": "1h"}}],
+	  "timestamp": false
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	log := handle.Logger
+
+	log.Info().Msg("one")
+	log.Info().Msg("two")
+	log.Info().Msg("three")
+	require.Eventually(t, func() bool {
+		return len(collector.received()) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, `{"level":"info","message":"one"}`+"\n"+`{"level":"info","message":"two"}`+"\n", collector.received()[0])
+
+	require.NoError(t, handle.Close())
+	bodies := collector.received()
+	require.Len(t, bodies, 2, "Closing should send the final batch")
+	assert.Equal(t, `{"level":"info","message":"three"}`+"\n", bodies[1])
+	assert.Equal(t, "meow", collector.headers[0].Get("X-Scope"))
+	assert.Equal(t, "application/x-ndjson", collector.headers[0].Get("Content-Type"))
+}
+
+func TestWriterConfig_Compile_HTTPFlushInterval(t *testing.T) {
+	collector := newRequestCollector()
+	defer collector.Close()
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeHTTP,
+		HTTP: &zeroconfig.HTTPConfig{URL: collector.URL, FlushInterval: 10 * time.Millisecond},
+	}).Compile()
+	require.NoError(t, err)
+	defer writer.(io.Closer).Close()
+	_, err = writer.Write([]byte(`{"message":"meow"}` + "\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(collector.received()) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestWriterConfig_Compile_HTTPRetry(t *testing.T) {
+	collector := newRequestCollector(http.StatusInternalServerError, http.StatusBadGateway)
+	defer collector.Close()
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeHTTP,
+		HTTP: &zeroconfig.HTTPConfig{URL: collector.URL, MaxRetries: 2, RetryBackoff: time.Millisecond},
+	}).Compile()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(`{"message":"meow"}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.(io.Closer).Close())
+	assert.Equal(t, []string{`{"message":"meow"}` + "\n"}, collector.received())
+	assert.EqualValues(t, 3, collector.requests.Load())
+
+	_, err = writer.Write([]byte(`{"message":"late"}` + "\n"))
+	assert.Error(t, err, "Writes after closing should fail")
+}
+
+func TestWriterConfig_Compile_HTTPDrop(t *testing.T) {
+	collector := newRequestCollector(http.StatusInternalServerError, http.StatusInternalServerError)
+	defer collector.Close()
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "http", "http": {"url": "`+collector.URL+`", "batch_size": 2, "max_retries": 1, "retry_backoff": "1ms"}}]
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Info().Msg("one")
+	handle.Logger.Info().Msg("two")
+	require.Eventually(t, func() bool {
+		return handle.Stats().Writers[0].Dropped == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.EqualError(t, handle.HealthCheck()["writer #1 (http)"], "failed to send events: unexpected status 500 Internal Server Error")
+	require.NoError(t, handle.Close())
+	assert.Empty(t, collector.received())
+}
+
+func TestWriterConfig_Compile_HTTPCloseTimeout(t *testing.T) {
+	var requests atomic.Int32
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Simulate an endpoint that's down by not responding until the test ends.
+		requests.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-unblock:
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "http", "http": {"url": "`+server.URL+`", "batch_size": 1, "max_retries": 3, "retry_backoff": "10s", "timeout": "200ms"}}]
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Info().Msg("one")
+	handle.Logger.Info().Msg("two")
+	handle.Logger.Info().Msg("three")
+	require.Eventually(t, func() bool {
+		return requests.Load() > 0
+	}, 2*time.Second, 5*time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, handle.Close())
+	assert.Less(t, time.Since(start), time.Second, "Close shouldn't wait for retry backoffs")
+	assert.EqualValues(t, 3, handle.Stats().Writers[0].Dropped)
+}
+
+func TestWriterConfig_Compile_HTTPInvalid(t *testing.T) {
+	tests := map[string]struct {
+		http *zeroconfig.HTTPConfig
+		err  string
+	}{
+		"Missing":  {nil, "http.url is required for the http writer"},
+		"Scheme":   {&zeroconfig.HTTPConfig{URL: "ftp://example.com"}, "http.url must use the http or https scheme"},
+		"Negative": {&zeroconfig.HTTPConfig{URL: "http://example.com", BatchSize: -1}, "http.batch_size, http.flush_interval, http.retry_backoff and http.timeout must not be negative"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeHTTP, HTTP: test.http}).Compile()
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
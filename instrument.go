@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// WriteStats contains the counters recorded for writers with instrumentation enabled.
+type WriteStats struct {
+	// Number of writes to the output.
+	Count uint64 `json:"count"`
+	// Number of bytes passed to the output.
+	Bytes uint64 `json:"bytes"`
+	// Number of writes that returned an error.
+	Errors uint64 `json:"errors"`
+	// The total and maximum time spent writing to the output.
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+}
+
+// writeInstrumentation records the counters of a single instrumented writer.
+type writeInstrumentation struct {
+	count        atomic.Uint64
+	bytes        atomic.Uint64
+	errors       atomic.Uint64
+	totalLatency atomic.Int64
+	maxLatency   atomic.Int64
+}
+
+func (wi *writeInstrumentation) stats() *WriteStats {
+	if wi == nil {
+		return nil
+	}
+	return &WriteStats{
+		Count:        wi.count.Load(),
+		Bytes:        wi.bytes.Load(),
+		Errors:       wi.errors.Load(),
+		TotalLatency: time.Duration(wi.totalLatency.Load()),
+		MaxLatency:   time.Duration(wi.maxLatency.Load()),
+	}
+}
+
+func (wi *writeInstrumentation) record(size int, err error, latency time.Duration) {
+	wi.count.Add(1)
+	wi.bytes.Add(uint64(size))
+	if err != nil {
+		wi.errors.Add(1)
+	}
+	wi.totalLatency.Add(int64(latency))
+	for {
+		prevMax := wi.maxLatency.Load()
+		if int64(latency) <= prevMax || wi.maxLatency.CompareAndSwap(prevMax, int64(latency)) {
+			return
+		}
+	}
+}
+
+// publishExpvar publishes the counters as an expvar map with the given name.
+// If a map with the same name was published before (e.g. by an earlier compile of the same config),
+// its values are replaced with the new counters.
+func (wi *writeInstrumentation) publishExpvar(name string) error {
+	var m *expvar.Map
+	if existing := expvar.Get(name); existing == nil {
+		m = expvar.NewMap(name)
+	} else if existingMap, ok := existing.(*expvar.Map); ok {
+		m = existingMap
+	} else {
+		return fmt.Errorf("expvar %q is already used by something else", name)
+	}
+	m.Set("count", expvar.Func(func() any { return wi.count.Load() }))
+	m.Set("bytes", expvar.Func(func() any { return wi.bytes.Load() }))
+	m.Set("errors", expvar.Func(func() any { return wi.errors.Load() }))
+	m.Set("total_latency_ns", expvar.Func(func() any { return wi.totalLatency.Load() }))
+	m.Set("max_latency_ns", expvar.Func(func() any { return wi.maxLatency.Load() }))
+	return nil
+}
+
+// instrumentedWriter records the count, size, latency and errors of writes to the underlying output.
+type instrumentedWriter struct {
+	zerolog.LevelWriter
+	counters *writeInstrumentation
+}
+
+func (iw *instrumentedWriter) Write(p []byte) (n int, err error) {
+	return iw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (iw *instrumentedWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = iw.LevelWriter.WriteLevel(level, p)
+	iw.counters.record(len(p), err, time.Since(start))
+	return
+}
+
+// wrapInstrumentation wraps the main output of a writer if instrumentation is enabled.
+func (wc *WriterConfig) wrapInstrumentation(output io.Writer, counters *writerCounters) (io.Writer, error) {
+	if !wc.Instrument {
+		return output, nil
+	}
+	var instrumentation *writeInstrumentation
+	if counters != nil {
+		if counters.writes == nil {
+			counters.writes = &writeInstrumentation{}
+		}
+		instrumentation = counters.writes
+	} else {
+		instrumentation = &writeInstrumentation{}
+	}
+	if wc.InstrumentExpvar != "" {
+		if err := instrumentation.publishExpvar(wc.InstrumentExpvar); err != nil {
+			return nil, err
+		}
+	}
+	return &instrumentedWriter{LevelWriter: asLevelWriter(output), counters: instrumentation}, nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// delayWriter sleeps before every write and fails every other write.
+type delayWriter struct {
+	delay time.Duration
+	calls int
+	bytes int
+}
+
+func (dw *delayWriter) Write(p []byte) (int, error) {
+	time.Sleep(dw.delay)
+	dw.calls++
+	dw.bytes += len(p)
+	if dw.calls%2 == 0 {
+		return 0, errors.New("meow")
+	}
+	return len(p), nil
+}
+
+func TestConfig_Compile_Instrument(t *testing.T) {
+	zeroconfig.Stdout = io.Discard
+	dw := &delayWriter{delay: 5 * time.Millisecond}
+	zeroconfig.RegisterWriter("delay-test", func(_ *zeroconfig.WriterConfig) (io.Writer, error) {
+		return dw, nil
+	})
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [
+	  {"type": "delay-test", "instrument": true, "instrument_expvar": "zeroconfig_test_delay"},
+	  {"type": "stdout"}
+	]}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	for i := 0; i < 4; i++ {
+		handle.Logger.Info().Msg("meow")
+	}
+	stats := handle.Stats()
+	assert.Nil(t, stats.Writers[1].Writes)
+	writes := stats.Writers[0].Writes
+	require.NotNil(t, writes)
+	assert.Equal(t, uint64(4), writes.Count)
+	assert.Equal(t, uint64(dw.bytes), writes.Bytes)
+	assert.Equal(t, uint64(2), writes.Errors)
+	assert.GreaterOrEqual(t, writes.TotalLatency, 20*time.Millisecond)
+	assert.GreaterOrEqual(t, writes.MaxLatency, 5*time.Millisecond)
+	assert.LessOrEqual(t, writes.MaxLatency, writes.TotalLatency)
+
+	var published map[string]int64
+	require.NoError(t, json.Unmarshal([]byte(expvar.Get("zeroconfig_test_delay").String()), &published))
+	assert.Equal(t, int64(4), published["count"])
+	assert.Equal(t, int64(2), published["errors"])
+	assert.Equal(t, int64(writes.MaxLatency), published["max_latency_ns"])
+}
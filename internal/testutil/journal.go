@@ -0,0 +1,48 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package testutil contains helpers for zeroconfig's own tests.
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// DecodeJournalEntry decodes a datagram in the journald native protocol into a map of fields.
+//
+// Each field is either a line of the form KEY=value, or for values that contain newlines,
+// the key on its own line followed by the length as a little-endian uint64, the value and a newline.
+// See https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+func DecodeJournalEntry(data []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		lineEnd := bytes.IndexByte(data, '\n')
+		if lineEnd < 0 {
+			return nil, fmt.Errorf("missing newline after field")
+		}
+		line := data[:lineEnd]
+		data = data[lineEnd+1:]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			continue
+		}
+		if len(data) < 8 {
+			return nil, fmt.Errorf("missing length of binary field %s", line)
+		}
+		length := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		if uint64(len(data)) < length+1 {
+			return nil, fmt.Errorf("binary field %s is truncated", line)
+		} else if data[length] != '\n' {
+			return nil, fmt.Errorf("missing newline after binary field %s", line)
+		}
+		fields[string(line)] = string(data[:length])
+		data = data[length+1:]
+	}
+	return fields, nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package testutil_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig/internal/testutil"
+)
+
+func TestDecodeJournalEntry(t *testing.T) {
+	fields, err := testutil.DecodeJournalEntry([]byte("PRIORITY=6\nMESSAGE=a=b\nSTACK\n\x05\x00\x00\x00\x00\x00\x00\x00a\nb\nc\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"PRIORITY": "6", "MESSAGE": "a=b", "STACK": "a\nb\nc"}, fields)
+
+	for _, invalid := range []string{"MESSAGE=meow", "STACK\n\x05\x00", "STACK\n\x05\x00\x00\x00\x00\x00\x00\x00a\nb", "STACK\n\x01\x00\x00\x00\x00\x00\x00\x00ab"} {
+		_, err = testutil.DecodeJournalEntry([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
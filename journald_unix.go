@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build unix
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// journaldSocketWriter sends events to a journald socket at a custom path using the native protocol.
+// Events are converted to journal fields the same way as in zerolog's journald writer.
+type journaldSocketWriter struct {
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+func newJournaldSocketWriter(path string) (*journaldSocketWriter, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSocketWriter{conn: conn, addr: &net.UnixAddr{Name: path, Net: "unixgram"}}, nil
+}
+
+func journaldPriority(level string) int {
+	lvl, _ := zerolog.ParseLevel(level)
+	switch lvl {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.InfoLevel:
+		return 6
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 0
+	default:
+		return 5
+	}
+}
+
+func appendJournalField(buf *bytes.Buffer, name, value string) {
+	if strings.ContainsRune(value, '\n') {
+		buf.WriteString(name)
+		buf.WriteByte('\n')
+		_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	} else {
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+}
+
+func (jsw *journaldSocketWriter) Write(p []byte) (n int, err error) {
+	var event map[string]any
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err = dec.Decode(&event); err != nil {
+		return 0, err
+	}
+	priority := 5
+	if level, ok := event[zerolog.LevelFieldName].(string); ok {
+		priority = journaldPriority(level)
+	}
+	var msg string
+	var buf bytes.Buffer
+	for _, key := range sortedKeys(event) {
+		switch key {
+		case zerolog.LevelFieldName, zerolog.TimestampFieldName:
+			continue
+		case zerolog.MessageFieldName:
+			msg, _ = event[key].(string)
+			continue
+		}
+		var value string
+		switch typedValue := event[key].(type) {
+		case string:
+			value = typedValue
+		case json.Number:
+			value = typedValue.String()
+		default:
+			data, err := zerolog.InterfaceMarshalFunc(typedValue)
+			if err != nil {
+				value = fmt.Sprintf("[error: %v]", err)
+			} else {
+				value = string(data)
+			}
+		}
+		appendJournalField(&buf, strings.ToUpper(key), value)
+	}
+	appendJournalField(&buf, "JSON", string(p))
+	var header bytes.Buffer
+	appendJournalField(&header, "PRIORITY", strconv.Itoa(priority))
+	appendJournalField(&header, "MESSAGE", msg)
+	_, _, err = jsw.conn.WriteMsgUnix(append(header.Bytes(), buf.Bytes()...), nil, jsw.addr)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (jsw *journaldSocketWriter) Close() error {
+	return jsw.conn.Close()
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// KVConfig contains the configuration for the kv writer type.
+type KVConfig struct {
+	// The name of the backend to use, e.g. consul or etcd. See RegisterKVBackend.
+	Backend string `json:"backend" yaml:"backend" toml:"backend"`
+	// The address of the store, e.g. http://127.0.0.1:8500 for consul. The format depends on the backend.
+	Address string `json:"address,omitempty" yaml:"address,omitempty" toml:"address,omitempty"`
+	// Prefix for the keys events are stored under. Keys are the prefix followed by a zero-padded timestamp.
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	// Make keys expire after this duration. Not all backends support TTLs.
+	TTL time.Duration `json:"ttl,omitempty" yaml:"ttl,omitempty" toml:"ttl,omitempty"`
+	// Delete the oldest keys written by this writer when there are more than this many.
+	// Defaults to 1000 if TTL isn't set.
+	MaxKeys int `json:"max_keys,omitempty" yaml:"max_keys,omitempty" toml:"max_keys,omitempty"`
+	// Maximum number of events per second to write, events above the limit are dropped. Defaults to 10.
+	RateLimit float64 `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty" toml:"rate_limit,omitempty"`
+
+	// The token to authenticate with, or a file or environment variable to read it from.
+	// Consul sends it as the ACL token (defaulting to the CONSUL_HTTP_TOKEN environment variable)
+	// and etcd in the Authorization header.
+	Token     string `json:"token,omitempty" yaml:"token,omitempty" toml:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty" yaml:"token_file,omitempty" toml:"token_file,omitempty"`
+	TokenEnv  string `json:"token_env,omitempty" yaml:"token_env,omitempty" toml:"token_env,omitempty"`
+}
+
+const (
+	defaultKVMaxKeys   = 1000
+	defaultKVRateLimit = 10
+)
+
+func (kc *KVConfig) UnmarshalJSON(data []byte) error {
+	type plainKVConfig KVConfig
+	aux := struct {
+		*plainKVConfig
+		TTL json.RawMessage `json:"ttl,omitempty"`
+	}{plainKVConfig: (*plainKVConfig)(kc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.TTL, &kc.TTL); err != nil {
+		return &PathError{Path: "ttl", Err: err}
+	}
+	return nil
+}
+
+// KVBackend is a key-value store that the kv writer type stores events in.
+//
+// The kv writer serializes all calls, so backends don't need to be safe for concurrent use.
+type KVBackend interface {
+	// Put stores a value. If ttl is non-zero, the key should expire after it.
+	Put(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+	Close() error
+}
+
+// KVBackendFactory creates a KVBackend from the kv writer config.
+type KVBackendFactory = func(KVConfig) (KVBackend, error)
+
+var kvBackends = map[string]KVBackendFactory{}
+
+// kvBackendTags contains the build tags of the built-in backends, for a more helpful error if they're not included.
+var kvBackendTags = map[string]string{
+	"consul": "zeroconfig_kv_consul",
+	"etcd":   "zeroconfig_kv_etcd",
+}
+
+// RegisterKVBackend adds a backend that can be selected with the backend option of kv writers.
+func RegisterKVBackend(name string, factory KVBackendFactory) {
+	kvBackends[name] = factory
+}
+
+// kvWriter stores each event under a new key in a key-value store.
+//
+// It's meant for low volumes of events, so writes are synchronous and rate limited.
+type kvWriter struct {
+	backend KVBackend
+	prefix  string
+	ttl     time.Duration
+	maxKeys int
+
+	lock sync.Mutex
+	// Oldest first, only tracked if maxKeys is set.
+	keys    []string
+	lastKey int64
+	limiter tokenBucket
+
+	deliveryHealth
+}
+
+func compileKV(wc *WriterConfig) (io.Writer, error) {
+	cfg := wc.KV
+	if cfg == nil || cfg.Backend == "" {
+		return nil, fmt.Errorf("kv.backend is required for the kv writer")
+	} else if cfg.Prefix == "" {
+		return nil, fmt.Errorf("kv.prefix is required for the kv writer")
+	} else if cfg.TTL < 0 || cfg.MaxKeys < 0 || cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("kv.ttl, kv.max_keys and kv.rate_limit must not be negative")
+	}
+	factory, ok := kvBackends[cfg.Backend]
+	if !ok {
+		if tag, ok := kvBackendTags[cfg.Backend]; ok {
+			return nil, fmt.Errorf("the %s kv backend requires building with the %s tag", cfg.Backend, tag)
+		}
+		return nil, fmt.Errorf("unknown kv backend %q", cfg.Backend)
+	}
+	token, err := resolveSecret("kv.token", cfg.Token, cfg.TokenFile, cfg.TokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	resolved := *cfg
+	resolved.Token = token
+	backend, err := factory(resolved)
+	if err != nil {
+		return nil, err
+	}
+	kw := &kvWriter{
+		backend: backend,
+		prefix:  cfg.Prefix,
+		ttl:     cfg.TTL,
+		maxKeys: cfg.MaxKeys,
+	}
+	if kw.maxKeys == 0 && kw.ttl == 0 {
+		kw.maxKeys = defaultKVMaxKeys
+	}
+	rate := cfg.RateLimit
+	if rate == 0 {
+		rate = defaultKVRateLimit
+	}
+	kw.limiter = newTokenBucket(rate)
+	return kw, nil
+}
+
+// nextKey returns a key based on the current time, making sure it's greater than all previous keys.
+func (kw *kvWriter) nextKey() string {
+	ts := time.Now().UnixNano()
+	if ts <= kw.lastKey {
+		ts = kw.lastKey + 1
+	}
+	kw.lastKey = ts
+	return fmt.Sprintf("%s%019d", kw.prefix, ts)
+}
+
+func (kw *kvWriter) Write(p []byte) (n int, err error) {
+	kw.lock.Lock()
+	defer kw.lock.Unlock()
+	if !kw.limiter.take(time.Now()) {
+		return len(p), nil
+	}
+	key := kw.nextKey()
+	err = kw.backend.Put(key, p, kw.ttl)
+	kw.setHealth(err)
+	if err != nil {
+		return 0, err
+	}
+	if kw.maxKeys > 0 {
+		kw.keys = append(kw.keys, key)
+		for len(kw.keys) > kw.maxKeys {
+			if err = kw.backend.Delete(kw.keys[0]); err != nil {
+				return len(p), fmt.Errorf("failed to delete old key: %w", err)
+			}
+			kw.keys = kw.keys[1:]
+		}
+	}
+	return len(p), nil
+}
+
+func (kw *kvWriter) Close() error {
+	kw.lock.Lock()
+	defer kw.lock.Unlock()
+	return kw.backend.Close()
+}
+
+func (kw *kvWriter) closeWithHandle() error {
+	return kw.Close()
+}
+
+// tokenBucket is a simple rate limiter that allows bursts of up to one second worth of events.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return tokenBucket{rate: rate, tokens: burst, last: time.Now()}
+}
+
+func (tb *tokenBucket) take(now time.Time) bool {
+	burst := tb.rate
+	if burst < 1 {
+		burst = 1
+	}
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > burst {
+		tb.tokens = burst
+	}
+	tb.last = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build zeroconfig_kv_consul
+
+package zeroconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConsulAddress = "http://127.0.0.1:8500"
+	consulRequestTimeout = 5 * time.Second
+)
+
+// consulKVBackend stores events using consul's HTTP KV API.
+// If the token isn't configured, it's read from the CONSUL_HTTP_TOKEN environment variable like the consul CLI does.
+type consulKVBackend struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func newConsulKVBackend(cfg KVConfig) (KVBackend, error) {
+	if cfg.TTL != 0 {
+		return nil, fmt.Errorf("the consul kv backend doesn't support ttl, use max_keys instead")
+	}
+	address := cfg.Address
+	if address == "" {
+		address = defaultConsulAddress
+	}
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	return &consulKVBackend{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: consulRequestTimeout},
+	}, nil
+}
+
+func (ckb *consulKVBackend) do(method, key string, body []byte) error {
+	req, err := http.NewRequest(method, ckb.address+"/v1/kv/"+(&url.URL{Path: key}).EscapedPath(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if ckb.token != "" {
+		req.Header.Set("X-Consul-Token", ckb.token)
+	}
+	resp, err := ckb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul returned HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (ckb *consulKVBackend) Put(key string, value []byte, _ time.Duration) error {
+	return ckb.do(http.MethodPut, key, value)
+}
+
+func (ckb *consulKVBackend) Delete(key string) error {
+	return ckb.do(http.MethodDelete, key, nil)
+}
+
+func (ckb *consulKVBackend) Close() error {
+	ckb.client.CloseIdleConnections()
+	return nil
+}
+
+func init() {
+	RegisterKVBackend("consul", newConsulKVBackend)
+}
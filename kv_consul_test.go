@@ -0,0 +1,49 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build zeroconfig_kv_consul
+
+package zeroconfig_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_KVConsul(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		lock.Unlock()
+		_, _ = w.Write([]byte("true"))
+	}))
+	defer server.Close()
+	log, err := (&zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type: zeroconfig.WriterTypeKV,
+		KV:   &zeroconfig.KVConfig{Backend: "consul", Address: server.URL, Prefix: "logs/", MaxKeys: 1},
+	}}}).Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	log.Info().Msg("hiss")
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, requests, 3)
+	assert.Regexp(t, `^PUT /v1/kv/logs/\d{19} \{.*"message":"meow"\}`, requests[0])
+	assert.Regexp(t, `^PUT /v1/kv/logs/\d{19} \{.*"message":"hiss"\}`, requests[1])
+	assert.Regexp(t, `^DELETE /v1/kv/logs/\d{19} $`, requests[2])
+	assert.Equal(t, requests[0][4:32], requests[2][7:35])
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build zeroconfig_kv_etcd
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEtcdAddress = "http://127.0.0.1:2379"
+	etcdRequestTimeout = 5 * time.Second
+)
+
+// etcdKVBackend stores events using the JSON gateway of the etcd v3 API.
+// Each key with a TTL gets its own lease, which is fine for the low volumes the kv writer is meant for.
+type etcdKVBackend struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func newEtcdKVBackend(cfg KVConfig) (KVBackend, error) {
+	if cfg.TTL != 0 && cfg.TTL < time.Second {
+		return nil, fmt.Errorf("the etcd kv backend requires ttl to be at least 1s")
+	}
+	address := cfg.Address
+	if address == "" {
+		address = defaultEtcdAddress
+	}
+	return &etcdKVBackend{
+		address: strings.TrimSuffix(address, "/"),
+		token:   cfg.Token,
+		client:  &http.Client{Timeout: etcdRequestTimeout},
+	}, nil
+}
+
+func (ekb *etcdKVBackend) call(path string, body, into any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, ekb.address+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ekb.token != "" {
+		req.Header.Set("Authorization", ekb.token)
+	}
+	resp, err := ekb.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("etcd returned HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	} else if into != nil {
+		return json.NewDecoder(resp.Body).Decode(into)
+	}
+	return nil
+}
+
+// Keys and values are []byte so that they're base64-encoded like the gateway expects.
+type etcdPutRequest struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	Lease string `json:"lease,omitempty"`
+}
+
+func (ekb *etcdKVBackend) Put(key string, value []byte, ttl time.Duration) error {
+	req := etcdPutRequest{Key: []byte(key), Value: value}
+	if ttl > 0 {
+		var lease struct {
+			ID string `json:"ID"`
+		}
+		err := ekb.call("/v3/lease/grant", map[string]int64{"TTL": int64(ttl / time.Second)}, &lease)
+		if err != nil {
+			return fmt.Errorf("failed to grant lease: %w", err)
+		}
+		req.Lease = lease.ID
+	}
+	return ekb.call("/v3/kv/put", &req, nil)
+}
+
+func (ekb *etcdKVBackend) Delete(key string) error {
+	return ekb.call("/v3/kv/deleterange", map[string][]byte{"key": []byte(key)}, nil)
+}
+
+func (ekb *etcdKVBackend) Close() error {
+	ekb.client.CloseIdleConnections()
+	return nil
+}
+
+func init() {
+	RegisterKVBackend("etcd", newEtcdKVBackend)
+}
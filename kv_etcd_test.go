@@ -0,0 +1,58 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build zeroconfig_kv_etcd
+
+package zeroconfig_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_KVEtcd(t *testing.T) {
+	var lock sync.Mutex
+	var puts []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			_, _ = w.Write([]byte(`{"ID":"1234","TTL":"60"}`))
+		case "/v3/kv/put":
+			var req map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			lock.Lock()
+			puts = append(puts, req)
+			lock.Unlock()
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	log, err := (&zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type: zeroconfig.WriterTypeKV,
+		KV:   &zeroconfig.KVConfig{Backend: "etcd", Address: server.URL, Prefix: "logs/", TTL: time.Minute},
+	}}}).Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, puts, 1)
+	assert.Equal(t, "1234", puts[0]["lease"])
+	// The gateway expects base64, which encoding/json uses for []byte.
+	var key []byte
+	require.NoError(t, json.Unmarshal([]byte(`"`+puts[0]["key"]+`"`), &key))
+	assert.Regexp(t, `^logs/\d{19}$`, string(key))
+}
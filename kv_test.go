@@ -0,0 +1,110 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type fakeKVBackend struct {
+	values map[string]string
+	ttls   map[string]time.Duration
+}
+
+func (fkb *fakeKVBackend) Put(key string, value []byte, ttl time.Duration) error {
+	fkb.values[key] = string(value)
+	fkb.ttls[key] = ttl
+	return nil
+}
+
+func (fkb *fakeKVBackend) Delete(key string) error {
+	delete(fkb.values, key)
+	return nil
+}
+
+func (fkb *fakeKVBackend) Close() error {
+	return nil
+}
+
+func (fkb *fakeKVBackend) sortedValues() []string {
+	keys := make([]string, 0, len(fkb.values))
+	for key := range fkb.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = fkb.values[key]
+	}
+	return values
+}
+
+func registerFakeKVBackend() *fakeKVBackend {
+	fake := &fakeKVBackend{values: make(map[string]string), ttls: make(map[string]time.Duration)}
+	zeroconfig.RegisterKVBackend("fake", func(cfg zeroconfig.KVConfig) (zeroconfig.KVBackend, error) {
+		return fake, nil
+	})
+	return fake
+}
+
+func TestWriterConfig_Compile_KV(t *testing.T) {
+	fake := registerFakeKVBackend()
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{"writers": [{"type": "kv", "kv": {
+	  "backend": "fake",
+	  "prefix": "logs/",
+	  "max_keys": 3,
+	  "ttl": "1h",
+	  "rate_limit": 100
+	}}]}`), &cfg))
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	for _, msg := range []string{"a", "b", "c", "d", "e"} {
+		log.Info().Msg(msg)
+	}
+	values := fake.sortedValues()
+	require.Len(t, values, 3)
+	for i, msg := range []string{"c", "d", "e"} {
+		assert.Contains(t, values[i], `"message":"`+msg+`"`)
+	}
+	for key, ttl := range fake.ttls {
+		assert.True(t, strings.HasPrefix(key, "logs/"))
+		assert.Equal(t, time.Hour, ttl)
+	}
+}
+
+func TestWriterConfig_Compile_KVRateLimit(t *testing.T) {
+	fake := registerFakeKVBackend()
+	log, err := (&zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type: zeroconfig.WriterTypeKV,
+		KV:   &zeroconfig.KVConfig{Backend: "fake", Prefix: "logs/", RateLimit: 5},
+	}}}).Compile()
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		log.Info().Int("i", i).Msg("meow")
+	}
+	assert.Len(t, fake.values, 5)
+}
+
+func TestWriterConfig_Compile_KVErrors(t *testing.T) {
+	compile := func(kv *zeroconfig.KVConfig) error {
+		_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeKV, KV: kv}).Compile()
+		return err
+	}
+	assert.EqualError(t, compile(nil), "kv.backend is required for the kv writer")
+	assert.EqualError(t, compile(&zeroconfig.KVConfig{Backend: "fake"}), "kv.prefix is required for the kv writer")
+	assert.EqualError(t, compile(&zeroconfig.KVConfig{Backend: "meow", Prefix: "logs/"}), `unknown kv backend "meow"`)
+}
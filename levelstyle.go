@@ -0,0 +1,162 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// LevelStyle describes how level names are written in log output.
+type LevelStyle string
+
+const (
+	// LevelStyleShort uses zerolog's level names (e.g. warn) in JSON and abbreviations (e.g. WRN) in pretty formats.
+	LevelStyleShort LevelStyle = "short"
+	// LevelStyleLong uses full words (e.g. warning and information) in JSON and
+	// the same words in uppercase (e.g. WARNING) in pretty formats.
+	LevelStyleLong LevelStyle = "long"
+	// LevelStyleUpper uses zerolog's level names in uppercase (e.g. WARN) in both JSON and pretty formats.
+	LevelStyleUpper LevelStyle = "upper"
+)
+
+// Same as the abbreviations used by zerolog.ConsoleWriter.
+var shortLevelNames = map[zerolog.Level]string{
+	zerolog.TraceLevel: "TRC",
+	zerolog.DebugLevel: "DBG",
+	zerolog.InfoLevel:  "INF",
+	zerolog.WarnLevel:  "WRN",
+	zerolog.ErrorLevel: "ERR",
+	zerolog.FatalLevel: "FTL",
+	zerolog.PanicLevel: "PNC",
+}
+
+var longLevelNames = map[zerolog.Level]string{
+	zerolog.TraceLevel: "trace",
+	zerolog.DebugLevel: "debug",
+	zerolog.InfoLevel:  "information",
+	zerolog.WarnLevel:  "warning",
+	zerolog.ErrorLevel: "error",
+	zerolog.FatalLevel: "fatal",
+	zerolog.PanicLevel: "panic",
+}
+
+// Same as the colors used by zerolog.ConsoleWriter.
+var levelColors = map[zerolog.Level]string{
+	zerolog.TraceLevel: "\x1b[35m",
+	zerolog.DebugLevel: "\x1b[33m",
+	zerolog.InfoLevel:  "\x1b[32m",
+	zerolog.WarnLevel:  "\x1b[31m",
+	zerolog.ErrorLevel: "\x1b[1m\x1b[31m",
+	zerolog.FatalLevel: "\x1b[1m\x1b[31m",
+	zerolog.PanicLevel: "\x1b[1m\x1b[31m",
+}
+
+// levelLabels contains the labels used for levels in JSON output and pretty formats.
+type levelLabels struct {
+	json   map[zerolog.Level]string
+	pretty map[zerolog.Level]string
+	levels map[string]zerolog.Level
+}
+
+// compileLevelLabels returns the labels for the given style and custom labels,
+// or nil if the output should use zerolog's defaults.
+func compileLevelLabels(style LevelStyle, custom map[string]string) (*levelLabels, error) {
+	switch style {
+	case "", LevelStyleShort:
+		if len(custom) == 0 {
+			return nil, nil
+		}
+	case LevelStyleLong, LevelStyleUpper:
+	default:
+		return nil, fmt.Errorf("unknown level_style %q", style)
+	}
+	labels := &levelLabels{
+		json:   make(map[zerolog.Level]string),
+		pretty: make(map[zerolog.Level]string),
+		levels: make(map[string]zerolog.Level),
+	}
+	for level, long := range longLevelNames {
+		name := zerolog.LevelFieldMarshalFunc(level)
+		switch style {
+		case "", LevelStyleShort:
+			labels.json[level] = name
+			labels.pretty[level] = shortLevelNames[level]
+		case LevelStyleLong:
+			labels.json[level] = long
+			labels.pretty[level] = strings.ToUpper(long)
+		case LevelStyleUpper:
+			labels.json[level] = strings.ToUpper(name)
+			labels.pretty[level] = strings.ToUpper(name)
+		}
+	}
+	for name, label := range custom {
+		level, err := zerolog.ParseLevel(name)
+		if err != nil || name == "" {
+			return nil, fmt.Errorf("invalid level %q in level_labels", name)
+		} else if label == "" {
+			return nil, fmt.Errorf("empty label for level %q in level_labels", name)
+		}
+		labels.json[level] = label
+		labels.pretty[level] = strings.ToUpper(label)
+	}
+	for level, label := range labels.json {
+		if other, ok := labels.levels[label]; ok {
+			return nil, fmt.Errorf("levels %s and %s have the same label %q", other, level, label)
+		}
+		labels.levels[label] = level
+	}
+	return labels, nil
+}
+
+// rewrite replaces the level field of events. Filters operate on the level passed to WriteLevel,
+// so they keep working on the real levels regardless of the labels.
+func (ll *levelLabels) rewrite(level zerolog.Level, obj jsonObject) (jsonObject, bool) {
+	i := obj.index(zerolog.LevelFieldName)
+	if i < 0 {
+		return obj, true
+	}
+	if level == zerolog.NoLevel {
+		var name string
+		if json.Unmarshal(obj[i].Value, &name) != nil {
+			return obj, true
+		}
+		var err error
+		if level, err = zerolog.ParseLevel(name); err != nil {
+			return obj, true
+		}
+	}
+	if label, ok := ll.json[level]; ok {
+		obj[i].Value = appendJSONString(nil, label)
+	}
+	return obj, true
+}
+
+// formatLevel returns a zerolog.ConsoleWriter level formatter for the relabeled level field.
+func (ll *levelLabels) formatLevel(noColor bool) zerolog.Formatter {
+	return func(i any) string {
+		label, ok := i.(string)
+		if !ok {
+			if i == nil {
+				return "???"
+			}
+			return strings.ToUpper(fmt.Sprint(i))
+		}
+		level, ok := ll.levels[label]
+		if !ok {
+			return label
+		}
+		pretty := ll.pretty[level]
+		if color, ok := levelColors[level]; ok && !noColor {
+			return color + pretty + "\x1b[0m"
+		}
+		return pretty
+	}
+}
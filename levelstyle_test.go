@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Compile_LevelStyle(t *testing.T) {
+	tests := []struct {
+		style  zeroconfig.LevelStyle
+		labels map[string]string
+		json   string
+		error  string
+		pretty string
+	}{
+		{"", nil, "warn", "error", "WRN"},
+		{zeroconfig.LevelStyleShort, nil, "warn", "error", "WRN"},
+		{zeroconfig.LevelStyleLong, nil, "warning", "error", "WARNING"},
+		{zeroconfig.LevelStyleUpper, nil, "WARN", "ERROR", "WARN"},
+		{zeroconfig.LevelStyleShort, map[string]string{"warn": "notice"}, "notice", "error", "NOTICE"},
+		{zeroconfig.LevelStyleLong, map[string]string{"warn": "caution"}, "caution", "error", "CAUTION"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.style)+"/"+test.json, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			zeroconfig.Stdout = &stdout
+			zeroconfig.Stderr = &stderr
+			cfg := zeroconfig.Config{
+				Timestamp:   ptr(false),
+				LevelStyle:  test.style,
+				LevelLabels: test.labels,
+				Writers: []zeroconfig.WriterConfig{{
+					Type:     zeroconfig.WriterTypeStdout,
+					MinLevel: ptr(zerolog.WarnLevel),
+				}, {
+					Type: zeroconfig.WriterTypeGroup,
+					Writers: []zeroconfig.WriterConfig{{
+						Type:   zeroconfig.WriterTypeStderr,
+						Format: zeroconfig.LogFormatPretty,
+						Color:  zeroconfig.ColorNever,
+					}},
+					MaxLevel: ptr(zerolog.WarnLevel),
+					MinLevel: ptr(zerolog.WarnLevel),
+				}},
+			}
+			log, err := cfg.Compile()
+			require.NoError(t, err)
+			log.Info().Msg("meow")
+			log.Warn().Msg("meow")
+			log.Error().Msg("hiss")
+			assert.Equal(t, `{"level":"`+test.json+`","message":"meow"}`+"\n"+`{"level":"`+test.error+`","message":"hiss"}`+"\n", stdout.String(), "Level bounds should use the real levels")
+			assert.Equal(t, "<nil> "+test.pretty+" meow\n", stderr.String())
+		})
+	}
+}
+
+func TestConfig_Compile_LevelStyleColor(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	log, err := (&zeroconfig.Config{
+		Timestamp:  ptr(false),
+		LevelStyle: zeroconfig.LevelStyleLong,
+		Writers: []zeroconfig.WriterConfig{{
+			Type:   zeroconfig.WriterTypeStdout,
+			Format: zeroconfig.LogFormatPrettyColored,
+			Color:  zeroconfig.ColorAlways,
+		}},
+	}).Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Contains(t, stdout.String(), "\x1b[32mINFORMATION\x1b[0m", "Long labels should be colored like the default ones")
+}
+
+func TestConfig_Compile_LevelStyleInvalid(t *testing.T) {
+	for _, cfg := range []zeroconfig.Config{
+		{LevelStyle: "meow"},
+		{LevelLabels: map[string]string{"meow": "hiss"}},
+		{LevelLabels: map[string]string{"info": ""}},
+		{LevelLabels: map[string]string{"info": "warn"}},
+	} {
+		cfg.Writers = []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}
+		_, err := cfg.Compile()
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"github.com/rs/zerolog"
+)
+
+// Messages of the events logged when Config.LogLifecycleEvents is enabled.
+const (
+	LoggingStartedMessage = "Logging started"
+	LoggingStoppedMessage = "Logging stopped"
+)
+
+type activeWriter struct {
+	index  int
+	config WriterConfig
+}
+
+func levelOrNull(dict *zerolog.Event, key string, level *zerolog.Level) *zerolog.Event {
+	if level == nil {
+		return dict.Interface(key, nil)
+	}
+	return dict.Str(key, level.String())
+}
+
+// writerSummary describes a writer in the logging started event.
+// All keys are always present to keep the schema stable, unset levels are null.
+func (wc *WriterConfig) writerSummary(dict *zerolog.Event) *zerolog.Event {
+	format := wc.Format
+	if format == "" {
+		format = LogFormatJSON
+	}
+	dict = dict.Str("type", string(wc.Type)).Str("format", string(format))
+	dict = levelOrNull(dict, "min_level", wc.MinLevel)
+	dict = levelOrNull(dict, "max_level", wc.MaxLevel)
+	children := zerolog.Arr()
+	for i := range wc.Writers {
+		children = children.Dict(wc.Writers[i].writerSummary(zerolog.Dict()))
+	}
+	return dict.Array("writers", children)
+}
+
+// logStarted logs the logging started event with a summary of the active writers.
+func logStarted(log *zerolog.Logger, minLevel *zerolog.Level, environment string, writers []activeWriter) {
+	summaries := zerolog.Arr()
+	for _, writer := range writers {
+		summaries = summaries.Dict(writer.config.writerSummary(zerolog.Dict().Int("index", writer.index)))
+	}
+	evt := log.Info().Str("environment", environment)
+	evt = levelOrNull(evt, "min_level", minLevel)
+	evt.Array("writers", summaries).Msg(LoggingStartedMessage)
+}
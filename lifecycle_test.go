@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_CompileHandle_LifecycleEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	cfg := zeroconfig.Config{
+		Timestamp:          ptr(false),
+		MinLevel:           ptr(zerolog.DebugLevel),
+		LogLifecycleEvents: true,
+		Writers: []zeroconfig.WriterConfig{{
+			Type:       zeroconfig.WriterTypeFile,
+			FileConfig: zeroconfig.FileConfig{Filename: path},
+		}, {
+			Type:     zeroconfig.WriterTypeGroup,
+			Format:   zeroconfig.LogFormatPretty,
+			MaxLevel: ptr(zerolog.DebugLevel),
+			Writers:  []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		}, {
+			Type:   zeroconfig.WriterTypeStderr,
+			OnlyIn: []string{"meow"},
+		}},
+		Coalesce: &zeroconfig.CoalesceConfig{Window: time.Hour},
+	}
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	handle.Logger.Warn().Msg("meow")
+	handle.Logger.Warn().Msg("meow")
+	require.NoError(t, handle.Close())
+	require.NoError(t, handle.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 4)
+	assert.JSONEq(t, `{
+		"level": "info",
+		"environment": "",
+		"min_level": "debug",
+		"writers": [
+			{"index": 1, "type": "file", "format": "json", "min_level": null, "max_level": null, "writers": []},
+			{"index": 2, "type": "group", "format": "pretty", "min_level": null, "max_level": "debug", "writers": [
+				{"type": "stdout", "format": "json", "min_level": null, "max_level": null, "writers": []}
+			]}
+		],
+		"message": "Logging started"
+	}`, lines[0], "Start event should describe the active writers")
+	assert.Equal(t, `{"level":"warn","message":"meow"}`, lines[1])
+	assert.Contains(t, lines[2], `"coalesced_count":1`, "Background tasks should be stopped before the stop event")
+	assert.Equal(t, `{"level":"info","message":"Logging stopped"}`, lines[3], "Stop event should be the last line")
+}
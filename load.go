@@ -0,0 +1,299 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat is a file format that configs can be loaded from.
+type ConfigFormat string
+
+const (
+	ConfigFormatJSON ConfigFormat = "json"
+	ConfigFormatYAML ConfigFormat = "yaml"
+	ConfigFormatTOML ConfigFormat = "toml"
+)
+
+// detectFormat chooses the format of a config file based on its extension: .json for JSON, .yaml or .yml for YAML
+// and .toml for TOML. Files with other extensions are JSON if the first non-whitespace character is {, and YAML otherwise.
+func detectFormat(path string, data []byte) ConfigFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ConfigFormatJSON
+	case ".yaml", ".yml":
+		return ConfigFormatYAML
+	case ".toml":
+		return ConfigFormatTOML
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return ConfigFormatJSON
+	}
+	return ConfigFormatYAML
+}
+
+// LoadConfig reads a config from the given file. The format is chosen based on the file extension:
+// .json for JSON, .yaml or .yml for YAML and .toml for TOML. Files with other extensions are parsed as JSON
+// if they start with {, and as YAML otherwise.
+//
+// Errors include the path, and syntax errors also include the line number.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := unmarshalConfig(data, detectFormat(path, data), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// CompileFile reads a config with LoadConfig and compiles it.
+func CompileFile(path string, opts ...CompileOption) (*zerolog.Logger, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	log, err := cfg.Compile(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config in %s: %w", path, err)
+	}
+	return log, nil
+}
+
+// LoadReader reads a config in the given format from a reader. Unlike LoadConfig, unknown fields are rejected.
+func LoadReader(r io.Reader, format ConfigFormat) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalStrict(data, format)
+}
+
+// UnmarshalStrict parses a config in the given format and rejects unknown fields.
+//
+// Errors point at the offending value: as a path like writers[2].max_size for JSON,
+// and additionally with the line number for YAML. TOML errors include the line number.
+func UnmarshalStrict(data []byte, format ConfigFormat) (*Config, error) {
+	return unmarshalConfig(data, format, true)
+}
+
+func unmarshalConfig(data []byte, format ConfigFormat, strict bool) (*Config, error) {
+	var cfg Config
+	switch format {
+	case ConfigFormatJSON:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, describeJSONSyntaxError(err, data)
+		}
+		if strict {
+			var generic any
+			if err := json.Unmarshal(data, &generic); err != nil {
+				return nil, err
+			} else if err = checkUnknownJSONFields(generic, reflect.TypeOf(cfg), ""); err != nil {
+				return nil, err
+			}
+		}
+	case ConfigFormatYAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		} else if err = checkYAMLFields(&node, reflect.TypeOf(cfg), "", strict); err != nil {
+			return nil, err
+		} else if err = node.Decode(&cfg); err != nil {
+			return nil, err
+		}
+	case ConfigFormatTOML:
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			return nil, err
+		}
+		if undecoded := md.Undecoded(); strict && len(undecoded) > 0 {
+			return nil, &PathError{Path: undecoded[0].String(), Err: errUnknownField}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	return &cfg, nil
+}
+
+var errUnknownField = errors.New("unknown field")
+
+// describeJSONSyntaxError adds the line and column to JSON syntax errors.
+func describeJSONSyntaxError(err error, data []byte) error {
+	var se *json.SyntaxError
+	if !errors.As(err, &se) {
+		return err
+	}
+	// The offset points after the invalid character.
+	offset := int(se.Offset) - 1
+	if offset > len(data) {
+		offset = len(data)
+	} else if offset < 0 {
+		offset = 0
+	}
+	line := bytes.Count(data[:offset], []byte{'\n'}) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// structFields returns the fields of a struct type by the names in the given tag, including fields of embedded structs.
+func structFields(t reflect.Type, tagKey string) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get(tagKey)
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		} else if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for key, fieldType := range structFields(field.Type, tagKey) {
+				fields[key] = fieldType
+			}
+			continue
+		} else if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func checkUnknownJSONFields(val any, t reflect.Type, path string) error {
+	t = derefType(t)
+	switch typedVal := val.(type) {
+	case map[string]any:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := structFields(t, "json")
+		keys := make([]string, 0, len(typedVal))
+		for key := range typedVal {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldType, ok := fields[key]
+			if !ok {
+				return &PathError{Path: joinPath(path, key), Err: errUnknownField}
+			} else if err := checkUnknownJSONFields(typedVal[key], fieldType, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, item := range typedVal {
+			if err := checkUnknownJSONFields(item, t.Elem(), path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var levelType = reflect.TypeOf(zerolog.NoLevel)
+
+// checkYAMLFields checks that level fields contain valid levels, so that the error can point at the offending value
+// (yaml.v3 returns errors from UnmarshalText without the line). In strict mode, it also rejects unknown fields.
+func checkYAMLFields(node *yaml.Node, t reflect.Type, path string, strict bool) error {
+	t = derefType(t)
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) > 0 {
+			return checkYAMLFields(node.Content[0], t, path, strict)
+		}
+	case yaml.AliasNode:
+		return checkYAMLFields(node.Alias, t, path, strict)
+	case yaml.ScalarNode:
+		if t != levelType || node.Tag == "!!null" {
+			return nil
+		}
+		var level zerolog.Level
+		if err := node.Decode(&level); err != nil {
+			return &PathError{Path: path, Err: fmt.Errorf("%w (line %d)", err, node.Line)}
+		}
+	case yaml.MappingNode:
+		if t == levelType || (t.Kind() == reflect.Map && derefType(t.Elem()) == levelType) {
+			// Maps of environments to levels, including the map form of min_level.
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if err := checkYAMLFields(node.Content[i+1], levelType, joinPath(path, node.Content[i].Value), strict); err != nil {
+					return err
+				}
+			}
+			return nil
+		} else if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := structFields(t, "yaml")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Tag == "!!merge" {
+				if err := checkYAMLFields(node.Content[i+1], t, path, strict); err != nil {
+					return err
+				}
+				continue
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok && strict {
+				return &PathError{Path: joinPath(path, key.Value), Err: fmt.Errorf("%w (line %d)", errUnknownField, key.Line)}
+			} else if !ok {
+				continue
+			} else if err := checkYAMLFields(node.Content[i+1], fieldType, joinPath(path, key.Value), strict); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, item := range node.Content {
+			if err := checkYAMLFields(item, t.Elem(), path+"["+strconv.Itoa(i)+"]", strict); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MustLoadAndCompile reads a config with LoadConfig and compiles it, panicking if either step fails.
+func MustLoadAndCompile(path string) *zerolog.Logger {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		panic(fmt.Errorf("zeroconfig: failed to load logging config: %w", err))
+	}
+	return cfg.MustCompile()
+}
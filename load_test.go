@@ -0,0 +1,344 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func ptr[T any](val T) *T {
+	return &val
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+min_level = "debug"
+timestamp = false
+
+[metadata]
+meow = 5
+
+[[writers]]
+type = "stdout"
+format = "pretty"
+max_level = "warn"
+
+[[writers]]
+type = "file"
+filename = "test.log"
+max_size = 10
+compress = true
+compress_level = "best"
+`), 0600))
+	cfg, err := zeroconfig.LoadConfig(path)
+	require.NoError(t, err, "Loading TOML config should be successful")
+	assert.Equal(t, &zeroconfig.Config{
+		MinLevel:  ptr(zerolog.DebugLevel),
+		Timestamp: ptr(false),
+		Metadata:  map[string]any{"meow": int64(5)},
+		Writers: []zeroconfig.WriterConfig{{
+			Type:     zeroconfig.WriterTypeStdout,
+			Format:   zeroconfig.LogFormatPretty,
+			MaxLevel: ptr(zerolog.WarnLevel),
+		}, {
+			Type: zeroconfig.WriterTypeFile,
+			FileConfig: zeroconfig.FileConfig{
+				Filename:      "test.log",
+				MaxSize:       10,
+				Compress:      true,
+				CompressLevel: zeroconfig.CompressLevelBest,
+			},
+		}},
+	}, cfg)
+}
+
+func TestLoadConfig_TOMLRoundTrip(t *testing.T) {
+	original := zeroconfig.Config{
+		MinLevel: ptr(zerolog.InfoLevel),
+		Caller:   true,
+		Metadata: map[string]any{"service": "meow"},
+		Writers: []zeroconfig.WriterConfig{{
+			Type:     zeroconfig.WriterTypeStderr,
+			Format:   zeroconfig.LogFormatPrettyColored,
+			MinLevel: ptr(zerolog.ErrorLevel),
+		}, {
+			Type:         zeroconfig.WriterTypeSyslog,
+			SyslogConfig: zeroconfig.SyslogConfig{Network: "udp", Host: "localhost", Flags: 8, Tag: "meow"},
+		}},
+	}
+	path := filepath.Join(t.TempDir(), "config.toml")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, toml.NewEncoder(file).Encode(&original), "Encoding config as TOML should be successful")
+	require.NoError(t, file.Close())
+
+	loaded, err := zeroconfig.LoadConfig(path)
+	require.NoError(t, err, "Loading encoded config should be successful")
+	assert.Equal(t, &original, loaded, "Config should survive a round trip through TOML")
+}
+
+func TestLoadConfig_YAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+min_level: {default: debug, prod: 1}
+timestamp: false
+metadata:
+  meow: 5
+writers:
+- type: stdout
+  format: pretty
+  max_level: warn
+- type: file
+  filename: test.log
+  max_size: 10
+  min_level: error
+  compress: true
+  compress_level: best
+- type: syslog
+  network: udp
+  host: localhost
+  tag: meow
+  max_level: null
+`), 0600))
+	cfg, err := zeroconfig.LoadConfig(path)
+	require.NoError(t, err, "Loading YAML config should be successful")
+	assert.Equal(t, &zeroconfig.Config{
+		MinLevel:    ptr(zerolog.DebugLevel),
+		EnvMinLevel: map[string]zerolog.Level{"prod": zerolog.InfoLevel},
+		Timestamp:   ptr(false),
+		Metadata:    map[string]any{"meow": json.Number("5")},
+		Writers: []zeroconfig.WriterConfig{{
+			Type:     zeroconfig.WriterTypeStdout,
+			Format:   zeroconfig.LogFormatPretty,
+			MaxLevel: ptr(zerolog.WarnLevel),
+		}, {
+			Type:     zeroconfig.WriterTypeFile,
+			MinLevel: ptr(zerolog.ErrorLevel),
+			FileConfig: zeroconfig.FileConfig{
+				Filename:      "test.log",
+				MaxSize:       10,
+				Compress:      true,
+				CompressLevel: zeroconfig.CompressLevelBest,
+			},
+		}, {
+			Type:         zeroconfig.WriterTypeSyslog,
+			SyslogConfig: zeroconfig.SyslogConfig{Network: "udp", Host: "localhost", Tag: "meow"},
+		}},
+	}, cfg)
+}
+
+func TestLoadConfig_YAMLRoundTrip(t *testing.T) {
+	original := zeroconfig.Config{
+		MinLevel:  ptr(zerolog.InfoLevel),
+		Timestamp: ptr(true),
+		Caller:    true,
+		Writers: []zeroconfig.WriterConfig{{
+			Type:     zeroconfig.WriterTypeStderr,
+			Format:   zeroconfig.LogFormatPrettyColored,
+			MinLevel: ptr(zerolog.ErrorLevel),
+			MaxLevel: ptr(zerolog.Level(10)),
+		}, {
+			Type:         zeroconfig.WriterTypeSyslog,
+			SyslogConfig: zeroconfig.SyslogConfig{Network: "udp", Host: "localhost", Flags: 8, Tag: "meow"},
+		}},
+	}
+	data, err := yaml.Marshal(&original)
+	require.NoError(t, err, "Encoding config as YAML should be successful")
+	loaded, err := zeroconfig.UnmarshalStrict(data, zeroconfig.ConfigFormatYAML)
+	require.NoError(t, err, "Loading encoded config should be successful")
+	assert.Equal(t, &original, loaded, "Config should survive a round trip through YAML")
+}
+
+func TestLoadConfig_SniffFormat(t *testing.T) {
+	tests := map[string]string{
+		"JSON":        `  {"min_level": "warn", "writers": [{"type": "stdout"}]}`,
+		"YAML":        "min_level: warn\nwriters:\n- type: stdout\n",
+		"YAMLFlowMap": "# comment\n{min_level: warn, writers: [{type: stdout}]}",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "logging.conf")
+			require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+			cfg, err := zeroconfig.LoadConfig(path)
+			require.NoError(t, err)
+			assert.Equal(t, &zeroconfig.Config{
+				MinLevel: ptr(zerolog.WarnLevel),
+				Writers:  []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+			}, cfg)
+		})
+	}
+}
+
+func TestLoadConfig_SyntaxErrors(t *testing.T) {
+	tests := map[string]struct {
+		name string
+		data string
+		err  string
+	}{
+		"JSON":      {"config.json", "{\n  \"min_level\": \"warn\",\n  \"writers\": [}\n}", "line 3, column 15: invalid character '}' looking for beginning of value"},
+		"YAML":      {"config.yml", "min_level: warn\nwriters:\n- type: stdout\n  format: [\n", "yaml: line 4: did not find expected node content"},
+		"SniffJSON": {"config", "{\"writers\": [}", "line 1, column 14: invalid character '}' looking for beginning of value"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.name)
+			require.NoError(t, os.WriteFile(path, []byte(test.data), 0600))
+			_, err := zeroconfig.LoadConfig(path)
+			assert.EqualError(t, err, "failed to parse "+path+": "+test.err)
+		})
+	}
+}
+
+func TestCompileFile(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("writers:\n- type: stdout\ntimestamp: false\n"), 0600))
+	log, err := zeroconfig.CompileFile(path)
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", out.String())
+
+	require.NoError(t, os.WriteFile(path, []byte("writers:\n- type: stdout\n  format: pretty\n  html_safe: true\n"), 0600))
+	_, err = zeroconfig.CompileFile(path)
+	assert.EqualError(t, err, "invalid config in "+path+": writers[0].html_safe: only supported with the json format")
+}
+
+func TestMustLoadAndCompile(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("writers:\n- type: stdout\n"), 0600))
+	log := zeroconfig.MustLoadAndCompile(path)
+	log.Info().Msg("meow")
+	assert.Contains(t, out.String(), `"message":"meow"`, "Loaded logger should be usable")
+
+	require.NoError(t, os.WriteFile(path, []byte("writers:\n- type: stdout\n- type: stdout\n  format: pretty\n  html_safe: true\n"), 0600))
+	assert.PanicsWithError(t, `zeroconfig: invalid logging config: writers[1].html_safe: only supported with the json format`, func() {
+		zeroconfig.MustLoadAndCompile(path)
+	}, "Panic message should include the path of the writer")
+
+	require.NoError(t, os.WriteFile(path, []byte("writers:\n- type: stdout\n  format: pretty\n  html_safe: true\n- type: file\n"), 0600))
+	assert.PanicsWithError(t, `zeroconfig: invalid logging config: writers[0].html_safe: only supported with the json format; writers[1].filename: required for the file writer`, func() {
+		zeroconfig.MustLoadAndCompile(path)
+	}, "Panic message should list every invalid writer on one line")
+
+	require.NoError(t, os.WriteFile(path, []byte("writers:\n- type: stdout\n- type: stdout\n  format: meow\n"), 0600))
+	assert.PanicsWithError(t, `zeroconfig: failed to load logging config: failed to parse `+path+`: unknown format "meow" (expected one of console, console-colored, json, logfmt, pretty, pretty-colored)`, func() {
+		zeroconfig.MustLoadAndCompile(path)
+	}, "Unknown formats should be rejected when loading")
+
+	missingPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.PanicsWithError(t, `zeroconfig: failed to load logging config: open `+missingPath+`: no such file or directory`, func() {
+		zeroconfig.MustLoadAndCompile(missingPath)
+	})
+}
+
+func TestUnmarshalStrict_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   zeroconfig.ConfigFormat
+		input    string
+		expected string
+	}{
+		{"JSONTypeError", zeroconfig.ConfigFormatJSON,
+			`{"writers": [{"type": "stdout"}, {"type": "stderr"}, {"type": "file", "max_size": "many"}]}`,
+			`writers[2].max_size: expected integer, got "many"`},
+		{"JSONNestedTypeError", zeroconfig.ConfigFormatJSON,
+			`{"writers": [{"type": "group", "writers": [{"type": "stdout", "sampling": {"basic_n": true}}]}]}`,
+			`writers[0].writers[0].sampling.basic_n: expected integer, got true`},
+		{"JSONNestedDuration", zeroconfig.ConfigFormatJSON,
+			`{"writers": [{"type": "stdout", "sampling": {"burst": 5, "burst_period": "soon"}}]}`,
+			`writers[0].sampling.burst_period: time: invalid duration "soon"`},
+		{"JSONLevel", zeroconfig.ConfigFormatJSON,
+			`{"writers": [{"type": "stdout", "min_level": "meow"}]}`,
+			`writers[0].min_level: Unknown Level String: 'meow', defaulting to NoLevel`},
+		{"JSONTopLevelTypeError", zeroconfig.ConfigFormatJSON,
+			`{"caller": "yes"}`,
+			`caller: expected boolean, got "yes"`},
+		{"JSONUnknownField", zeroconfig.ConfigFormatJSON,
+			`{"writers": [{"type": "file", "filename": "a.log", "max_sise": 5}]}`,
+			`writers[0].max_sise: unknown field`},
+		{"JSONSyntaxError", zeroconfig.ConfigFormatJSON,
+			"{\n  \"writers\": [\n    {\"type\": \"stdout\",}\n  ]\n}",
+			`line 3, column 23: invalid character '}' looking for beginning of object key string`},
+		{"YAMLUnknownField", zeroconfig.ConfigFormatYAML,
+			"writers:\n- type: stdout\n- type: file\n  filename: a.log\n  max_sise: 5\n",
+			`writers[1].max_sise: unknown field (line 5)`},
+		{"YAMLLevel", zeroconfig.ConfigFormatYAML,
+			"writers:\n- type: stdout\n- type: file\n  filename: a.log\n  min_level: loud\n",
+			`writers[1].min_level: Unknown Level String: 'loud', defaulting to NoLevel (line 5)`},
+		{"YAMLEnvLevel", zeroconfig.ConfigFormatYAML,
+			"min_level:\n  default: info\n  dev: meow\n",
+			`min_level.dev: Unknown Level String: 'meow', defaulting to NoLevel (line 3)`},
+		{"YAMLTypeError", zeroconfig.ConfigFormatYAML,
+			"writers:\n- type: file\n  max_size: many\n",
+			"yaml: unmarshal errors:\n  line 3: cannot unmarshal !!str `many` into int"},
+		{"TOMLUnknownField", zeroconfig.ConfigFormatTOML,
+			"[[writers]]\ntype = \"file\"\nmax_sise = 5\n",
+			`writers.max_sise: unknown field`},
+		{"UnknownFormat", "ini", "", `unsupported config format "ini"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := zeroconfig.UnmarshalStrict([]byte(test.input), test.format)
+			require.Error(t, err)
+			assert.Equal(t, test.expected, err.Error())
+		})
+	}
+}
+
+func TestLoadReader(t *testing.T) {
+	cfg, err := zeroconfig.LoadReader(strings.NewReader("writers:\n- type: stdout\n  min_level: debug\n"), zeroconfig.ConfigFormatYAML)
+	require.NoError(t, err)
+	assert.Equal(t, []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout, MinLevel: ptr(zerolog.DebugLevel)}}, cfg.Writers)
+}
+
+var fuzzSeeds = []struct {
+	format zeroconfig.ConfigFormat
+	input  string
+}{
+	{zeroconfig.ConfigFormatJSON, `{"min_level": "debug", "writers": [{"type": "stdout", "format": "pretty", "min_level": 10, "max_level": "warn"}], "metadata": {"a": 1}}`},
+	{zeroconfig.ConfigFormatJSON, `{"writers": [{"type": "group", "writers": [{"type": "file", "filename": "a.log", "compress_level": "best", "sampling": {"basic_n": 2, "startup_burst": "5s"}}]}]}`},
+	{zeroconfig.ConfigFormatJSON, `{"raw_metadata": {"a": {"b": [1, 2]}}, "suppression_summary_interval": "1m", "writers": []}`},
+	{zeroconfig.ConfigFormatYAML, "min_level: debug\nwriters:\n- type: syslog\n  network: udp\n  host: localhost\n- type: file\n  filename: a.log\n  hash_shard: {shard_count: 2, key_field: a}\n"},
+	{zeroconfig.ConfigFormatYAML, "metadata: &m {a: 12345678901234567890}\nwriters:\n- type: stdout\n  metadata_override: *m\n"},
+	{zeroconfig.ConfigFormatTOML, "min_level = \"debug\"\n[[writers]]\ntype = \"stdout\"\nmin_level = 2\n[writers.sampling]\nstartup_burst = 5\n"},
+}
+
+func FuzzUnmarshalStrict(f *testing.F) {
+	formats := []zeroconfig.ConfigFormat{zeroconfig.ConfigFormatJSON, zeroconfig.ConfigFormatYAML, zeroconfig.ConfigFormatTOML}
+	for _, seed := range fuzzSeeds {
+		for i, format := range formats {
+			if format == seed.format {
+				f.Add(uint8(i), []byte(seed.input))
+			}
+		}
+	}
+	f.Fuzz(func(t *testing.T, formatIndex uint8, data []byte) {
+		format := formats[int(formatIndex)%len(formats)]
+		cfg, err := zeroconfig.UnmarshalStrict(data, format)
+		if err == nil && cfg == nil {
+			t.Fatal("UnmarshalStrict returned neither a config nor an error")
+		}
+		_, _ = zeroconfig.LoadReader(bytes.NewReader(data), format)
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// logfmtMessageKey is the key used for the message field in logfmt output, regardless of zerolog.MessageFieldName.
+const logfmtMessageKey = "msg"
+
+// logfmtWriter converts JSON events to logfmt lines (key=value pairs separated by spaces).
+//
+// The timestamp, level and message are written first, followed by the other fields in their original order.
+// Events that aren't valid JSON objects are passed through unmodified.
+type logfmtWriter struct {
+	zerolog.LevelWriter
+}
+
+func newLogfmtWriter(writer io.Writer) *logfmtWriter {
+	return &logfmtWriter{LevelWriter: asLevelWriter(writer)}
+}
+
+func (lw *logfmtWriter) Write(p []byte) (n int, err error) {
+	return lw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (lw *logfmtWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	obj, err := parseJSONObject(p)
+	if err != nil {
+		return lw.LevelWriter.WriteLevel(level, p)
+	}
+	out := make([]byte, 0, len(p))
+	leading := [...]struct{ field, key string }{
+		{zerolog.TimestampFieldName, zerolog.TimestampFieldName},
+		{zerolog.LevelFieldName, zerolog.LevelFieldName},
+		{zerolog.MessageFieldName, logfmtMessageKey},
+	}
+	for _, lead := range leading {
+		if i := obj.index(lead.field); i >= 0 {
+			out = appendLogfmtPair(out, lead.key, obj[i].Value)
+			obj = append(obj[:i], obj[i+1:]...)
+		}
+	}
+	for _, field := range obj {
+		out = appendLogfmtPair(out, field.Key, field.Value)
+	}
+	out = append(out, '\n')
+	_, err = lw.LevelWriter.WriteLevel(level, out)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func appendLogfmtPair(dst []byte, key string, value json.RawMessage) []byte {
+	if len(dst) > 0 {
+		dst = append(dst, ' ')
+	}
+	dst = appendLogfmtKey(dst, key)
+	dst = append(dst, '=')
+	var str string
+	if len(value) > 0 && value[0] == '"' && json.Unmarshal(value, &str) == nil {
+		return appendLogfmtValue(dst, str)
+	}
+	// Numbers, booleans and null are written as-is, objects and arrays as compact JSON.
+	var buf bytes.Buffer
+	if json.Compact(&buf, value) == nil {
+		value = buf.Bytes()
+	}
+	return appendLogfmtValue(dst, string(value))
+}
+
+// appendLogfmtKey appends a key, replacing characters that aren't allowed in logfmt keys with underscores.
+func appendLogfmtKey(dst []byte, key string) []byte {
+	if key == "" {
+		return append(dst, '_')
+	}
+	for _, r := range key {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			dst = append(dst, '_')
+		} else {
+			dst = append(dst, string(r)...)
+		}
+	}
+	return dst
+}
+
+// appendLogfmtValue appends a value, quoting it if it's empty or contains spaces, equals signs,
+// quotes or control characters.
+func appendLogfmtValue(dst []byte, value string) []byte {
+	if value != "" && strings.IndexFunc(value, needsLogfmtQuoting) < 0 {
+		return append(dst, value...)
+	}
+	return appendJSONString(dst, value)
+}
+
+func needsLogfmtQuoting(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == 0x7f
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_Logfmt(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	log := compile(t, `{
+	  "writers": [{"type": "stdout", "format": "logfmt"}],
+	  "metadata": {"service": "meow bridge"}
+	}`, zeroconfig.WithClock(frozenClock))
+
+	log.Info().
+		Str("user", "meow").
+		Str("query", "a=b").
+		Str("quote", `say "hi"`).
+		Str("empty", "").
+		Int("count", 5).
+		Bool("ok", true).
+		Interface("tags", []string{"a", "b"}).
+		Msg("Hello world")
+	assert.Equal(t, `time=2023-03-14T15:09:26Z level=info msg="Hello world" service="meow bridge" user=meow query="a=b" quote="say \"hi\"" empty="" count=5 ok=true tags="[\"a\",\"b\"]"`+"\n", stdout.String())
+
+	stdout.Reset()
+	log.Warn().Str("line\nbreak", "multi\nline").Send()
+	assert.Equal(t, `time=2023-03-14T15:09:26Z level=warn service="meow bridge" line_break="multi\nline"`+"\n", stdout.String())
+}
+
+func TestWriterConfig_Compile_LogfmtInvalid(t *testing.T) {
+	_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, Format: zeroconfig.LogFormatLogfmt, HTMLSafe: true}).Compile()
+	assert.EqualError(t, err, "html_safe is only supported with the json format")
+
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	writer, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, Format: zeroconfig.LogFormatLogfmt}).Compile()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("not json\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "not json\n", stdout.String(), "Invalid events should be passed through")
+}
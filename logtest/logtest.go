@@ -0,0 +1,294 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package logtest contains helpers for testing zerolog output, such as normalizing
+// non-deterministic values, comparing JSON lines structurally and golden files.
+package logtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// Placeholders that non-deterministic values are replaced with by Normalize.
+const (
+	TimestampPlaceholder = "<timestamp>"
+	CallerPlaceholder    = "<caller>"
+	DurationPlaceholder  = "<duration>"
+)
+
+// Options contains options for Normalize. The zero value normalizes timestamps, callers and the default duration fields.
+type Options struct {
+	KeepTimestamps bool
+	KeepCallers    bool
+	KeepDurations  bool
+	// Fields in JSON lines that contain durations. Defaults to duration and elapsed.
+	DurationFields []string
+}
+
+var defaultDurationFields = []string{"duration", "elapsed"}
+
+var (
+	prettyTimestampRegex = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`)
+	prettyCallerRegex    = regexp.MustCompile(`(?:[\w.\-]+/)*[\w.\-]+\.go:\d+`)
+	prettyDurationRegex  = regexp.MustCompile(`\b(?:\d+(?:\.\d+)?(?:ns|µs|us|ms|s|m|h))+\b`)
+)
+
+// Normalize replaces non-deterministic values in log output with placeholders.
+//
+// Lines that are JSON objects have the timestamp, caller and duration fields replaced (keeping the key order),
+// while other lines (e.g. pretty output) have anything that looks like a timestamp at the start of the line,
+// a file:line caller or a Go duration replaced.
+func Normalize(b []byte, opts Options) []byte {
+	durationFields := opts.DurationFields
+	if durationFields == nil {
+		durationFields = defaultDurationFields
+	}
+	lines := bytes.SplitAfter(b, []byte{'\n'})
+	var out []byte
+	for _, line := range lines {
+		content := bytes.TrimSuffix(line, []byte{'\n'})
+		newline := len(content) != len(line)
+		if obj, err := parseObject(content); err == nil {
+			for i, field := range obj {
+				switch {
+				case !opts.KeepTimestamps && field.key == zerolog.TimestampFieldName:
+					obj[i].value = json.RawMessage(`"` + TimestampPlaceholder + `"`)
+				case !opts.KeepCallers && field.key == zerolog.CallerFieldName:
+					obj[i].value = json.RawMessage(`"` + CallerPlaceholder + `"`)
+				case !opts.KeepDurations && contains(durationFields, field.key):
+					obj[i].value = json.RawMessage(`"` + DurationPlaceholder + `"`)
+				}
+			}
+			content = obj.marshal()
+		} else {
+			if !opts.KeepTimestamps {
+				content = prettyTimestampRegex.ReplaceAll(content, []byte(TimestampPlaceholder))
+			}
+			if !opts.KeepCallers {
+				content = prettyCallerRegex.ReplaceAll(content, []byte(CallerPlaceholder))
+			}
+			if !opts.KeepDurations {
+				content = prettyDurationRegex.ReplaceAll(content, []byte(DurationPlaceholder))
+			}
+		}
+		out = append(out, content...)
+		if newline {
+			out = append(out, '\n')
+		}
+	}
+	return out
+}
+
+func contains(list []string, item string) bool {
+	for _, val := range list {
+		if val == item {
+			return true
+		}
+	}
+	return false
+}
+
+type field struct {
+	key   string
+	value json.RawMessage
+}
+
+type object []field
+
+func parseObject(data []byte) (object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	var obj object
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			return nil, err
+		}
+		obj = append(obj, field{key: tok.(string), value: val})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	} else if dec.More() {
+		return nil, fmt.Errorf("trailing data after object")
+	}
+	return obj, nil
+}
+
+func (obj object) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range obj {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(field.value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// Matcher is a custom matcher for a field value in AssertJSONLines.
+type Matcher interface {
+	// Match checks the value of the field, which has been decoded with json.Decoder.UseNumber.
+	// If the value doesn't match, the returned error describes why.
+	Match(value any) error
+}
+
+type ignoreMatcher struct{}
+
+func (ignoreMatcher) Match(any) error {
+	return nil
+}
+
+// Ignore matches any value. The field must still be present.
+var Ignore Matcher = ignoreMatcher{}
+
+type regexMatcher struct {
+	regex *regexp.Regexp
+}
+
+func (rm regexMatcher) Match(value any) error {
+	var str string
+	switch typedValue := value.(type) {
+	case string:
+		str = typedValue
+	case json.Number:
+		str = typedValue.String()
+	default:
+		return fmt.Errorf("expected string or number matching %s, got %T", rm.regex, value)
+	}
+	if !rm.regex.MatchString(str) {
+		return fmt.Errorf("%q doesn't match %s", str, rm.regex)
+	}
+	return nil
+}
+
+// Regex matches string and number values against the given regular expression.
+func Regex(pattern string) Matcher {
+	return regexMatcher{regex: regexp.MustCompile(pattern)}
+}
+
+// Line is an expected JSON log line for AssertJSONLines. Values can be Matchers or any JSON-serializable value.
+type Line map[string]any
+
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var val any
+	err := dec.Decode(&val)
+	return val, err
+}
+
+// AssertJSONLines checks that got contains exactly the wanted JSON lines, in order.
+//
+// Each line must have exactly the keys in the corresponding Line. Matcher values are used as-is,
+// other values are compared to the decoded field after normalizing both through JSON.
+func AssertJSONLines(t testing.TB, got []byte, want ...Line) bool {
+	t.Helper()
+	lines := bytes.Split(bytes.TrimSuffix(got, []byte{'\n'}), []byte{'\n'})
+	if len(got) == 0 {
+		lines = nil
+	}
+	ok := true
+	if len(lines) != len(want) {
+		t.Errorf("Expected %d JSON lines, got %d:\n%s", len(want), len(lines), got)
+		ok = false
+	}
+	for i := 0; i < len(lines) && i < len(want); i++ {
+		decoded, err := decodeJSON(lines[i])
+		obj, isObject := decoded.(map[string]any)
+		if err != nil || !isObject {
+			t.Errorf("Line #%d is not a JSON object: %s", i+1, lines[i])
+			ok = false
+			continue
+		}
+		for key, expected := range want[i] {
+			actual, exists := obj[key]
+			if !exists {
+				t.Errorf("Line #%d is missing field %q: %s", i+1, key, lines[i])
+				ok = false
+			} else if err = matchValue(expected, actual); err != nil {
+				t.Errorf("Line #%d field %q: %v", i+1, key, err)
+				ok = false
+			}
+		}
+		for key := range obj {
+			if _, expected := want[i][key]; !expected {
+				t.Errorf("Line #%d has unexpected field %q: %s", i+1, key, lines[i])
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+func matchValue(expected, actual any) error {
+	if matcher, ok := expected.(Matcher); ok {
+		return matcher.Match(actual)
+	}
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return fmt.Errorf("failed to marshal expected value: %w", err)
+	}
+	normalized, err := decodeJSON(data)
+	if err != nil {
+		return err
+	} else if !reflect.DeepEqual(normalized, actual) {
+		return fmt.Errorf("expected %s, got %v", data, actual)
+	}
+	return nil
+}
+
+// UpdateEnv is the environment variable that makes Golden write golden files instead of comparing them.
+// An environment variable is used instead of a flag, so that importing this package doesn't conflict
+// with an -update flag defined by the test package itself.
+const UpdateEnv = "LOGTEST_UPDATE"
+
+// Golden compares got to the contents of testdata/<name>.golden. When the test is run with LOGTEST_UPDATE=1,
+// the file is written instead. Non-deterministic values should be removed with Normalize first.
+func Golden(t testing.TB, name string, got []byte) bool {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if update, _ := strconv.ParseBool(os.Getenv(UpdateEnv)); update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create golden file directory: %v", err)
+		} else if err = os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+		return true
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with LOGTEST_UPDATE=1 to create it): %v", err)
+		return false
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("Output doesn't match golden file %s (run with LOGTEST_UPDATE=1 to update it)\nwant:\n%s\ngot:\n%s", path, want, got)
+		return false
+	}
+	return true
+}
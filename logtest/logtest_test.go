@@ -0,0 +1,135 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package logtest_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig/logtest"
+)
+
+func TestNormalize_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf).With().Timestamp().Caller().Logger()
+	log.Info().Dur("duration", 1234*time.Millisecond).Dur("elapsed", time.Second).Int("count", 5).Msg("meow")
+	assert.Equal(t,
+		`{"level":"info","duration":"<duration>","elapsed":"<duration>","count":5,"time":"<timestamp>","caller":"<caller>","message":"meow"}`+"\n",
+		string(logtest.Normalize(buf.Bytes(), logtest.Options{})),
+		"Timestamps, callers and durations should be replaced without reordering keys",
+	)
+
+	out := logtest.Normalize(buf.Bytes(), logtest.Options{KeepCallers: true, DurationFields: []string{"elapsed"}})
+	assert.NotContains(t, string(out), logtest.CallerPlaceholder)
+	assert.Contains(t, string(out), `"duration":1234,`, "Only the configured duration fields should be replaced")
+	assert.Contains(t, string(out), `"elapsed":"<duration>"`)
+}
+
+func TestNormalize_Pretty(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		opts     logtest.Options
+	}{
+		{"2023-04-05T12:34:56.789+03:00 INF meow\n", "<timestamp> INF meow\n", logtest.Options{}},
+		{"2023-04-05T12:34:56Z INF meow\n", "2023-04-05T12:34:56Z INF meow\n", logtest.Options{KeepTimestamps: true}},
+		{"2023-04-05 12:34:56 DBG zeroconfig/config.go:123 > meow", "<timestamp> DBG <caller> > meow", logtest.Options{}},
+		{"<nil> WRN slow duration=1.5ms took=1h2m3.5s size=10MB", "<nil> WRN slow duration=<duration> took=<duration> size=10MB", logtest.Options{}},
+		{"<nil> WRN slow duration=1.5ms", "<nil> WRN slow duration=1.5ms", logtest.Options{KeepDurations: true}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, string(logtest.Normalize([]byte(test.input), test.opts)))
+	}
+}
+
+// recordingT records errors instead of failing the test, so that failing assertions can be tested.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (rt *recordingT) Helper() {}
+
+func (rt *recordingT) Errorf(format string, args ...any) {
+	rt.errors = append(rt.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertJSONLines(t *testing.T) {
+	got := []byte(`{"level":"info","time":"2023-04-05T12:34:56Z","count":5,"nested":{"a":[1,2]},"message":"meow"}
+{"level":"error","id":"abc-123","message":"meow #2"}
+`)
+	assert.True(t, logtest.AssertJSONLines(t, got,
+		logtest.Line{"level": "info", "time": logtest.Ignore, "count": 5, "nested": map[string]any{"a": []int{1, 2}}, "message": "meow"},
+		logtest.Line{"level": "error", "id": logtest.Regex(`^abc-\d+$`), "message": logtest.Regex("^meow")},
+	))
+
+	tests := []struct {
+		name  string
+		want  []logtest.Line
+		count int
+	}{
+		{"WrongValue", []logtest.Line{
+			{"level": "info", "time": logtest.Ignore, "count": 6, "nested": logtest.Ignore, "message": "meow"},
+			{"level": "error", "id": logtest.Ignore, "message": "meow #2"},
+		}, 1},
+		{"MissingAndExtraField", []logtest.Line{
+			{"level": "info", "time": logtest.Ignore, "count": 5, "message": "meow", "extra": logtest.Ignore},
+			{"level": "error", "id": logtest.Ignore, "message": "meow #2"},
+		}, 2},
+		{"RegexMismatch", []logtest.Line{
+			{"level": "info", "time": logtest.Regex(`^\d{4}$`), "count": logtest.Regex(`^5$`), "nested": logtest.Regex(".*"), "message": "meow"},
+			{"level": "error", "id": logtest.Ignore, "message": "meow #2"},
+		}, 2},
+		{"LineCount", []logtest.Line{
+			{"level": "info", "time": logtest.Ignore, "count": 5, "nested": logtest.Ignore, "message": "meow"},
+		}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rt := &recordingT{TB: t}
+			assert.False(t, logtest.AssertJSONLines(rt, got, test.want...))
+			assert.Len(t, rt.errors, test.count, "Unexpected errors: %v", rt.errors)
+		})
+	}
+
+	rt := &recordingT{TB: t}
+	assert.False(t, logtest.AssertJSONLines(rt, []byte("not json\n"), logtest.Line{}))
+	assert.True(t, logtest.AssertJSONLines(t, nil), "Empty output should match no lines")
+}
+
+func TestGolden(t *testing.T) {
+	t.Setenv(logtest.UpdateEnv, "")
+	logtest.Golden(t, "golden", []byte("<timestamp> INF meow\n"))
+	rt := &recordingT{TB: t}
+	assert.False(t, logtest.Golden(rt, "golden", []byte("<timestamp> INF meow #2\n")))
+	assert.Len(t, rt.errors, 1)
+}
+
+func TestGolden_Update(t *testing.T) {
+	assert.Nil(t, flag.Lookup("update"), "logtest shouldn't register flags that conflict with the importing package")
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(t.TempDir()))
+	defer func() {
+		require.NoError(t, os.Chdir(wd))
+	}()
+	t.Setenv(logtest.UpdateEnv, "1")
+	assert.True(t, logtest.Golden(t, "updated", []byte("meow\n")))
+	data, err := os.ReadFile(filepath.Join("testdata", "updated.golden"))
+	require.NoError(t, err)
+	assert.Equal(t, "meow\n", string(data))
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// MemoryConfig contains the configuration for the memory writer type.
+type MemoryConfig struct {
+	// Maximum number of lines to keep. Defaults to DefaultMemoryCapacity.
+	Capacity int `json:"capacity,omitempty" yaml:"capacity,omitempty" toml:"capacity,omitempty"`
+	// Maximum age of lines to keep. Older lines are left out even if the buffer isn't full. Unlimited by default.
+	MaxAge time.Duration `json:"max_age,omitempty" yaml:"max_age,omitempty" toml:"max_age,omitempty"`
+}
+
+func (mc *MemoryConfig) UnmarshalJSON(data []byte) error {
+	type plainMemoryConfig MemoryConfig
+	aux := struct {
+		*plainMemoryConfig
+		MaxAge json.RawMessage `json:"max_age,omitempty"`
+	}{plainMemoryConfig: (*plainMemoryConfig)(mc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.MaxAge, &mc.MaxAge); err != nil {
+		return &PathError{Path: "max_age", Err: err}
+	}
+	return nil
+}
+
+// DefaultMemoryCapacity is the number of lines kept by the memory writer if MemoryConfig.Capacity isn't set.
+const DefaultMemoryCapacity = 1000
+
+// MemoryBuffer is the output of the memory writer type. It keeps the most recent lines written to it,
+// dropping the oldest ones when it's full or when they're older than the max age. It's safe for concurrent use.
+//
+// Use Handle.Writers or Config.CompileWithWriters to get the buffer of a compiled config.
+type MemoryBuffer struct {
+	lock   sync.Mutex
+	lines  ring[memoryLine]
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+type memoryLine struct {
+	written time.Time
+	data    []byte
+}
+
+// NewMemoryBuffer creates a memory buffer that keeps up to the given number of lines.
+func NewMemoryBuffer(capacity int) *MemoryBuffer {
+	return NewMemoryBufferMaxAge(capacity, 0)
+}
+
+// NewMemoryBufferMaxAge creates a memory buffer that keeps up to the given number of lines,
+// leaving out lines that were written longer than maxAge ago. A zero maxAge keeps lines regardless of age.
+func NewMemoryBufferMaxAge(capacity int, maxAge time.Duration) *MemoryBuffer {
+	return &MemoryBuffer{lines: newRing[memoryLine](capacity), maxAge: maxAge, now: time.Now}
+}
+
+func compileMemory(wc *WriterConfig) (io.Writer, error) {
+	capacity := DefaultMemoryCapacity
+	var maxAge time.Duration
+	if wc.Memory != nil {
+		if wc.Memory.Capacity != 0 {
+			capacity = wc.Memory.Capacity
+		}
+		maxAge = wc.Memory.MaxAge
+	}
+	if capacity < 0 {
+		return nil, fmt.Errorf("memory.capacity must not be negative")
+	} else if maxAge < 0 {
+		return nil, fmt.Errorf("memory.max_age must not be negative")
+	}
+	return NewMemoryBufferMaxAge(capacity, maxAge), nil
+}
+
+// Write stores a copy of the line, without the trailing newline.
+func (mb *MemoryBuffer) Write(p []byte) (n int, err error) {
+	line := memoryLine{data: append([]byte(nil), bytes.TrimSuffix(p, []byte{'\n'})...)}
+	mb.lock.Lock()
+	line.written = mb.now()
+	mb.lines.push(line)
+	mb.lock.Unlock()
+	return len(p), nil
+}
+
+// Lines returns copies of the lines in the buffer, oldest first.
+func (mb *MemoryBuffer) Lines() [][]byte {
+	mb.lock.Lock()
+	entries := mb.lines.list()
+	var cutoff time.Time
+	if mb.maxAge > 0 {
+		cutoff = mb.now().Add(-mb.maxAge)
+	}
+	mb.lock.Unlock()
+	lines := make([][]byte, 0, len(entries))
+	for _, line := range entries {
+		if line.written.Before(cutoff) {
+			continue
+		}
+		lines = append(lines, append([]byte(nil), line.data...))
+	}
+	return lines
+}
+
+// WriteTo writes the lines in the buffer to the given writer, oldest first, each followed by a newline.
+// It's meant for attaching recent logs to crash reports.
+func (mb *MemoryBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	for _, line := range mb.Lines() {
+		written, err := w.Write(append(line, '\n'))
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Reset removes all lines from the buffer.
+func (mb *MemoryBuffer) Reset() {
+	mb.lock.Lock()
+	mb.lines = newRing[memoryLine](len(mb.lines.items))
+	mb.lock.Unlock()
+}
+
+// ring keeps the most recent items, overwriting the oldest ones when full. It's not safe for concurrent use.
+type ring[T any] struct {
+	items []T
+	// The index of the oldest item once the ring is full.
+	next int
+	full bool
+}
+
+func newRing[T any](capacity int) ring[T] {
+	return ring[T]{items: make([]T, capacity)}
+}
+
+func (r *ring[T]) push(item T) {
+	if len(r.items) == 0 {
+		return
+	}
+	r.items[r.next] = item
+	r.next++
+	if r.next == len(r.items) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// list returns the items in a new slice, oldest first.
+func (r *ring[T]) list() []T {
+	var items []T
+	if r.full {
+		items = append(items, r.items[r.next:]...)
+	}
+	return append(items, r.items[:r.next]...)
+}
+
+// resize changes the capacity of the ring, keeping the newest items that fit.
+func (r *ring[T]) resize(capacity int) {
+	if capacity == len(r.items) {
+		return
+	}
+	items := r.list()
+	if len(items) > capacity {
+		items = items[len(items)-capacity:]
+	}
+	*r = newRing[T](capacity)
+	for _, item := range items {
+		r.push(item)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestWriterConfig_Compile_Memory(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	cfg := parseConfig(t, `{
+	  "writers": [
+	    {"type": "stdout", "min_level": "warn"},
+	    {"type": "memory", "memory": {"capacity": 3}}
+	  ],
+	  "min_level": "debug",
+	  "timestamp": false
+	}`)
+	log, writers, err := cfg.CompileWithWriters()
+	require.NoError(t, err)
+	buffer, ok := writers[1].(*zeroconfig.MemoryBuffer)
+	require.True(t, ok, "The output of memory writers should be a *MemoryBuffer")
+	assert.Empty(t, buffer.Lines())
+
+	for i := 1; i <= 4; i++ {
+		log.Debug().Int("i", i).Msg("meow")
+	}
+	log.Error().Msg("crash")
+	assert.Equal(t, [][]byte{
+		[]byte(`{"level":"debug","i":3,"message":"meow"}`),
+		[]byte(`{"level":"debug","i":4,"message":"meow"}`),
+		[]byte(`{"level":"error","message":"crash"}`),
+	}, buffer.Lines(), "The oldest lines should be dropped, including ones below other writers' min level")
+	assert.Equal(t, `{"level":"error","message":"crash"}`+"\n", stdout.String())
+
+	var dump bytes.Buffer
+	n, err := buffer.WriteTo(&dump)
+	require.NoError(t, err)
+	assert.EqualValues(t, dump.Len(), n)
+	assert.Equal(t, `{"level":"debug","i":3,"message":"meow"}`+"\n"+
+		`{"level":"debug","i":4,"message":"meow"}`+"\n"+
+		`{"level":"error","message":"crash"}`+"\n", dump.String())
+
+	buffer.Reset()
+	assert.Empty(t, buffer.Lines())
+}
+
+func TestMemoryBuffer_Concurrent(t *testing.T) {
+	buffer := zeroconfig.NewMemoryBuffer(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = fmt.Fprintf(buffer, "%d-%d\n", i, j)
+				buffer.Lines()
+			}
+		}(i)
+	}
+	wg.Wait()
+	lines := buffer.Lines()
+	assert.Len(t, lines, 10)
+	for _, line := range lines {
+		assert.Regexp(t, `^\d-\d+$`, string(line))
+	}
+}
+
+func TestWriterConfig_Compile_MemoryInvalid(t *testing.T) {
+	_, err := parseConfig(t, `{"writers": [{"type": "memory", "memory": {"capacity": -1}}]}`).Compile()
+	assert.EqualError(t, err, "writers[0].memory.capacity: must not be negative")
+}
+
+func TestMemoryBuffer_MaxAge(t *testing.T) {
+	var cfg zeroconfig.MemoryConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"capacity": 3, "max_age": "1m"}`), &cfg))
+	assert.Equal(t, time.Minute, cfg.MaxAge)
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	buffer := zeroconfig.NewMemoryBufferMaxAge(cfg.Capacity, cfg.MaxAge)
+	zeroconfig.SetMemoryClock(buffer, func() time.Time {
+		return now
+	})
+	for i := 1; i <= 4; i++ {
+		_, _ = fmt.Fprintf(buffer, "%d\n", i)
+		now = now.Add(20 * time.Second)
+	}
+	assert.Equal(t, [][]byte{[]byte("2"), []byte("3"), []byte("4")}, buffer.Lines(), "The capacity should be hit first")
+
+	now = now.Add(20 * time.Second)
+	assert.Equal(t, [][]byte{[]byte("3"), []byte("4")}, buffer.Lines(), "Lines older than max_age should be left out")
+	var dump bytes.Buffer
+	now = now.Add(20 * time.Second)
+	_, err := buffer.WriteTo(&dump)
+	require.NoError(t, err)
+	assert.Equal(t, "4\n", dump.String())
+}
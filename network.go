@@ -0,0 +1,268 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// NetworkConfig contains the configuration for the network writer type.
+type NetworkConfig struct {
+	// The network to use: tcp, tcp4, tcp6, udp, udp4 or udp6.
+	Network string `json:"network" yaml:"network" toml:"network"`
+	// The address to connect to, like collector:5170.
+	Address string `json:"address" yaml:"address" toml:"address"`
+	// Timeout for writing a single event. Defaults to DefaultNetworkWriteTimeout.
+	WriteTimeout time.Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty" toml:"write_timeout,omitempty"`
+	// Timeout for connecting. Defaults to WriteTimeout.
+	DialTimeout time.Duration `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty" toml:"dial_timeout,omitempty"`
+	// Don't connect until the first event is written. By default, the connection is made when compiling,
+	// so that an unreachable collector is reported as a compile error.
+	LazyConnect bool `json:"lazy_connect,omitempty" yaml:"lazy_connect,omitempty" toml:"lazy_connect,omitempty"`
+	// When a write fails, reconnect immediately and send the event again once before dropping it.
+	// This means a collector restart doesn't lose events, but an event may be received twice
+	// if part of it was sent before the failure.
+	ReconnectOnError bool `json:"reconnect_on_error,omitempty" yaml:"reconnect_on_error,omitempty" toml:"reconnect_on_error,omitempty"`
+	// How long to wait before reconnecting after the connection is lost. The wait is doubled after each failed
+	// attempt, up to MaxReconnectBackoff. Defaults to 100ms and 30s respectively.
+	ReconnectBackoff    time.Duration `json:"reconnect_backoff,omitempty" yaml:"reconnect_backoff,omitempty" toml:"reconnect_backoff,omitempty"`
+	MaxReconnectBackoff time.Duration `json:"max_reconnect_backoff,omitempty" yaml:"max_reconnect_backoff,omitempty" toml:"max_reconnect_backoff,omitempty"`
+}
+
+// DefaultNetworkWriteTimeout is the write timeout used by the network writer if NetworkConfig.WriteTimeout isn't set.
+const DefaultNetworkWriteTimeout = 5 * time.Second
+
+const (
+	defaultNetworkReconnectBackoff    = 100 * time.Millisecond
+	defaultNetworkMaxReconnectBackoff = 30 * time.Second
+)
+
+func (nc *NetworkConfig) UnmarshalJSON(data []byte) error {
+	type plainNetworkConfig NetworkConfig
+	aux := struct {
+		*plainNetworkConfig
+		WriteTimeout        json.RawMessage `json:"write_timeout,omitempty"`
+		DialTimeout         json.RawMessage `json:"dial_timeout,omitempty"`
+		ReconnectBackoff    json.RawMessage `json:"reconnect_backoff,omitempty"`
+		MaxReconnectBackoff json.RawMessage `json:"max_reconnect_backoff,omitempty"`
+	}{plainNetworkConfig: (*plainNetworkConfig)(nc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.WriteTimeout, &nc.WriteTimeout); err != nil {
+		return &PathError{Path: "write_timeout", Err: err}
+	} else if err = parseDurationJSON(aux.DialTimeout, &nc.DialTimeout); err != nil {
+		return &PathError{Path: "dial_timeout", Err: err}
+	} else if err = parseDurationJSON(aux.ReconnectBackoff, &nc.ReconnectBackoff); err != nil {
+		return &PathError{Path: "reconnect_backoff", Err: err}
+	} else if err = parseDurationJSON(aux.MaxReconnectBackoff, &nc.MaxReconnectBackoff); err != nil {
+		return &PathError{Path: "max_reconnect_backoff", Err: err}
+	}
+	return nil
+}
+
+// dropCounter is implemented by outputs that discard events they fail to deliver.
+type dropCounter interface {
+	droppedEvents() uint64
+}
+
+// networkWriter writes events to a TCP or UDP connection.
+//
+// Writes are synchronous. When the connection is lost, it's reconnected on a later write after a backoff,
+// and events written before the reconnection are dropped.
+type networkWriter struct {
+	network      string
+	address      string
+	writeTimeout time.Duration
+	dialTimeout  time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	retry        bool
+	tlsConfig    *tls.Config
+
+	lock      sync.Mutex
+	conn      net.Conn
+	backoff   time.Duration
+	nextRetry time.Time
+	closed    bool
+	dropped   atomic.Uint64
+
+	deliveryHealth
+}
+
+func compileNetwork(wc *WriterConfig) (io.Writer, error) {
+	cfg := wc.Net
+	if cfg == nil || cfg.Address == "" {
+		return nil, fmt.Errorf("net.address is required for the network writer")
+	}
+	switch cfg.Network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	case "":
+		return nil, fmt.Errorf("net.network is required for the network writer")
+	default:
+		return nil, fmt.Errorf("unsupported network %q for the network writer", cfg.Network)
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid net.address: %w", err)
+	} else if cfg.WriteTimeout < 0 || cfg.DialTimeout < 0 || cfg.ReconnectBackoff < 0 || cfg.MaxReconnectBackoff < 0 {
+		return nil, fmt.Errorf("net.write_timeout, net.dial_timeout, net.reconnect_backoff and net.max_reconnect_backoff must not be negative")
+	}
+	// Collectors expect one JSON object per line, which the pretty formats don't produce.
+	if format, _ := ParseLogFormat(string(wc.Format)); format != "" && format != LogFormatJSON {
+		return nil, fmt.Errorf("the network writer only supports the json format")
+	}
+	return newNetworkWriter(cfg, nil)
+}
+
+// newNetworkWriter creates a network writer from an already validated config, applying defaults.
+// If tlsConfig is set, TCP connections are wrapped in TLS.
+func newNetworkWriter(cfg *NetworkConfig, tlsConfig *tls.Config) (*networkWriter, error) {
+	nw := &networkWriter{
+		network:      cfg.Network,
+		address:      cfg.Address,
+		writeTimeout: cfg.WriteTimeout,
+		dialTimeout:  cfg.DialTimeout,
+		minBackoff:   cfg.ReconnectBackoff,
+		maxBackoff:   cfg.MaxReconnectBackoff,
+		retry:        cfg.ReconnectOnError,
+		tlsConfig:    tlsConfig,
+	}
+	if nw.writeTimeout == 0 {
+		nw.writeTimeout = DefaultNetworkWriteTimeout
+	}
+	if nw.dialTimeout == 0 {
+		nw.dialTimeout = nw.writeTimeout
+	}
+	if nw.minBackoff == 0 {
+		nw.minBackoff = defaultNetworkReconnectBackoff
+	}
+	if nw.maxBackoff == 0 {
+		nw.maxBackoff = defaultNetworkMaxReconnectBackoff
+	}
+	if nw.maxBackoff < nw.minBackoff {
+		nw.maxBackoff = nw.minBackoff
+	}
+	nw.backoff = nw.minBackoff
+	if !cfg.LazyConnect {
+		conn, err := nw.dial()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", nw.address, err)
+		}
+		nw.conn = conn
+	}
+	return nw, nil
+}
+
+func (nw *networkWriter) dial() (net.Conn, error) {
+	if nw.tlsConfig != nil {
+		return tls.DialWithDialer(&net.Dialer{Timeout: nw.dialTimeout}, nw.network, nw.address, nw.tlsConfig)
+	}
+	return net.DialTimeout(nw.network, nw.address, nw.dialTimeout)
+}
+
+// connect dials the address unless a previous attempt failed too recently. The lock must be held.
+func (nw *networkWriter) connect() error {
+	now := time.Now()
+	if now.Before(nw.nextRetry) {
+		return fmt.Errorf("waiting to reconnect")
+	}
+	conn, err := nw.dial()
+	if err != nil {
+		nw.nextRetry = now.Add(nw.backoff)
+		nw.backoff *= 2
+		if nw.backoff > nw.maxBackoff {
+			nw.backoff = nw.maxBackoff
+		}
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	nw.conn = conn
+	nw.backoff = nw.minBackoff
+	return nil
+}
+
+func (nw *networkWriter) Write(p []byte) (n int, err error) {
+	return nw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (nw *networkWriter) WriteLevel(_ zerolog.Level, p []byte) (n int, err error) {
+	if err = nw.writeParts(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeParts sends a single event that consists of one or more writes, e.g. UDP datagrams.
+// Delivery failures drop the event instead of returning an error.
+func (nw *networkWriter) writeParts(parts ...[]byte) (err error) {
+	nw.lock.Lock()
+	defer nw.lock.Unlock()
+	if nw.closed {
+		return errors.New("network writer is closed")
+	}
+	if nw.conn == nil {
+		if err = nw.connect(); err != nil {
+			nw.dropped.Add(1)
+			nw.setHealth(err)
+			return nil
+		}
+	}
+	err = nw.send(parts)
+	if err != nil && nw.retry {
+		// Retry once with a fresh connection, so that a restarted collector doesn't lose the event.
+		nw.nextRetry = time.Time{}
+		if err = nw.connect(); err == nil {
+			err = nw.send(parts)
+		}
+	}
+	if err != nil {
+		// By default, the event is dropped rather than retried, as part of it may have been sent already.
+		nw.dropped.Add(1)
+		nw.setHealth(err)
+		return nil
+	}
+	nw.setHealth(nil)
+	return nil
+}
+
+// send writes an event to the current connection, discarding the connection if it fails. The lock must be held.
+func (nw *networkWriter) send(parts [][]byte) error {
+	_ = nw.conn.SetWriteDeadline(time.Now().Add(nw.writeTimeout))
+	for _, part := range parts {
+		if _, err := nw.conn.Write(part); err != nil {
+			_ = nw.conn.Close()
+			nw.conn = nil
+			return fmt.Errorf("failed to send event: %w", err)
+		}
+	}
+	return nil
+}
+
+func (nw *networkWriter) droppedEvents() uint64 {
+	return nw.dropped.Load()
+}
+
+// Close closes the connection. Writes after closing return an error.
+func (nw *networkWriter) Close() error {
+	nw.lock.Lock()
+	defer nw.lock.Unlock()
+	nw.closed = true
+	if nw.conn == nil {
+		return nil
+	}
+	err := nw.conn.Close()
+	nw.conn = nil
+	return err
+}
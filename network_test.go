@@ -0,0 +1,198 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// lineCollector accepts a single connection and sends each line received from it to a channel.
+type lineCollector struct {
+	listener net.Listener
+	lines    chan string
+	conn     chan net.Conn
+}
+
+func newLineCollector(t *testing.T, addr string) *lineCollector {
+	listener, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	lc := &lineCollector{listener: listener, lines: make(chan string, 100), conn: make(chan net.Conn, 1)}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(lc.conn)
+			return
+		}
+		lc.conn <- conn
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lc.lines <- scanner.Text()
+		}
+	}()
+	return lc
+}
+
+// stop closes the listener and the accepted connection, if any.
+func (lc *lineCollector) stop() {
+	_ = lc.listener.Close()
+	if conn, ok := <-lc.conn; ok {
+		_ = conn.Close()
+	}
+}
+
+func receiveLine(t *testing.T, lines <-chan string) string {
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event")
+		return ""
+	}
+}
+
+func TestWriterConfig_Compile_NetworkTCP(t *testing.T) {
+	collector := newLineCollector(t, "127.0.0.1:0")
+	addr := collector.listener.Addr().String()
+
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "network", "min_level": "info", "net": {"network": "tcp", "address": "`+addr+`", "reconnect_backoff": "10ms"}}],
+	  "timestamp": false
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	log := handle.Logger
+
+	log.Debug().Msg("filtered")
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","message":"meow"}`, receiveLine(t, collector.lines))
+
+	// Stop the collector, which should make writes fail and get dropped.
+	collector.stop()
+	require.Eventually(t, func() bool {
+		log.Info().Msg("lost")
+		return handle.Stats().Writers[0].Dropped > 0
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Error(t, handle.HealthCheck()["writer #1 (network)"])
+
+	// Start it again, which the writer should reconnect to after the backoff.
+	collector = newLineCollector(t, addr)
+	defer collector.stop()
+	require.Eventually(t, func() bool {
+		log.Warn().Msg("back")
+		return handle.HealthCheck()["writer #1 (network)"] == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, `{"level":"warn","message":"back"}`, receiveLine(t, collector.lines))
+}
+
+func TestWriterConfig_Compile_NetworkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeNetwork,
+		Net:  &zeroconfig.NetworkConfig{Network: "udp", Address: conn.LocalAddr().String()},
+	}).Compile()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(`{"message":"meow"}` + "\n"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, `{"message":"meow"}`+"\n", string(buf[:n]), "Each event should be sent as a single datagram")
+}
+
+func TestWriterConfig_Compile_NetworkInvalid(t *testing.T) {
+	tests := map[string]struct {
+		net *zeroconfig.NetworkConfig
+		err string
+	}{
+		"Missing":        {nil, "net.address is required for the network writer"},
+		"NoNetwork":      {&zeroconfig.NetworkConfig{Address: "localhost:1"}, "net.network is required for the network writer"},
+		"UnknownNetwork": {&zeroconfig.NetworkConfig{Network: "unix", Address: "localhost:1"}, `unsupported network "unix" for the network writer`},
+		"NoPort":         {&zeroconfig.NetworkConfig{Network: "tcp", Address: "localhost"}, "invalid net.address: address localhost: missing port in address"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeNetwork, Net: test.net}).Compile()
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestWriterConfig_Compile_NetworkReconnectOnError(t *testing.T) {
+	collector := newLineCollector(t, "127.0.0.1:0")
+	addr := collector.listener.Addr().String()
+
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "net", "net": {"network": "tcp", "address": "`+addr+`", "reconnect_on_error": true}}],
+	  "timestamp": false
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	log := handle.Logger
+
+	log.Info().Msg("meow")
+	assert.Equal(t, `{"level":"info","message":"meow"}`, receiveLine(t, collector.lines))
+
+	// Restart the collector. Writes to the old connection fail, which should reconnect immediately
+	// and send the event again instead of dropping it.
+	collector.stop()
+	collector = newLineCollector(t, addr)
+	defer collector.stop()
+	for i := 0; i < 10; i++ {
+		log.Info().Int("i", i).Msg("back")
+		time.Sleep(5 * time.Millisecond)
+	}
+	line := receiveLine(t, collector.lines)
+	assert.Contains(t, line, `"message":"back"`)
+	assert.Zero(t, handle.Stats().Writers[0].Dropped)
+}
+
+func TestWriterConfig_Compile_NetworkLazyConnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	_, err = (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeNetwork,
+		Net:  &zeroconfig.NetworkConfig{Network: "tcp", Address: addr},
+	}).Compile()
+	assert.ErrorContains(t, err, "failed to connect to "+addr)
+
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeNetwork,
+		Net:  &zeroconfig.NetworkConfig{Network: "tcp", Address: addr, LazyConnect: true},
+	}).Compile()
+	require.NoError(t, err, "Compiling shouldn't connect with lazy_connect")
+	_, err = writer.Write([]byte(`{"message":"meow"}` + "\n"))
+	assert.NoError(t, err, "Events should be dropped while the collector is unreachable")
+}
+
+func TestWriterConfig_Compile_NetworkPretty(t *testing.T) {
+	_, err := (&zeroconfig.WriterConfig{
+		Type:   zeroconfig.WriterTypeNetwork,
+		Format: zeroconfig.LogFormatPretty,
+		Net:    &zeroconfig.NetworkConfig{Network: "tcp", Address: "localhost:1"},
+	}).Compile()
+	assert.EqualError(t, err, "the network writer only supports the json format")
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// notifyWriter rings the terminal bell (and optionally sends a desktop notification)
+// after writing events at or above the configured level.
+//
+// It receives the raw JSON event, so it must be placed before the console writer.
+type notifyWriter struct {
+	zerolog.LevelWriter
+	terminal io.Writer
+	level    zerolog.Level
+	desktop  *desktopNotifier
+}
+
+var bell = []byte{'\a'}
+
+func (nw *notifyWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	n, err = nw.LevelWriter.WriteLevel(level, p)
+	if err != nil || level == zerolog.NoLevel || level < nw.level {
+		return
+	}
+	_, _ = nw.terminal.Write(bell)
+	if nw.desktop != nil {
+		var evt map[string]any
+		if json.Unmarshal(p, &evt) == nil {
+			msg, _ := evt[zerolog.MessageFieldName].(string)
+			nw.desktop.notify(level, msg)
+		}
+	}
+	return
+}
+
+const desktopNotifyQueueSize = 4
+
+// desktopNotifyInterval is the minimum time between two desktop notifications.
+var desktopNotifyInterval = 5 * time.Second
+
+type desktopNotification struct {
+	level zerolog.Level
+	msg   string
+}
+
+// desktopNotifier shows desktop notifications one at a time in a background goroutine, so that a burst of errors
+// doesn't start a process per event. Notifications that arrive while the previous one is being shown or while
+// waiting for desktopNotifyInterval are merged into one: the most severe (and then latest) message is shown along
+// with the number of other events. Notifications that don't fit in the small queue are dropped, but still counted.
+type desktopNotifier struct {
+	queue     chan desktopNotification
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   atomic.Uint64
+}
+
+func newDesktopNotifier() *desktopNotifier {
+	dn := &desktopNotifier{
+		queue: make(chan desktopNotification, desktopNotifyQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go dn.loop(desktopNotifyInterval)
+	return dn
+}
+
+func (dn *desktopNotifier) notify(level zerolog.Level, msg string) {
+	select {
+	case dn.queue <- desktopNotification{level: level, msg: msg}:
+	default:
+		dn.dropped.Add(1)
+	}
+}
+
+func (dn *desktopNotifier) loop(interval time.Duration) {
+	defer close(dn.done)
+	for {
+		var next desktopNotification
+		select {
+		case next = <-dn.queue:
+		case <-dn.stop:
+			return
+		}
+		more := dn.dropped.Swap(0)
+	Merge:
+		for {
+			select {
+			case queued := <-dn.queue:
+				if queued.level >= next.level {
+					next = queued
+				}
+				more++
+			default:
+				break Merge
+			}
+		}
+		body := next.msg
+		if more > 0 {
+			body += fmt.Sprintf(" (and %d more)", more)
+		}
+		sendDesktopNotification(next.level.String(), body)
+		select {
+		case <-time.After(interval):
+		case <-dn.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background goroutine. Pending notifications are discarded.
+func (dn *desktopNotifier) Close() error {
+	dn.closeOnce.Do(func() {
+		close(dn.stop)
+	})
+	<-dn.done
+	return nil
+}
+
+func (dn *desktopNotifier) closeWithHandle() error {
+	return dn.Close()
+}
+
+// desktopNotificationCommand returns the command used to show a desktop notification.
+// Notifications are only supported on macOS (via osascript) and on other unix-like
+// systems that have notify-send installed (usually from libnotify).
+var desktopNotificationCommand = func(title, body string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + strconv.Quote(body) + " with title " + strconv.Quote(title)
+		return exec.Command("osascript", "-e", script)
+	case "windows", "android", "ios", "js", "plan9":
+		return nil
+	default:
+		return exec.Command("notify-send", "--app-name=zerolog", title, body)
+	}
+}
+
+func sendDesktopNotification(level, msg string) {
+	cmd := desktopNotificationCommand("Log event at level "+level, msg)
+	if cmd != nil {
+		// Errors are ignored, the bell was already rung so notifications are best-effort.
+		_ = cmd.Run()
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type fakeNotifications struct {
+	lock   sync.Mutex
+	titles []string
+	bodies []string
+}
+
+func (fn *fakeNotifications) command(title, body string) *exec.Cmd {
+	fn.lock.Lock()
+	fn.titles = append(fn.titles, title)
+	fn.bodies = append(fn.bodies, body)
+	fn.lock.Unlock()
+	// Returning nil skips running a command, like on platforms without notification support.
+	return nil
+}
+
+func (fn *fakeNotifications) get() (titles, bodies []string) {
+	fn.lock.Lock()
+	defer fn.lock.Unlock()
+	return append([]string(nil), fn.titles...), append([]string(nil), fn.bodies...)
+}
+
+func compileNotifyHandle(t *testing.T) *zeroconfig.Handle {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	cfg := parseConfig(t, `{
+	  "writers": [
+	    {"type": "stdout", "format": "pretty", "notify_on_level": "error", "notify_desktop": true}
+	  ],
+	  "timestamp": false
+	}`)
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	return handle
+}
+
+func TestNotifyDesktop_BurstCoalesced(t *testing.T) {
+	var fake fakeNotifications
+	defer zeroconfig.SetDesktopNotifications(fake.command, time.Hour)()
+	handle := compileNotifyHandle(t)
+
+	handle.Logger.Warn().Msg("not notified")
+	for i := 0; i < 100; i++ {
+		handle.Logger.Error().Msgf("meow #%d", i)
+	}
+	assert.Eventually(t, func() bool {
+		titles, _ := fake.get()
+		return len(titles) > 0
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	titles, bodies := fake.get()
+	require.Len(t, titles, 1, "Burst should only show one notification until the interval passes")
+	assert.Equal(t, "Log event at level error", titles[0])
+	assert.Regexp(t, `^meow #\d+`, bodies[0])
+
+	start := time.Now()
+	require.NoError(t, handle.Close())
+	assert.Less(t, time.Since(start), time.Second, "Close shouldn't wait for the notification interval")
+	titles, _ = fake.get()
+	assert.Len(t, titles, 1, "Pending notifications should be discarded on close")
+}
+
+var moreNotificationsRegex = regexp.MustCompile(`^meow #\d+(?: \(and (\d+) more\))?$`)
+
+func TestNotifyDesktop_CountsMergedEvents(t *testing.T) {
+	var fake fakeNotifications
+	defer zeroconfig.SetDesktopNotifications(fake.command, 10*time.Millisecond)()
+	handle := compileNotifyHandle(t)
+	defer handle.Close()
+
+	const events = 100
+	for i := 0; i < events; i++ {
+		handle.Logger.Error().Msgf("meow #%d", i)
+	}
+	countEvents := func() (count int) {
+		_, bodies := fake.get()
+		for _, body := range bodies {
+			match := moreNotificationsRegex.FindStringSubmatch(body)
+			if !assert.NotNil(t, match, fmt.Sprintf("Unexpected notification body %q", body)) {
+				return -1
+			}
+			more, _ := strconv.Atoi(match[1])
+			count += 1 + more
+		}
+		return
+	}
+	assert.Eventually(t, func() bool {
+		return countEvents() == events
+	}, 2*time.Second, 5*time.Millisecond, "Every event should be shown or counted in a notification")
+	titles, _ := fake.get()
+	assert.Less(t, len(titles), events/2, "Events should be merged instead of shown one by one")
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultPanicQuarantine is the number of consecutive panics after which a writer is disabled
+// if Config.PanicQuarantine isn't set.
+const DefaultPanicQuarantine = 3
+
+// WriterPanicError is the write error returned when a writer panics.
+type WriterPanicError struct {
+	Value any
+}
+
+func (wpe *WriterPanicError) Error() string {
+	return fmt.Sprintf("writer panicked: %v", wpe.Value)
+}
+
+// Writer types registered with RegisterWriter, which always have panics recovered.
+var customWriterTypes = map[WriterType]struct{}{}
+
+type quarantineNotice struct {
+	index      int
+	writerType WriterType
+	value      any
+}
+
+// panicPolicy contains the panic recovery settings shared by all writers of a config.
+type panicPolicy struct {
+	all       bool
+	threshold int
+	guards    int
+	// Receives a notice when a writer is disabled, which is logged by a background task of the Handle.
+	notices chan quarantineNotice
+}
+
+func newPanicPolicy(all bool, threshold int) (*panicPolicy, error) {
+	if threshold < 0 {
+		return nil, fmt.Errorf("panic_quarantine must not be negative")
+	} else if threshold == 0 {
+		threshold = DefaultPanicQuarantine
+	}
+	return &panicPolicy{all: all, threshold: threshold, notices: make(chan quarantineNotice, 8)}, nil
+}
+
+// panicGuardWriter converts panics of the underlying writer into write errors,
+// and stops writing to it after too many consecutive panics.
+type panicGuardWriter struct {
+	zerolog.LevelWriter
+	writerType WriterType
+	threshold  int
+	counters   *writerCounters
+	notices    chan<- quarantineNotice
+
+	consecutive atomic.Int32
+	quarantined atomic.Bool
+}
+
+// wrapPanicGuard wraps the main output of a writer if it's a custom writer type or if all writers should be guarded.
+func (wc *writerCounters) wrapPanicGuard(cfg *WriterConfig, output io.Writer) io.Writer {
+	_, custom := customWriterTypes[cfg.Type]
+	guard := &panicGuardWriter{
+		LevelWriter: asLevelWriter(output),
+		writerType:  cfg.Type,
+		threshold:   DefaultPanicQuarantine,
+		counters:    wc,
+	}
+	if wc != nil && wc.panicPolicy != nil {
+		if !custom && !wc.panicPolicy.all {
+			return output
+		}
+		wc.panicPolicy.guards++
+		guard.threshold = wc.panicPolicy.threshold
+		guard.notices = wc.panicPolicy.notices
+	} else if !custom {
+		return output
+	}
+	return guard
+}
+
+func (pgw *panicGuardWriter) Write(p []byte) (n int, err error) {
+	return pgw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (pgw *panicGuardWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if pgw.quarantined.Load() {
+		return len(p), nil
+	}
+	defer func() {
+		if value := recover(); value != nil {
+			// Nothing is known to have been written, so report zero bytes like any other failed write.
+			n, err = 0, &WriterPanicError{Value: value}
+			pgw.recordPanic(value)
+		}
+	}()
+	n, err = pgw.LevelWriter.WriteLevel(level, p)
+	pgw.consecutive.Store(0)
+	return
+}
+
+func (pgw *panicGuardWriter) recordPanic(value any) {
+	if pgw.counters != nil {
+		pgw.counters.panics.Add(1)
+	}
+	if int(pgw.consecutive.Add(1)) < pgw.threshold || !pgw.quarantined.CompareAndSwap(false, true) || pgw.counters == nil {
+		return
+	}
+	pgw.counters.quarantined.Add(1)
+	if pgw.notices != nil {
+		select {
+		case pgw.notices <- quarantineNotice{index: pgw.counters.index, writerType: pgw.writerType, value: value}:
+		default:
+		}
+	}
+}
+
+// startQuarantineNotices logs an error through the remaining writers whenever a writer is disabled due to panics.
+func (h *Handle) startQuarantineNotices(notices <-chan quarantineNotice) {
+	h.startTask(func(stop <-chan struct{}) {
+		for {
+			select {
+			case <-stop:
+				return
+			case notice := <-notices:
+				h.Logger.Error().
+					Int("writer_index", notice.index).
+					Str("writer_type", string(notice.writerType)).
+					Str("panic", fmt.Sprint(notice.value)).
+					Msg("Disabled writer after repeated panics")
+			}
+		}
+	})
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type panickingWriter struct{}
+
+func (panickingWriter) Write([]byte) (int, error) {
+	panic("meow")
+}
+
+func init() {
+	zeroconfig.RegisterWriter("panicky", func(*zeroconfig.WriterConfig) (io.Writer, error) {
+		return panickingWriter{}, nil
+	})
+}
+
+func captureWriteErrors(t *testing.T) func() []error {
+	var lock sync.Mutex
+	var errs []error
+	prevHandler := zerolog.ErrorHandler
+	zerolog.ErrorHandler = func(err error) {
+		lock.Lock()
+		errs = append(errs, err)
+		lock.Unlock()
+	}
+	t.Cleanup(func() {
+		zerolog.ErrorHandler = prevHandler
+	})
+	return func() []error {
+		lock.Lock()
+		defer lock.Unlock()
+		return errs
+	}
+}
+
+func TestConfig_Compile_PanicQuarantine(t *testing.T) {
+	var out lockedBuffer
+	zeroconfig.Stdout = &out
+	writeErrors := captureWriteErrors(t)
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "stdout"}, {"type": "panicky"}],
+	  "timestamp": false,
+	  "panic_quarantine": 2
+	}`), &cfg))
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+
+	require.NotPanics(t, func() {
+		handle.Logger.Info().Msg("one")
+		handle.Logger.Info().Msg("two")
+		handle.Logger.Info().Msg("three")
+	})
+	require.Eventually(t, func() bool {
+		return strings.Contains(string(out.Bytes()), "Disabled writer")
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, `{"level":"info","message":"one"}
+{"level":"info","message":"two"}
+{"level":"info","message":"three"}
+{"level":"error","writer_index":2,"writer_type":"panicky","panic":"meow","message":"Disabled writer after repeated panics"}
+`, string(out.Bytes()))
+
+	errs := writeErrors()
+	require.Len(t, errs, 2, "Only panics before the quarantine should be reported")
+	var panicErr *zeroconfig.WriterPanicError
+	require.ErrorAs(t, errs[0], &panicErr)
+	assert.Equal(t, "meow", panicErr.Value)
+
+	stats := handle.Stats()
+	assert.EqualValues(t, 0, stats.Writers[0].Panics)
+	assert.False(t, stats.Writers[0].Quarantined)
+	assert.EqualValues(t, 2, stats.Writers[1].Panics)
+	assert.True(t, stats.Writers[1].Quarantined)
+}
+
+func TestConfig_Compile_RecoverPanics(t *testing.T) {
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(`{
+	  "writers": [{"type": "stdout"}],
+	  "recover_panics": true
+	}`), &cfg))
+	zeroconfig.Stdout = panickingWriter{}
+	writeErrors := captureWriteErrors(t)
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	require.NotPanics(t, func() {
+		log.Info().Msg("meow")
+	})
+	assert.Len(t, writeErrors(), 1)
+
+	cfg.RecoverPanics = false
+	log, err = cfg.Compile()
+	require.NoError(t, err)
+	assert.Panics(t, func() {
+		log.Info().Msg("meow")
+	}, "Built-in writers shouldn't be guarded without recover_panics")
+}
+
+func TestConfig_Compile_PanicQuarantine_Negative(t *testing.T) {
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}, PanicQuarantine: -1}
+	_, err := cfg.Compile()
+	assert.EqualError(t, err, "panic_quarantine: must not be negative")
+}
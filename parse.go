@@ -0,0 +1,155 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// logFormatAliases maps alternative names of log formats to the canonical ones.
+var logFormatAliases = map[string]LogFormat{
+	"console":         LogFormatPretty,
+	"console-colored": LogFormatPrettyColored,
+}
+
+// writerTypeAliases maps alternative names of writer types to the canonical ones.
+var writerTypeAliases = map[string]WriterType{
+	"none": WriterTypeDiscard,
+	"net":  WriterTypeNetwork,
+}
+
+// ParseWriterType parses a writer type, checking that it's been registered (see RegisterWriter).
+//
+// In addition to the registered types, "none" is accepted as an alias for the discard type and "net" for network.
+func ParseWriterType(val string) (WriterType, error) {
+	wt := WriterType(strings.ToLower(val))
+	if alias, ok := writerTypeAliases[string(wt)]; ok {
+		return alias, nil
+	} else if wt == WriterTypeGroup {
+		return wt, nil
+	} else if _, ok := writerCompilers[wt]; ok {
+		return wt, nil
+	} else if val == "" {
+		return "", fmt.Errorf("writer type is required")
+	}
+	options := make([]string, 0, len(writerCompilers)+1)
+	options = append(options, string(WriterTypeGroup))
+	for known := range writerCompilers {
+		options = append(options, string(known))
+	}
+	for alias := range writerTypeAliases {
+		options = append(options, alias)
+	}
+	return "", unknownValueError("writer type", val, options)
+}
+
+func (wt WriterType) String() string {
+	return string(wt)
+}
+
+func (wt *WriterType) UnmarshalText(text []byte) (err error) {
+	*wt, err = ParseWriterType(string(text))
+	return
+}
+
+func (wt WriterType) MarshalText() ([]byte, error) {
+	if wt == "" {
+		return []byte{}, nil
+	}
+	parsed, err := ParseWriterType(string(wt))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(parsed), nil
+}
+
+// ParseLogFormat parses a log format. The empty string is allowed and means the default (JSON) format.
+//
+// In addition to the format constants, "console" and "console-colored" are accepted as aliases
+// for the pretty formats.
+func ParseLogFormat(val string) (LogFormat, error) {
+	lf := LogFormat(strings.ToLower(val))
+	switch lf {
+	case "", LogFormatJSON, LogFormatPretty, LogFormatPrettyColored, LogFormatLogfmt:
+		return lf, nil
+	}
+	if alias, ok := logFormatAliases[string(lf)]; ok {
+		return alias, nil
+	}
+	options := []string{string(LogFormatJSON), string(LogFormatPretty), string(LogFormatPrettyColored), string(LogFormatLogfmt)}
+	for alias := range logFormatAliases {
+		options = append(options, alias)
+	}
+	return "", unknownValueError("format", val, options)
+}
+
+func (lf LogFormat) String() string {
+	return string(lf)
+}
+
+func (lf *LogFormat) UnmarshalText(text []byte) (err error) {
+	*lf, err = ParseLogFormat(string(text))
+	return
+}
+
+func (lf LogFormat) MarshalText() ([]byte, error) {
+	parsed, err := ParseLogFormat(string(lf))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(parsed), nil
+}
+
+// unknownValueError returns an error for an unknown value, suggesting the closest known option
+// if there is one, or listing all the options otherwise.
+func unknownValueError(what, val string, options []string) error {
+	sort.Strings(options)
+	lowerVal := strings.ToLower(val)
+	best, bestDistance := "", len(lowerVal)/2+1
+	for _, option := range options {
+		if distance := editDistance(lowerVal, option); distance < bestDistance {
+			best, bestDistance = option, distance
+		}
+	}
+	if best != "" {
+		return fmt.Errorf("unknown %s %q (did you mean %q?)", what, val, best)
+	}
+	return fmt.Errorf("unknown %s %q (expected one of %s)", what, val, strings.Join(options, ", "))
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
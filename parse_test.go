@@ -0,0 +1,119 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestParseWriterType(t *testing.T) {
+	wt, err := zeroconfig.ParseWriterType("STDOUT")
+	require.NoError(t, err)
+	assert.Equal(t, zeroconfig.WriterTypeStdout, wt)
+	wt, err = zeroconfig.ParseWriterType("group")
+	require.NoError(t, err)
+	assert.Equal(t, zeroconfig.WriterTypeGroup, wt)
+
+	_, err = zeroconfig.ParseWriterType("stdot")
+	assert.EqualError(t, err, `unknown writer type "stdot" (did you mean "stdout"?)`)
+	_, err = zeroconfig.ParseWriterType("meow")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown writer type "meow" (expected one of `)
+	assert.Contains(t, err.Error(), "file, gelf, group, http, journald")
+	_, err = zeroconfig.ParseWriterType("")
+	assert.EqualError(t, err, "writer type is required")
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for input, expected := range map[string]zeroconfig.LogFormat{
+		"":                zeroconfig.LogFormat(""),
+		"json":            zeroconfig.LogFormatJSON,
+		"Pretty":          zeroconfig.LogFormatPretty,
+		"pretty-colored":  zeroconfig.LogFormatPrettyColored,
+		"console":         zeroconfig.LogFormatPretty,
+		"console-colored": zeroconfig.LogFormatPrettyColored,
+		"logfmt":          zeroconfig.LogFormatLogfmt,
+	} {
+		lf, err := zeroconfig.ParseLogFormat(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, lf, input)
+	}
+	_, err := zeroconfig.ParseLogFormat("prety")
+	assert.EqualError(t, err, `unknown format "prety" (did you mean "pretty"?)`)
+	_, err = zeroconfig.ParseLogFormat("meow")
+	assert.EqualError(t, err, `unknown format "meow" (expected one of console, console-colored, json, logfmt, pretty, pretty-colored)`)
+}
+
+func TestWriterTypeAndLogFormat_RoundTrip(t *testing.T) {
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{
+		{Type: zeroconfig.WriterTypeStdout, Format: "console"},
+		{Type: zeroconfig.WriterTypeStderr, Format: zeroconfig.LogFormatJSON},
+		{Type: zeroconfig.WriterTypeGroup, Writers: []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}},
+	}}
+	check := func(t *testing.T, parsed *zeroconfig.Config) {
+		require.Len(t, parsed.Writers, 3)
+		assert.Equal(t, zeroconfig.WriterTypeStdout, parsed.Writers[0].Type)
+		assert.Equal(t, zeroconfig.LogFormatPretty, parsed.Writers[0].Format, "Aliases should be marshaled as the canonical format")
+		assert.Equal(t, zeroconfig.WriterTypeStderr, parsed.Writers[1].Type)
+		assert.Equal(t, zeroconfig.LogFormatJSON, parsed.Writers[1].Format)
+		assert.Equal(t, zeroconfig.WriterTypeGroup, parsed.Writers[2].Type)
+		assert.Equal(t, zeroconfig.LogFormat(""), parsed.Writers[2].Format)
+	}
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(&cfg)
+		require.NoError(t, err)
+		var parsed zeroconfig.Config
+		require.NoError(t, json.Unmarshal(data, &parsed))
+		check(t, &parsed)
+	})
+	t.Run("yaml", func(t *testing.T) {
+		data, err := yaml.Marshal(&cfg)
+		require.NoError(t, err)
+		var parsed zeroconfig.Config
+		require.NoError(t, yaml.Unmarshal(data, &parsed))
+		check(t, &parsed)
+	})
+	t.Run("toml", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, toml.NewEncoder(&buf).Encode(&cfg))
+		var parsed zeroconfig.Config
+		_, err := toml.Decode(buf.String(), &parsed)
+		require.NoError(t, err)
+		check(t, &parsed)
+	})
+
+	_, err := json.Marshal(&zeroconfig.WriterConfig{Type: "meow"})
+	assert.Error(t, err, "Invalid writer types shouldn't be marshaled")
+	_, err = json.Marshal(&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeStdout, Format: "meow"})
+	assert.Error(t, err, "Invalid formats shouldn't be marshaled")
+}
+
+func TestWriterTypeAndLogFormat_UnmarshalInvalid(t *testing.T) {
+	var cfg zeroconfig.Config
+	err := json.Unmarshal([]byte(`{"writers": [{"type": "stdout"}, {"type": "stdot"}]}`), &cfg)
+	assert.EqualError(t, err, `writers[1].type: unknown writer type "stdot" (did you mean "stdout"?)`)
+	err = json.Unmarshal([]byte(`{"writers": [{"type": "stdout", "format": "prety"}]}`), &cfg)
+	assert.EqualError(t, err, `writers[0].format: unknown format "prety" (did you mean "pretty"?)`)
+	err = json.Unmarshal([]byte(`{"writers": [{"type": 5}]}`), &cfg)
+	assert.EqualError(t, err, `writers[0].type: expected string, got 5`)
+
+	err = yaml.Unmarshal([]byte("writers:\n- type: stdot\n"), &cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `did you mean "stdout"?`)
+	_, err = toml.Decode("[[writers]]\ntype = \"stdout\"\nformat = \"meow\"\n", &cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown format "meow"`)
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileEnvVar is the environment variable that LoadProfile reads the profile name from if one isn't given.
+const ProfileEnvVar = "LOG_PROFILE"
+
+func decodeFormat(data []byte, format ConfigFormat, into any) error {
+	switch format {
+	case ConfigFormatJSON:
+		return describeJSONSyntaxError(json.Unmarshal(data, into), data)
+	case ConfigFormatYAML:
+		return yaml.Unmarshal(data, into)
+	case ConfigFormatTOML:
+		return toml.Unmarshal(data, into)
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+// profileDecoder decodes a single profile on top of an existing config, only changing the fields set in the profile.
+type profileDecoder func(into *Config) error
+
+// decodeProfiles returns a decoder for each profile in the file, or nil if the file doesn't have a profiles field.
+func decodeProfiles(data []byte, format ConfigFormat) (map[string]profileDecoder, error) {
+	var profiles map[string]profileDecoder
+	switch format {
+	case ConfigFormatJSON:
+		var doc struct {
+			Profiles map[string]json.RawMessage `json:"profiles"`
+		}
+		if err := decodeFormat(data, format, &doc); err != nil || doc.Profiles == nil {
+			return nil, err
+		}
+		profiles = make(map[string]profileDecoder, len(doc.Profiles))
+		for name, raw := range doc.Profiles {
+			raw := raw
+			profiles[name] = func(into *Config) error {
+				return describeJSONSyntaxError(json.Unmarshal(raw, into), raw)
+			}
+		}
+	case ConfigFormatYAML:
+		var doc struct {
+			Profiles map[string]yaml.Node `yaml:"profiles"`
+		}
+		if err := decodeFormat(data, format, &doc); err != nil || doc.Profiles == nil {
+			return nil, err
+		}
+		profiles = make(map[string]profileDecoder, len(doc.Profiles))
+		for name, node := range doc.Profiles {
+			node := node
+			profiles[name] = func(into *Config) error {
+				return node.Decode(into)
+			}
+		}
+	case ConfigFormatTOML:
+		var doc struct {
+			Profiles map[string]toml.Primitive `toml:"profiles"`
+		}
+		md, err := toml.Decode(string(data), &doc)
+		if err != nil || doc.Profiles == nil {
+			return nil, err
+		}
+		profiles = make(map[string]profileDecoder, len(doc.Profiles))
+		for name, prim := range doc.Profiles {
+			prim := prim
+			profiles[name] = func(into *Config) error {
+				return md.PrimitiveDecode(prim, into)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	return profiles, nil
+}
+
+// mergeDefaultMaps adds the keys of map fields (like Metadata) in defaults that are missing from cfg,
+// so that profiles add to the default maps instead of replacing them.
+func mergeDefaultMaps(cfg, defaults *Config) {
+	cfgVal := reflect.ValueOf(cfg).Elem()
+	defaultsVal := reflect.ValueOf(defaults).Elem()
+	for i := 0; i < cfgVal.NumField(); i++ {
+		target, defaultMap := cfgVal.Field(i), defaultsVal.Field(i)
+		if target.Kind() != reflect.Map || defaultMap.Len() == 0 {
+			continue
+		}
+		merged := reflect.MakeMapWithSize(target.Type(), target.Len()+defaultMap.Len())
+		for _, src := range []reflect.Value{defaultMap, target} {
+			iter := src.MapRange()
+			for iter.Next() {
+				merged.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		target.Set(merged)
+	}
+}
+
+// LoadProfileConfig reads a config file that may contain multiple named profiles and returns the config for the given profile.
+//
+// The top-level fields of the file are defaults for all profiles, and the profiles field contains a map from profile
+// name to config. The selected profile is decoded on top of the defaults, so any field set in the profile overrides
+// the default, including false and empty values (e.g. `caller: false` or `writers: []`). Metadata maps are merged
+// and writers lists are replaced. If the name is empty, it's read from the LOG_PROFILE environment variable.
+// If that's empty too, or if the file has no profiles, only the top-level config is used.
+func LoadProfileConfig(path, name string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format := detectFormat(path, data)
+	var cfg Config
+	if err = decodeFormat(data, format, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	profiles, err := decodeProfiles(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profiles in %s: %w", path, err)
+	}
+	if name == "" {
+		name = Getenv(ProfileEnvVar)
+	}
+	if name == "" || profiles == nil {
+		return &cfg, nil
+	}
+	decodeProfile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown log profile %q (available profiles: %s)", name, strings.Join(sortedKeys(profiles), ", "))
+	}
+	// Decode the defaults again instead of copying cfg, so that the profile can't modify the maps of cfg.
+	var merged Config
+	if err = decodeFormat(data, format, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	} else if err = decodeProfile(&merged); err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s in %s: %w", name, path, err)
+	}
+	mergeDefaultMaps(&merged, &cfg)
+	return &merged, nil
+}
+
+// LoadProfile reads a config file with LoadProfileConfig and compiles the selected profile.
+func LoadProfile(path, name string) (*zerolog.Logger, error) {
+	cfg, err := LoadProfileConfig(path, name)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Compile()
+}
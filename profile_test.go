@@ -0,0 +1,149 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestLoadProfileConfig(t *testing.T) {
+	const path = "testdata/profiles.yaml"
+	defaultMetadata := map[string]any{"service": "meow"}
+	tests := []struct {
+		profile  string
+		expected zeroconfig.Config
+	}{
+		{"", zeroconfig.Config{
+			MinLevel: ptr(zerolog.InfoLevel),
+			Metadata: defaultMetadata,
+			Writers:  []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}},
+		}},
+		{"dev", zeroconfig.Config{
+			MinLevel: ptr(zerolog.DebugLevel),
+			Metadata: defaultMetadata,
+			Writers:  []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout, Format: zeroconfig.LogFormatPretty}},
+		}},
+		{"prod", zeroconfig.Config{
+			MinLevel: ptr(zerolog.InfoLevel),
+			Metadata: map[string]any{"service": "meow", "environment": "production"},
+			Writers: []zeroconfig.WriterConfig{
+				{Type: zeroconfig.WriterTypeStdout},
+				{Type: zeroconfig.WriterTypeStderr, MinLevel: ptr(zerolog.ErrorLevel)},
+			},
+		}},
+		{"ci", zeroconfig.Config{
+			MinLevel:  ptr(zerolog.InfoLevel),
+			Metadata:  defaultMetadata,
+			Timestamp: ptr(false),
+			Writers:   []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStderr}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			cfg, err := zeroconfig.LoadProfileConfig(path, test.profile)
+			require.NoError(t, err)
+			assert.Equal(t, &test.expected, cfg)
+		})
+	}
+
+	_, err := zeroconfig.LoadProfileConfig(path, "staging")
+	require.Error(t, err)
+	assert.Equal(t, `unknown log profile "staging" (available profiles: ci, dev, prod)`, err.Error())
+}
+
+func TestLoadProfileConfig_Env(t *testing.T) {
+	defer func() {
+		zeroconfig.Getenv = os.Getenv
+	}()
+	zeroconfig.Getenv = func(key string) string {
+		if key == zeroconfig.ProfileEnvVar {
+			return "ci"
+		}
+		return ""
+	}
+	cfg, err := zeroconfig.LoadProfileConfig("testdata/profiles.yaml", "")
+	require.NoError(t, err)
+	assert.Equal(t, []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStderr}}, cfg.Writers, "Profile should be selected from the environment")
+
+	cfg, err = zeroconfig.LoadProfileConfig("testdata/profiles.yaml", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, zeroconfig.LogFormatPretty, cfg.Writers[0].Format, "Explicit name should take precedence over the environment")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"writers": [{"type": "stdout"}]}`), 0600))
+	cfg, err = zeroconfig.LoadProfileConfig(path, "")
+	require.NoError(t, err, "Configs without profiles should be loaded normally even if a profile is selected")
+	assert.Equal(t, []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}, cfg.Writers)
+}
+
+func TestLoadProfileConfig_DisableDefaults(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": `
+caller: true
+dedup_stacks: true
+metadata: {service: meow}
+writers: [{type: stdout}]
+profiles:
+  quiet:
+    caller: false
+    dedup_stacks: false
+    metadata: {env: test}
+    writers: []
+  same: {}
+`,
+		"config.json": `{
+  "caller": true, "dedup_stacks": true, "metadata": {"service": "meow"}, "writers": [{"type": "stdout"}],
+  "profiles": {
+    "quiet": {"caller": false, "dedup_stacks": false, "metadata": {"env": "test"}, "writers": []},
+    "same": {}
+  }
+}`,
+		"config.toml": `
+caller = true
+dedup_stacks = true
+writers = [{type = "stdout"}]
+[metadata]
+service = "meow"
+[profiles.quiet]
+caller = false
+dedup_stacks = false
+writers = []
+[profiles.quiet.metadata]
+env = "test"
+[profiles.same]
+`,
+	}
+	for filename, data := range files {
+		t.Run(filename, func(t *testing.T) {
+			path := filepath.Join(dir, filename)
+			require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+
+			cfg, err := zeroconfig.LoadProfileConfig(path, "quiet")
+			require.NoError(t, err)
+			assert.False(t, cfg.Caller, "Profiles should be able to disable default bools")
+			assert.False(t, cfg.DedupStacks)
+			assert.Empty(t, cfg.Writers, "Profiles should be able to clear the default writers")
+			assert.Equal(t, map[string]any{"service": "meow", "env": "test"}, cfg.Metadata)
+
+			cfg, err = zeroconfig.LoadProfileConfig(path, "same")
+			require.NoError(t, err)
+			assert.True(t, cfg.Caller, "Fields that aren't in the profile should keep the default")
+			assert.True(t, cfg.DedupStacks)
+			assert.Equal(t, []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeStdout}}, cfg.Writers)
+			assert.Equal(t, map[string]any{"service": "meow"}, cfg.Metadata)
+		})
+	}
+}
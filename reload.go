@@ -0,0 +1,298 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ReloadableLogger is a logger whose config can be replaced at runtime, e.g. to change the min level
+// without restarting.
+//
+// The Logger field stays the same after reloading, so it can be stored and used to create child loggers
+// as usual. Events logged through it (and through child loggers) always use the writers, level,
+// metadata and hooks of the latest config. Each event uses a single config from start to end, even if
+// it's logged while the config is being reloaded.
+//
+// The level is checked for each event, so Logger.GetLevel always returns zerolog.TraceLevel.
+// Use Level to get the level of the current config.
+type ReloadableLogger struct {
+	Logger *zerolog.Logger
+
+	// The lock is only held while reloading, events use the atomic pointers.
+	reloadLock sync.Mutex
+	generation uint64
+	current    atomic.Pointer[reloadTarget]
+	// The config that was replaced by the reload in progress, which is kept until it's closed.
+	previous atomic.Pointer[reloadTarget]
+}
+
+// reloadTarget is a compiled config that the events of the stable logger are passed to.
+type reloadTarget struct {
+	compiledLogger
+	handle     *Handle
+	generation uint64
+	// The number of events that have been hooked with this config, but not written yet.
+	pending atomic.Int64
+
+	// The lock is held for reading while writing events, so that the handle isn't closed in the middle of a write.
+	lock   sync.RWMutex
+	closed bool
+}
+
+// reloadDrainTimeout is how long Reload waits for events that were started with the previous config to be written.
+// Events only stay pending for longer if a hook of a child logger discards them after they were started.
+var reloadDrainTimeout = time.Second
+
+// NewReloadableLogger compiles the given config into a ReloadableLogger.
+func NewReloadableLogger(cfg *Config) (*ReloadableLogger, error) {
+	target, err := compileReloadTarget(cfg, 1)
+	if err != nil {
+		return nil, err
+	}
+	rl := &ReloadableLogger{generation: 1}
+	rl.current.Store(target)
+	log := zerolog.New(reloadWriter{rl}).Sample(reloadSampler{rl}).Hook(reloadHook{rl})
+	rl.Logger = &log
+	return rl, nil
+}
+
+// Reload compiles the given config and switches the logger to it. The Handle of the previous config
+// is closed after the switch, which closes its outputs. If compiling fails, the previous config stays active.
+//
+// Both configs are open at the same time, so the new config takes over resources that can only be used once,
+// like the listeners of tail writers, instead of opening them again.
+func (rl *ReloadableLogger) Reload(cfg *Config) error {
+	rl.reloadLock.Lock()
+	defer rl.reloadLock.Unlock()
+	target, err := compileReloadTarget(cfg, rl.generation+1)
+	if err != nil {
+		return err
+	}
+	rl.generation = target.generation
+	prev := rl.current.Load()
+	rl.previous.Store(prev)
+	rl.current.Store(target)
+	for start := time.Now(); prev.pending.Load() > 0 && time.Since(start) < reloadDrainTimeout; {
+		time.Sleep(time.Millisecond)
+	}
+	// Writes hold the read lock, so nothing is using the previous outputs after this. Events that are still
+	// pending after the timeout are written through the new config instead.
+	prev.lock.Lock()
+	prev.closed = true
+	prev.lock.Unlock()
+	rl.previous.Store(nil)
+	return prev.handle.Close()
+}
+
+// Handle returns the Handle of the current config, e.g. to get stats. It shouldn't be closed directly.
+func (rl *ReloadableLogger) Handle() *Handle {
+	return rl.current.Load().handle
+}
+
+// Level returns the minimum level of the current config.
+func (rl *ReloadableLogger) Level() zerolog.Level {
+	target := rl.current.Load()
+	if target.atomicLevel != nil {
+		return target.atomicLevel.Level()
+	}
+	return target.minLevel
+}
+
+// Close closes the Handle of the current config.
+func (rl *ReloadableLogger) Close() error {
+	return rl.Handle().Close()
+}
+
+func compileReloadTarget(cfg *Config, generation uint64) (*reloadTarget, error) {
+	target := &reloadTarget{generation: generation}
+	handle, err := cfg.compileHandle(&target.compiledLogger)
+	if err != nil {
+		return nil, err
+	}
+	target.handle = handle
+	return target, nil
+}
+
+// write writes an event through the config. It returns false if the config has already been replaced and closed.
+func (rt *reloadTarget) write(level zerolog.Level, p []byte) (bool, error) {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+	if rt.closed {
+		return false, nil
+	}
+	_, err := contextWriter{rt.writer, rt.context}.WriteLevel(level, p)
+	return true, err
+}
+
+// reloadSampler drops events below the level of the current config before zerolog creates them.
+type reloadSampler struct {
+	rl *ReloadableLogger
+}
+
+func (rs reloadSampler) Sample(level zerolog.Level) bool {
+	return rs.rl.current.Load().enabled(level)
+}
+
+// reloadGenerationField is added to events by reloadHook to tell reloadWriter which config they were started with.
+// It starts with a null byte, so it can't conflict with real fields, and it's removed before events are written.
+const reloadGenerationField = "\x00zeroconfig_reload_generation"
+
+var reloadGenerationKey = append(appendJSONString(nil, reloadGenerationField), ':')
+
+// reloadHook runs the hooks of the current config and records which config was used for the event.
+type reloadHook struct {
+	rl *ReloadableLogger
+}
+
+func (rh reloadHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	target := rh.rl.acquire()
+	started := false
+	defer func() {
+		if !started {
+			target.pending.Add(-1)
+		}
+	}()
+	// The sampler checked the level of the config that was current when the event was created,
+	// which may have been replaced since then. Sampling can also be disabled globally.
+	if !target.enabled(level) {
+		e.Discard()
+		return
+	}
+	// Hooks that add the caller expect to be called directly by zerolog, so skip this function.
+	e.CallerSkipFrame(1)
+	for _, hook := range target.hooks {
+		hook.Run(e, level, msg)
+	}
+	e.CallerSkipFrame(-1)
+	if e.Enabled() {
+		e.Uint64(reloadGenerationField, target.generation)
+		started = true
+	}
+}
+
+// acquire returns the current config after counting an event as pending in it,
+// so that Reload doesn't close it before the event is written.
+func (rl *ReloadableLogger) acquire() *reloadTarget {
+	for {
+		target := rl.current.Load()
+		target.pending.Add(1)
+		if rl.current.Load() == target {
+			return target
+		}
+		// Reload switched to a new config in between, it may already be waiting for the previous one.
+		target.pending.Add(-1)
+	}
+}
+
+// reloadWriter writes events through the config that reloadHook used for them, adding the metadata of that config.
+type reloadWriter struct {
+	rl *ReloadableLogger
+}
+
+func (rw reloadWriter) Write(p []byte) (n int, err error) {
+	return rw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (rw reloadWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	generation, event, found := takeReloadGeneration(p)
+	target := rw.rl.current.Load()
+	if prev := rw.rl.previous.Load(); found && prev != nil && prev.generation == generation {
+		target = prev
+	}
+	if found && target.generation == generation {
+		defer target.pending.Add(-1)
+	}
+	for {
+		var ok bool
+		ok, err = target.write(level, event)
+		if ok {
+			break
+		}
+		target = rw.rl.current.Load()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// takeReloadGeneration returns the generation that reloadHook added to an event and a copy of the event without it.
+func takeReloadGeneration(p []byte) (generation uint64, event []byte, found bool) {
+	start := bytes.Index(p, reloadGenerationKey)
+	if start < 0 {
+		return 0, p, false
+	}
+	end := start + len(reloadGenerationKey)
+	for end < len(p) && p[end] >= '0' && p[end] <= '9' {
+		end++
+	}
+	generation, err := strconv.ParseUint(string(p[start+len(reloadGenerationKey):end]), 10, 64)
+	if err != nil {
+		return 0, p, false
+	}
+	// Remove the comma that separates the field from the previous field, or from the next one if it's the first.
+	if start > 0 && p[start-1] == ',' {
+		start--
+	} else if end < len(p) && p[end] == ',' {
+		end++
+	}
+	event = make([]byte, 0, len(p)-(end-start))
+	event = append(append(event, p[:start]...), p[end:]...)
+	return generation, event, true
+}
+
+// contextWriter inserts the metadata of a config into events of ReloadableLogger, which don't have it.
+type contextWriter struct {
+	zerolog.LevelWriter
+	context []byte
+}
+
+func (cw contextWriter) Write(p []byte) (n int, err error) {
+	return cw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (cw contextWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if cw.context == nil {
+		return cw.LevelWriter.WriteLevel(level, p)
+	}
+	_, err = cw.LevelWriter.WriteLevel(level, insertContext(level, p, cw.context))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// insertContext inserts the fields of a logger context into an event, after the level field like zerolog does.
+func insertContext(level zerolog.Level, p, context []byte) []byte {
+	prefixLen := 1
+	if level != zerolog.NoLevel && zerolog.LevelFieldName != "" {
+		levelField := appendJSONString([]byte{'{'}, zerolog.LevelFieldName)
+		levelField = appendJSONString(append(levelField, ':'), zerolog.LevelFieldMarshalFunc(level))
+		if bytes.HasPrefix(p, levelField) {
+			prefixLen = len(levelField)
+		}
+	}
+	out := make([]byte, 0, len(p)+len(context)+1)
+	out = append(out, p[:prefixLen]...)
+	if prefixLen > 1 {
+		out = append(out, ',')
+	}
+	// The context starts with the opening brace of the object.
+	out = append(out, context[1:]...)
+	rest := p[prefixLen:]
+	if len(rest) > 0 && rest[0] != '}' && rest[0] != ',' {
+		out = append(out, ',')
+	}
+	return append(out, rest...)
+}
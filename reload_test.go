@@ -0,0 +1,188 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func parseConfig(t *testing.T, data string) *zeroconfig.Config {
+	var cfg zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(data), &cfg))
+	return &cfg
+}
+
+func TestReloadableLogger_Reload(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	rl, err := zeroconfig.NewReloadableLogger(parseConfig(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "min_level": "info",
+	  "timestamp": false
+	}`))
+	require.NoError(t, err)
+	defer rl.Close()
+	log := rl.Logger
+	child := log.With().Str("component", "meow").Logger()
+
+	log.Debug().Msg("dropped")
+	child.Info().Msg("one")
+	assert.Equal(t, `{"level":"info","component":"meow","message":"one"}`+"\n", out.String())
+
+	out.Reset()
+	require.NoError(t, rl.Reload(parseConfig(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "min_level": "debug",
+	  "timestamp": false,
+	  "metadata": {"service": "cat"}
+	}`)))
+	assert.Same(t, log, rl.Logger, "Logger pointer should stay the same")
+	log.Debug().Msg("two")
+	child.Debug().Int("count", 3).Msg("three")
+	log.Log().Msg("four")
+	assert.Equal(t, `{"level":"debug","service":"cat","message":"two"}
+{"level":"debug","service":"cat","component":"meow","count":3,"message":"three"}
+{"service":"cat","message":"four"}
+`, out.String())
+
+	out.Reset()
+	err = rl.Reload(parseConfig(t, `{"writers": [{"type": "stdout", "format": "pretty", "html_safe": true}]}`))
+	assert.Error(t, err, "Reloading an invalid config should fail")
+	log.Debug().Msg("five")
+	assert.Equal(t, `{"level":"debug","service":"cat","message":"five"}`+"\n", out.String(), "Previous config should stay active after failed reload")
+}
+
+func TestReloadableLogger_ClosesOldOutputs(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.log")
+	newPath := filepath.Join(dir, "new.log")
+	rl, err := zeroconfig.NewReloadableLogger(parseConfig(t, `{
+	  "writers": [{"type": "file", "filename": "`+oldPath+`"}],
+	  "timestamp": false
+	}`))
+	require.NoError(t, err)
+	rl.Logger.Info().Msg("old")
+	oldHandle := rl.Handle()
+	require.NoError(t, rl.Reload(parseConfig(t, `{
+	  "writers": [{"type": "file", "filename": "`+newPath+`"}],
+	  "timestamp": false,
+	  "caller": true
+	}`)))
+	assert.NoError(t, oldHandle.Close(), "Old handle should already be closed")
+	rl.Logger.WithLevel(zerolog.WarnLevel).Msg("new")
+	require.NoError(t, rl.Close())
+
+	data, err := os.ReadFile(oldPath)
+	require.NoError(t, err)
+	assert.Equal(t, `{"level":"info","message":"old"}`+"\n", string(data))
+	data, err = os.ReadFile(newPath)
+	require.NoError(t, err)
+	var evt map[string]any
+	require.NoError(t, json.Unmarshal(data, &evt))
+	assert.Equal(t, "new", evt["message"])
+	assert.Contains(t, evt["caller"], "reload_test.go", "Caller should point to the log call")
+}
+
+func TestReloadableLogger_Reload_Tail(t *testing.T) {
+	addr := freeTCPAddr(t)
+	cfg := `{"writers": [{"type": "tail", "listen": "` + addr + `"}], "timestamp": false}`
+	rl, err := zeroconfig.NewReloadableLogger(parseConfig(t, cfg))
+	require.NoError(t, err)
+	defer rl.Close()
+
+	require.NoError(t, rl.Reload(parseConfig(t, cfg)), "Reloading should take over the tail listener")
+	conn := dialTail(t, addr, "")
+	defer conn.Close()
+	waitForTailClients(t, rl.Handle(), 1)
+	rl.Logger.Info().Msg("meow")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `{"level":"info","message":"meow"}`+"\n", line)
+}
+
+func TestReloadableLogger_Level(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	rl, err := zeroconfig.NewReloadableLogger(parseConfig(t, `{"writers": [{"type": "stdout"}], "min_level": "info"}`))
+	require.NoError(t, err)
+	defer rl.Close()
+
+	assert.Equal(t, zerolog.InfoLevel, rl.Level())
+	assert.Equal(t, zerolog.TraceLevel, rl.Logger.GetLevel())
+	assert.False(t, rl.Logger.Debug().Enabled(), "Events below the level shouldn't be created")
+	assert.True(t, rl.Logger.Info().Enabled())
+
+	require.NoError(t, rl.Reload(parseConfig(t, `{"writers": [{"type": "stdout"}], "min_level": "debug"}`)))
+	assert.Equal(t, zerolog.DebugLevel, rl.Level())
+	assert.True(t, rl.Logger.Debug().Enabled())
+}
+
+// pauseHook blocks events with the message "pause" until the release channel is closed.
+type pauseHook struct {
+	paused  chan struct{}
+	release chan struct{}
+}
+
+func (ph pauseHook) Run(_ *zerolog.Event, _ zerolog.Level, msg string) {
+	if msg == "pause" {
+		close(ph.paused)
+		<-ph.release
+	}
+}
+
+func TestReloadableLogger_Reload_InFlight(t *testing.T) {
+	hook := pauseHook{paused: make(chan struct{}), release: make(chan struct{})}
+	zeroconfig.RegisterHook("pause", hook)
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	rl, err := zeroconfig.NewReloadableLogger(parseConfig(t, `{
+	  "writers": [{"type": "stdout"}],
+	  "timestamp": false,
+	  "hooks": ["pause"],
+	  "metadata": {"config": "a"}
+	}`))
+	require.NoError(t, err)
+	defer rl.Close()
+
+	logged := make(chan struct{})
+	go func() {
+		rl.Logger.Info().Msg("pause")
+		close(logged)
+	}()
+	<-hook.paused
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- rl.Reload(parseConfig(t, `{
+		  "writers": [{"type": "stdout", "format": "pretty"}],
+		  "timestamp": false,
+		  "caller": true,
+		  "metadata": {"config": "b"}
+		}`))
+	}()
+	select {
+	case <-reloaded:
+		t.Fatal("Reload shouldn't close the previous config while an event is being logged with it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(hook.release)
+	<-logged
+	require.NoError(t, <-reloaded)
+	assert.Equal(t, `{"level":"info","config":"a","message":"pause"}`+"\n", out.String(),
+		"Event should be written by the config it was started with")
+}
@@ -0,0 +1,362 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+type jsonField struct {
+	Key   string
+	Value json.RawMessage
+}
+
+// jsonObject is a JSON object that preserves the order of its keys.
+type jsonObject []jsonField
+
+func parseJSONObject(data []byte) (jsonObject, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	} else if tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected object, got %v", tok)
+	}
+	var obj jsonObject
+	for dec.More() {
+		tok, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected object key, got %v", tok)
+		}
+		var val json.RawMessage
+		if err = dec.Decode(&val); err != nil {
+			return nil, err
+		}
+		obj = append(obj, jsonField{Key: key, Value: val})
+	}
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (obj jsonObject) index(key string) int {
+	for i, field := range obj {
+		if field.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (obj jsonObject) get(key string) (json.RawMessage, bool) {
+	if i := obj.index(key); i >= 0 {
+		return obj[i].Value, true
+	}
+	return nil, false
+}
+
+func (obj jsonObject) remove(key string) jsonObject {
+	if i := obj.index(key); i >= 0 {
+		return append(obj[:i], obj[i+1:]...)
+	}
+	return obj
+}
+
+func (obj jsonObject) appendTo(dst []byte) []byte {
+	dst = append(dst, '{')
+	for i, field := range obj {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, field.Key)
+		dst = append(dst, ':')
+		dst = append(dst, field.Value...)
+	}
+	return append(dst, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s to dst as a JSON string. Like zerolog, it doesn't escape HTML characters.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				dst = append(dst, `�`...)
+			} else {
+				dst = append(dst, s[i:i+size]...)
+			}
+			i += size
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		default:
+			if c < 0x20 {
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			} else {
+				dst = append(dst, c)
+			}
+		}
+		i++
+	}
+	return append(dst, '"')
+}
+
+// eventRewriteFunc modifies a parsed log event. If it returns false, the event is dropped.
+type eventRewriteFunc = func(level zerolog.Level, obj jsonObject) (jsonObject, bool)
+
+// eventRewriter is a zerolog.LevelWriter that parses each JSON event, passes it through
+// a list of rewrite functions and serializes it again before writing it to the wrapped writer.
+//
+// Events that aren't valid JSON objects are passed through unmodified.
+type eventRewriter struct {
+	zerolog.LevelWriter
+	funcs []eventRewriteFunc
+	// Counter for events dropped by the rewrite functions, may be nil.
+	dropped *atomic.Uint64
+}
+
+func newEventRewriter(writer io.Writer, funcs ...eventRewriteFunc) *eventRewriter {
+	lw := asLevelWriter(writer)
+	return &eventRewriter{LevelWriter: lw, funcs: funcs}
+}
+
+func (er *eventRewriter) Write(p []byte) (n int, err error) {
+	return er.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (er *eventRewriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	obj, err := parseJSONObject(p)
+	if err != nil {
+		return er.LevelWriter.WriteLevel(level, p)
+	}
+	for _, fn := range er.funcs {
+		var ok bool
+		obj, ok = fn(level, obj)
+		if !ok {
+			if er.dropped != nil {
+				er.dropped.Add(1)
+			}
+			return len(p), nil
+		}
+	}
+	out := obj.appendTo(make([]byte, 0, len(p)))
+	if bytes.HasSuffix(p, []byte{'\n'}) {
+		out = append(out, '\n')
+	}
+	_, err = er.LevelWriter.WriteLevel(level, out)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func removeFieldsRewriter(keys []string) eventRewriteFunc {
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		for _, key := range keys {
+			obj = obj.remove(key)
+		}
+		return obj, true
+	}
+}
+
+// RedactedValue is the value that fields listed in WriterConfig.RedactFields are replaced with.
+const RedactedValue = "[redacted]"
+
+// updatePath replaces the value of a field, or removes the field if value is nil. Dots in the path refer to fields
+// of nested objects, but keys that contain dots themselves are matched first. Missing fields are ignored.
+func (obj jsonObject) updatePath(path string, value json.RawMessage) jsonObject {
+	if i := obj.index(path); i >= 0 {
+		if value == nil {
+			return append(obj[:i], obj[i+1:]...)
+		}
+		obj[i].Value = value
+		return obj
+	}
+	for dot := strings.IndexByte(path, '.'); dot >= 0; dot = nextDot(path, dot) {
+		i := obj.index(path[:dot])
+		if i < 0 {
+			continue
+		}
+		child, err := parseJSONObject(obj[i].Value)
+		if err != nil {
+			// Not an object, so it doesn't have nested fields.
+			continue
+		}
+		obj[i].Value = child.updatePath(path[dot+1:], value).appendTo(nil)
+		return obj
+	}
+	return obj
+}
+
+func nextDot(path string, prev int) int {
+	if next := strings.IndexByte(path[prev+1:], '.'); next >= 0 {
+		return prev + 1 + next
+	}
+	return -1
+}
+
+// removePathsRewriter removes the given fields, which may be nested (see jsonObject.updatePath).
+func removePathsRewriter(paths []string) eventRewriteFunc {
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		for _, path := range paths {
+			obj = obj.updatePath(path, nil)
+		}
+		return obj, true
+	}
+}
+
+// redactPathsRewriter replaces the values of the given fields with RedactedValue.
+func redactPathsRewriter(paths []string) eventRewriteFunc {
+	redacted := appendJSONString(nil, RedactedValue)
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		for _, path := range paths {
+			obj = obj.updatePath(path, redacted)
+		}
+		return obj, true
+	}
+}
+
+func overrideFieldsRewriter(values map[string]any) (eventRewriteFunc, error) {
+	marshaled := make(map[string]json.RawMessage, len(values))
+	for key, value := range values {
+		data, err := zerolog.InterfaceMarshalFunc(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value of %q: %w", key, err)
+		}
+		marshaled[key] = data
+	}
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		for i, field := range obj {
+			if value, ok := marshaled[field.Key]; ok {
+				obj[i].Value = value
+			}
+		}
+		return obj, true
+	}, nil
+}
+
+// addFieldsRewriter adds the given fields to events that don't already have them.
+// The fields are added before the message in sorted key order, so that the message stays last like in zerolog.
+func addFieldsRewriter(values map[string]any) (eventRewriteFunc, error) {
+	fields := make(jsonObject, 0, len(values))
+	for _, key := range sortedKeys(values) {
+		data, err := zerolog.InterfaceMarshalFunc(values[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value of %q: %w", key, err)
+		}
+		fields = append(fields, jsonField{Key: key, Value: data})
+	}
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		var missing jsonObject
+		for _, field := range fields {
+			if obj.index(field.Key) < 0 {
+				missing = append(missing, field)
+			}
+		}
+		if len(missing) == 0 {
+			return obj, true
+		}
+		insertAt := obj.index(zerolog.MessageFieldName)
+		if insertAt < 0 {
+			return append(obj, missing...), true
+		}
+		out := make(jsonObject, 0, len(obj)+len(missing))
+		out = append(out, obj[:insertAt]...)
+		out = append(out, missing...)
+		return append(out, obj[insertAt:]...), true
+	}, nil
+}
+
+func normalizeJSONValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var val any
+	err := dec.Decode(&val)
+	return val, err
+}
+
+func normalizeFieldValues(values map[string]any) (map[string]any, error) {
+	normalized := make(map[string]any, len(values))
+	for key, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value of %q: %w", key, err)
+		}
+		normalized[key], err = normalizeJSONValue(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return normalized, nil
+}
+
+func (obj jsonObject) fieldEquals(key string, expected any) bool {
+	raw, ok := obj.get(key)
+	if !ok {
+		return false
+	}
+	val, err := normalizeJSONValue(raw)
+	return err == nil && reflect.DeepEqual(val, expected)
+}
+
+// matchFieldsRewriter drops events unless all the given fields have the given values.
+func matchFieldsRewriter(values map[string]any) (eventRewriteFunc, error) {
+	normalized, err := normalizeFieldValues(values)
+	if err != nil {
+		return nil, err
+	}
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		for key, expected := range normalized {
+			if !obj.fieldEquals(key, expected) {
+				return obj, false
+			}
+		}
+		return obj, true
+	}, nil
+}
+
+// excludeFieldsRewriter drops events if any of the given fields have the given values.
+func excludeFieldsRewriter(values map[string]any) (eventRewriteFunc, error) {
+	normalized, err := normalizeFieldValues(values)
+	if err != nil {
+		return nil, err
+	}
+	return func(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+		for key, expected := range normalized {
+			if obj.fieldEquals(key, expected) {
+				return obj, false
+			}
+		}
+		return obj, true
+	}, nil
+}
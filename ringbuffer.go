@@ -0,0 +1,151 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// RingBufferConfig contains the configuration for the ringbuffer writer type.
+type RingBufferConfig struct {
+	// The name of the buffer, used with GetRingBuffer and DumpRingBuffer.
+	Name string `json:"name" yaml:"name" toml:"name"`
+	// Maximum number of events to keep. Defaults to DefaultRingBufferSize.
+	Size int `json:"size,omitempty" yaml:"size,omitempty" toml:"size,omitempty"`
+}
+
+// DefaultRingBufferSize is the number of events kept by the ringbuffer writer if RingBufferConfig.Size isn't set.
+const DefaultRingBufferSize = 500
+
+// RingEntry is a single event stored in a ring buffer.
+type RingEntry struct {
+	Level zerolog.Level
+	// The timestamp of the event, or the time it was written if the event doesn't have one.
+	Time time.Time
+	// The JSON of the event, without the trailing newline.
+	Raw json.RawMessage
+}
+
+// ringBuffer is the output of the ringbuffer writer type.
+type ringBuffer struct {
+	lock    sync.Mutex
+	entries ring[RingEntry]
+}
+
+// ringBuffers contains the buffers of all ringbuffer writers compiled in the process by name.
+// They're kept until released with ReleaseRingBuffer, as handles don't own them.
+var (
+	ringBuffersLock sync.Mutex
+	ringBuffers     = make(map[string]*ringBuffer)
+)
+
+func compileRingBuffer(wc *WriterConfig) (io.Writer, error) {
+	cfg := wc.RingBuffer
+	if cfg == nil || cfg.Name == "" {
+		return nil, fmt.Errorf("ringbuffer.name is required for the ringbuffer writer")
+	} else if cfg.Size < 0 {
+		return nil, fmt.Errorf("ringbuffer.size must not be negative")
+	} else if format, _ := ParseLogFormat(string(wc.Format)); format != "" && format != LogFormatJSON {
+		return nil, fmt.Errorf("the ringbuffer writer only supports the json format")
+	}
+	size := cfg.Size
+	if size == 0 {
+		size = DefaultRingBufferSize
+	}
+	ringBuffersLock.Lock()
+	defer ringBuffersLock.Unlock()
+	rb, ok := ringBuffers[cfg.Name]
+	if !ok {
+		rb = &ringBuffer{entries: newRing[RingEntry](size)}
+		ringBuffers[cfg.Name] = rb
+	} else {
+		// Compiling the same name again (e.g. when reloading the config) reuses the buffer, so no events are lost.
+		rb.lock.Lock()
+		rb.entries.resize(size)
+		rb.lock.Unlock()
+	}
+	return rb, nil
+}
+
+func (rb *ringBuffer) Write(p []byte) (n int, err error) {
+	return rb.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (rb *ringBuffer) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	entry := RingEntry{
+		Level: level,
+		Raw:   append(json.RawMessage(nil), bytes.TrimSuffix(p, []byte{'\n'})...),
+	}
+	if obj, err := parseJSONObject(p); err == nil {
+		if val, ok := obj.get(zerolog.TimestampFieldName); ok {
+			entry.Time, _ = parseEventTime(val)
+		}
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	rb.lock.Lock()
+	rb.entries.push(entry)
+	rb.lock.Unlock()
+	return len(p), nil
+}
+
+func getRingBuffer(name string) *ringBuffer {
+	ringBuffersLock.Lock()
+	defer ringBuffersLock.Unlock()
+	return ringBuffers[name]
+}
+
+// ReleaseRingBuffer forgets the ring buffer with the given name, so that its memory can be freed once no logger
+// writes to it anymore. Writers compiled before the release keep writing to the old buffer, but GetRingBuffer and
+// DumpRingBuffer won't find it, and compiling a writer with the same name creates a new empty buffer.
+// It returns false if there was no buffer with the name.
+func ReleaseRingBuffer(name string) bool {
+	ringBuffersLock.Lock()
+	defer ringBuffersLock.Unlock()
+	_, ok := ringBuffers[name]
+	delete(ringBuffers, name)
+	return ok
+}
+
+// GetRingBuffer returns the events in the ring buffer with the given name, oldest first.
+// It returns nil if no ringbuffer writer with the name has been compiled.
+func GetRingBuffer(name string) []RingEntry {
+	rb := getRingBuffer(name)
+	if rb == nil {
+		return nil
+	}
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	return rb.entries.list()
+}
+
+// DumpRingBuffer writes the events in the ring buffer with the given name to w in the pretty format without colors,
+// oldest first. It's meant for panic handlers and crash reports.
+func DumpRingBuffer(name string, w io.Writer) error {
+	rb := getRingBuffer(name)
+	if rb == nil {
+		return fmt.Errorf("ring buffer %q not found", name)
+	}
+	rb.lock.Lock()
+	entries := rb.entries.list()
+	rb.lock.Unlock()
+	pretty := zerolog.ConsoleWriter{Out: w, NoColor: true, TimeFormat: time.RFC3339}
+	for _, entry := range entries {
+		if _, err := pretty.Write(entry.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
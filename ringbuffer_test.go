@@ -0,0 +1,140 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// ringBufferConfig returns a config with a single ringbuffer writer named after the test.
+// Buffers are global, so the registry is reset first to not see events from previous runs (e.g. with -count).
+func ringBufferConfig(t *testing.T, size int) *zeroconfig.Config {
+	zeroconfig.ResetRingBuffers()
+	t.Cleanup(func() {
+		zeroconfig.ReleaseRingBuffer(t.Name())
+	})
+	return &zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type:       zeroconfig.WriterTypeRingBuffer,
+		RingBuffer: &zeroconfig.RingBufferConfig{Name: t.Name(), Size: size},
+	}}}
+}
+
+func TestWriterConfig_Compile_RingBuffer(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	log, err := ringBufferConfig(t, 3).Compile(zeroconfig.WithClock(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}))
+	require.NoError(t, err)
+	assert.Empty(t, zeroconfig.GetRingBuffer(t.Name()))
+	assert.Nil(t, zeroconfig.GetRingBuffer("test-nonexistent"))
+
+	log.Debug().Msg("one")
+	log.Info().Msg("two")
+	log.Warn().Int("count", 3).Msg("three")
+	log.Error().Msg("four")
+	assert.Equal(t, []zeroconfig.RingEntry{{
+		Level: zerolog.InfoLevel,
+		Time:  time.Date(2024, 1, 2, 3, 4, 7, 0, time.UTC),
+		Raw:   json.RawMessage(`{"level":"info","time":"2024-01-02T03:04:07Z","message":"two"}`),
+	}, {
+		Level: zerolog.WarnLevel,
+		Time:  time.Date(2024, 1, 2, 3, 4, 8, 0, time.UTC),
+		Raw:   json.RawMessage(`{"level":"warn","count":3,"time":"2024-01-02T03:04:08Z","message":"three"}`),
+	}, {
+		Level: zerolog.ErrorLevel,
+		Time:  time.Date(2024, 1, 2, 3, 4, 9, 0, time.UTC),
+		Raw:   json.RawMessage(`{"level":"error","time":"2024-01-02T03:04:09Z","message":"four"}`),
+	}}, zeroconfig.GetRingBuffer(t.Name()), "The oldest events should be overwritten")
+
+	var dump bytes.Buffer
+	require.NoError(t, zeroconfig.DumpRingBuffer(t.Name(), &dump))
+	assert.Regexp(t, `^\S+ INF two\n\S+ WRN three count=3\n\S+ ERR four\n$`, dump.String())
+	assert.EqualError(t, zeroconfig.DumpRingBuffer("test-nonexistent", &dump), `ring buffer "test-nonexistent" not found`)
+}
+
+func TestWriterConfig_Compile_RingBufferRecompile(t *testing.T) {
+	cfg := ringBufferConfig(t, 0)
+	cfg.Timestamp = ptr(false)
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		log.Info().Int("i", i).Send()
+	}
+	require.Len(t, zeroconfig.GetRingBuffer(t.Name()), 5)
+
+	cfg.Writers[0].RingBuffer.Size = 2
+	log, err = cfg.Compile()
+	require.NoError(t, err)
+	entries := zeroconfig.GetRingBuffer(t.Name())
+	require.Len(t, entries, 2, "Recompiling with a smaller size should keep the newest events")
+	assert.JSONEq(t, `{"level":"info","i":3}`, string(entries[0].Raw))
+	assert.JSONEq(t, `{"level":"info","i":4}`, string(entries[1].Raw))
+	assert.False(t, entries[1].Time.IsZero(), "Events without a timestamp should get the time they were written")
+
+	log.Info().Int("i", 5).Send()
+	entries = zeroconfig.GetRingBuffer(t.Name())
+	assert.JSONEq(t, `{"level":"info","i":5}`, string(entries[1].Raw))
+}
+
+func TestWriterConfig_Compile_RingBufferConcurrent(t *testing.T) {
+	log, err := ringBufferConfig(t, 10).Compile()
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.Info().Msg(fmt.Sprintf("%d-%d", i, j))
+				zeroconfig.GetRingBuffer(t.Name())
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, zeroconfig.GetRingBuffer(t.Name()), 10)
+}
+
+func TestWriterConfig_Compile_RingBufferInvalid(t *testing.T) {
+	_, err := parseConfig(t, `{"writers": [{"type": "ringbuffer"}, {"type": "ringbuffer", "ringbuffer": {"name": "x", "size": -1}}]}`).Compile()
+	assert.EqualError(t, err, "writers[0].ringbuffer.name: required for the ringbuffer writer; writers[1].ringbuffer.size: must not be negative")
+	_, err = (&zeroconfig.WriterConfig{
+		Type:       zeroconfig.WriterTypeRingBuffer,
+		Format:     zeroconfig.LogFormatPretty,
+		RingBuffer: &zeroconfig.RingBufferConfig{Name: t.Name()},
+	}).Compile()
+	assert.EqualError(t, err, "the ringbuffer writer only supports the json format")
+}
+
+func TestReleaseRingBuffer(t *testing.T) {
+	cfg := ringBufferConfig(t, 0)
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	log.Info().Msg("meow")
+	require.Len(t, zeroconfig.GetRingBuffer(t.Name()), 1)
+
+	assert.True(t, zeroconfig.ReleaseRingBuffer(t.Name()))
+	assert.False(t, zeroconfig.ReleaseRingBuffer(t.Name()))
+	assert.Nil(t, zeroconfig.GetRingBuffer(t.Name()))
+	log.Info().Msg("meow")
+	assert.Nil(t, zeroconfig.GetRingBuffer(t.Name()), "Released buffers should stay released")
+
+	_, err = cfg.Compile()
+	require.NoError(t, err)
+	assert.Empty(t, zeroconfig.GetRingBuffer(t.Name()), "Compiling again should create a new buffer")
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// RingFileConfig contains the configuration options for the ring file writer.
+// The path of the file is specified with the Filename field of FileConfig.
+type RingFileConfig struct {
+	// Total size of the ring file in bytes, including the header. Defaults to 1 MiB.
+	RingSize int64 `json:"ring_size,omitempty" yaml:"ring_size,omitempty" toml:"ring_size,omitempty"`
+}
+
+// The ring file starts with a fixed-size header:
+//
+//	magic (8 bytes) | file size (8) | tail offset (8) | head offset (8) | next sequence number (8) | padding
+//
+// followed by records, each of which has a header:
+//
+//	magic (2 bytes) | data length (4) | sequence number (8) | CRC-32 of sequence number and data (4)
+//
+// When a record doesn't fit at the end of the file, a wrap marker is written (if there's space)
+// and the record is written at the start of the data area instead. The file header is always
+// updated before writing the record, so a crash can only leave the newest record torn, which
+// is detected by the checksum.
+const (
+	ringMagic            = "ZCRING01"
+	ringHeaderSize       = 64
+	ringRecordHeaderSize = 18
+	ringRecordMagic      = 0x5a52
+	ringWrapMagic        = 0x5a57
+	defaultRingSize      = 1024 * 1024
+)
+
+var ringByteOrder = binary.BigEndian
+
+type ringHeader struct {
+	size    uint64
+	tail    uint64
+	head    uint64
+	nextSeq uint64
+}
+
+func (rh *ringHeader) marshal() []byte {
+	buf := make([]byte, ringHeaderSize)
+	copy(buf, ringMagic)
+	ringByteOrder.PutUint64(buf[8:], rh.size)
+	ringByteOrder.PutUint64(buf[16:], rh.tail)
+	ringByteOrder.PutUint64(buf[24:], rh.head)
+	ringByteOrder.PutUint64(buf[32:], rh.nextSeq)
+	return buf
+}
+
+func readRingHeader(r io.ReaderAt) (*ringHeader, error) {
+	buf := make([]byte, ringHeaderSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	} else if string(buf[:8]) != ringMagic {
+		return nil, fmt.Errorf("not a ring file")
+	}
+	rh := &ringHeader{
+		size:    ringByteOrder.Uint64(buf[8:]),
+		tail:    ringByteOrder.Uint64(buf[16:]),
+		head:    ringByteOrder.Uint64(buf[24:]),
+		nextSeq: ringByteOrder.Uint64(buf[32:]),
+	}
+	if rh.size < ringHeaderSize+ringRecordHeaderSize || rh.tail >= rh.size || rh.head > rh.size {
+		return nil, fmt.Errorf("corrupted ring file header")
+	}
+	return rh, nil
+}
+
+type ringRecord struct {
+	offset uint64
+	length uint64
+	seq    uint64
+}
+
+func ringChecksum(seq []byte, data []byte) uint32 {
+	crc := crc32.ChecksumIEEE(seq)
+	return crc32.Update(crc, crc32.IEEETable, data)
+}
+
+// walkRing reads the records in the ring in order, starting from the tail.
+// It stops at the newest record, or at the first record that is invalid or out of sequence.
+func walkRing(r io.ReaderAt, rh *ringHeader) (records []ringRecord, data [][]byte) {
+	if rh.nextSeq == 0 {
+		return
+	}
+	pos := rh.tail
+	var walked uint64
+	hdr := make([]byte, ringRecordHeaderSize)
+	for walked <= rh.size {
+		if pos+ringRecordHeaderSize > rh.size {
+			walked += rh.size - pos
+			pos = ringHeaderSize
+			continue
+		}
+		if _, err := r.ReadAt(hdr, int64(pos)); err != nil {
+			return
+		}
+		magic := ringByteOrder.Uint16(hdr)
+		if magic == ringWrapMagic {
+			walked += rh.size - pos
+			pos = ringHeaderSize
+			continue
+		} else if magic != ringRecordMagic {
+			return
+		}
+		length := uint64(ringByteOrder.Uint32(hdr[2:]))
+		seq := ringByteOrder.Uint64(hdr[6:])
+		if pos+ringRecordHeaderSize+length > rh.size {
+			return
+		} else if len(records) > 0 && seq != records[len(records)-1].seq+1 {
+			return
+		}
+		buf := make([]byte, length)
+		if _, err := r.ReadAt(buf, int64(pos+ringRecordHeaderSize)); err != nil {
+			return
+		} else if ringChecksum(hdr[6:14], buf) != ringByteOrder.Uint32(hdr[14:]) {
+			return
+		}
+		records = append(records, ringRecord{offset: pos, length: ringRecordHeaderSize + length, seq: seq})
+		data = append(data, buf)
+		if seq+1 == rh.nextSeq {
+			return
+		}
+		pos += ringRecordHeaderSize + length
+		walked += ringRecordHeaderSize + length
+	}
+	return
+}
+
+// ReadRingFile reads all intact records from a ring file written by the ringfile writer type, oldest first.
+//
+// If the process crashed in the middle of a write, the torn record and anything after it is ignored.
+func ReadRingFile(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rh, err := readRingHeader(file)
+	if err != nil {
+		return nil, err
+	}
+	_, data := walkRing(file, rh)
+	return data, nil
+}
+
+// ringFileWriter writes records into a ring file. When the file is created (or its size changes), disk space is
+// reserved for all of it, so that writes can't fail later because the disk is full. That's done with fallocate
+// on Linux and by writing zeros elsewhere (or if the filesystem doesn't support fallocate). Copy-on-write
+// filesystems like btrfs and ZFS may still need new space for each write.
+type ringFileWriter struct {
+	lock    sync.Mutex
+	file    *os.File
+	header  ringHeader
+	records []ringRecord
+}
+
+func randomSessionID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func compileRingFile(wc *WriterConfig) (io.Writer, error) {
+	filename := wc.expandedFilename()
+	if filename == "" {
+		return nil, fmt.Errorf("ringfile writer requires a filename")
+	}
+	size := wc.RingSize
+	if size == 0 {
+		size = defaultRingSize
+	} else if size < ringHeaderSize+ringRecordHeaderSize*16 {
+		return nil, fmt.Errorf("ring_size is too small")
+	}
+	rw, err := openRingFile(filename, uint64(size))
+	if err != nil {
+		return nil, err
+	}
+	marker := zerolog.New(rw)
+	marker.Info().Timestamp().Str("session", randomSessionID()).Msg("Ring file session started")
+	return rw, nil
+}
+
+func openRingFile(path string, size uint64) (*ringFileWriter, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	rw := &ringFileWriter{file: file}
+	rh, err := readRingHeader(file)
+	if err == nil && rh.size == size {
+		rw.header = *rh
+		rw.records, _ = walkRing(file, rh)
+		if len(rw.records) > 0 {
+			// Continue after the last intact record, so that a torn record gets overwritten.
+			last := rw.records[len(rw.records)-1]
+			rw.header.head = last.offset + last.length
+			rw.header.nextSeq = last.seq + 1
+			rw.header.tail = rw.records[0].offset
+		} else {
+			rw.header.head = ringHeaderSize
+			rw.header.tail = ringHeaderSize
+		}
+	} else {
+		// Not a ring file or the size changed: start over.
+		if err = file.Truncate(0); err == nil {
+			err = preallocateRingFile(file, int64(size))
+		}
+		if err == nil {
+			err = file.Sync()
+		}
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		rw.header = ringHeader{size: size, tail: ringHeaderSize, head: ringHeaderSize}
+	}
+	if err = rw.writeHeader(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return rw, nil
+}
+
+// writeRingZeros fills the file with zeros. Unlike Truncate, which usually creates a sparse file without
+// any disk space reserved, this makes the filesystem allocate space for the whole file.
+func writeRingZeros(file *os.File, size int64) error {
+	zeros := make([]byte, 64*1024)
+	for offset := int64(0); offset < size; offset += int64(len(zeros)) {
+		chunk := zeros
+		if size-offset < int64(len(chunk)) {
+			chunk = chunk[:size-offset]
+		}
+		if _, err := file.WriteAt(chunk, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rw *ringFileWriter) writeHeader() error {
+	_, err := rw.file.WriteAt(rw.header.marshal(), 0)
+	return err
+}
+
+var errRingRecordTooLarge = errors.New("record is too large for ring file")
+
+func (rw *ringFileWriter) Write(p []byte) (n int, err error) {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	length := ringRecordHeaderSize + uint64(len(p))
+	if length > rw.header.size-ringHeaderSize || uint64(len(p)) > math.MaxUint32 {
+		return 0, errRingRecordTooLarge
+	}
+	pos := rw.header.head
+	if pos+length > rw.header.size {
+		// Everything after the head is older than the records at the start of the file,
+		// so it must be discarded before wrapping around.
+		for len(rw.records) > 0 && rw.records[0].offset >= pos {
+			rw.records = rw.records[1:]
+		}
+		if pos+ringRecordHeaderSize <= rw.header.size {
+			marker := make([]byte, 2)
+			ringByteOrder.PutUint16(marker, ringWrapMagic)
+			if _, err = rw.file.WriteAt(marker, int64(pos)); err != nil {
+				return 0, err
+			}
+		}
+		pos = ringHeaderSize
+	}
+	for len(rw.records) > 0 && rw.records[0].offset >= pos && rw.records[0].offset < pos+length {
+		rw.records = rw.records[1:]
+	}
+	record := ringRecord{offset: pos, length: length, seq: rw.header.nextSeq}
+	rw.records = append(rw.records, record)
+	rw.header.tail = rw.records[0].offset
+	rw.header.head = pos + length
+	rw.header.nextSeq++
+	if err = rw.writeHeader(); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, length)
+	ringByteOrder.PutUint16(buf, ringRecordMagic)
+	ringByteOrder.PutUint32(buf[2:], uint32(len(p)))
+	ringByteOrder.PutUint64(buf[6:], record.seq)
+	copy(buf[ringRecordHeaderSize:], p)
+	ringByteOrder.PutUint32(buf[14:], ringChecksum(buf[6:14], p))
+	if _, err = rw.file.WriteAt(buf, int64(pos)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *ringFileWriter) Close() error {
+	rw.lock.Lock()
+	defer rw.lock.Unlock()
+	return rw.file.Close()
+}
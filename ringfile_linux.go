@@ -0,0 +1,35 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package zeroconfig
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocateRingFile reserves disk space for the whole ring file with fallocate.
+// Filesystems that don't support fallocate get zeros written instead.
+func preallocateRingFile(file *os.File, size int64) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var allocErr error
+	err = conn.Control(func(fd uintptr) {
+		allocErr = unix.Fallocate(int(fd), 0, 0, size)
+	})
+	if err != nil {
+		return err
+	} else if errors.Is(allocErr, unix.EOPNOTSUPP) || errors.Is(allocErr, unix.ENOSYS) {
+		return writeRingZeros(file, size)
+	}
+	return allocErr
+}
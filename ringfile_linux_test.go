@@ -0,0 +1,31 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package zeroconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterConfig_Compile_RingFilePreallocated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.ring")
+	log := compile(t, `{"writers": [{"type": "ringfile", "filename": "`+path+`", "ring_size": 262144}]}`)
+	log.Info().Msg("meow")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.EqualValues(t, 262144, info.Size())
+	// Truncate would only allocate the blocks that have been written to.
+	assert.GreaterOrEqual(t, info.Sys().(*syscall.Stat_t).Blocks*512, int64(262144), "Whole file should be allocated")
+}
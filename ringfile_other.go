@@ -0,0 +1,19 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+
+package zeroconfig
+
+import (
+	"os"
+)
+
+// preallocateRingFile reserves disk space for the whole ring file by writing zeros,
+// as fallocate is only used on Linux.
+func preallocateRingFile(file *os.File, size int64) error {
+	return writeRingZeros(file, size)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type ringLine struct {
+	Message string `json:"message"`
+	Num     *int   `json:"num"`
+	Session string `json:"session"`
+}
+
+func openRing(t *testing.T, path string) (*zerolog.Logger, io.Closer) {
+	wc := zeroconfig.WriterConfig{
+		Type:           zeroconfig.WriterTypeRingFile,
+		FileConfig:     zeroconfig.FileConfig{Filename: path},
+		RingFileConfig: zeroconfig.RingFileConfig{RingSize: 2048},
+	}
+	writer, err := wc.Compile()
+	require.NoError(t, err, "Compiling ringfile writer should be successful")
+	log := zerolog.New(writer)
+	return &log, writer.(io.Closer)
+}
+
+func readRing(t *testing.T, path string) []ringLine {
+	records, err := zeroconfig.ReadRingFile(path)
+	require.NoError(t, err, "Reading ring file should be successful")
+	lines := make([]ringLine, len(records))
+	for i, record := range records {
+		require.NoError(t, json.Unmarshal(record, &lines[i]), "Record #%d should be valid JSON", i)
+	}
+	return lines
+}
+
+func assertContiguous(t *testing.T, lines []ringLine, last int) {
+	require.NotEmpty(t, lines)
+	for i, line := range lines {
+		require.NotNil(t, line.Num, "Record #%d should have a number", i)
+		assert.Equal(t, last-len(lines)+1+i, *line.Num, "Records should be in order without gaps")
+	}
+}
+
+func TestRingFile_WrapAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring.bin")
+	log, closer := openRing(t, path)
+	for i := 0; i < 100; i++ {
+		log.Info().Int("num", i).Msg("meow")
+	}
+	require.NoError(t, closer.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2048, info.Size(), "Ring file should have a fixed size")
+
+	lines := readRing(t, path)
+	assert.Less(t, len(lines), 100, "Ring should have wrapped around")
+	assertContiguous(t, lines, 99)
+
+	log, closer = openRing(t, path)
+	for i := 100; i < 103; i++ {
+		log.Info().Int("num", i).Msg("meow")
+	}
+	require.NoError(t, closer.Close())
+
+	lines = readRing(t, path)
+	require.Greater(t, len(lines), 4)
+	assertContiguous(t, lines[:len(lines)-4], 99)
+	assert.Equal(t, "Ring file session started", lines[len(lines)-4].Message, "Reopening should write a session marker")
+	assert.NotEmpty(t, lines[len(lines)-4].Session)
+	assertContiguous(t, lines[len(lines)-3:], 102)
+}
+
+func TestRingFile_TornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring.bin")
+	log, closer := openRing(t, path)
+	for i := 0; i < 50; i++ {
+		log.Info().Int("num", i).Msg("meow")
+	}
+	require.NoError(t, closer.Close())
+
+	// Simulate a crash in the middle of writing the newest record by corrupting its last byte.
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	header := make([]byte, 32)
+	_, err = file.ReadAt(header, 0)
+	require.NoError(t, err)
+	head := binary.BigEndian.Uint64(header[24:])
+	_, err = file.WriteAt([]byte{0}, int64(head-2))
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	lines := readRing(t, path)
+	assertContiguous(t, lines, 48)
+
+	log, closer = openRing(t, path)
+	log.Info().Int("num", 49).Msg("meow")
+	require.NoError(t, closer.Close())
+	lines = readRing(t, path)
+	assertContiguous(t, lines[:len(lines)-2], 48)
+	assert.Equal(t, "Ring file session started", lines[len(lines)-2].Message, "Torn record should be overwritten")
+	assert.Equal(t, 49, *lines[len(lines)-1].Num)
+}
@@ -0,0 +1,241 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// SamplingConfig contains the configuration for sampling events written to a single writer.
+type SamplingConfig struct {
+	// Only write one of every N events. 0 and 1 mean all events are written.
+	BasicN uint32 `json:"basic_n,omitempty" yaml:"basic_n,omitempty" toml:"basic_n,omitempty"`
+	// Write the first Burst events of every BurstPeriod unsampled, and only apply BasicN after that.
+	// If BasicN isn't set, events after the burst are dropped until the next period.
+	Burst uint32 `json:"burst,omitempty" yaml:"burst,omitempty" toml:"burst,omitempty"`
+	// The period for Burst. Defaults to 1s.
+	BurstPeriod time.Duration `json:"burst_period,omitempty" yaml:"burst_period,omitempty" toml:"burst_period,omitempty"`
+	// Events at or above this level are never sampled.
+	BypassLevel *zerolog.Level `json:"bypass_level,omitempty" yaml:"bypass_level,omitempty" toml:"bypass_level,omitempty"`
+	// Sampling settings for specific levels (by name, like debug), which replace the settings above for events
+	// at that level. An empty override disables sampling for the level.
+	Levels map[string]*SamplingConfig `json:"levels,omitempty" yaml:"levels,omitempty" toml:"levels,omitempty"`
+	// Write the first events after compiling unsampled, either a number of events or a duration.
+	StartupBurst *StartupBurst `json:"startup_burst,omitempty" yaml:"startup_burst,omitempty" toml:"startup_burst,omitempty"`
+}
+
+const defaultSamplingBurstPeriod = 1 * time.Second
+
+func (sc *SamplingConfig) UnmarshalJSON(data []byte) error {
+	type plainSamplingConfig SamplingConfig
+	aux := struct {
+		*plainSamplingConfig
+		BurstPeriod json.RawMessage `json:"burst_period,omitempty"`
+	}{plainSamplingConfig: (*plainSamplingConfig)(sc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	} else if err = parseDurationJSON(aux.BurstPeriod, &sc.BurstPeriod); err != nil {
+		return &PathError{Path: "burst_period", Err: err}
+	}
+	return nil
+}
+
+// StartupBurst is either a number of events or a duration during which sampling is disabled after startup.
+//
+// It's parsed from a plain number (a count) or a Go duration string like 30s.
+type StartupBurst struct {
+	Count    uint64
+	Duration time.Duration
+}
+
+// ParseStartupBurst parses a startup burst count or duration.
+func ParseStartupBurst(val string) (StartupBurst, error) {
+	if count, err := strconv.ParseUint(val, 10, 64); err == nil {
+		return StartupBurst{Count: count}, nil
+	}
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		return StartupBurst{}, fmt.Errorf("invalid startup burst %q: must be a count or a duration", val)
+	} else if dur < 0 {
+		return StartupBurst{}, fmt.Errorf("invalid startup burst %q: duration must not be negative", val)
+	}
+	return StartupBurst{Duration: dur}, nil
+}
+
+func (sb StartupBurst) String() string {
+	if sb.Duration != 0 {
+		return sb.Duration.String()
+	}
+	return strconv.FormatUint(sb.Count, 10)
+}
+
+func (sb *StartupBurst) UnmarshalText(text []byte) (err error) {
+	*sb, err = ParseStartupBurst(string(text))
+	return
+}
+
+func (sb StartupBurst) MarshalText() ([]byte, error) {
+	return []byte(sb.String()), nil
+}
+
+func (sb *StartupBurst) UnmarshalJSON(data []byte) error {
+	var str string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+	} else {
+		str = string(data)
+	}
+	return sb.UnmarshalText([]byte(str))
+}
+
+func (sb StartupBurst) MarshalJSON() ([]byte, error) {
+	if sb.Duration != 0 {
+		return json.Marshal(sb.Duration.String())
+	}
+	return []byte(strconv.FormatUint(sb.Count, 10)), nil
+}
+
+// UnmarshalTOML implements toml.Unmarshaler, as TOML integers aren't passed to UnmarshalText.
+func (sb *StartupBurst) UnmarshalTOML(value any) error {
+	switch typedValue := value.(type) {
+	case int64:
+		if typedValue < 0 {
+			return fmt.Errorf("invalid startup burst %d: count must not be negative", typedValue)
+		}
+		*sb = StartupBurst{Count: uint64(typedValue)}
+		return nil
+	case string:
+		return sb.UnmarshalText([]byte(typedValue))
+	default:
+		return fmt.Errorf("invalid startup burst %v: must be a count or a duration", value)
+	}
+}
+
+// startupBurstSampler passes all events until the startup burst is used up, then defers to the next sampler.
+type startupBurstSampler struct {
+	count    uint64
+	deadline time.Time
+	seen     atomic.Uint64
+	next     zerolog.Sampler
+}
+
+func (sbs *startupBurstSampler) Sample(level zerolog.Level) bool {
+	if sbs.count > 0 && sbs.seen.Add(1) <= sbs.count {
+		return true
+	} else if !sbs.deadline.IsZero() && time.Now().Before(sbs.deadline) {
+		return true
+	}
+	return sbs.next.Sample(level)
+}
+
+// samplingWriter drops events that aren't part of the sample.
+type samplingWriter struct {
+	zerolog.LevelWriter
+	sampler zerolog.Sampler
+	// Counter for events that weren't part of the sample, may be nil.
+	dropped *atomic.Uint64
+}
+
+func (sw *samplingWriter) Write(p []byte) (n int, err error) {
+	return sw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (sw *samplingWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	if !sw.sampler.Sample(level) {
+		if sw.dropped != nil {
+			sw.dropped.Add(1)
+		}
+		return len(p), nil
+	}
+	return sw.LevelWriter.WriteLevel(level, p)
+}
+
+// bypassLevelSampler passes all events at or above the level and defers to the next sampler for the rest.
+type bypassLevelSampler struct {
+	level zerolog.Level
+	next  zerolog.Sampler
+}
+
+func (bls *bypassLevelSampler) Sample(level zerolog.Level) bool {
+	if level >= bls.level && level != zerolog.NoLevel {
+		return true
+	}
+	return bls.next.Sample(level)
+}
+
+// levelOverrideSampler uses a separate sampler for some levels. Nil samplers pass all events.
+type levelOverrideSampler struct {
+	overrides map[zerolog.Level]zerolog.Sampler
+	next      zerolog.Sampler
+}
+
+func (los *levelOverrideSampler) Sample(level zerolog.Level) bool {
+	sampler, ok := los.overrides[level]
+	if !ok {
+		sampler = los.next
+	}
+	return sampler == nil || sampler.Sample(level)
+}
+
+func (sc *SamplingConfig) compile() (zerolog.Sampler, error) {
+	if sc == nil {
+		return nil, nil
+	}
+	var sampler zerolog.Sampler
+	if sc.BasicN > 1 {
+		sampler = &zerolog.BasicSampler{N: sc.BasicN}
+	}
+	if sc.Burst > 0 {
+		period := sc.BurstPeriod
+		if period <= 0 {
+			period = defaultSamplingBurstPeriod
+		}
+		sampler = &zerolog.BurstSampler{Burst: sc.Burst, Period: period, NextSampler: sampler}
+	}
+	if len(sc.Levels) > 0 {
+		overrides := make(map[zerolog.Level]zerolog.Sampler, len(sc.Levels))
+		for name, override := range sc.Levels {
+			level, err := zerolog.ParseLevel(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid level %q in levels: %w", name, err)
+			}
+			overrides[level], err = override.compile()
+			if err != nil {
+				return nil, fmt.Errorf("invalid override for %s: %w", name, err)
+			}
+		}
+		sampler = &levelOverrideSampler{overrides: overrides, next: sampler}
+	}
+	if sampler == nil {
+		return nil, nil
+	}
+	if sc.StartupBurst != nil {
+		burst := &startupBurstSampler{count: sc.StartupBurst.Count, next: sampler}
+		if sc.StartupBurst.Duration > 0 {
+			burst.deadline = time.Now().Add(sc.StartupBurst.Duration)
+		}
+		sampler = burst
+	}
+	if sc.BypassLevel != nil {
+		sampler = &bypassLevelSampler{level: *sc.BypassLevel, next: sampler}
+	}
+	return sampler, nil
+}
+
+func wrapSampling(writer io.Writer, sampler zerolog.Sampler, dropped *atomic.Uint64) zerolog.LevelWriter {
+	lw := asLevelWriter(writer)
+	return &samplingWriter{LevelWriter: lw, sampler: sampler, dropped: dropped}
+}
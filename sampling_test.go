@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestStartupBurst_Unmarshal(t *testing.T) {
+	var cfg zeroconfig.SamplingConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"basic_n": 5, "startup_burst": 100}`), &cfg))
+	assert.Equal(t, &zeroconfig.StartupBurst{Count: 100}, cfg.StartupBurst)
+	require.NoError(t, json.Unmarshal([]byte(`{"startup_burst": "30s"}`), &cfg))
+	assert.Equal(t, &zeroconfig.StartupBurst{Duration: 30 * time.Second}, cfg.StartupBurst)
+	require.NoError(t, yaml.Unmarshal([]byte("startup_burst: 100"), &cfg))
+	assert.Equal(t, &zeroconfig.StartupBurst{Count: 100}, cfg.StartupBurst)
+	require.NoError(t, yaml.Unmarshal([]byte("startup_burst: 1m"), &cfg))
+	assert.Equal(t, &zeroconfig.StartupBurst{Duration: time.Minute}, cfg.StartupBurst)
+	require.NoError(t, toml.Unmarshal([]byte("startup_burst = 100"), &cfg))
+	assert.Equal(t, &zeroconfig.StartupBurst{Count: 100}, cfg.StartupBurst)
+	require.NoError(t, toml.Unmarshal([]byte(`startup_burst = "5s"`), &cfg))
+	assert.Equal(t, &zeroconfig.StartupBurst{Duration: 5 * time.Second}, cfg.StartupBurst)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"startup_burst": "meow"}`), &cfg))
+	assert.Error(t, json.Unmarshal([]byte(`{"startup_burst": -1}`), &cfg))
+	assert.Error(t, json.Unmarshal([]byte(`{"startup_burst": "-5s"}`), &cfg))
+
+	data, err := json.Marshal(zeroconfig.SamplingConfig{StartupBurst: &zeroconfig.StartupBurst{Duration: time.Second}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"startup_burst":"1s"}`, string(data))
+}
+
+func TestWriterConfig_Compile_Sampling(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout", "sampling": {"basic_n": 10}}],
+	  "timestamp": false
+	}`)
+	for i := 0; i < 100; i++ {
+		log.Info().Msg("meow")
+	}
+	assert.Equal(t, 10, strings.Count(out.String(), "\n"), "Only every 10th event should be written")
+}
+
+func TestWriterConfig_Compile_SamplingStartupBurstCount(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout", "sampling": {"basic_n": 10, "startup_burst": 20}}],
+	  "timestamp": false
+	}`)
+	for i := 0; i < 120; i++ {
+		log.Info().Msg("meow")
+	}
+	assert.Equal(t, 30, strings.Count(out.String(), "\n"), "First 20 events should be written unsampled, then every 10th")
+}
+
+func TestWriterConfig_Compile_SamplingStartupBurstDuration(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout", "sampling": {"basic_n": 10, "startup_burst": "50ms"}}],
+	  "timestamp": false
+	}`)
+	for i := 0; i < 20; i++ {
+		log.Info().Msg("meow")
+	}
+	assert.Equal(t, 20, strings.Count(out.String(), "\n"), "Events during the startup burst should be written unsampled")
+	out.Reset()
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		log.Info().Msg("meow")
+	}
+	assert.Equal(t, 10, strings.Count(out.String(), "\n"), "Events after the startup burst should be sampled")
+}
+
+func TestWriterConfig_Compile_SamplingBurst(t *testing.T) {
+	dir := t.TempDir()
+	var stderr bytes.Buffer
+	zeroconfig.Stderr = &stderr
+	log := compile(t, `{
+	  "writers": [
+	    {"type": "file", "filename": "`+dir+`/sampled.log", "sampling": {"burst": 5, "burst_period": "1h", "basic_n": 10, "bypass_level": "warn"}},
+	    {"type": "stderr"}
+	  ],
+	  "timestamp": false
+	}`)
+	for i := 0; i < 105; i++ {
+		log.Debug().Msg("meow")
+	}
+	log.Error().Msg("important")
+	data, err := os.ReadFile(filepath.Join(dir, "sampled.log"))
+	require.NoError(t, err)
+	assert.Equal(t, 16, strings.Count(string(data), "\n"), "First 5 events should be written, then every 10th, plus the error")
+	assert.Contains(t, string(data), "important", "Errors should bypass sampling")
+	assert.Equal(t, 106, strings.Count(stderr.String(), "\n"), "Writers without sampling should get everything")
+}
+
+func TestWriterConfig_Compile_SamplingBurstOnly(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	log := compile(t, `{
+	  "writers": [{"type": "stdout", "sampling": {"burst": 3, "burst_period": "50ms"}}],
+	  "timestamp": false
+	}`)
+	for i := 0; i < 10; i++ {
+		log.Info().Msg("meow")
+	}
+	assert.Equal(t, 3, strings.Count(out.String(), "\n"), "Events after the burst should be dropped")
+	time.Sleep(60 * time.Millisecond)
+	log.Info().Msg("meow")
+	assert.Equal(t, 4, strings.Count(out.String(), "\n"), "A new burst should start after the period")
+}
+
+func TestWriterConfig_Compile_SamplingLevels(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	cfg := parseConfig(t, `{
+	  "writers": [
+	    {"type": "stdout", "max_level": "info", "sampling": {"basic_n": 5, "levels": {"debug": {"basic_n": 20}, "info": {}}}},
+	    {"type": "stderr"}
+	  ],
+	  "timestamp": false
+	}`)
+	log, err := cfg.Compile()
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		log.Debug().Msg("meow")
+		log.Info().Msg("meow")
+		log.Warn().Msg("meow")
+	}
+	assert.Equal(t, 5, strings.Count(stdout.String(), `"level":"debug"`), "Debug events should use the override")
+	assert.Equal(t, 100, strings.Count(stdout.String(), `"level":"info"`), "Info events shouldn't be sampled")
+	assert.Zero(t, strings.Count(stdout.String(), `"level":"warn"`), "Max level should still apply")
+	assert.Equal(t, 300, strings.Count(stderr.String(), "\n"), "Unsampled writers should get all events")
+
+	cfg.Writers[0].Sampling.Levels["meow"] = &zeroconfig.SamplingConfig{}
+	_, err = cfg.Compile()
+	assert.ErrorContains(t, err, `writers[0].sampling: invalid level "meow" in levels`)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// resolveSecret returns the value of a credential option that can be set inline, as <field>_file
+// (the trimmed contents of a file) or as <field>_env (an environment variable), in that order of precedence.
+//
+// The field name is used in errors, and should be the full path of the inline option like kv.token.
+// Environment variables are read with LookupEnv.
+func resolveSecret(field, inline, file, env string) (string, error) {
+	if inline != "" {
+		return inline, nil
+	} else if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", &PathError{Path: field + "_file", Err: fmt.Errorf("failed to read secret: %w", err)}
+		}
+		return strings.TrimSpace(string(data)), nil
+	} else if env != "" {
+		val, ok := LookupEnv(env)
+		if !ok {
+			return "", &PathError{Path: field + "_env", Err: fmt.Errorf("environment variable %s is not set", env)}
+		}
+		return strings.TrimSpace(val), nil
+	}
+	return "", nil
+}
+
+// resolveSecretMap resolves a map of credentials like resolveSecret, where each key can be set in any of the maps.
+// The field name is the path of the inline map like http.headers.
+func resolveSecretMap(field string, inline, file, env map[string]string) (map[string]string, error) {
+	keys := make(map[string]struct{}, len(inline)+len(file)+len(env))
+	for _, m := range []map[string]string{inline, file, env} {
+		for key := range m {
+			keys[key] = struct{}{}
+		}
+	}
+	resolved := make(map[string]string, len(keys))
+	for _, key := range sortedKeys(keys) {
+		val, err := resolveSecret(field, inline[key], file[key], env[key])
+		if err != nil {
+			// Point at the key inside the _file or _env map rather than at a nonexistent field.
+			if pathErr, ok := err.(*PathError); ok {
+				pathErr.Path += "." + key
+			}
+			return nil, err
+		}
+		resolved[key] = val
+	}
+	return resolved, nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// compileKVToken compiles a kv writer with the given config and returns the token the backend received.
+func compileKVToken(t *testing.T, cfg zeroconfig.KVConfig) (string, error) {
+	var token string
+	zeroconfig.RegisterKVBackend("token-test", func(cfg zeroconfig.KVConfig) (zeroconfig.KVBackend, error) {
+		token = cfg.Token
+		return &fakeKVBackend{values: make(map[string]string), ttls: make(map[string]time.Duration)}, nil
+	})
+	cfg.Backend = "token-test"
+	cfg.Prefix = "logs/"
+	_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeKV, KV: &cfg}).Compile()
+	return token, err
+}
+
+func TestWriterConfig_Compile_Secrets(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("from-file\n"), 0600))
+	t.Setenv("ZEROCONFIG_TEST_TOKEN", "from-env")
+
+	for _, tc := range []struct {
+		name     string
+		cfg      zeroconfig.KVConfig
+		expected string
+	}{
+		{"Inline", zeroconfig.KVConfig{Token: "inline"}, "inline"},
+		{"File", zeroconfig.KVConfig{TokenFile: tokenFile}, "from-file"},
+		{"Env", zeroconfig.KVConfig{TokenEnv: "ZEROCONFIG_TEST_TOKEN"}, "from-env"},
+		{"InlineOverFile", zeroconfig.KVConfig{Token: "inline", TokenFile: tokenFile, TokenEnv: "ZEROCONFIG_TEST_TOKEN"}, "inline"},
+		{"FileOverEnv", zeroconfig.KVConfig{TokenFile: tokenFile, TokenEnv: "ZEROCONFIG_TEST_TOKEN"}, "from-file"},
+		{"None", zeroconfig.KVConfig{}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := compileKVToken(t, tc.cfg)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, token)
+		})
+	}
+}
+
+func TestWriterConfig_Compile_SecretErrors(t *testing.T) {
+	_, err := compileKVToken(t, zeroconfig.KVConfig{TokenFile: filepath.Join(t.TempDir(), "missing")})
+	var pathErr *zeroconfig.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "kv.token_file", pathErr.Path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = compileKVToken(t, zeroconfig.KVConfig{TokenEnv: "ZEROCONFIG_TEST_MISSING_TOKEN"})
+	assert.EqualError(t, err, "kv.token_env: environment variable ZEROCONFIG_TEST_MISSING_TOKEN is not set")
+}
+
+func TestWriterConfig_Compile_HTTPSecretHeaders(t *testing.T) {
+	collector := newRequestCollector()
+	defer collector.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("Bearer from-file\n"), 0600))
+	zeroconfig.LookupEnv = func(name string) (string, bool) {
+		return map[string]string{"ORG_ID": "from-env"}[name], name == "ORG_ID"
+	}
+	defer func() {
+		zeroconfig.LookupEnv = os.LookupEnv
+	}()
+
+	writer, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeHTTP, HTTP: &zeroconfig.HTTPConfig{
+		URL:         collector.URL,
+		Headers:     map[string]string{"X-Inline": "meow", "X-Scope-OrgID": "inline"},
+		HeadersFile: map[string]string{"Authorization": tokenFile},
+		HeadersEnv:  map[string]string{"X-Scope-OrgID": "ORG_ID", "X-Env": "ORG_ID"},
+	}}).Compile()
+	require.NoError(t, err)
+	_, err = writer.Write([]byte(`{"message":"meow"}` + "\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.(io.Closer).Close())
+	require.Len(t, collector.headers, 1)
+	assert.Equal(t, "Bearer from-file", collector.headers[0].Get("Authorization"))
+	assert.Equal(t, "meow", collector.headers[0].Get("X-Inline"))
+	assert.Equal(t, "inline", collector.headers[0].Get("X-Scope-OrgID"), "Inline headers should take precedence")
+	assert.Equal(t, "from-env", collector.headers[0].Get("X-Env"), "Variables should be read with LookupEnv")
+
+	_, err = (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeHTTP, HTTP: &zeroconfig.HTTPConfig{
+		URL:        collector.URL,
+		HeadersEnv: map[string]string{"Authorization": "MISSING"},
+	}}).Compile()
+	assert.EqualError(t, err, "http.headers_env.Authorization: environment variable MISSING is not set")
+}
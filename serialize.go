@@ -0,0 +1,32 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// serializedWriter holds a lock for the whole duration of each write, so that all the writers
+// behind a multi-writer receive concurrent events in the same order.
+type serializedWriter struct {
+	zerolog.LevelWriter
+	lock sync.Mutex
+}
+
+func (sw *serializedWriter) Write(p []byte) (n int, err error) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	return sw.LevelWriter.Write(p)
+}
+
+func (sw *serializedWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	return sw.LevelWriter.WriteLevel(level, p)
+}
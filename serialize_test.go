@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// orderWriter records the order of the events it receives.
+type orderWriter struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (ow *orderWriter) Write(p []byte) (int, error) {
+	// Yield to make interleaving between the writers more likely without serialization.
+	runtime.Gosched()
+	ow.lock.Lock()
+	ow.events = append(ow.events, string(p))
+	ow.lock.Unlock()
+	return len(p), nil
+}
+
+func TestConfig_Compile_SerializeWriters(t *testing.T) {
+	var outputs []*orderWriter
+	zeroconfig.RegisterWriter("order-test", func(_ *zeroconfig.WriterConfig) (io.Writer, error) {
+		ow := &orderWriter{}
+		outputs = append(outputs, ow)
+		return ow, nil
+	})
+	log, err := (&zeroconfig.Config{
+		Writers:          []zeroconfig.WriterConfig{{Type: "order-test"}, {Type: "order-test"}},
+		SerializeWriters: true,
+	}).Compile()
+	require.NoError(t, err)
+	require.Len(t, outputs, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				log.Info().Int("goroutine", i).Int("event", j).Msg("meow")
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, outputs[0].events, 1000)
+	assert.Equal(t, outputs[0].events, outputs[1].events)
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.21
+
+package zeroconfig
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/rs/zerolog"
+)
+
+// CompileSlog compiles the config like Compile and wraps the logger in a slog.Handler, see NewSlogHandler.
+func (c *Config) CompileSlog(opts ...CompileOption) (*slog.Logger, error) {
+	log, err := c.Compile(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(NewSlogHandler(log)), nil
+}
+
+// NewSlogHandler returns a slog.Handler that writes records to the given zerolog logger.
+//
+// Levels are mapped to the closest zerolog level (anything below debug is trace), attributes become fields
+// and groups become nested objects. The record time is ignored in favor of the logger's own timestamp.
+func NewSlogHandler(log *zerolog.Logger) slog.Handler {
+	return &slogHandler{log: log, groups: []slogGroup{{}}}
+}
+
+// slogGroup is a group opened with WithGroup and the attributes added to it with WithAttrs.
+// The first group of a handler has no name and contains the top-level attributes.
+type slogGroup struct {
+	name  string
+	attrs []slog.Attr
+}
+
+type slogHandler struct {
+	log    *zerolog.Logger
+	groups []slogGroup
+}
+
+func slogToZerologLevel(level slog.Level) zerolog.Level {
+	switch {
+	case level < slog.LevelDebug:
+		return zerolog.TraceLevel
+	case level < slog.LevelInfo:
+		return zerolog.DebugLevel
+	case level < slog.LevelWarn:
+		return zerolog.InfoLevel
+	case level < slog.LevelError:
+		return zerolog.WarnLevel
+	default:
+		return zerolog.ErrorLevel
+	}
+}
+
+func (sh *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	zl := slogToZerologLevel(level)
+	return zl >= sh.log.GetLevel() && zl >= zerolog.GlobalLevel()
+}
+
+func (sh *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	evt := sh.log.WithLevel(slogToZerologLevel(record.Level))
+	if evt == nil {
+		return nil
+	}
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	sh.appendGroup(evt, 0, attrs).Msg(record.Message)
+	return nil
+}
+
+// appendGroup appends the attributes of the group at the given index to the event,
+// followed by the inner groups as nested objects and finally the record attributes in the innermost group.
+func (sh *slogHandler) appendGroup(evt *zerolog.Event, index int, record []slog.Attr) *zerolog.Event {
+	evt = appendSlogAttrs(evt, sh.groups[index].attrs)
+	if index == len(sh.groups)-1 {
+		return appendSlogAttrs(evt, record)
+	} else if !sh.hasAttrs(index+1, record) {
+		// Groups without any attributes are omitted.
+		return evt
+	}
+	return evt.Dict(sh.groups[index+1].name, sh.appendGroup(zerolog.Dict(), index+1, record))
+}
+
+func (sh *slogHandler) hasAttrs(fromIndex int, record []slog.Attr) bool {
+	if len(record) > 0 {
+		return true
+	}
+	for _, group := range sh.groups[fromIndex:] {
+		if len(group.attrs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func appendSlogAttrs(evt *zerolog.Event, attrs []slog.Attr) *zerolog.Event {
+	for _, attr := range attrs {
+		evt = appendSlogAttr(evt, attr)
+	}
+	return evt
+}
+
+func appendSlogAttr(evt *zerolog.Event, attr slog.Attr) *zerolog.Event {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return evt
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return evt.Str(attr.Key, attr.Value.String())
+	case slog.KindInt64:
+		return evt.Int64(attr.Key, attr.Value.Int64())
+	case slog.KindUint64:
+		return evt.Uint64(attr.Key, attr.Value.Uint64())
+	case slog.KindFloat64:
+		return evt.Float64(attr.Key, attr.Value.Float64())
+	case slog.KindBool:
+		return evt.Bool(attr.Key, attr.Value.Bool())
+	case slog.KindDuration:
+		return evt.Dur(attr.Key, attr.Value.Duration())
+	case slog.KindTime:
+		return evt.Time(attr.Key, attr.Value.Time())
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		if len(group) == 0 {
+			return evt
+		} else if attr.Key == "" {
+			// Groups with an empty key are inlined.
+			return appendSlogAttrs(evt, group)
+		}
+		return evt.Dict(attr.Key, appendSlogAttrs(zerolog.Dict(), group))
+	default:
+		if err, ok := attr.Value.Any().(error); ok {
+			return evt.AnErr(attr.Key, err)
+		}
+		return evt.Interface(attr.Key, attr.Value.Any())
+	}
+}
+
+func (sh *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return sh
+	}
+	groups := append([]slogGroup(nil), sh.groups...)
+	last := &groups[len(groups)-1]
+	last.attrs = append(append([]slog.Attr(nil), last.attrs...), attrs...)
+	return &slogHandler{log: sh.log, groups: groups}
+}
+
+func (sh *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return sh
+	}
+	groups := append(append([]slogGroup(nil), sh.groups...), slogGroup{name: name})
+	return &slogHandler{log: sh.log, groups: groups}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build go1.21
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func compileSlog(t *testing.T, cfg string) (*slog.Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	var parsed zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(cfg), &parsed))
+	log, err := parsed.CompileSlog()
+	require.NoError(t, err)
+	return log, &out
+}
+
+func TestConfig_CompileSlog(t *testing.T) {
+	log, out := compileSlog(t, `{"writers": [{"type": "stdout"}], "min_level": "debug", "timestamp": false}`)
+	log.Debug("debug", "count", 3)
+	log.Info("info", "ok", true, "took", 1500*time.Millisecond)
+	log.Warn("warn", slog.Uint64("size", 7), slog.Float64("ratio", 0.5))
+	log.Error("error", "error", errors.New("meow"))
+	log.Log(context.Background(), slog.LevelDebug-4, "trace is below the min level")
+	log.Log(context.Background(), slog.LevelError+4, "above error")
+	assert.Equal(t, `{"level":"debug","count":3,"message":"debug"}`+"\n"+
+		`{"level":"info","ok":true,"took":1500,"message":"info"}`+"\n"+
+		`{"level":"warn","size":7,"ratio":0.5,"message":"warn"}`+"\n"+
+		`{"level":"error","error":"meow","message":"error"}`+"\n"+
+		`{"level":"error","message":"above error"}`+"\n", out.String())
+	assert.False(t, log.Enabled(context.Background(), slog.LevelDebug-4))
+	assert.True(t, log.Enabled(context.Background(), slog.LevelDebug))
+}
+
+func TestConfig_CompileSlog_Groups(t *testing.T) {
+	log, out := compileSlog(t, `{"writers": [{"type": "stdout"}], "timestamp": false}`)
+	log = log.With("service", "api").WithGroup("request").With("id", 1).WithGroup("user")
+	log.Info("nested", "name", "meow", slog.Group("", slog.Int("inline", 2)), slog.Group("empty"))
+	log.Info("no record attrs")
+	log.With(slog.Group("meta", slog.String("a", "b"))).WithGroup("unused").Info("unused group")
+	assert.Equal(t, `{"level":"info","service":"api","request":{"id":1,"user":{"name":"meow","inline":2}},"message":"nested"}`+"\n"+
+		`{"level":"info","service":"api","request":{"id":1},"message":"no record attrs"}`+"\n"+
+		`{"level":"info","service":"api","request":{"id":1,"user":{"meta":{"a":"b"}}},"message":"unused group"}`+"\n", out.String())
+}
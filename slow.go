@@ -0,0 +1,56 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// SlowLogConfig configures how operations are logged by SlowLogConfig.Start.
+type SlowLogConfig struct {
+	// Operations that take longer than this are logged at SlowLevel.
+	Threshold time.Duration
+	// The level to log slow operations at. Defaults to warn.
+	SlowLevel *zerolog.Level
+	// The level to log operations that finished within the threshold at.
+	// If nil, fast operations are not logged at all.
+	FastLevel *zerolog.Level
+}
+
+// Start starts timing an operation. The returned function must be called when the operation finishes,
+// which will log msg along with the duration if the operation was slow (or FastLevel is set).
+func (slc SlowLogConfig) Start(logger *zerolog.Logger, msg string) func() {
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		var level zerolog.Level
+		if elapsed > slc.Threshold {
+			level = zerolog.WarnLevel
+			if slc.SlowLevel != nil {
+				level = *slc.SlowLevel
+			}
+		} else if slc.FastLevel != nil {
+			level = *slc.FastLevel
+		} else {
+			return
+		}
+		logger.WithLevel(level).
+			Dur("duration", elapsed).
+			Dur("threshold", slc.Threshold).
+			Msg(msg)
+	}
+}
+
+// LogIfSlow starts timing an operation and returns a function that logs msg at warn level
+// if more than threshold has elapsed when it's called. Fast operations are not logged.
+//
+//	defer zeroconfig.LogIfSlow(log, 500*time.Millisecond, "Slow database query")()
+func LogIfSlow(logger *zerolog.Logger, threshold time.Duration, msg string) func() {
+	return SlowLogConfig{Threshold: threshold}.Start(logger, msg)
+}
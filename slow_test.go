@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type slowLine struct {
+	Level    zerolog.Level `json:"level"`
+	Message  string        `json:"message"`
+	Duration float64       `json:"duration"`
+}
+
+func TestLogIfSlow(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+
+	zeroconfig.LogIfSlow(&log, time.Hour, "fast")()
+	assert.Empty(t, buf.String(), "Fast operations should not be logged")
+
+	done := zeroconfig.LogIfSlow(&log, time.Millisecond, "slow")
+	time.Sleep(5 * time.Millisecond)
+	done()
+	var line slowLine
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, zerolog.WarnLevel, line.Level)
+	assert.Equal(t, "slow", line.Message)
+	assert.GreaterOrEqual(t, line.Duration, float64(5), "Duration should be included in milliseconds")
+}
+
+func TestSlowLogConfig_Levels(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	slc := zeroconfig.SlowLogConfig{
+		Threshold: time.Hour,
+		SlowLevel: ptr(zerolog.ErrorLevel),
+		FastLevel: ptr(zerolog.DebugLevel),
+	}
+	slc.Start(&log, "fast")()
+	var line slowLine
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line), "Fast operation should be logged when FastLevel is set")
+	assert.Equal(t, zerolog.DebugLevel, line.Level)
+	buf.Reset()
+
+	slc.Threshold = 0
+	done := slc.Start(&log, "slow")
+	time.Sleep(time.Millisecond)
+	done()
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, zerolog.ErrorLevel, line.Level, "Slow operation should use SlowLevel")
+}
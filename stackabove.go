@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultStackMaxFrames is the number of frames captured for Config.StackAbove if StackMaxFrames is zero.
+const DefaultStackMaxFrames = 16
+
+// internalPackages are skipped when capturing stacks for Config.StackAbove, so that the stack starts at the caller.
+var internalPackages = map[string]struct{}{
+	"github.com/rs/zerolog": {},
+	"go.mau.fi/zeroconfig":  {},
+	"runtime":               {},
+}
+
+// funcPackage returns the import path of the package of a fully qualified function name
+// like go.mau.fi/zeroconfig.(*Handle).Close.
+func funcPackage(name string) string {
+	lastSlash := strings.LastIndexByte(name, '/')
+	if dot := strings.IndexByte(name[lastSlash+1:], '.'); dot >= 0 {
+		return name[:lastSlash+1+dot]
+	}
+	return name
+}
+
+// stackHook adds the call stack to events at or above a level.
+//
+// Internal frames are recognized by their package instead of being skipped by count,
+// so the result is the same no matter how many zerolog or zeroconfig frames are in between.
+type stackHook struct {
+	level     zerolog.Level
+	maxFrames int
+}
+
+func (sh stackHook) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level < sh.level || level == zerolog.NoLevel {
+		return
+	}
+	// Capture some extra frames to account for the internal ones that are skipped.
+	pcs := make([]uintptr, sh.maxFrames+16)
+	// Skip runtime.Callers and this function.
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	frames := runtime.CallersFrames(pcs)
+	stack := zerolog.Arr()
+	count := 0
+	inCaller := false
+	for count < sh.maxFrames {
+		frame, more := frames.Next()
+		if _, internal := internalPackages[funcPackage(frame.Function)]; !internal || inCaller {
+			if frame.Function == "runtime.goexit" || frame.Function == "runtime.main" {
+				break
+			}
+			inCaller = true
+			stack.Dict(zerolog.Dict().
+				Str("func", frame.Function).
+				Str("file", frame.File).
+				Int("line", frame.Line))
+			count++
+		}
+		if !more {
+			break
+		}
+	}
+	e.Array(zerolog.ErrorStackFieldName, stack)
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+type stackFrame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+func logWithStack(t *testing.T, cfg string, fn func(*zerolog.Logger)) []map[string]json.RawMessage {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	var config zeroconfig.Config
+	require.NoError(t, json.Unmarshal([]byte(cfg), &config))
+	log, err := config.Compile()
+	require.NoError(t, err)
+	fn(log)
+	var events []map[string]json.RawMessage
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		var evt map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal([]byte(line), &evt))
+		events = append(events, evt)
+	}
+	return events
+}
+
+func TestConfig_Compile_StackAbove(t *testing.T) {
+	for _, cfg := range []string{
+		`{"writers": [{"type": "stdout"}], "stack_above": "warn"}`,
+		`{"writers": [{"type": "stdout"}], "stack_above": "warn", "caller": true, "include_uptime": true}`,
+	} {
+		events := logWithStack(t, cfg, func(log *zerolog.Logger) {
+			log.Info().Msg("meow")
+			log.Warn().Msg("hiss")
+			sub := log.With().Str("component", "cat").Logger()
+			sub.Error().CallerSkipFrame(1).Msg("growl")
+		})
+		require.Len(t, events, 3)
+		assert.NotContains(t, events[0], "stack")
+		for _, evt := range events[1:] {
+			var frames []stackFrame
+			require.NoError(t, json.Unmarshal(evt["stack"], &frames))
+			require.NotEmpty(t, frames)
+			// The first frame is the function that logged the event, not zerolog or zeroconfig internals.
+			assert.Contains(t, frames[0].Func, "TestConfig_Compile_StackAbove")
+			assert.True(t, strings.HasSuffix(frames[0].File, "stackabove_test.go"))
+			assert.LessOrEqual(t, len(frames), zeroconfig.DefaultStackMaxFrames)
+		}
+	}
+}
+
+func TestConfig_Compile_StackMaxFrames(t *testing.T) {
+	events := logWithStack(t, `{"writers": [{"type": "stdout"}], "stack_above": "debug", "stack_max_frames": 2}`, func(log *zerolog.Logger) {
+		log.Debug().Msg("meow")
+	})
+	var frames []stackFrame
+	require.NoError(t, json.Unmarshal(events[0]["stack"], &frames))
+	assert.Len(t, frames, 2)
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+
+	"github.com/rs/zerolog"
+)
+
+// CompileStdlib compiles the config like Compile and wraps the logger in a standard library *log.Logger,
+// see NewStdlibLogger.
+func (c *Config) CompileStdlib(level zerolog.Level, opts ...CompileOption) (*log.Logger, error) {
+	zl, err := c.Compile(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewStdlibLogger(zl, level), nil
+}
+
+// NewStdlibLogger returns a standard library *log.Logger that logs each Print call as one event with the given level,
+// using the printed text as the message.
+//
+// The returned logger has no flags set. If the flags are changed later, the date and time are still stripped
+// from the start of messages, since zerolog adds its own timestamp.
+func NewStdlibLogger(zl *zerolog.Logger, level zerolog.Level) *log.Logger {
+	return log.New(stdlibWriter{log: zl, level: level}, "", 0)
+}
+
+// stdlibTimestampRegex matches the date and time that the standard library logger adds with the Ldate, Ltime
+// and Lmicroseconds flags.
+var stdlibTimestampRegex = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} )?(\d{2}:\d{2}:\d{2}(\.\d{6})? )?`)
+
+type stdlibWriter struct {
+	log   *zerolog.Logger
+	level zerolog.Level
+}
+
+func (sw stdlibWriter) Write(p []byte) (n int, err error) {
+	msg := bytes.TrimSuffix(p, []byte{'\n'})
+	msg = msg[len(stdlibTimestampRegex.Find(msg)):]
+	sw.log.WithLevel(sw.level).Msg(string(msg))
+	return len(p), nil
+}
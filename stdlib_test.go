@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_CompileStdlib(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	std, err := parseConfig(t, `{"writers": [{"type": "stdout"}], "timestamp": false}`).CompileStdlib(zerolog.WarnLevel)
+	require.NoError(t, err)
+	std.Printf("meow %d", 5)
+	std.Println("multi\nline")
+	std.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	std.Print("with timestamp")
+	std.SetPrefix("[http] ")
+	std.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	std.Print("with prefix")
+	assert.Equal(t, `{"level":"warn","message":"meow 5"}`+"\n"+
+		`{"level":"warn","message":"multi\nline"}`+"\n"+
+		`{"level":"warn","message":"with timestamp"}`+"\n"+
+		`{"level":"warn","message":"[http] with prefix"}`+"\n", out.String())
+}
+
+func TestNewStdlibLogger_MinLevel(t *testing.T) {
+	var out bytes.Buffer
+	zeroconfig.Stdout = &out
+	zl := compile(t, `{"writers": [{"type": "stdout"}], "min_level": "info", "timestamp": false}`)
+	zeroconfig.NewStdlibLogger(zl, zerolog.DebugLevel).Print("hidden")
+	zeroconfig.NewStdlibLogger(zl, zerolog.InfoLevel).Print("shown")
+	assert.Equal(t, `{"level":"info","message":"shown"}`+"\n", out.String())
+}
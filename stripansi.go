@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+
+	"github.com/rs/zerolog"
+)
+
+// ansiEscapeRegex matches CSI sequences (like colors), OSC sequences (like hyperlinks) and other two-byte escapes.
+var ansiEscapeRegex = regexp.MustCompile(`\x1b(?:\[[0-9:;<=>?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// StripANSI removes ANSI escape sequences from a string.
+func StripANSI(s string) string {
+	return ansiEscapeRegex.ReplaceAllString(s, "")
+}
+
+// zerolog escapes the ESC character like this in JSON strings.
+var jsonEscapeChar = []byte(`\u001b`)
+
+// stripANSIRewriter removes ANSI escape sequences from all top-level string fields, including the message.
+func stripANSIRewriter(_ zerolog.Level, obj jsonObject) (jsonObject, bool) {
+	for i, field := range obj {
+		if len(field.Value) == 0 || field.Value[0] != '"' || !bytes.Contains(field.Value, jsonEscapeChar) {
+			continue
+		}
+		var str string
+		if json.Unmarshal(field.Value, &str) != nil {
+			continue
+		}
+		obj[i].Value = appendJSONString(nil, StripANSI(str))
+	}
+	return obj, true
+}
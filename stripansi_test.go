@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestStripANSI(t *testing.T) {
+	assert.Equal(t, "error: meow", zeroconfig.StripANSI("\x1b[1;31merror:\x1b[0m meow"))
+	assert.Equal(t, "link", zeroconfig.StripANSI("\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x07"))
+	assert.Equal(t, "plain", zeroconfig.StripANSI("plain"))
+}
+
+func TestConfig_Compile_StripANSI(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	zeroconfig.Stderr = &stderr
+	log, err := (&zeroconfig.Config{
+		Writers: []zeroconfig.WriterConfig{
+			{Type: zeroconfig.WriterTypeStdout},
+			{Type: zeroconfig.WriterTypeStderr, Format: zeroconfig.LogFormatPrettyColored, Color: zeroconfig.ColorAlways},
+		},
+		StripANSI: true,
+		Timestamp: new(bool),
+	}).Compile()
+	require.NoError(t, err)
+	log.Info().Str("output", "\x1b[32mok\x1b[0m").Int("count", 5).Msg("\x1b[1mbuild\x1b[0m finished")
+	assert.Equal(t, `{"level":"info","output":"ok","count":5,"message":"build finished"}`+"\n", stdout.String())
+	assert.Contains(t, stderr.String(), "\x1b[1mbuild\x1b[0m finished", "Colored pretty output should keep escape sequences")
+}
+
+func TestWriterConfig_Compile_StripANSIPretty(t *testing.T) {
+	var stdout bytes.Buffer
+	zeroconfig.Stdout = &stdout
+	log := compile(t, `{"writers": [{"type": "stdout", "format": "pretty", "strip_ansi": true}], "timestamp": false}`)
+	log.Warn().Msg("\x1b[33mcareful\x1b[0m")
+	assert.NotContains(t, stdout.String(), "\x1b[33m")
+	assert.Contains(t, stdout.String(), "careful")
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TailConfig contains the configuration options for the tail writer.
+type TailConfig struct {
+	// The TCP address to listen on, like localhost:9901.
+	Listen string `json:"listen,omitempty" yaml:"listen,omitempty" toml:"listen,omitempty"`
+	// Maximum number of events to buffer for each client. Clients that fall further behind are disconnected.
+	// Defaults to 1000.
+	ClientBuffer int `json:"client_buffer,omitempty" yaml:"client_buffer,omitempty" toml:"client_buffer,omitempty"`
+}
+
+const defaultTailClientBuffer = 1000
+
+// TailNegotiationTimeout is how long the tail writer waits for a client to send a minimum level
+// before streaming all events to it.
+var TailNegotiationTimeout = 500 * time.Millisecond
+
+type tailClient struct {
+	conn     net.Conn
+	minLevel zerolog.Level
+	queue    chan []byte
+	// Closed when the client is removed, which stops its writer loop.
+	done chan struct{}
+}
+
+// tailWriter streams events to TCP clients connected to a listener.
+//
+// Clients can optionally send a level name as the first line to only receive events at or above that level.
+// Writes never block: if a client's buffer is full, the client is disconnected.
+type tailWriter struct {
+	listener *tailListener
+	buffer   int
+
+	lock    sync.RWMutex
+	clients map[*tailClient]struct{}
+	// Connections that haven't finished negotiating the level yet.
+	pending map[net.Conn]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// tailListener accepts connections for the tail writers listening on the same address.
+//
+// New connections go to the most recently compiled writer, so that a new config (e.g. when reloading)
+// can take over the listener while the handle of the previous config is still open.
+// The listener is closed when the last writer using it is closed.
+type tailListener struct {
+	addr     string
+	listener net.Listener
+	// The writers using the listener, guarded by tailListenersLock.
+	writers []*tailWriter
+	wg      sync.WaitGroup
+}
+
+// tailListeners contains the open tail listeners by address.
+var (
+	tailListenersLock sync.Mutex
+	tailListeners     = make(map[string]*tailListener)
+)
+
+// acquireTailListener adds the writer to the listener for the given address, and starts listening if there isn't one.
+func acquireTailListener(addr string, tw *tailWriter) (*tailListener, error) {
+	tailListenersLock.Lock()
+	defer tailListenersLock.Unlock()
+	if tl, ok := tailListeners[addr]; ok {
+		tl.writers = append(tl.writers, tw)
+		return tl, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tl := &tailListener{addr: addr, listener: listener, writers: []*tailWriter{tw}}
+	// Each writer with a random port gets its own listener, so there's nothing to share.
+	if _, port, _ := net.SplitHostPort(addr); port != "0" && port != "" {
+		tailListeners[addr] = tl
+	}
+	tl.wg.Add(1)
+	go tl.acceptLoop()
+	return tl, nil
+}
+
+// release removes the writer from the listener, and closes the listener if no writers are left.
+func (tl *tailListener) release(tw *tailWriter) error {
+	tailListenersLock.Lock()
+	for i, writer := range tl.writers {
+		if writer == tw {
+			tl.writers = append(tl.writers[:i], tl.writers[i+1:]...)
+			break
+		}
+	}
+	if len(tl.writers) > 0 {
+		tailListenersLock.Unlock()
+		return nil
+	}
+	if tailListeners[tl.addr] == tl {
+		delete(tailListeners, tl.addr)
+	}
+	tailListenersLock.Unlock()
+	err := tl.listener.Close()
+	tl.wg.Wait()
+	return err
+}
+
+// Limits for waiting after failed accepts, like net/http's Server uses.
+const (
+	tailAcceptMinBackoff = 5 * time.Millisecond
+	tailAcceptMaxBackoff = 1 * time.Second
+)
+
+func (tl *tailListener) acceptLoop() {
+	defer tl.wg.Done()
+	var backoff time.Duration
+	for {
+		conn, err := tl.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Errors like running out of file descriptors usually persist for a while, so back off
+			// instead of spinning on Accept.
+			if backoff == 0 {
+				backoff = tailAcceptMinBackoff
+			} else if backoff *= 2; backoff > tailAcceptMaxBackoff {
+				backoff = tailAcceptMaxBackoff
+			}
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		var target *tailWriter
+		tailListenersLock.Lock()
+		if len(tl.writers) > 0 {
+			target = tl.writers[len(tl.writers)-1]
+		}
+		tailListenersLock.Unlock()
+		if target == nil || !target.accept(conn) {
+			_ = conn.Close()
+		}
+	}
+}
+
+func compileTail(wc *WriterConfig) (io.Writer, error) {
+	if wc.Listen == "" {
+		return nil, fmt.Errorf("listen address is required for the tail writer")
+	}
+	tw := &tailWriter{
+		buffer:  wc.ClientBuffer,
+		clients: make(map[*tailClient]struct{}),
+		pending: make(map[net.Conn]struct{}),
+	}
+	if tw.buffer <= 0 {
+		tw.buffer = defaultTailClientBuffer
+	}
+	var err error
+	tw.listener, err = acquireTailListener(wc.Listen, tw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for tail clients: %w", err)
+	}
+	return tw, nil
+}
+
+// accept starts handling a new connection, or returns false if the writer is closed.
+func (tw *tailWriter) accept(conn net.Conn) bool {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if tw.closed {
+		return false
+	}
+	tw.pending[conn] = struct{}{}
+	tw.wg.Add(1)
+	go tw.handleClient(conn)
+	return true
+}
+
+func (tw *tailWriter) handleClient(conn net.Conn) {
+	defer tw.wg.Done()
+	client := &tailClient{
+		conn:     conn,
+		minLevel: zerolog.TraceLevel,
+		queue:    make(chan []byte, tw.buffer),
+		done:     make(chan struct{}),
+	}
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(TailNegotiationTimeout))
+	line, err := reader.ReadString('\n')
+	if err == nil {
+		if line = strings.TrimSpace(line); line != "" {
+			client.minLevel, err = zerolog.ParseLevel(line)
+			if err != nil {
+				_, _ = fmt.Fprintf(conn, "unknown level %q\n", line)
+				tw.dropPending(conn)
+				return
+			}
+		}
+	} else if !isTimeout(err) {
+		tw.dropPending(conn)
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	if !tw.addClient(client) {
+		_ = conn.Close()
+		return
+	}
+	tw.wg.Add(1)
+	go tw.clientWriteLoop(client)
+	// Anything else the client sends is ignored, reading is only used to notice disconnections.
+	_, _ = io.Copy(io.Discard, reader)
+	tw.removeClient(client)
+}
+
+func (tw *tailWriter) dropPending(conn net.Conn) {
+	tw.lock.Lock()
+	delete(tw.pending, conn)
+	tw.lock.Unlock()
+	_ = conn.Close()
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (tw *tailWriter) clientWriteLoop(client *tailClient) {
+	defer tw.wg.Done()
+	defer client.conn.Close()
+	for {
+		select {
+		case <-client.done:
+			return
+		case data := <-client.queue:
+			if _, err := client.conn.Write(data); err != nil {
+				tw.removeClient(client)
+				return
+			}
+		}
+	}
+}
+
+func (tw *tailWriter) addClient(client *tailClient) bool {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	delete(tw.pending, client.conn)
+	if tw.closed {
+		return false
+	}
+	tw.clients[client] = struct{}{}
+	return true
+}
+
+func (tw *tailWriter) removeClient(client *tailClient) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if _, ok := tw.clients[client]; ok {
+		delete(tw.clients, client)
+		close(client.done)
+		// Closing the connection unblocks the reader and any pending write.
+		_ = client.conn.Close()
+	}
+}
+
+func (tw *tailWriter) Write(p []byte) (n int, err error) {
+	return tw.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (tw *tailWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
+	var slow []*tailClient
+	tw.lock.RLock()
+	var data []byte
+	for client := range tw.clients {
+		if level < client.minLevel {
+			continue
+		}
+		if data == nil {
+			// zerolog reuses the buffer after Write returns, so it must be copied before queueing.
+			data = append([]byte(nil), p...)
+		}
+		select {
+		case client.queue <- data:
+		default:
+			slow = append(slow, client)
+		}
+	}
+	tw.lock.RUnlock()
+	for _, client := range slow {
+		tw.removeClient(client)
+	}
+	return len(p), nil
+}
+
+// Close disconnects all clients, and stops listening unless another writer uses the same listener.
+func (tw *tailWriter) Close() error {
+	tw.lock.Lock()
+	if tw.closed {
+		tw.lock.Unlock()
+		return nil
+	}
+	tw.closed = true
+	for conn := range tw.pending {
+		_ = conn.Close()
+	}
+	for client := range tw.clients {
+		delete(tw.clients, client)
+		close(client.done)
+		_ = client.conn.Close()
+	}
+	tw.lock.Unlock()
+	err := tw.listener.release(tw)
+	tw.wg.Wait()
+	return err
+}
+
+func (tw *tailWriter) healthCheck() error {
+	tw.lock.RLock()
+	defer tw.lock.RUnlock()
+	if tw.closed {
+		return fmt.Errorf("tail listener is closed")
+	}
+	return nil
+}
+
+func (tw *tailWriter) closeWithHandle() error {
+	return tw.Close()
+}
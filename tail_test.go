@@ -0,0 +1,205 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func freeTCPAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return addr
+}
+
+func compileTail(t *testing.T, bufferSize int) (*zeroconfig.Handle, string) {
+	addr := freeTCPAddr(t)
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type:       zeroconfig.WriterTypeTail,
+		TailConfig: zeroconfig.TailConfig{Listen: addr, ClientBuffer: bufferSize},
+	}}}
+	handle, err := cfg.CompileHandle()
+	require.NoError(t, err)
+	return handle, addr
+}
+
+func dialTail(t *testing.T, addr, level string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte(level + "\n"))
+	require.NoError(t, err)
+	return conn
+}
+
+func waitForTailClients(t *testing.T, handle *zeroconfig.Handle, count int) {
+	require.Eventually(t, func() bool {
+		return zeroconfig.TailClients(handle) == count
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWriterConfig_Compile_Tail(t *testing.T) {
+	handle, addr := compileTail(t, 0)
+	defer handle.Close()
+	all := dialTail(t, addr, "")
+	defer all.Close()
+	warn := dialTail(t, addr, "warn")
+	defer warn.Close()
+	waitForTailClients(t, handle, 2)
+
+	handle.Logger.Info().Msg("meow")
+	handle.Logger.Warn().Msg("hiss")
+
+	allReader := bufio.NewReader(all)
+	_ = all.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := allReader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"message":"meow"`)
+	line, err = allReader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"message":"hiss"`)
+
+	_ = warn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err = bufio.NewReader(warn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"message":"hiss"`)
+}
+
+func TestWriterConfig_Compile_TailUnknownLevel(t *testing.T) {
+	handle, addr := compileTail(t, 0)
+	defer handle.Close()
+	conn := dialTail(t, addr, "meow")
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "unknown level \"meow\"\n", line)
+}
+
+func TestWriterConfig_Compile_TailSlowClient(t *testing.T) {
+	handle, addr := compileTail(t, 2)
+	defer handle.Close()
+	slow := dialTail(t, addr, "")
+	defer slow.Close()
+	waitForTailClients(t, handle, 1)
+
+	// The slow client never reads, so its buffer fills up once the socket buffers are full.
+	payload := strings.Repeat("a", 64*1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000 && zeroconfig.TailClients(handle) > 0; i++ {
+			handle.Logger.Info().Str("payload", payload).Msg("meow")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on a slow tail client")
+	}
+	assert.Equal(t, 0, zeroconfig.TailClients(handle))
+}
+
+func TestHandle_Close_Tail(t *testing.T) {
+	handle, addr := compileTail(t, 0)
+	conn := dialTail(t, addr, "")
+	defer conn.Close()
+	waitForTailClients(t, handle, 1)
+	require.NoError(t, handle.Close())
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Error(t, err)
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	assert.Error(t, err)
+}
+
+func TestWriterConfig_Compile_TailNoListen(t *testing.T) {
+	_, err := (&zeroconfig.WriterConfig{Type: zeroconfig.WriterTypeTail}).Compile()
+	assert.EqualError(t, err, "listen address is required for the tail writer")
+}
+
+func TestConfig_CompileHandle_ClosesOutputsOnError(t *testing.T) {
+	first, second := freeTCPAddr(t), freeTCPAddr(t)
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{
+		{Type: zeroconfig.WriterTypeTail, TailConfig: zeroconfig.TailConfig{Listen: first}},
+		{Type: zeroconfig.WriterTypeTail, TailConfig: zeroconfig.TailConfig{Listen: second}},
+		{Type: zeroconfig.WriterTypeTail, TailConfig: zeroconfig.TailConfig{Listen: second, ClientBuffer: 5}},
+	}}
+	_, err := cfg.CompileHandle()
+	require.Error(t, err)
+
+	for _, addr := range []string{first, second} {
+		listener, err := net.Listen("tcp", addr)
+		require.NoError(t, err, "Listeners of a failed compile should be closed")
+		assert.NoError(t, listener.Close())
+	}
+}
+
+func TestWriterConfig_Compile_TailSharedListener(t *testing.T) {
+	handle, addr := compileTail(t, 0)
+	cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type:       zeroconfig.WriterTypeTail,
+		TailConfig: zeroconfig.TailConfig{Listen: addr},
+	}}}
+	newHandle, err := cfg.CompileHandle()
+	require.NoError(t, err, "Compiling the same address again should take over the listener")
+	require.NoError(t, handle.Close())
+
+	conn := dialTail(t, addr, "")
+	defer conn.Close()
+	waitForTailClients(t, newHandle, 1)
+	newHandle.Logger.Info().Msg("meow")
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, line, `"message":"meow"`)
+
+	require.NoError(t, newHandle.Close())
+	_, err = net.DialTimeout("tcp", addr, time.Second)
+	assert.Error(t, err, "The listener should be closed with the last writer")
+}
+
+// failingListener is a listener whose Accept always fails until it's closed, like one that ran out of file descriptors.
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int32
+	closed  atomic.Bool
+}
+
+func (fl *failingListener) Accept() (net.Conn, error) {
+	if fl.closed.Load() {
+		return nil, net.ErrClosed
+	}
+	fl.accepts.Add(1)
+	return nil, syscall.EMFILE
+}
+
+func (fl *failingListener) Close() error {
+	fl.closed.Store(true)
+	return nil
+}
+
+func TestTailAcceptBackoff(t *testing.T) {
+	listener := &failingListener{}
+	stop := zeroconfig.StartTailAcceptLoop(listener)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	// 5+10+20+40 ms of backoff fit in 100ms, so there should be about 5 attempts instead of thousands.
+	assert.LessOrEqual(t, listener.accepts.Load(), int32(10), "Accept errors should be retried with a backoff")
+}
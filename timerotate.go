@@ -0,0 +1,264 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotationInterval is how often the file writer starts a new file regardless of its size.
+type RotationInterval string
+
+const (
+	// RotationIntervalHourly starts a new file every hour, named like name-2006-01-02T15.ext
+	RotationIntervalHourly RotationInterval = "hourly"
+	// RotationIntervalDaily starts a new file every day, named like name-2006-01-02.ext
+	RotationIntervalDaily RotationInterval = "daily"
+)
+
+// layout returns the time layout that is embedded in file names, or an empty string if the interval is unknown.
+func (ri RotationInterval) layout() string {
+	switch ri {
+	case RotationIntervalHourly:
+		return "2006-01-02T15"
+	case RotationIntervalDaily:
+		return "2006-01-02"
+	default:
+		return ""
+	}
+}
+
+// start returns the start of the period that the given time is in.
+func (ri RotationInterval) start(t time.Time) time.Time {
+	if ri == RotationIntervalHourly {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// end returns the end of the period that starts at the given time.
+func (ri RotationInterval) end(start time.Time) time.Time {
+	if ri == RotationIntervalHourly {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// rotationNow is the clock used to find the current period. It's a variable so that tests can replace it.
+var rotationNow = time.Now
+
+// timeRotatingWriter writes each period to its own dated file. Within a period, the file is rotated
+// by size like a normal file writer. MaxAge and MaxBackups also apply to the files of previous periods.
+type timeRotatingWriter struct {
+	cfg      FileConfig
+	interval RotationInterval
+	layout   string
+
+	lock    sync.Mutex
+	period  string
+	current FileBackend
+
+	cleanupWG sync.WaitGroup
+}
+
+func newTimeRotatingWriter(cfg FileConfig) (*timeRotatingWriter, error) {
+	layout := cfg.RotationInterval.layout()
+	if layout == "" {
+		return nil, fmt.Errorf("unknown rotation_interval %q", cfg.RotationInterval)
+	} else if cfg.Filename == "" {
+		return nil, fmt.Errorf("rotation_interval requires a filename")
+	} else if cfg.MaxSize < 0 || cfg.MaxAge < 0 || cfg.MaxBackups < 0 {
+		return nil, fmt.Errorf("max_size, max_age and max_backups must not be negative")
+	}
+	return &timeRotatingWriter{cfg: cfg, interval: cfg.RotationInterval, layout: layout}, nil
+}
+
+func (trw *timeRotatingWriter) now() time.Time {
+	now := rotationNow()
+	if !trw.cfg.LocalTime {
+		now = now.UTC()
+	}
+	return now
+}
+
+// periodFilename returns the file name for the given period, e.g. name-2006-01-02.ext
+func (trw *timeRotatingWriter) periodFilename(period string) string {
+	ext := filepath.Ext(trw.cfg.Filename)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(trw.cfg.Filename, ext), period, ext)
+}
+
+// Open opens the file for the current period.
+func (trw *timeRotatingWriter) Open() error {
+	trw.lock.Lock()
+	defer trw.lock.Unlock()
+	return trw.switchPeriod()
+}
+
+// switchPeriod opens a new file if the period has changed since the current file was opened.
+func (trw *timeRotatingWriter) switchPeriod() error {
+	period := trw.now().Format(trw.layout)
+	if trw.current != nil && period == trw.period {
+		return nil
+	} else if trw.current != nil {
+		err := trw.current.Close()
+		trw.current = nil
+		if err != nil {
+			return fmt.Errorf("failed to close file of previous period: %w", err)
+		}
+	}
+	cfg := trw.cfg
+	cfg.Filename = trw.periodFilename(period)
+	backend, err := newLumberjackBackend(cfg)
+	if err != nil {
+		return err
+	} else if err = backend.Open(); err != nil {
+		return err
+	}
+	trw.current = backend
+	trw.period = period
+	if trw.cfg.MaxAge > 0 || trw.cfg.MaxBackups > 0 || trw.cfg.Compress {
+		trw.cleanupWG.Add(1)
+		go trw.cleanup()
+	}
+	return nil
+}
+
+func (trw *timeRotatingWriter) Write(p []byte) (n int, err error) {
+	trw.lock.Lock()
+	defer trw.lock.Unlock()
+	if err = trw.switchPeriod(); err != nil {
+		return 0, err
+	}
+	return trw.current.Write(p)
+}
+
+// Rotate rotates the file of the current period like a size-based rotation would.
+func (trw *timeRotatingWriter) Rotate() error {
+	trw.lock.Lock()
+	defer trw.lock.Unlock()
+	if err := trw.switchPeriod(); err != nil {
+		return err
+	}
+	return trw.current.Rotate()
+}
+
+// Close closes the current file and waits for any background cleanup to finish.
+func (trw *timeRotatingWriter) Close() error {
+	trw.lock.Lock()
+	var err error
+	if trw.current != nil {
+		err = trw.current.Close()
+		trw.current = nil
+	}
+	trw.lock.Unlock()
+	trw.cleanupWG.Wait()
+	return err
+}
+
+// periodFile is a file written by a time rotating writer.
+type periodFile struct {
+	path   string
+	period string
+	start  time.Time
+	// Is this the last file of its period, rather than a backup made by size-based rotation?
+	last bool
+}
+
+// periodFiles returns all files of the writer, newest first.
+func (trw *timeRotatingWriter) periodFiles() ([]periodFile, error) {
+	dir := filepath.Dir(trw.cfg.Filename)
+	base := filepath.Base(trw.cfg.Filename)
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)] + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	loc := trw.now().Location()
+	var files []periodFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		middle := strings.TrimSuffix(name[len(prefix):], compressSuffix)
+		if !strings.HasSuffix(middle, ext) {
+			continue
+		}
+		middle = middle[:len(middle)-len(ext)]
+		if len(middle) < len(trw.layout) {
+			continue
+		}
+		period, rest := middle[:len(trw.layout)], middle[len(trw.layout):]
+		start, err := time.ParseInLocation(trw.layout, period, loc)
+		if err != nil {
+			continue
+		} else if rest != "" {
+			// Backups from size-based rotation within the period are named like name-<period>-<timestamp>.ext
+			if !strings.HasPrefix(rest, "-") {
+				continue
+			} else if _, err = time.Parse(backupTimeFormat, rest[1:]); err != nil {
+				continue
+			}
+		}
+		files = append(files, periodFile{
+			path:   filepath.Join(dir, name),
+			period: period,
+			start:  start,
+			last:   rest == "",
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path > files[j].path
+	})
+	return files, nil
+}
+
+// cleanup removes files of previous periods that are past MaxAge or MaxBackups, and compresses the rest if enabled.
+func (trw *timeRotatingWriter) cleanup() {
+	defer trw.cleanupWG.Done()
+	// Share the lock with the file writer so that compression of backups doesn't overlap.
+	compressLock.Lock()
+	defer compressLock.Unlock()
+	// The period may have changed again since this cleanup was started.
+	trw.lock.Lock()
+	currentPeriod := trw.period
+	trw.lock.Unlock()
+	files, err := trw.periodFiles()
+	if err != nil {
+		return
+	}
+	cutoff := trw.now().AddDate(0, 0, -trw.cfg.MaxAge)
+	level := trw.cfg.CompressLevel
+	if level == CompressLevelUnset {
+		level = CompressLevelDefault
+	}
+	var kept int
+	for _, file := range files {
+		if file.period >= currentPeriod {
+			continue
+		} else if trw.cfg.MaxAge > 0 && trw.interval.end(file.start).Before(cutoff) {
+			_ = os.Remove(file.path)
+			continue
+		} else if trw.cfg.MaxBackups > 0 && kept >= trw.cfg.MaxBackups {
+			_ = os.Remove(file.path)
+			continue
+		}
+		kept++
+		// Backups within a period are compressed by the file writer itself.
+		if trw.cfg.Compress && file.last && !strings.HasSuffix(file.path, compressSuffix) {
+			// Errors are ignored like in the file writer.
+			_ = compressFile(file.path, level)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// fakeRotationClock replaces the clock of time-based rotation until the test ends.
+func fakeRotationClock(t *testing.T, start time.Time) *atomic.Int64 {
+	var now atomic.Int64
+	now.Store(start.UnixNano())
+	t.Cleanup(zeroconfig.SetRotationClock(func() time.Time {
+		return time.Unix(0, now.Load())
+	}))
+	return &now
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWriterConfig_Compile_FileRotationInterval(t *testing.T) {
+	dir := t.TempDir()
+	now := fakeRotationClock(t, time.Date(2024, 1, 2, 23, 59, 0, 0, time.UTC))
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{
+			Filename:         filepath.Join(dir, "app.log"),
+			RotationInterval: zeroconfig.RotationIntervalDaily,
+			HeaderLine:       "# header",
+		},
+	}).Compile()
+	require.NoError(t, err)
+	fw := writer.(rotatable)
+	_, err = fw.Write([]byte("one\n"))
+	require.NoError(t, err)
+	now.Store(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC).UnixNano())
+	_, err = fw.Write([]byte("two\n"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	assert.Equal(t, []string{"app-2024-01-02.log", "app-2024-01-03.log"}, listDir(t, dir))
+	data, err := os.ReadFile(filepath.Join(dir, "app-2024-01-02.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "# header\none\n", string(data))
+	data, err = os.ReadFile(filepath.Join(dir, "app-2024-01-03.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "# header\ntwo\n", string(data), "Each period should get its own file with a header")
+}
+
+func TestWriterConfig_Compile_FileRotationIntervalCleanup(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"app-2024-01-01T08.log", "app-2024-01-02T07.log", "app-2024-01-02T08.log.gz", "app-2024-01-02T09.log",
+		"app-2024-01-02T09-2024-01-02T09-30-00.000.log", "app-2024-01-02.log", "unrelated.log",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("old\n"), 0600))
+	}
+	now := fakeRotationClock(t, time.Date(2024, 1, 2, 10, 15, 0, 0, time.UTC))
+	writer, err := (&zeroconfig.WriterConfig{
+		Type: zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{
+			Filename:         filepath.Join(dir, "app.log"),
+			RotationInterval: zeroconfig.RotationIntervalHourly,
+			MaxAge:           1,
+			MaxBackups:       4,
+			Compress:         true,
+		},
+	}).Compile()
+	require.NoError(t, err)
+	fw := writer.(rotatable)
+	_, err = fw.Write([]byte("ten\n"))
+	require.NoError(t, err)
+	now.Store(time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC).UnixNano())
+	_, err = fw.Write([]byte("eleven\n"))
+	require.NoError(t, err)
+	require.NoError(t, fw.Close())
+
+	assert.Equal(t, []string{
+		"app-2024-01-02.log",
+		"app-2024-01-02T08.log.gz",
+		"app-2024-01-02T09-2024-01-02T09-30-00.000.log",
+		"app-2024-01-02T09.log.gz",
+		"app-2024-01-02T10.log.gz",
+		"app-2024-01-02T11.log",
+		"unrelated.log",
+	}, listDir(t, dir), "Files beyond max_age or max_backups should be removed and files of previous periods compressed")
+}
+
+func TestWriterConfig_Compile_FileRotationIntervalInvalid(t *testing.T) {
+	_, err := (&zeroconfig.WriterConfig{
+		Type:       zeroconfig.WriterTypeFile,
+		FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(t.TempDir(), "app.log"), RotationInterval: "weekly"},
+	}).Compile()
+	assert.EqualError(t, err, `unknown rotation_interval "weekly"`)
+
+	var cfg zeroconfig.Config
+	cfg.Writers = []zeroconfig.WriterConfig{{Type: zeroconfig.WriterTypeFile, FileConfig: zeroconfig.FileConfig{Filename: "app.log", RotationInterval: "weekly"}}}
+	assert.EqualError(t, cfg.Validate(), `writers[0].rotation_interval: unknown rotation_interval "weekly"`)
+}
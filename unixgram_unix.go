@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build unix
+
+package zeroconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+const defaultMaxDatagramSize = 65536
+
+// unixgramWriter sends each event as a datagram to a unix socket.
+//
+// The socket isn't connected, so the path is resolved again for every event. That way the receiver can be started
+// after the writer, and it can be restarted (which creates a new socket file) without the writer noticing.
+type unixgramWriter struct {
+	conn     *net.UnixConn
+	addr     *net.UnixAddr
+	maxSize  int
+	truncate bool
+
+	deliveryHealth
+}
+
+func compileUnixgram(wc *WriterConfig) (io.Writer, error) {
+	if wc.Path == "" {
+		return nil, fmt.Errorf("unixgram writer requires a path")
+	} else if wc.MaxDatagramSize < 0 {
+		return nil, fmt.Errorf("max_datagram_size can't be negative")
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	uw := &unixgramWriter{
+		conn:     conn,
+		addr:     &net.UnixAddr{Name: wc.Path, Net: "unixgram"},
+		maxSize:  wc.MaxDatagramSize,
+		truncate: wc.Truncate,
+	}
+	if uw.maxSize == 0 {
+		uw.maxSize = defaultMaxDatagramSize
+	}
+	return uw, nil
+}
+
+func (uw *unixgramWriter) Write(p []byte) (n int, err error) {
+	datagram := p
+	if len(datagram) > uw.maxSize {
+		if !uw.truncate {
+			return 0, fmt.Errorf("event is too large for a datagram (%d > %d bytes)", len(p), uw.maxSize)
+		}
+		datagram = datagram[:uw.maxSize]
+		if p[len(p)-1] == '\n' {
+			datagram = append(datagram[:uw.maxSize-1:uw.maxSize-1], '\n')
+		}
+	}
+	_, err = uw.conn.WriteToUnix(datagram, uw.addr)
+	uw.setHealth(err)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+		// Nobody is listening right now, which is fine for fire-and-forget logging.
+		return len(p), nil
+	} else if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (uw *unixgramWriter) Close() error {
+	return uw.conn.Close()
+}
+
+func init() {
+	writerCompilers[WriterTypeUnixgram] = compileUnixgram
+}
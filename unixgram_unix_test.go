@@ -0,0 +1,101 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build unix
+
+package zeroconfig_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func listenUnixgram(t *testing.T, path string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	return conn
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func shortTempDir(t *testing.T) string {
+	// Unix socket paths have a short length limit, so t.TempDir may be too long.
+	dir, err := os.MkdirTemp("", "zc")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func TestWriterConfig_Compile_Unixgram(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "collector.sock")
+	log := compile(t, fmt.Sprintf(`{"writers": [{"type": "unixgram", "path": "%s"}], "timestamp": false}`, path))
+
+	// Events are dropped while the receiver doesn't exist.
+	log.Info().Msg("nobody is listening")
+	conn := listenUnixgram(t, path)
+	log.Info().Msg("first")
+	assert.Equal(t, `{"level":"info","message":"first"}`+"\n", readDatagram(t, conn))
+
+	// The receiver is restarted and creates a new socket at the same path.
+	require.NoError(t, conn.Close())
+	log.Info().Msg("receiver is down")
+	require.NoError(t, os.Remove(path))
+	conn = listenUnixgram(t, path)
+	defer conn.Close()
+	log.Info().Msg("second")
+	assert.Equal(t, `{"level":"info","message":"second"}`+"\n", readDatagram(t, conn))
+}
+
+func TestWriterConfig_Compile_UnixgramTruncate(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "collector.sock")
+	conn := listenUnixgram(t, path)
+	defer conn.Close()
+	log := compile(t, fmt.Sprintf(`{"writers": [
+	  {"type": "unixgram", "path": "%s", "max_datagram_size": 40, "truncate": true},
+	  {"type": "unixgram", "path": "%[1]s", "max_datagram_size": 40}
+	], "timestamp": false}`, path))
+	log.Info().Msg(strings.Repeat("meow", 10))
+	assert.Equal(t, `{"level":"info","message":"meowmeowmeow`+"\n", readDatagram(t, conn), "Truncated event should keep the trailing newline")
+	log.Info().Msg("short")
+	assert.Equal(t, `{"level":"info","message":"short"}`+"\n", readDatagram(t, conn))
+	assert.Equal(t, `{"level":"info","message":"short"}`+"\n", readDatagram(t, conn))
+}
+
+func TestWriterConfig_Compile_UnixgramHealth(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "collector.sock")
+	handle, err := (&zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+		Type:           zeroconfig.WriterTypeUnixgram,
+		UnixgramConfig: zeroconfig.UnixgramConfig{Path: path},
+	}}}).CompileHandle()
+	require.NoError(t, err)
+	defer handle.Close()
+	handle.Logger.Info().Msg("nobody is listening")
+	assert.Error(t, handle.HealthCheck()["writer #1 (unixgram)"])
+
+	conn := listenUnixgram(t, path)
+	defer conn.Close()
+	handle.Logger.Info().Msg("meow")
+	readDatagram(t, conn)
+	assert.NoError(t, handle.HealthCheck()["writer #1 (unixgram)"])
+}
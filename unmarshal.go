@@ -0,0 +1,486 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// parseLevelJSON parses a zerolog level from JSON. In addition to the level names
+// that zerolog.Level.UnmarshalText accepts, this allows plain numbers for custom levels.
+func parseLevelJSON(data json.RawMessage, into **zerolog.Level) error {
+	if data == nil {
+		return nil
+	} else if string(data) == "null" {
+		*into = nil
+		return nil
+	}
+	var level zerolog.Level
+	if data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		} else if err = level.UnmarshalText([]byte(str)); err != nil {
+			return err
+		}
+	} else {
+		num, err := strconv.ParseInt(string(data), 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid level %s", data)
+		}
+		level = zerolog.Level(num)
+	}
+	*into = &level
+	return nil
+}
+
+// parseTextJSON parses a JSON string into a value that implements encoding.TextUnmarshaler.
+// Unlike json.Unmarshal, it returns the unmarshaler's error as-is, so it can be wrapped in a PathError.
+func parseTextJSON(data json.RawMessage, into encoding.TextUnmarshaler) error {
+	if data == nil || string(data) == "null" {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("expected string, got %s", data)
+	}
+	return into.UnmarshalText([]byte(str))
+}
+
+// parseEnvLevelJSON parses a level like parseLevelJSON, but also allows a map from environment name to level.
+// The default key in the map is stored in into and the rest in envLevels.
+func parseEnvLevelJSON(data json.RawMessage, into **zerolog.Level, envLevels *map[string]zerolog.Level) error {
+	if len(data) == 0 || data[0] != '{' {
+		return parseLevelJSON(data, into)
+	}
+	var levels map[string]json.RawMessage
+	if err := json.Unmarshal(data, &levels); err != nil {
+		return err
+	}
+	for _, env := range sortedKeys(levels) {
+		var level *zerolog.Level
+		if err := parseLevelJSON(levels[env], &level); err != nil {
+			return &PathError{Path: env, Err: err}
+		} else if env == "default" {
+			*into = level
+		} else if level != nil {
+			if *envLevels == nil {
+				*envLevels = make(map[string]zerolog.Level)
+			}
+			(*envLevels)[env] = *level
+		}
+	}
+	return nil
+}
+
+// parseDurationJSON parses a duration from JSON. In addition to plain numbers (nanoseconds)
+// like encoding/json does by default, this allows strings that time.ParseDuration accepts.
+func parseDurationJSON(data json.RawMessage, into *time.Duration) error {
+	if data == nil || string(data) == "null" {
+		return nil
+	} else if data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		dur, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		*into = dur
+		return nil
+	}
+	return json.Unmarshal(data, (*int64)(into))
+}
+
+// PathError is an error in a specific value of a config, like writers[2].max_size.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+func (pe *PathError) Error() string {
+	return pe.Path + ": " + pe.Err.Error()
+}
+
+func (pe *PathError) Unwrap() error {
+	return pe.Err
+}
+
+// wrapPath adds a path prefix to an error. If the error is already a PathError, the paths are combined.
+func wrapPath(prefix string, err error) error {
+	if pe, ok := err.(*PathError); ok {
+		if strings.HasPrefix(pe.Path, "[") {
+			return &PathError{Path: prefix + pe.Path, Err: pe.Err}
+		}
+		return &PathError{Path: prefix + "." + pe.Path, Err: pe.Err}
+	}
+	return &PathError{Path: prefix, Err: err}
+}
+
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// lookupJSONPath finds the raw value at a dotted path (as used in json.UnmarshalTypeError) in a JSON object.
+func lookupJSONPath(data []byte, path string) (json.RawMessage, bool) {
+	raw := json.RawMessage(data)
+	for _, key := range strings.Split(path, ".") {
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(raw, &obj) != nil {
+			return nil, false
+		}
+		var ok bool
+		raw, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return raw, true
+}
+
+// describeJSONError converts json.UnmarshalTypeErrors into PathErrors that include the offending value.
+func describeJSONError(err error, data []byte) error {
+	var ute *json.UnmarshalTypeError
+	if !errors.As(err, &ute) || ute.Field == "" {
+		return err
+	}
+	got := ute.Value
+	if raw, ok := lookupJSONPath(data, ute.Field); ok {
+		got = string(raw)
+		if len(got) > 64 {
+			got = got[:61] + "..."
+		}
+	}
+	return &PathError{Path: ute.Field, Err: fmt.Errorf("expected %s, got %s", jsonTypeName(ute.Type), got)}
+}
+
+func unmarshalWritersJSON(raw []json.RawMessage) ([]WriterConfig, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	writers := make([]WriterConfig, len(raw))
+	for i, data := range raw {
+		if err := json.Unmarshal(data, &writers[i]); err != nil {
+			return nil, wrapPath(fmt.Sprintf("writers[%d]", i), describeJSONError(err, data))
+		}
+	}
+	return writers, nil
+}
+
+func (wc *WriterConfig) UnmarshalJSON(data []byte) error {
+	type plainWriterConfig WriterConfig
+	aux := struct {
+		*plainWriterConfig
+		Type          json.RawMessage   `json:"type,omitempty"`
+		Format        json.RawMessage   `json:"format,omitempty"`
+		MinLevel      json.RawMessage   `json:"min_level,omitempty"`
+		MaxLevel      json.RawMessage   `json:"max_level,omitempty"`
+		NotifyOnLevel json.RawMessage   `json:"notify_on_level,omitempty"`
+		Writers       []json.RawMessage `json:"writers,omitempty"`
+		// Nested configs with their own UnmarshalJSON are unmarshaled separately to keep the path in errors.
+		Sampling         json.RawMessage `json:"sampling,omitempty"`
+		AdaptiveSampling json.RawMessage `json:"adaptive_sampling,omitempty"`
+		KV               json.RawMessage `json:"kv,omitempty"`
+		Net              json.RawMessage `json:"net,omitempty"`
+		HTTP             json.RawMessage `json:"http,omitempty"`
+	}{plainWriterConfig: (*plainWriterConfig)(wc)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	}
+	var err error
+	if err = parseTextJSON(aux.Type, &wc.Type); err != nil {
+		return &PathError{Path: "type", Err: err}
+	} else if err = parseTextJSON(aux.Format, &wc.Format); err != nil {
+		return &PathError{Path: "format", Err: err}
+	} else if err = parseLevelJSON(aux.MinLevel, &wc.MinLevel); err != nil {
+		return &PathError{Path: "min_level", Err: err}
+	} else if err = parseLevelJSON(aux.MaxLevel, &wc.MaxLevel); err != nil {
+		return &PathError{Path: "max_level", Err: err}
+	} else if err = parseLevelJSON(aux.NotifyOnLevel, &wc.NotifyOnLevel); err != nil {
+		return &PathError{Path: "notify_on_level", Err: err}
+	} else if wc.Writers, err = unmarshalWritersJSON(aux.Writers); err != nil {
+		return err
+	} else if err = unmarshalNestedJSON("sampling", aux.Sampling, &wc.Sampling); err != nil {
+		return err
+	} else if err = unmarshalNestedJSON("adaptive_sampling", aux.AdaptiveSampling, &wc.AdaptiveSampling); err != nil {
+		return err
+	} else if err = unmarshalNestedJSON("kv", aux.KV, &wc.KV); err != nil {
+		return err
+	} else if err = unmarshalNestedJSON("net", aux.Net, &wc.Net); err != nil {
+		return err
+	} else if err = unmarshalNestedJSON("http", aux.HTTP, &wc.HTTP); err != nil {
+		return err
+	}
+	return nil
+}
+
+// unmarshalNestedJSON unmarshals the value of a field, adding the field name to the path of errors.
+func unmarshalNestedJSON(field string, data json.RawMessage, into any) error {
+	if data == nil {
+		return nil
+	} else if err := json.Unmarshal(data, into); err != nil {
+		return wrapPath(field, describeJSONError(err, data))
+	}
+	return nil
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plainConfig Config
+	aux := struct {
+		*plainConfig
+		MinLevel json.RawMessage   `json:"min_level,omitempty"`
+		Metadata json.RawMessage   `json:"metadata,omitempty"`
+		Writers  []json.RawMessage `json:"writers,omitempty"`
+
+		EnvMetadata json.RawMessage `json:"env_metadata,omitempty"`
+
+		SuppressionSummaryInterval json.RawMessage `json:"suppression_summary_interval,omitempty"`
+	}{plainConfig: (*plainConfig)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return describeJSONError(err, data)
+	}
+	var err error
+	if err = parseEnvLevelJSON(aux.MinLevel, &c.MinLevel, &c.EnvMinLevel); err != nil {
+		return &PathError{Path: "min_level", Err: err}
+	} else if err = parseDurationJSON(aux.SuppressionSummaryInterval, &c.SuppressionSummaryInterval); err != nil {
+		return &PathError{Path: "suppression_summary_interval", Err: err}
+	}
+	// Only replace the writers if they're set, so that the config can be decoded on top of another one.
+	if aux.Writers != nil {
+		if c.Writers, err = unmarshalWritersJSON(aux.Writers); err != nil {
+			return err
+		}
+	}
+	if aux.Metadata != nil {
+		var metadata any
+		if err = decodeJSONUseNumber(aux.Metadata, &metadata); err != nil {
+			return &PathError{Path: "metadata", Err: err}
+		} else if err = c.setMetadata(metadata); err != nil {
+			return err
+		}
+	}
+	if aux.EnvMetadata != nil {
+		if err = decodeJSONUseNumber(aux.EnvMetadata, &c.EnvMetadata); err != nil {
+			return &PathError{Path: "env_metadata", Err: err}
+		}
+	}
+	return nil
+}
+
+// decodeJSONUseNumber decodes numbers as json.Number, so that integers don't turn into floats and lose precision.
+func decodeJSONUseNumber(data json.RawMessage, into any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(into)
+}
+
+func (c *Config) UnmarshalYAML(node *yaml.Node) error {
+	type plainConfig Config
+	// The map form of min_level and the list form of metadata are handled separately,
+	// as they can't be decoded into the normal fields.
+	var envMinLevel *yaml.Node
+	plainNode := node
+	if node.Kind == yaml.MappingNode {
+		copied := *node
+		copied.Content = make([]*yaml.Node, 0, len(node.Content))
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "min_level" && value.Kind == yaml.MappingNode {
+				envMinLevel = value
+			} else if key.Value != "metadata" || value.Kind != yaml.SequenceNode {
+				copied.Content = append(copied.Content, key, value)
+			}
+		}
+		plainNode = &copied
+	}
+	if err := plainNode.Decode((*plainConfig)(c)); err != nil {
+		return err
+	}
+	if envMinLevel != nil {
+		var levels map[string]zerolog.Level
+		if err := envMinLevel.Decode(&levels); err != nil {
+			return fmt.Errorf("min_level: %w", err)
+		}
+		for env, level := range levels {
+			level := level
+			if env == "default" {
+				c.MinLevel = &level
+			} else {
+				if c.EnvMinLevel == nil {
+					c.EnvMinLevel = make(map[string]zerolog.Level)
+				}
+				c.EnvMinLevel[env] = level
+			}
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		switch node.Content[i].Value {
+		case "metadata":
+			metadata, err := yamlNodeToValue(node.Content[i+1])
+			if err != nil {
+				return fmt.Errorf("metadata: %w", err)
+			} else if err = c.setMetadata(metadata); err != nil {
+				return err
+			}
+		case "env_metadata":
+			envMetadata, err := yamlNodeToValue(node.Content[i+1])
+			if err != nil {
+				return fmt.Errorf("env_metadata: %w", err)
+			}
+			envMap, _ := envMetadata.(map[string]any)
+			for env, metadata := range envMap {
+				c.EnvMetadata[env], _ = metadata.(map[string]any)
+			}
+		}
+	}
+	return nil
+}
+
+// MetadataEntry is a single key-value pair in Config.MetadataList.
+type MetadataEntry struct {
+	Key   string `json:"key" yaml:"key" toml:"key"`
+	Value any    `json:"value" yaml:"value" toml:"value"`
+}
+
+// setMetadata sets either Metadata or MetadataList depending on whether the decoded value is a map or a list.
+func (c *Config) setMetadata(metadata any) error {
+	switch typed := metadata.(type) {
+	case nil:
+		c.Metadata = nil
+	case map[string]any:
+		c.Metadata = typed
+	case []any:
+		c.MetadataList = make([]MetadataEntry, len(typed))
+		seen := make(map[string]struct{}, len(typed))
+		for i, item := range typed {
+			path := fmt.Sprintf("metadata[%d]", i)
+			obj, ok := item.(map[string]any)
+			key, isString := obj["key"].(string)
+			_, hasValue := obj["value"]
+			if !ok || !isString || !hasValue || len(obj) != 2 {
+				return &PathError{Path: path, Err: fmt.Errorf("list entries must be objects with only a key and a value")}
+			} else if _, duplicate := seen[key]; duplicate {
+				return &PathError{Path: path, Err: fmt.Errorf("duplicate key %q", key)}
+			}
+			seen[key] = struct{}{}
+			c.MetadataList[i] = MetadataEntry{Key: key, Value: obj["value"]}
+		}
+	default:
+		return &PathError{Path: "metadata", Err: fmt.Errorf("expected object or list, got %s", jsonTypeName(reflect.TypeOf(metadata)))}
+	}
+	return nil
+}
+
+// yamlNodeToValue decodes a YAML node like yaml.v3 does, except that integers are decoded
+// as json.Number, so that integers too large for int64 don't lose precision.
+func yamlNodeToValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlNodeToValue(node.Alias)
+	case yaml.MappingNode:
+		out := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			var key string
+			if err := node.Content[i].Decode(&key); err != nil {
+				return nil, err
+			}
+			val, err := yamlNodeToValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			out[key] = val
+		}
+		return out, nil
+	case yaml.SequenceNode:
+		out := make([]any, len(node.Content))
+		for i, child := range node.Content {
+			val, err := yamlNodeToValue(child)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+	case yaml.ScalarNode:
+		// yaml.v3 resolves integers that don't fit in uint64 as floats.
+		_, isBigInt := new(big.Int).SetString(node.Value, 10)
+		if node.ShortTag() == "!!int" || (node.ShortTag() == "!!float" && isBigInt) {
+			// Normalize other bases and underscores, but keep plain decimal integers exactly as written.
+			if _, err := strconv.ParseInt(node.Value, 10, 64); err != nil {
+				if intVal, err := strconv.ParseInt(strings.ReplaceAll(node.Value, "_", ""), 0, 64); err == nil {
+					return json.Number(strconv.FormatInt(intVal, 10)), nil
+				} else if bigVal, ok := new(big.Int).SetString(node.Value, 0); ok {
+					return json.Number(bigVal.String()), nil
+				}
+			}
+			return json.Number(node.Value), nil
+		}
+	}
+	var val any
+	err := node.Decode(&val)
+	return val, err
+}
+
+// RawJSON is a pre-serialized JSON value.
+//
+// When unmarshaling from JSON, the value is stored verbatim. Other formats (e.g. YAML and TOML)
+// use UnmarshalText, so the value must be a string that contains the JSON.
+type RawJSON []byte
+
+func (rj RawJSON) MarshalJSON() ([]byte, error) {
+	if rj == nil {
+		return []byte("null"), nil
+	}
+	return rj, nil
+}
+
+func (rj *RawJSON) UnmarshalJSON(data []byte) error {
+	*rj = append((*rj)[:0], data...)
+	return nil
+}
+
+func (rj RawJSON) MarshalText() ([]byte, error) {
+	return rj, nil
+}
+
+func (rj *RawJSON) UnmarshalText(text []byte) error {
+	if !json.Valid(text) {
+		return fmt.Errorf("invalid JSON")
+	}
+	*rj = append((*rj)[:0], text...)
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultUptimeFieldName is the field used for Config.IncludeUptime if UptimeFieldName is empty.
+const DefaultUptimeFieldName = "uptime"
+
+// uptimeHook adds the time since the logger was compiled to every event.
+type uptimeHook struct {
+	field string
+	start time.Time
+	now   func() time.Time
+}
+
+func (uh uptimeHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	e.Dur(uh.field, uh.now().Sub(uh.start))
+}
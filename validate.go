@@ -0,0 +1,286 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// ValidationError is returned by Config.Validate and contains every problem found in the config.
+// The problems are PathErrors, like the errors of UnmarshalStrict.
+//
+// The message joins the problems with semicolons, so that it stays on one line in log lines and panics.
+// Use Problems to show them separately.
+type ValidationError struct {
+	Problems []error
+}
+
+func (ve *ValidationError) Error() string {
+	msgs := make([]string, len(ve.Problems))
+	for i, err := range ve.Problems {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual problems, which errors.Is and errors.As check on Go 1.20 and later.
+func (ve *ValidationError) Unwrap() []error {
+	return ve.Problems
+}
+
+// Validate checks the config for problems without compiling it, so no files are opened and no connections are made.
+// All writers are checked, including ones that aren't active in the current environment.
+//
+// It doesn't catch everything that Compile does (e.g. unreachable servers or missing directories), but it reports
+// all problems it finds at once as a *ValidationError instead of stopping at the first one.
+// Compile calls Validate before doing anything else.
+func (c *Config) Validate() error {
+	var problems []error
+	add := func(path string, err error) {
+		problems = append(problems, &PathError{Path: path, Err: err})
+	}
+	if c.Heartbeat != nil {
+		if err := c.Heartbeat.validate(); err != nil {
+			add("heartbeat", err)
+		}
+	}
+	if _, err := newOutputRegistry(c.DedupeOutputs); err != nil {
+		add("dedupe_outputs", err)
+	}
+	if _, err := compileLevelLabels(c.LevelStyle, c.LevelLabels); err != nil {
+		add("level_labels", err)
+	}
+	if err := c.FieldNames.validate(); err != nil {
+		add("field_names", err)
+	}
+	for i, name := range c.Hooks {
+		if _, err := lookupHook(name); err != nil {
+			add(fmt.Sprintf("hooks[%d]", i), err)
+		}
+	}
+	switch c.CallerFormat {
+	case "", CallerFormatFull, CallerFormatShort, CallerFormatRelative:
+	default:
+		add("caller_format", fmt.Errorf("unknown caller_format %q", c.CallerFormat))
+	}
+	if c.CallerSkipFrames < 0 {
+		add("caller_skip_frames", fmt.Errorf("must not be negative"))
+	}
+	if c.PanicQuarantine < 0 {
+		add("panic_quarantine", fmt.Errorf("must not be negative"))
+	}
+	if c.ExpandEnv {
+		if _, err := c.expandEnv(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	for i := range c.Writers {
+		problems = c.Writers[i].validate(fmt.Sprintf("writers[%d]", i), 0, problems)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validate appends the problems found in the writer config to the given list.
+// The depth is the number of groups the writer is in.
+func (wc *WriterConfig) validate(path string, depth int, problems []error) []error {
+	add := func(field string, err error) {
+		problems = append(problems, &PathError{Path: path + field, Err: err})
+	}
+	if _, err := ParseWriterType(string(wc.Type)); err != nil {
+		add(".type", err)
+	}
+	if format, err := ParseLogFormat(string(wc.Format)); err != nil {
+		add(".format", err)
+	} else if wc.Type != WriterTypeGroup && wc.Type != WriterTypeDiscard {
+		// Groups pass these options down to their children, which are checked separately,
+		// and discard writers accept and ignore everything.
+		isPretty := format == LogFormatPretty || format == LogFormatPrettyColored
+		if wc.NotifyOnLevel != nil && !isPretty {
+			add(".notify_on_level", fmt.Errorf("only supported with pretty formats"))
+		}
+		if wc.HTMLSafe && format != "" && format != LogFormatJSON {
+			add(".html_safe", fmt.Errorf("only supported with the json format"))
+		}
+	}
+	if _, err := wc.useColor(""); err != nil {
+		add(".color", err)
+	}
+	if err := wc.Console.validate(); err != nil {
+		add(".console", err)
+	}
+	if wc.MinLevel != nil && wc.MaxLevel != nil && *wc.MinLevel != zerolog.NoLevel && *wc.MaxLevel != zerolog.NoLevel &&
+		*wc.MinLevel > *wc.MaxLevel {
+		add(".min_level", fmt.Errorf("%s is above max_level %s", wc.MinLevel, wc.MaxLevel))
+	}
+	if _, err := wc.NoLevel.parse(); err != nil {
+		add(".no_level", err)
+	}
+	if _, err := wc.compileRewriters(); err != nil {
+		add("", err)
+	}
+	if wc.AsyncBufferSize < 0 {
+		add(".async_buffer_size", fmt.Errorf("can't be negative"))
+	}
+	switch wc.AsyncOnFull {
+	case "", AsyncFullDrop, AsyncFullBlock:
+	default:
+		add(".async_on_full", fmt.Errorf("unknown async_on_full policy %q", wc.AsyncOnFull))
+	}
+	if _, err := wc.Sampling.compile(); err != nil {
+		add(".sampling", err)
+	}
+	switch wc.Type {
+	case WriterTypeFile, WriterTypeRingFile:
+		if wc.Filename == "" {
+			add(".filename", fmt.Errorf("required for the %s writer", wc.Type))
+		}
+		if wc.Type != WriterTypeFile {
+			break
+		}
+		limits := []struct {
+			field string
+			value int
+		}{{".max_size", wc.MaxSize}, {".max_age", wc.MaxAge}, {".max_backups", wc.MaxBackups}}
+		for _, limit := range limits {
+			if limit.value < 0 {
+				add(limit.field, fmt.Errorf("must not be negative"))
+			}
+		}
+		if wc.RotationInterval != "" && wc.RotationInterval.layout() == "" {
+			add(".rotation_interval", fmt.Errorf("unknown rotation_interval %q", wc.RotationInterval))
+		}
+	case WriterTypeSyslog, WriterTypeSyslogCEE:
+		if err := checkSyslogNetwork(wc.Network); err != nil {
+			add(".network", err)
+		} else if err = checkSyslogAddress(wc.Network, wc.Host); err != nil {
+			add(".host", err)
+		}
+		if _, err := parseSyslogFacility(wc.Facility); err != nil {
+			add(".facility", err)
+		}
+		if err := wc.checkSyslogPayload(); err != nil {
+			add(".payload", err)
+		}
+	case WriterTypeTail:
+		if wc.Listen == "" {
+			add(".listen", fmt.Errorf("required for the %s writer", wc.Type))
+		}
+	case WriterTypeNetwork:
+		if wc.Net == nil || wc.Net.Address == "" {
+			add(".net.address", fmt.Errorf("required for the %s writer", wc.Type))
+		}
+		if wc.Net == nil || wc.Net.Network == "" {
+			add(".net.network", fmt.Errorf("required for the %s writer", wc.Type))
+		}
+	case WriterTypeHTTP:
+		if wc.HTTP == nil || wc.HTTP.URL == "" {
+			add(".http.url", fmt.Errorf("required for the %s writer", wc.Type))
+		}
+	case WriterTypeGELF:
+		if wc.GELF == nil || wc.GELF.Address == "" {
+			add(".gelf.address", fmt.Errorf("required for the %s writer", wc.Type))
+		}
+	case WriterTypeMemory:
+		if wc.Memory != nil && wc.Memory.Capacity < 0 {
+			add(".memory.capacity", fmt.Errorf("must not be negative"))
+		}
+		if wc.Memory != nil && wc.Memory.MaxAge < 0 {
+			add(".memory.max_age", fmt.Errorf("must not be negative"))
+		}
+	case WriterTypeRingBuffer:
+		if wc.RingBuffer == nil || wc.RingBuffer.Name == "" {
+			add(".ringbuffer.name", fmt.Errorf("required for the %s writer", wc.Type))
+		} else if wc.RingBuffer.Size < 0 {
+			add(".ringbuffer.size", fmt.Errorf("must not be negative"))
+		}
+	case WriterTypeGroup:
+		if depth > 1 {
+			add(".type", fmt.Errorf("groups can only be nested one level deep"))
+		} else if len(wc.Writers) == 0 {
+			add(".writers", fmt.Errorf("group must have at least one writer"))
+		}
+		for i, child := range wc.Writers {
+			merged := child.inherit(wc)
+			problems = merged.validate(fmt.Sprintf("%s.writers[%d]", path, i), depth+1, problems)
+		}
+	}
+	return problems
+}
+
+// syslogNetworks are the networks that syslog.Dial accepts. An empty network connects to the local syslog server.
+var syslogNetworks = []string{"tcp", "tcp4", "tcp6", "udp", "udp4", "udp6", "unix", "unixgram"}
+
+func checkSyslogNetwork(network string) error {
+	if network != "" && !containsString(syslogNetworks, network) {
+		return fmt.Errorf("unknown syslog network %q (expected one of %s)", network, strings.Join(syslogNetworks, ", "))
+	}
+	return nil
+}
+
+// syslogFacilities are the facility names from syslog.h, mapped to the facility part of a priority.
+var syslogFacilities = map[string]int{
+	"kern": 0 << 3, "user": 1 << 3, "mail": 2 << 3, "daemon": 3 << 3,
+	"auth": 4 << 3, "syslog": 5 << 3, "lpr": 6 << 3, "news": 7 << 3,
+	"uucp": 8 << 3, "cron": 9 << 3, "authpriv": 10 << 3, "ftp": 11 << 3,
+	"local0": 16 << 3, "local1": 17 << 3, "local2": 18 << 3, "local3": 19 << 3,
+	"local4": 20 << 3, "local5": 21 << 3, "local6": 22 << 3, "local7": 23 << 3,
+}
+
+// parseSyslogFacility returns the facility with the given name, or -1 if the name is empty.
+func parseSyslogFacility(name string) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return -1, fmt.Errorf("unknown syslog facility %q (expected e.g. user, daemon or local0-local7)", name)
+	}
+	return facility, nil
+}
+
+// checkSyslogPayload checks that the payload is known and compatible with the writer type and format.
+func (wc *WriterConfig) checkSyslogPayload() error {
+	switch wc.Payload {
+	case "", SyslogPayloadEvent:
+		return nil
+	case SyslogPayloadMessage:
+		if wc.Type == WriterTypeSyslogCEE {
+			return fmt.Errorf("payload %s can't be used with the %s writer", wc.Payload, wc.Type)
+		} else if wc.Format != "" && wc.Format != LogFormatJSON {
+			return fmt.Errorf("payload %s requires the json format", wc.Payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown syslog payload %q (expected event or message)", wc.Payload)
+	}
+}
+
+// checkSyslogAddress checks that the network is known, and that a syslog host that is a filesystem path
+// is used with a unix network.
+func checkSyslogAddress(network, host string) error {
+	if err := checkSyslogNetwork(network); err != nil {
+		return err
+	}
+	isUnixNetwork := network == "unix" || network == "unixgram"
+	if !strings.HasPrefix(host, "/") {
+		if isUnixNetwork {
+			return fmt.Errorf("syslog network %s requires host to be an absolute socket path", network)
+		} else if network != "" && host == "" {
+			return fmt.Errorf("syslog network %s requires a host", network)
+		}
+		return nil
+	} else if !isUnixNetwork {
+		return fmt.Errorf("syslog host %s is a path, which requires the unix or unixgram network", host)
+	}
+	return nil
+}
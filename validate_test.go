@@ -0,0 +1,149 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package zeroconfig_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &zeroconfig.Config{Writers: []zeroconfig.WriterConfig{
+		{Type: zeroconfig.WriterTypeStdout, Format: zeroconfig.LogFormatPretty},
+		{Type: zeroconfig.WriterTypeFile, FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(dir, "sub", "test.log")}},
+		{Type: zeroconfig.WriterTypeGroup, OnlyIn: []string{"nonexistent"}, Writers: []zeroconfig.WriterConfig{
+			{Type: zeroconfig.WriterTypeStderr, MinLevel: ptr(zerolog.DebugLevel), MaxLevel: ptr(zerolog.WarnLevel)},
+		}},
+	}}
+	assert.NoError(t, cfg.Validate())
+	_, err := os.Stat(filepath.Join(dir, "sub"))
+	assert.True(t, os.IsNotExist(err), "Validating shouldn't create files")
+}
+
+func TestConfig_Validate_Problems(t *testing.T) {
+	cfg := parseConfig(t, `{
+	  "writers": [
+	    {"type": "stdout", "min_level": "error", "max_level": "info"},
+	    {"type": "file"},
+	    {"type": "syslog", "network": "udp", "host": "/dev/log"},
+	    {"type": "syslog", "network": "sctp", "host": "localhost:514"},
+	    {"type": "file", "filename": "test.log", "max_size": -1, "max_backups": -5},
+	    {"type": "group", "format": "pretty", "writers": [
+	      {"type": "ringfile"},
+	      {"type": "group", "writers": [{"type": "group", "writers": [{"type": "stdout"}]}]}
+	    ]}
+	  ],
+	  "dedupe_outputs": "sometimes"
+	}`)
+	cfg.Writers = append(cfg.Writers, zeroconfig.WriterConfig{Type: "meow", Format: "fancy"})
+
+	err := cfg.Validate()
+	var validationErr *zeroconfig.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []string{
+		`dedupe_outputs: unknown dedupe_outputs mode "sometimes"`,
+		"writers[0].min_level: error is above max_level info",
+		"writers[1].filename: required for the file writer",
+		"writers[2].host: syslog host /dev/log is a path, which requires the unix or unixgram network",
+		`writers[3].network: unknown syslog network "sctp" (expected one of tcp, tcp4, tcp6, udp, udp4, udp6, unix, unixgram)`,
+		"writers[4].max_size: must not be negative",
+		"writers[4].max_backups: must not be negative",
+		"writers[5].writers[0].filename: required for the ringfile writer",
+		"writers[5].writers[1].writers[0].type: groups can only be nested one level deep",
+		`writers[6].type: unknown writer type "meow" (expected one of ` + writerTypeList(t) + `)`,
+		`writers[6].format: unknown format "fancy" (expected one of console, console-colored, json, logfmt, pretty, pretty-colored)`,
+	}, problemMessages(validationErr))
+}
+
+func TestConfig_Validate_Syslog(t *testing.T) {
+	cfg := parseConfig(t, `{
+	  "writers": [
+	    {"type": "syslog", "facility": "LOCAL3", "payload": "message"},
+	    {"type": "syslog", "facility": "local9", "payload": "everything"},
+	    {"type": "syslog", "format": "pretty", "payload": "message"},
+	    {"type": "syslog-cee", "payload": "message"}
+	  ]
+	}`)
+	err := cfg.Validate()
+	var validationErr *zeroconfig.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []string{
+		`writers[1].facility: unknown syslog facility "local9" (expected e.g. user, daemon or local0-local7)`,
+		`writers[1].payload: unknown syslog payload "everything" (expected event or message)`,
+		"writers[2].payload: payload message requires the json format",
+		"writers[3].payload: payload message can't be used with the syslog-cee writer",
+	}, problemMessages(validationErr))
+}
+
+func TestConfig_Validate_RequiredAndIncompatible(t *testing.T) {
+	cfg := parseConfig(t, `{
+	  "writers": [
+	    {"type": "tail"},
+	    {"type": "network", "net": {"address": "localhost:5000"}},
+	    {"type": "network"},
+	    {"type": "http", "http": {}},
+	    {"type": "gelf"},
+	    {"type": "stdout", "format": "pretty", "html_safe": true, "notify_on_level": "error"},
+	    {"type": "stdout", "notify_on_level": "error"},
+	    {"type": "stdout", "format": "logfmt", "html_safe": true, "notify_on_level": "error"},
+	    {"type": "group", "format": "pretty", "html_safe": true, "writers": [{"type": "stderr", "format": "json"}]}
+	  ]
+	}`)
+	err := cfg.Validate()
+	var validationErr *zeroconfig.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []string{
+		"writers[0].listen: required for the tail writer",
+		"writers[1].net.network: required for the network writer",
+		"writers[2].net.address: required for the network writer",
+		"writers[2].net.network: required for the network writer",
+		"writers[3].http.url: required for the http writer",
+		"writers[4].gelf.address: required for the gelf writer",
+		"writers[5].html_safe: only supported with the json format",
+		"writers[6].notify_on_level: only supported with pretty formats",
+		"writers[7].notify_on_level: only supported with pretty formats",
+		"writers[7].html_safe: only supported with the json format",
+	}, problemMessages(validationErr))
+}
+
+func problemMessages(err *zeroconfig.ValidationError) []string {
+	msgs := make([]string, len(err.Problems))
+	for i, problem := range err.Problems {
+		msgs[i] = problem.Error()
+	}
+	return msgs
+}
+
+// writerTypeList returns the list of known writer types from the error of an unknown type.
+func writerTypeList(t *testing.T) string {
+	_, err := zeroconfig.ParseWriterType("meow")
+	require.Error(t, err)
+	msg := err.Error()
+	const prefix = `unknown writer type "meow" (expected one of `
+	return msg[len(prefix) : len(msg)-1]
+}
+
+func TestConfig_Compile_ValidatesFirst(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &zeroconfig.Config{Writers: []zeroconfig.WriterConfig{
+		{Type: zeroconfig.WriterTypeFile, FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(dir, "test.log")}},
+		{Type: zeroconfig.WriterTypeFile, FileConfig: zeroconfig.FileConfig{Filename: filepath.Join(dir, "other.log"), MaxAge: -1}},
+	}}
+	_, err := cfg.Compile()
+	assert.EqualError(t, err, "writers[1].max_age: must not be negative")
+	_, err = os.Stat(filepath.Join(dir, "test.log"))
+	assert.True(t, os.IsNotExist(err), "Earlier writers shouldn't be opened if a later one is invalid")
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build zeroconfig_websocket
+
+package zeroconfig
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultWebSocketBufferSize = 1000
+	webSocketMinBackoff        = 100 * time.Millisecond
+	webSocketMaxBackoff        = 30 * time.Second
+	webSocketWriteTimeout      = 10 * time.Second
+)
+
+// webSocketWriter sends events to a WebSocket server in the background.
+//
+// Events are queued in a bounded buffer, so logging never blocks on the network. While the connection is down,
+// it's retried with exponential backoff, and events that don't fit in the buffer are dropped.
+type webSocketWriter struct {
+	url    string
+	header http.Header
+	dialer *websocket.Dialer
+	queue  chan []byte
+	stop   chan struct{}
+	done   chan struct{}
+
+	closeOnce sync.Once
+	deliveryHealth
+}
+
+func compileWebSocket(wc *WriterConfig) (io.Writer, error) {
+	if wc.URL == "" {
+		return nil, fmt.Errorf("websocket writer requires a url")
+	}
+	parsed, err := url.Parse(wc.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	} else if parsed.Scheme != "ws" && parsed.Scheme != "wss" {
+		return nil, fmt.Errorf("websocket url must use the ws or wss scheme")
+	}
+	bufferSize := wc.BufferSize
+	if bufferSize == 0 {
+		bufferSize = defaultWebSocketBufferSize
+	} else if bufferSize < 0 {
+		return nil, fmt.Errorf("buffer_size can't be negative")
+	}
+	token, err := resolveSecret("token", wc.Token, wc.TokenFile, wc.TokenEnv)
+	if err != nil {
+		return nil, err
+	}
+	wsw := &webSocketWriter{
+		url:    wc.URL,
+		dialer: websocket.DefaultDialer,
+		queue:  make(chan []byte, bufferSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if token != "" {
+		wsw.header = http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+	go wsw.loop()
+	return wsw, nil
+}
+
+func (wsw *webSocketWriter) Write(p []byte) (n int, err error) {
+	msg := make([]byte, len(p))
+	copy(msg, p)
+	select {
+	case wsw.queue <- msg:
+	default:
+		// The buffer is full, most likely because the connection is down.
+		wsw.setHealth(fmt.Errorf("buffer is full, dropping events"))
+	}
+	return len(p), nil
+}
+
+// connect dials the server until it succeeds or the writer is closed.
+func (wsw *webSocketWriter) connect() *websocket.Conn {
+	backoff := webSocketMinBackoff
+	for {
+		conn, _, err := wsw.dialer.Dial(wsw.url, wsw.header)
+		if err == nil {
+			// Control frames are only processed while reading, so incoming messages must be consumed.
+			go func() {
+				for {
+					if _, _, err := conn.NextReader(); err != nil {
+						_ = conn.Close()
+						return
+					}
+				}
+			}()
+			return conn
+		}
+		wsw.setHealth(fmt.Errorf("failed to connect: %w", err))
+		select {
+		case <-wsw.stop:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > webSocketMaxBackoff {
+			backoff = webSocketMaxBackoff
+		}
+	}
+}
+
+func (wsw *webSocketWriter) loop() {
+	defer close(wsw.done)
+	var conn *websocket.Conn
+	var pending []byte
+	defer func() {
+		if conn != nil {
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			_ = conn.Close()
+		}
+	}()
+	for {
+		if pending == nil {
+			select {
+			case pending = <-wsw.queue:
+			case <-wsw.stop:
+				return
+			}
+		}
+		if conn == nil {
+			if conn = wsw.connect(); conn == nil {
+				return
+			}
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, pending); err != nil {
+			wsw.setHealth(fmt.Errorf("failed to send event: %w", err))
+			// Keep the pending message and send it again after reconnecting.
+			_ = conn.Close()
+			conn = nil
+			continue
+		}
+		pending = nil
+		wsw.setHealth(nil)
+	}
+}
+
+// Close stops the background goroutine and closes the connection. Events that haven't been sent yet are discarded.
+func (wsw *webSocketWriter) Close() error {
+	wsw.closeOnce.Do(func() {
+		close(wsw.stop)
+	})
+	<-wsw.done
+	return nil
+}
+
+func init() {
+	writerCompilers[WriterTypeWebSocket] = compileWebSocket
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build zeroconfig_websocket
+
+package zeroconfig_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.mau.fi/zeroconfig"
+)
+
+// startWebSocketServer starts a server that sends all received messages to the returned channel.
+// The server rejects connections until available is set to true.
+func startWebSocketServer(t *testing.T) (string, chan string, *atomic.Bool) {
+	messages := make(chan string, 100)
+	var available atomic.Bool
+	available.Store(true)
+	var upgrader websocket.Upgrader
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			messages <- string(msg)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), messages, &available
+}
+
+func receiveMessages(t *testing.T, messages chan string, count int) []string {
+	var out []string
+	for i := 0; i < count; i++ {
+		select {
+		case msg := <-messages:
+			out = append(out, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for message #%d", i+1)
+		}
+	}
+	return out
+}
+
+func TestWriterConfig_Compile_WebSocket(t *testing.T) {
+	url, messages, _ := startWebSocketServer(t)
+	log := compile(t, fmt.Sprintf(`{"writers": [{"type": "websocket", "url": "%s"}], "timestamp": false}`, url))
+	log.Info().Msg("meow")
+	log.Warn().Int("n", 2).Msg("meow")
+	assert.Equal(t, []string{
+		`{"level":"info","message":"meow"}` + "\n",
+		`{"level":"warn","n":2,"message":"meow"}` + "\n",
+	}, receiveMessages(t, messages, 2))
+}
+
+func TestWriterConfig_Compile_WebSocketReconnect(t *testing.T) {
+	url, messages, available := startWebSocketServer(t)
+	available.Store(false)
+	log := compile(t, fmt.Sprintf(`{"writers": [{"type": "websocket", "url": "%s"}], "timestamp": false}`, url))
+	for i := 0; i < 3; i++ {
+		log.Info().Int("i", i).Msg("buffered")
+	}
+	time.Sleep(300 * time.Millisecond)
+	available.Store(true)
+	assert.Equal(t, []string{
+		`{"level":"info","i":0,"message":"buffered"}` + "\n",
+		`{"level":"info","i":1,"message":"buffered"}` + "\n",
+		`{"level":"info","i":2,"message":"buffered"}` + "\n",
+	}, receiveMessages(t, messages, 3), "Buffered messages should be sent after reconnecting")
+}
+
+func TestWriterConfig_Compile_WebSocketBufferLimit(t *testing.T) {
+	url, messages, available := startWebSocketServer(t)
+	available.Store(false)
+	log := compile(t, fmt.Sprintf(`{"writers": [{"type": "websocket", "url": "%s", "buffer_size": 2}], "timestamp": false}`, url))
+	for i := 0; i < 10; i++ {
+		log.Info().Int("i", i).Msg("buffered")
+	}
+	available.Store(true)
+	received := receiveMessages(t, messages, 2)
+	select {
+	case msg := <-messages:
+		// One message may have already been taken from the buffer before it filled up.
+		received = append(received, msg)
+	case <-time.After(500 * time.Millisecond):
+	}
+	require.LessOrEqual(t, len(received), 3)
+	for i, msg := range received {
+		assert.Equal(t, fmt.Sprintf(`{"level":"info","i":%d,"message":"buffered"}`+"\n", i), msg)
+	}
+}
+
+func TestWriterConfig_Compile_WebSocketInvalidURL(t *testing.T) {
+	for _, url := range []string{"", "http://localhost", "ws://[::1"} {
+		cfg := zeroconfig.Config{Writers: []zeroconfig.WriterConfig{{
+			Type:            zeroconfig.WriterTypeWebSocket,
+			WebSocketConfig: zeroconfig.WebSocketConfig{URL: url},
+		}}}
+		_, err := cfg.Compile()
+		assert.Error(t, err, url)
+	}
+}